@@ -21,6 +21,7 @@ import (
 	tmos "github.com/cometbft/cometbft/libs/os"
 	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
 	dbm "github.com/cosmos/cosmos-db"
+	gogogrpc "github.com/cosmos/gogoproto/grpc"
 	"github.com/cosmos/gogoproto/proto"
 	ibccallbacks "github.com/cosmos/ibc-go/modules/apps/callbacks"
 	"github.com/cosmos/ibc-go/modules/capability"
@@ -83,6 +84,7 @@ import (
 	feemarkettypes "github.com/evmos/ethermint/x/feemarket/types"
 	"github.com/gorilla/mux"
 	"github.com/spf13/cast"
+	"google.golang.org/grpc"
 
 	autocliv1 "cosmossdk.io/api/cosmos/autocli/v1"
 	reflectionv1 "cosmossdk.io/api/cosmos/reflection/v1"
@@ -342,6 +344,12 @@ type App struct {
 	configurator module.Configurator
 
 	qms storetypes.RootMultiStore
+	// the versiondb compaction scheduler, nil if not enabled
+	versionDBCompaction interface{ Stop() }
+	// the versiondb instances of the sharded store groups, closed after versiondb is flushed
+	versionDBShards []io.Closer
+	// registers the memiavl WAL stream service on the grpc server, nil if not enabled
+	registerWALStream func(grpc.ServiceRegistrar) error
 
 	blockProposalHandler *ProposalHandler
 
@@ -952,12 +960,19 @@ func New(
 	// wire up the versiondb's `StreamingService` and `MultiStore`.
 	if cast.ToBool(appOpts.Get("versiondb.enable")) {
 		var err error
-		app.qms, err = app.setupVersionDB(homePath, keys, tkeys, memKeys, okeys)
+		app.qms, err = app.setupVersionDB(homePath, keys, tkeys, memKeys, okeys, newVersionDBOptions(appOpts))
 		if err != nil {
 			panic(err)
 		}
+	} else if cast.ToBool(appOpts.Get(memiavlstore.FlagCommitmentOnly)) {
+		panic("memiavl commitment-only mode requires versiondb to serve the values")
 	}
 
+	if err := memiavlstore.SetupStreaming(app.BaseApp, appOpts, keys); err != nil {
+		panic(err)
+	}
+	app.registerWALStream = memiavlstore.WALStreamRegistrar(homePath, appOpts)
+
 	var qmsVersion int64
 	if app.qms != nil {
 		qmsVersion = app.qms.LatestVersion()
@@ -1325,6 +1340,16 @@ func (app *App) RegisterAPIRoutes(apiSvr *api.Server, apiConfig config.APIConfig
 	}
 }
 
+// RegisterGRPCServer registers the grpc services of the modules, and the memiavl WAL stream service if enabled.
+func (app *App) RegisterGRPCServer(server gogogrpc.Server) {
+	app.BaseApp.RegisterGRPCServer(server)
+	if app.registerWALStream != nil {
+		if err := app.registerWALStream(server); err != nil {
+			panic(err)
+		}
+	}
+}
+
 // RegisterTxService implements the Application.RegisterTxService method.
 func (app *App) RegisterTxService(clientCtx client.Context) {
 	authtx.RegisterTxService(app.GRPCQueryRouter(), clientCtx, app.Simulate, app.interfaceRegistry)
@@ -1450,10 +1475,18 @@ func VerifyAddressFormat(bz []byte) error {
 func (app *App) Close() error {
 	errs := []error{app.BaseApp.Close()}
 
+	// the scheduled compaction must not run on the closed versiondb
+	if app.versionDBCompaction != nil {
+		app.versionDBCompaction.Stop()
+	}
+
 	// flush the versiondb
 	if closer, ok := app.qms.(io.Closer); ok {
 		errs = append(errs, closer.Close())
 	}
+	for _, shard := range app.versionDBShards {
+		errs = append(errs, shard.Close())
+	}
 
 	// mainly to flush memiavl
 	if closer, ok := app.CommitMultiStore().(io.Closer); ok {
@@ -4,9 +4,13 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
+	"github.com/crypto-org-chain/cronos/store/rootmulti"
 	"github.com/crypto-org-chain/cronos/versiondb"
 	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
 
@@ -19,13 +23,17 @@ func (app *App) setupVersionDB(
 	tkeys map[string]*storetypes.TransientStoreKey,
 	memKeys map[string]*storetypes.MemoryStoreKey,
 	okeys map[string]*storetypes.ObjectStoreKey,
+	opts versionDBOptions,
 ) (storetypes.RootMultiStore, error) {
 	dataDir := filepath.Join(homePath, "data", "versiondb")
-	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+	openStore := tsrocksdb.NewStore
+	if opts.ReadOnly {
+		openStore = tsrocksdb.NewReadOnlyStore
+	} else if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
 		return nil, err
 	}
 
-	versionDB, err := tsrocksdb.NewStore(dataDir)
+	versionDB, err := openStore(dataDir)
 	if err != nil {
 		return nil, err
 	}
@@ -39,27 +47,183 @@ func (app *App) setupVersionDB(
 	// see: https://github.com/crypto-org-chain/cronos/issues/1683
 	versionDB.SetSkipVersionZero(true)
 
+	var versionStore versiondb.VersionStore = versionDB
+	instances := []tsrocksdb.Store{versionDB}
+	if len(opts.Shards) > 0 {
+		if err := checkShardedStores(versionDB, opts.Shards); err != nil {
+			return nil, err
+		}
+		groups, err := openVersionDBShards(ResolveVersionDBShards(homePath, opts.Shards), opts.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		versionStore = versiondb.NewShardedVersionStore(versionDB, groups)
+		for _, group := range groups {
+			instances = append(instances, group.Store.(tsrocksdb.Store))
+			app.versionDBShards = append(app.versionDBShards, group.Store.(tsrocksdb.Store))
+		}
+	}
+
+	if !opts.ReadOnly {
+		var compaction *tsrocksdb.CompactionScheduler
+		versionStore, compaction, err = app.setupVersionDBWriter(versionStore, instances, exposedKeys, opts)
+		if err != nil {
+			return nil, err
+		}
+		if compaction != nil {
+			app.versionDBCompaction = compaction
+		}
+	}
+
+	if opts.CommitmentOnly {
+		if opts.AsyncWriteBuffer > 0 {
+			return nil, errors.New("memiavl commitment-only mode requires synchronous versiondb writes")
+		}
+		cms, ok := app.CommitMultiStore().(*rootmulti.Store)
+		if !ok {
+			return nil, errors.New("commitment-only mode requires memiavl")
+		}
+		// the latest values are read from versiondb, memiavl only keeps the value hashes
+		cms.SetStorage(func(name string, version *int64) storetypes.KVStore {
+			return versiondb.NewKVStore(versionStore, name, version)
+		})
+	}
+
+	verDB := versiondb.NewMultiStore(app.CommitMultiStore(), versionStore, keys, delegatedStoreKeys(tkeys, memKeys, okeys))
+	if cms, ok := app.CommitMultiStore().(*rootmulti.Store); ok {
+		// the abci queries at the heights pruned in memiavl are served from versiondb, without the merkle proofs
+		cms.SetHistoricalQuerier(verDB)
+	}
+	if opts.QueryFallback {
+		// serve the queries from the commitment store, only use versiondb for the pruned heights,
+		// or all the historical heights if FastHistoricalQuery is set.
+		app.SetQueryMultiStore(versiondb.NewFallbackMultiStore(app.CommitMultiStore(), verDB, opts.FastHistoricalQuery))
+	} else {
+		app.SetQueryMultiStore(verDB)
+	}
+	return verDB, nil
+}
+
+// setupVersionDBWriter sets up the compaction and the streaming service to write the change sets into versiondb,
+// returns the version store wrapped by the async writer if enabled, and the started compaction scheduler if
+// configured, which must be stopped before versiondb is closed.
+func (app *App) setupVersionDBWriter(
+	versionStore versiondb.VersionStore,
+	instances []tsrocksdb.Store,
+	exposedKeys []storetypes.StoreKey,
+	opts versionDBOptions,
+) (versiondb.VersionStore, *tsrocksdb.CompactionScheduler, error) {
+	var compaction *tsrocksdb.CompactionScheduler
+	if len(opts.CompactionWindow) > 0 {
+		window, err := tsrocksdb.ParseCompactionWindow(opts.CompactionWindow)
+		if err != nil {
+			return nil, nil, err
+		}
+		compaction = tsrocksdb.NewCompactionScheduler(
+			instances, window, tsrocksdb.DefaultCompactionCheckInterval, app.Logger().With("module", "versiondb"),
+		)
+		compaction.Start()
+	}
+
+	if opts.AsyncWriteBuffer > 0 {
+		asyncStore, err := versiondb.NewAsyncVersionStore(versionStore, opts.AsyncWriteBuffer)
+		if err != nil {
+			if compaction != nil {
+				compaction.Stop()
+			}
+			return nil, nil, err
+		}
+		versionStore = asyncStore
+	}
+
 	app.CommitMultiStore().AddListeners(exposedKeys)
 
 	// register in app streaming manager
 	sm := app.StreamingManager()
 	sm.ABCIListeners = append(sm.ABCIListeners,
-		versiondb.NewStreamingService(versionDB),
+		versiondb.NewStreamingService(versionStore),
 	)
 	app.SetStreamingManager(sm)
+	return versionStore, compaction, nil
+}
 
-	delegatedStoreKeys := make(map[storetypes.StoreKey]struct{})
+// delegatedStoreKeys returns the non-persistent store keys, which are delegated to the commitment store.
+func delegatedStoreKeys(
+	tkeys map[string]*storetypes.TransientStoreKey,
+	memKeys map[string]*storetypes.MemoryStoreKey,
+	okeys map[string]*storetypes.ObjectStoreKey,
+) map[storetypes.StoreKey]struct{} {
+	delegated := make(map[storetypes.StoreKey]struct{})
 	for _, k := range tkeys {
-		delegatedStoreKeys[k] = struct{}{}
+		delegated[k] = struct{}{}
 	}
 	for _, k := range memKeys {
-		delegatedStoreKeys[k] = struct{}{}
+		delegated[k] = struct{}{}
 	}
 	for _, k := range okeys {
-		delegatedStoreKeys[k] = struct{}{}
+		delegated[k] = struct{}{}
 	}
+	return delegated
+}
 
-	verDB := versiondb.NewMultiStore(app.CommitMultiStore(), versionDB, keys, delegatedStoreKeys)
-	app.SetQueryMultiStore(verDB)
-	return verDB, nil
+// checkShardedStores fails if any of the sharded stores has history in the default versiondb, which is not visible
+// after the store is moved to a shard.
+func checkShardedStores(versionDB tsrocksdb.Store, shards map[string]string) error {
+	stores := make([]string, 0, len(shards))
+	for store := range shards {
+		stores = append(stores, store)
+	}
+	sort.Strings(stores)
+
+	for _, store := range stores {
+		has, err := versionDB.HasStoreHistory(store)
+		if err != nil {
+			return err
+		}
+		if has {
+			return fmt.Errorf(
+				"store %s is configured in versiondb.shards, but it has history in the default versiondb, "+
+					"remove it from the shards, or rebuild the default versiondb and the shard from the change sets", store,
+			)
+		}
+	}
+	return nil
+}
+
+// openVersionDBShards opens the versiondb instances configured for store groups,
+// `shards` maps store names to db directories, the stores sharing the same directory share the same instance.
+func openVersionDBShards(shards map[string]string, readOnly bool) ([]versiondb.StoreGroup, error) {
+	storesByDir := make(map[string][]string)
+	for store, dir := range shards {
+		storesByDir[dir] = append(storesByDir[dir], store)
+	}
+
+	dirs := make([]string, 0, len(storesByDir))
+	for dir := range storesByDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	groups := make([]versiondb.StoreGroup, 0, len(dirs))
+	openStore := tsrocksdb.NewStore
+	if readOnly {
+		openStore = tsrocksdb.NewReadOnlyStore
+	}
+	for _, dir := range dirs {
+		if !readOnly {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+		store, err := openStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		store.SetSkipVersionZero(true)
+
+		stores := storesByDir[dir]
+		sort.Strings(stores)
+		groups = append(groups, versiondb.StoreGroup{Stores: stores, Store: store})
+	}
+	return groups, nil
 }
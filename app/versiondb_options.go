@@ -0,0 +1,50 @@
+package app
+
+import (
+	"path/filepath"
+
+	memiavlstore "github.com/crypto-org-chain/cronos/store"
+	"github.com/spf13/cast"
+
+	servertypes "github.com/cosmos/cosmos-sdk/server/types"
+)
+
+// versionDBOptions is the versiondb config read from the app options.
+type versionDBOptions struct {
+	// maps the store names to the db directories of the shards
+	Shards           map[string]string
+	AsyncWriteBuffer int
+	CompactionWindow string
+	// serve the queries from the commitment store, only use versiondb for the pruned heights
+	QueryFallback bool
+	// serve all the historical heights from versiondb if QueryFallback is set
+	FastHistoricalQuery bool
+	// memiavl commitment-only mode, the values are read from versiondb
+	CommitmentOnly bool
+	// not in the config file, set by the commands which only serve the queries
+	ReadOnly bool
+}
+
+func newVersionDBOptions(appOpts servertypes.AppOptions) versionDBOptions {
+	return versionDBOptions{
+		Shards:              cast.ToStringMapString(appOpts.Get("versiondb.shards")),
+		AsyncWriteBuffer:    cast.ToInt(appOpts.Get("versiondb.async-write-buffer")),
+		CompactionWindow:    cast.ToString(appOpts.Get("versiondb.compaction-window")),
+		QueryFallback:       cast.ToBool(appOpts.Get("versiondb.query-fallback")),
+		FastHistoricalQuery: cast.ToBool(appOpts.Get("versiondb.fast-historical-query")),
+		CommitmentOnly:      cast.ToBool(appOpts.Get(memiavlstore.FlagCommitmentOnly)),
+		ReadOnly:            cast.ToBool(appOpts.Get("versiondb.read-only")),
+	}
+}
+
+// ResolveVersionDBShards returns the shard directories with the relative ones resolved against the home directory.
+func ResolveVersionDBShards(homePath string, shards map[string]string) map[string]string {
+	resolved := make(map[string]string, len(shards))
+	for store, dir := range shards {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(homePath, dir)
+		}
+		resolved[store] = dir
+	}
+	return resolved
+}
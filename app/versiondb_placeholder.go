@@ -15,6 +15,7 @@ func (app *App) setupVersionDB(
 	tkeys map[string]*storetypes.TransientStoreKey,
 	memKeys map[string]*storetypes.MemoryStoreKey,
 	okeys map[string]*storetypes.ObjectStoreKey,
+	opts versionDBOptions,
 ) (storetypes.RootMultiStore, error) {
 	return nil, errors.New("versiondb is not supported in this binary")
 }
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cmtcfg "github.com/cometbft/cometbft/config"
+	cmtstate "github.com/cometbft/cometbft/state"
+	cmtstore "github.com/cometbft/cometbft/store"
+	"github.com/crypto-org-chain/cronos/memiavl"
+	memiavlclient "github.com/crypto-org-chain/cronos/store/client"
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/server"
+)
+
+const (
+	flagAppHashHeight    = "height"
+	flagAppHashReference = "reference"
+	flagAppHashJSON      = "json"
+)
+
+// appHashReport is the per-store root hashes of a version, it's also the format of the reference file.
+type appHashReport struct {
+	Version int64           `json:"version"`
+	AppHash string          `json:"app_hash"`
+	Stores  []storeRootHash `json:"stores"`
+}
+
+type storeRootHash struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// AppHashCmd recomputes the app hash from memiavl at a version, and compares it with the one recorded in the block store.
+func AppHashCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "app-hash",
+		Short: "Recompute the app hash from memiavl at a height and compare it with the one recorded in the block store",
+		Long: `Load memiavl at the height in read-only mode, recompute the app hash from the root hashes of the stores,
+and compare it with the app hash recorded in the header of the next block, or in the CometBFT state for the latest height.
+
+The block header only records the app hash, to pinpoint the diverging stores, pass the output of --json on a healthy
+node as --reference, the stores whose root hashes differ are printed. Exits with non-zero code on any mismatch.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			height, err := cmd.Flags().GetInt64(flagAppHashHeight)
+			if err != nil {
+				return err
+			}
+			reference, err := cmd.Flags().GetString(flagAppHashReference)
+			if err != nil {
+				return err
+			}
+			asJSON, err := cmd.Flags().GetBool(flagAppHashJSON)
+			if err != nil {
+				return err
+			}
+			if height <= 0 {
+				return errors.New("--height is required")
+			}
+
+			report, err := memiavlAppHash(filepath.Join(ctx.Config.RootDir, "data", "memiavl.db"), height)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				bz, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(bz))
+			} else {
+				for _, store := range report.Stores {
+					fmt.Printf("%s: %s\n", store.Name, store.Hash)
+				}
+				fmt.Printf("version: %d, app hash: %s\n", report.Version, report.AppHash)
+			}
+
+			var mismatch bool
+			recorded, err := recordedAppHash(ctx.Config, height)
+			if err != nil {
+				return err
+			}
+			if report.AppHash != hex.EncodeToString(recorded) {
+				mismatch = true
+				fmt.Fprintf(cmd.ErrOrStderr(), "app hash mismatch with block store, recorded: %X, recomputed: %s\n", recorded, report.AppHash)
+			}
+
+			if len(reference) > 0 {
+				bz, err := os.ReadFile(reference)
+				if err != nil {
+					return err
+				}
+				var expected appHashReport
+				if err := json.Unmarshal(bz, &expected); err != nil {
+					return fmt.Errorf("invalid reference file: %w", err)
+				}
+				for _, diff := range diffStoreRootHashes(expected.Stores, report.Stores) {
+					mismatch = true
+					fmt.Fprintln(cmd.ErrOrStderr(), diff)
+				}
+			}
+
+			if mismatch {
+				return errors.New("app hash mismatch")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64(flagAppHashHeight, 0, "the height to check")
+	cmd.Flags().String(flagAppHashReference, "", "the json output of this command on a healthy node, to compare the root hashes of the stores")
+	cmd.Flags().Bool(flagAppHashJSON, false, "print the root hashes in json format")
+	return cmd
+}
+
+// memiavlAppHash loads memiavl at the version in read-only mode, and collects the root hashes.
+func memiavlAppHash(dir string, version int64) (*appHashReport, error) {
+	db, err := memiavl.Load(dir, memiavl.Options{ReadOnly: true, TargetVersion: uint32(version)})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if db.Version() != version {
+		return nil, fmt.Errorf("version %d not found in memiavl, loaded version: %d", version, db.Version())
+	}
+
+	commitInfo := db.LastCommitInfo()
+	report := &appHashReport{
+		Version: commitInfo.Version,
+		AppHash: hex.EncodeToString(memiavlclient.CommitInfoHash(commitInfo)),
+	}
+	for _, info := range commitInfo.StoreInfos {
+		report.Stores = append(report.Stores, storeRootHash{
+			Name: info.Name,
+			Hash: hex.EncodeToString(info.CommitId.Hash),
+		})
+	}
+	return report, nil
+}
+
+// recordedAppHash returns the app hash after executing the block at the height, it's recorded in the header
+// of the next block, or in the CometBFT state if it's the latest block.
+func recordedAppHash(cfg *cmtcfg.Config, height int64) ([]byte, error) {
+	blockDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "blockstore", Config: cfg})
+	if err != nil {
+		return nil, err
+	}
+	blockStore := cmtstore.NewBlockStore(blockDB)
+	meta := blockStore.LoadBlockMeta(height + 1)
+	if err := blockStore.Close(); err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		return meta.Header.AppHash, nil
+	}
+
+	stateDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "state", Config: cfg})
+	if err != nil {
+		return nil, err
+	}
+	stateStore := cmtstate.NewStore(stateDB, cmtstate.StoreOptions{})
+	defer stateStore.Close()
+	state, err := stateStore.Load()
+	if err != nil {
+		return nil, err
+	}
+	if state.LastBlockHeight != height {
+		return nil, fmt.Errorf("app hash of height %d not found in block store", height)
+	}
+	return state.AppHash, nil
+}
+
+// diffStoreRootHashes returns the descriptions of the stores whose root hashes differ.
+func diffStoreRootHashes(expected, actual []storeRootHash) []string {
+	hashes := make(map[string]string, len(actual))
+	for _, store := range actual {
+		hashes[store.Name] = store.Hash
+	}
+
+	var diffs []string
+	for _, store := range expected {
+		hash, ok := hashes[store.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("store %s: missing", store.Name))
+			continue
+		}
+		delete(hashes, store.Name)
+		if hash != store.Hash {
+			diffs = append(diffs, fmt.Sprintf("store %s: root hash mismatch, expect %s, got %s", store.Name, store.Hash, hash))
+		}
+	}
+	for _, store := range actual {
+		if _, ok := hashes[store.Name]; ok {
+			diffs = append(diffs, fmt.Sprintf("store %s: unexpected", store.Name))
+		}
+	}
+	return diffs
+}
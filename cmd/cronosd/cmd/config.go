@@ -3,6 +3,20 @@ package cmd
 type VersionDBConfig struct {
 	// Enable defines if the versiondb should be enabled.
 	Enable bool `mapstructure:"enable"`
+	// AsyncWriteBuffer defines the maximum number of blocks buffered for asynchronous writing,
+	// 0 means the change sets are written synchronously in block commit.
+	AsyncWriteBuffer int `mapstructure:"async-write-buffer"`
+	// CompactionWindow defines the daily quiet hours to run the requested bottommost compactions, e.g. "02:00-05:00",
+	// empty means disabled.
+	CompactionWindow string `mapstructure:"compaction-window"`
+	// QueryFallback defines if the grpc queries are served from the commitment store,
+	// and only fall back to versiondb for the heights pruned from it.
+	QueryFallback bool `mapstructure:"query-fallback"`
+	// FastHistoricalQuery defines if the historical heights are served from versiondb in query-fallback mode,
+	// only the latest height is served from the commitment store.
+	FastHistoricalQuery bool `mapstructure:"fast-historical-query"`
+	// Shards maps store names to separate db directories, the stores not listed are kept in the default versiondb.
+	Shards map[string]string `mapstructure:"shards"`
 }
 
 func DefaultVersionDBConfig() VersionDBConfig {
@@ -15,4 +29,34 @@ var DefaultVersionDBTemplate = `
 [versiondb]
 # Enable defines if the versiondb should be enabled.
 enable = {{ .VersionDB.Enable }}
+
+# AsyncWriteBuffer defines the maximum number of blocks buffered for asynchronous writing,
+# the buffered blocks are written in a single batch by a background goroutine, so it don't add latency to block commit.
+# 0 means the change sets are written synchronously in block commit.
+async-write-buffer = {{ .VersionDB.AsyncWriteBuffer }}
+
+# CompactionWindow defines the daily quiet hours (local time) to run the bottommost compaction,
+# after large prunes or migrations, e.g. "02:00-05:00", empty means disabled.
+compaction-window = "{{ .VersionDB.CompactionWindow }}"
+
+# QueryFallback defines if the grpc queries are served from the commitment store,
+# and only fall back to versiondb for the heights pruned from it, without proofs.
+# By default all the grpc queries are served from versiondb.
+query-fallback = {{ .VersionDB.QueryFallback }}
+
+# FastHistoricalQuery defines if the historical heights are served from versiondb in query-fallback mode,
+# only the latest height is served from the commitment store, it skips the tree traversal and the loading of
+# the historical trees, cuts the latency of the EVM JSON-RPC calls at historical blocks, like eth_getStorageAt
+# and eth_getBalance, the merkle proofs are still served by the commitment store.
+fast-historical-query = {{ .VersionDB.FastHistoricalQuery }}
+
+# Shards maps store names to separate db directories, potentially on different disks,
+# the stores sharing the same directory share the same db instance,
+# the stores not listed are kept in the default versiondb, the relative directories are resolved against the home
+# directory, a store with history in the default versiondb can't be moved to a shard, for example:
+# evm = "/mnt/disk2/versiondb-evm"
+[versiondb.shards]
+{{- range $store, $dir := .VersionDB.Shards }}
+{{ $store }} = "{{ $dir }}"
+{{- end }}
 `
@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/server"
+)
+
+const (
+	flagConvertFrom      = "from"
+	flagConvertTo        = "to"
+	flagConvertDBs       = "dbs"
+	flagConvertOutput    = "output"
+	flagConvertBatchSize = "batch-size"
+	flagConvertReplace   = "replace"
+)
+
+// progressInterval is the minimal interval between the progress reports.
+const progressInterval = time.Second
+
+// ConvertDBCmd converts the databases of the node between the backends, the node must be stopped.
+func ConvertDBCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert-db",
+		Short: "Convert the databases of the node between goleveldb and rocksdb, the node must be stopped",
+		Long: `Convert the application db and the CometBFT dbs between goleveldb and rocksdb, the key-value pairs are
+copied in a streaming way into the output directory, then verified by comparing with the source db.
+
+With --replace, the original dbs are renamed to "<name>.db.<from>.bak" and the converted ones are moved into the
+data directory, remember to update "app-db-backend" in app.toml and "db_backend" in config.toml afterwards.
+The rocksdb backend is only available in the binary built with rocksdb support.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			from, err := cmd.Flags().GetString(flagConvertFrom)
+			if err != nil {
+				return err
+			}
+			to, err := cmd.Flags().GetString(flagConvertTo)
+			if err != nil {
+				return err
+			}
+			names, err := cmd.Flags().GetStringSlice(flagConvertDBs)
+			if err != nil {
+				return err
+			}
+			output, err := cmd.Flags().GetString(flagConvertOutput)
+			if err != nil {
+				return err
+			}
+			batchSize, err := cmd.Flags().GetInt(flagConvertBatchSize)
+			if err != nil {
+				return err
+			}
+			replace, err := cmd.Flags().GetBool(flagConvertReplace)
+			if err != nil {
+				return err
+			}
+			if from == to {
+				return errors.New("the source and target backends are the same")
+			}
+
+			dataDir := filepath.Join(ctx.Config.RootDir, "data")
+			if len(output) == 0 {
+				output = filepath.Join(dataDir, "convert-"+to)
+			}
+
+			var converted []string
+			for _, name := range names {
+				if _, err := os.Stat(filepath.Join(dataDir, name+".db")); os.IsNotExist(err) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s.db not found, skip\n", name)
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(output, name+".db")); err == nil {
+					return fmt.Errorf("%s.db already exists in %s", name, output)
+				}
+
+				progress := newConvertProgress(cmd.ErrOrStderr(), name)
+				if err := convertDB(name, dataDir, dbm.BackendType(from), output, dbm.BackendType(to), batchSize, progress); err != nil {
+					return fmt.Errorf("fail to convert %s.db: %w", name, err)
+				}
+				if err := verifyConvertedDB(name, dataDir, dbm.BackendType(from), output, dbm.BackendType(to), progress); err != nil {
+					return fmt.Errorf("fail to verify %s.db: %w", name, err)
+				}
+				converted = append(converted, name)
+			}
+
+			if !replace {
+				fmt.Printf("converted dbs are written to %s\n", output)
+				return nil
+			}
+
+			for _, name := range converted {
+				dbDir := filepath.Join(dataDir, name+".db")
+				if err := os.Rename(dbDir, fmt.Sprintf("%s.%s.bak", dbDir, from)); err != nil {
+					return err
+				}
+				if err := os.Rename(filepath.Join(output, name+".db"), dbDir); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("converted dbs are moved into %s, update the db backend in app.toml and config.toml to %s\n", dataDir, to)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagConvertFrom, string(dbm.GoLevelDBBackend), "the backend of the source dbs")
+	cmd.Flags().String(flagConvertTo, string(dbm.RocksDBBackend), "the backend of the converted dbs")
+	cmd.Flags().StringSlice(flagConvertDBs, []string{"application", "blockstore", "state", "tx_index", "evidence"}, "the dbs to convert, the missing ones are skipped")
+	cmd.Flags().String(flagConvertOutput, "", "the directory of the converted dbs, default to <home>/data/convert-<to>")
+	cmd.Flags().Int(flagConvertBatchSize, 10000, "the number of key-value pairs per write batch")
+	cmd.Flags().Bool(flagConvertReplace, false, "replace the original dbs with the converted ones, the original ones are kept as backups")
+	return cmd
+}
+
+// convertDB copies all the key-value pairs of the db to the new backend.
+func convertDB(name, srcDir string, srcBackend dbm.BackendType, dstDir string, dstBackend dbm.BackendType, batchSize int, progress *convertProgress) (returnErr error) {
+	src, err := dbm.NewDB(name, srcBackend, srcDir)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := dbm.NewDB(name, dstBackend, dstDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dst.Close(); returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	it, err := src.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	progress.start("copy")
+	batch := dst.NewBatch()
+	defer func() {
+		_ = batch.Close()
+	}()
+	var pending int
+	for ; it.Valid(); it.Next() {
+		key, value := it.Key(), it.Value()
+		if err := batch.Set(key, value); err != nil {
+			return err
+		}
+		progress.add(len(key) + len(value))
+
+		pending++
+		if pending >= batchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			_ = batch.Close()
+			batch = dst.NewBatch()
+			pending = 0
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+	progress.done()
+	return nil
+}
+
+// verifyConvertedDB compares the key-value pairs of the converted db with the source db.
+func verifyConvertedDB(name, srcDir string, srcBackend dbm.BackendType, dstDir string, dstBackend dbm.BackendType, progress *convertProgress) error {
+	src, err := dbm.NewDB(name, srcBackend, srcDir)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := dbm.NewDB(name, dstBackend, dstDir)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	srcIt, err := src.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer srcIt.Close()
+	dstIt, err := dst.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer dstIt.Close()
+
+	progress.start("verify")
+	for ; srcIt.Valid(); srcIt.Next() {
+		if !dstIt.Valid() {
+			return fmt.Errorf("key %X not found in the converted db", srcIt.Key())
+		}
+		if !bytes.Equal(srcIt.Key(), dstIt.Key()) {
+			return fmt.Errorf("key mismatch, expect %X, got %X", srcIt.Key(), dstIt.Key())
+		}
+		if !bytes.Equal(srcIt.Value(), dstIt.Value()) {
+			return fmt.Errorf("value mismatch, key: %X", srcIt.Key())
+		}
+		progress.add(len(srcIt.Key()) + len(srcIt.Value()))
+		dstIt.Next()
+	}
+	if err := errors.Join(srcIt.Error(), dstIt.Error()); err != nil {
+		return err
+	}
+	if dstIt.Valid() {
+		return fmt.Errorf("unexpected key %X in the converted db", dstIt.Key())
+	}
+	progress.done()
+	return nil
+}
+
+// convertProgress reports the number of key-value pairs processed periodically, in a single refreshing line.
+type convertProgress struct {
+	w     io.Writer
+	name  string
+	stage string
+
+	keys       int64
+	bytes      int64
+	startTime  time.Time
+	lastReport time.Time
+}
+
+func newConvertProgress(w io.Writer, name string) *convertProgress {
+	return &convertProgress{w: w, name: name}
+}
+
+func (p *convertProgress) start(stage string) {
+	p.stage = stage
+	p.keys = 0
+	p.bytes = 0
+	p.startTime = time.Now()
+	p.lastReport = p.startTime
+}
+
+func (p *convertProgress) add(size int) {
+	p.keys++
+	p.bytes += int64(size)
+	if now := time.Now(); now.Sub(p.lastReport) >= progressInterval {
+		p.lastReport = now
+		p.report()
+	}
+}
+
+func (p *convertProgress) done() {
+	p.report()
+	fmt.Fprintln(p.w)
+}
+
+func (p *convertProgress) report() {
+	elapsed := time.Since(p.startTime)
+	rate := float64(p.bytes) / (1 << 20) / max(elapsed.Seconds(), 1e-3)
+	fmt.Fprintf(p.w, "\r%s.db %s: %d keys, %s, %.1f MiB/s, %s",
+		p.name, p.stage, p.keys, formatBytes(p.bytes), rate, elapsed.Truncate(time.Second))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
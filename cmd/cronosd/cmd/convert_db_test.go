@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertDB(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+
+	expected := make(map[string]string)
+	src, err := dbm.NewDB("application", dbm.GoLevelDBBackend, srcDir)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		key, value := fmt.Sprintf("key%04d", i), fmt.Sprintf("value%d", i)
+		require.NoError(t, src.Set([]byte(key), []byte(value)))
+		expected[key] = value
+	}
+	require.NoError(t, src.Close())
+
+	// small batches to cover the batch rotation
+	progress := newConvertProgress(io.Discard, "application")
+	require.NoError(t, convertDB("application", srcDir, dbm.GoLevelDBBackend, dstDir, dbm.GoLevelDBBackend, 64, progress))
+	require.NoError(t, verifyConvertedDB("application", srcDir, dbm.GoLevelDBBackend, dstDir, dbm.GoLevelDBBackend, progress))
+
+	dst, err := dbm.NewDB("application", dbm.GoLevelDBBackend, dstDir)
+	require.NoError(t, err)
+	it, err := dst.Iterator(nil, nil)
+	require.NoError(t, err)
+	actual := make(map[string]string)
+	for ; it.Valid(); it.Next() {
+		actual[string(it.Key())] = string(it.Value())
+	}
+	require.NoError(t, it.Error())
+	require.NoError(t, it.Close())
+	require.Equal(t, expected, actual)
+
+	// the verification catches the differences
+	require.NoError(t, dst.Set([]byte("key0000"), []byte("modified")))
+	require.NoError(t, dst.Close())
+	require.Error(t, verifyConvertedDB("application", srcDir, dbm.GoLevelDBBackend, dstDir, dbm.GoLevelDBBackend, progress))
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cmtcfg "github.com/cometbft/cometbft/config"
+	cmtstate "github.com/cometbft/cometbft/state"
+	cmtstore "github.com/cometbft/cometbft/store"
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"cosmossdk.io/store/rootmulti"
+	storetypes "cosmossdk.io/store/types"
+
+	"github.com/cosmos/cosmos-sdk/server"
+)
+
+// commitInfoKeyFmt is the key of the commit info of a version in application.db, see rootmulti.
+const commitInfoKeyFmt = "s/%d"
+
+// dbVersions collects the latest versions of the databases of a node.
+type dbVersions struct {
+	// CometBFT block store
+	blockBase, blockHeight int64
+	// CometBFT state store
+	stateHeight  int64
+	stateAppHash []byte
+
+	// memiavl or application.db
+	appName    string
+	appVersion int64
+	// the app hash and the root hashes of the stores at appVersion
+	appHash   []byte
+	appStores []storeRootHash
+
+	versionDB        bool
+	versionDBVersion int64
+}
+
+// DoctorCmd checks the consistency between the databases of the node, and suggests the fixes.
+func DoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the consistency between the databases of the node and suggest fixes, the node must be stopped",
+		Long: `Check the latest versions of memiavl or application.db, versiondb and the CometBFT block and state stores,
+flag the divergences and suggest the fixes, exits with non-zero code if any problem is found.
+
+The app hash of memiavl or application.db is compared with the one in the CometBFT state if they are at the same
+height, to name the diverging stores, pass the output of "app-hash --json" at that height on a healthy node as
+--reference.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			referenceFile, err := cmd.Flags().GetString(flagAppHashReference)
+			if err != nil {
+				return err
+			}
+			var reference *appHashReport
+			if len(referenceFile) > 0 {
+				bz, err := os.ReadFile(referenceFile)
+				if err != nil {
+					return err
+				}
+				reference = &appHashReport{}
+				if err := json.Unmarshal(bz, reference); err != nil {
+					return fmt.Errorf("invalid reference file: %w", err)
+				}
+			}
+
+			versions, err := loadDBVersions(ctx)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("block store: %d-%d\n", versions.blockBase, versions.blockHeight)
+			fmt.Printf("state store: %d, app hash: %X\n", versions.stateHeight, versions.stateAppHash)
+			fmt.Printf("%s: %d, app hash: %X\n", versions.appName, versions.appVersion, versions.appHash)
+			if versions.versionDB {
+				fmt.Printf("versiondb: %d\n", versions.versionDBVersion)
+			}
+
+			problems := versions.diagnose(reference)
+			if len(problems) == 0 {
+				fmt.Println("no problem found")
+				return nil
+			}
+			for _, problem := range problems {
+				fmt.Printf("- %s\n", problem)
+			}
+			return fmt.Errorf("found %d problems", len(problems))
+		},
+	}
+
+	cmd.Flags().String(flagAppHashReference, "", "the json output of the app-hash command on a healthy node, to compare the root hashes of the stores")
+	return cmd
+}
+
+func loadDBVersions(ctx *server.Context) (*dbVersions, error) {
+	cfg := ctx.Config
+	home := cfg.RootDir
+	versions := &dbVersions{}
+
+	blockDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "blockstore", Config: cfg})
+	if err != nil {
+		return nil, err
+	}
+	blockStore := cmtstore.NewBlockStore(blockDB)
+	versions.blockBase = blockStore.Base()
+	versions.blockHeight = blockStore.Height()
+	if err := blockStore.Close(); err != nil {
+		return nil, err
+	}
+
+	stateDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "state", Config: cfg})
+	if err != nil {
+		return nil, err
+	}
+	stateStore := cmtstate.NewStore(stateDB, cmtstate.StoreOptions{})
+	state, err := stateStore.Load()
+	if err != nil {
+		return nil, errors.Join(err, stateStore.Close())
+	}
+	versions.stateHeight = state.LastBlockHeight
+	versions.stateAppHash = state.AppHash
+	if err := stateStore.Close(); err != nil {
+		return nil, err
+	}
+
+	if cast.ToBool(ctx.Viper.Get("memiavl.enable")) {
+		versions.appName = "memiavl"
+		if err := versions.loadMemIAVL(filepath.Join(home, "data", "memiavl.db")); err != nil {
+			return nil, err
+		}
+	} else {
+		versions.appName = "application.db"
+		db, err := opendb.OpenReadOnlyDB(home, server.GetAppDBBackend(ctx.Viper))
+		if err != nil {
+			return nil, err
+		}
+		if err := versions.loadAppDB(db); err != nil {
+			return nil, errors.Join(err, db.Close())
+		}
+		if err := db.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cast.ToBool(ctx.Viper.Get("versiondb.enable")) {
+		if _, err := os.Stat(filepath.Join(home, "data", "versiondb")); err == nil {
+			versions.versionDB = true
+			shards := cast.ToStringMapString(ctx.Viper.Get("versiondb.shards"))
+			versions.versionDBVersion, err = versionDBLatestVersion(home, shards)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// loadMemIAVL loads the latest version of memiavl in read-only mode, and collects the root hashes.
+func (v *dbVersions) loadMemIAVL(dir string) error {
+	db, err := memiavl.Load(dir, memiavl.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	commitInfo := db.LastCommitInfo()
+	v.appVersion = commitInfo.Version
+	v.appHash = commitInfo.Hash()
+	for _, info := range commitInfo.StoreInfos {
+		v.appStores = append(v.appStores, storeRootHash{Name: info.Name, Hash: hex.EncodeToString(info.CommitId.Hash)})
+	}
+	return nil
+}
+
+// loadAppDB reads the latest version and its commit info from application.db.
+func (v *dbVersions) loadAppDB(db dbm.DB) error {
+	v.appVersion = rootmulti.GetLatestVersion(db)
+	if v.appVersion == 0 {
+		return nil
+	}
+	bz, err := db.Get([]byte(fmt.Sprintf(commitInfoKeyFmt, v.appVersion)))
+	if err != nil {
+		return err
+	}
+	if bz == nil {
+		return fmt.Errorf("commit info of version %d not found in application.db", v.appVersion)
+	}
+	var commitInfo storetypes.CommitInfo
+	if err := commitInfo.Unmarshal(bz); err != nil {
+		return fmt.Errorf("invalid commit info of version %d: %w", v.appVersion, err)
+	}
+	v.appHash = commitInfo.Hash()
+	for _, info := range commitInfo.StoreInfos {
+		v.appStores = append(v.appStores, storeRootHash{Name: info.Name, Hash: hex.EncodeToString(info.CommitId.Hash)})
+	}
+	return nil
+}
+
+// diagnose returns the problems found with the suggested fixes, the reference is the output of app-hash on a healthy
+// node, which is optional.
+func (v *dbVersions) diagnose(reference *appHashReport) []string {
+	var problems []string
+
+	if v.stateHeight != v.blockHeight && v.stateHeight+1 != v.blockHeight {
+		problems = append(problems, fmt.Sprintf(
+			"state store height %d diverges from block store height %d, the CometBFT data is corrupted, restore it from a snapshot",
+			v.stateHeight, v.blockHeight,
+		))
+	}
+
+	switch {
+	case v.appVersion > v.blockHeight:
+		problems = append(problems, fmt.Sprintf(
+			"%s version %d is ahead of block store height %d, run `cronosd rollback --hard --height %d`",
+			v.appName, v.appVersion, v.blockHeight, v.blockHeight,
+		))
+	case v.appVersion+1 < v.blockBase:
+		problems = append(problems, fmt.Sprintf(
+			"%s version %d is behind the earliest block %d, the blocks to replay are pruned, restore %s from a snapshot",
+			v.appName, v.appVersion, v.blockBase, v.appName,
+		))
+	case v.appVersion < v.stateHeight:
+		problems = append(problems, fmt.Sprintf(
+			"%s version %d is behind state store height %d, if the node halts with app hash mismatch, "+
+				"run `cronosd rollback --hard --height %d` to resync the blocks",
+			v.appName, v.appVersion, v.stateHeight, v.appVersion,
+		))
+	}
+
+	if v.appVersion > 0 && v.appVersion == v.stateHeight && !bytes.Equal(v.appHash, v.stateAppHash) {
+		problem := fmt.Sprintf(
+			"%s app hash %X at version %d diverges from state store app hash %X",
+			v.appName, v.appHash, v.appVersion, v.stateAppHash,
+		)
+		if reference != nil && reference.Version == v.appVersion {
+			if diffs := diffStoreRootHashes(reference.Stores, v.appStores); len(diffs) > 0 {
+				problem += ", " + strings.Join(diffs, ", ")
+			}
+			problem += fmt.Sprintf(", restore %s from a snapshot", v.appName)
+		} else {
+			problem += fmt.Sprintf(
+				", pass the output of `cronosd app-hash --height %d --json` on a healthy node as --reference to find the diverging stores",
+				v.appVersion,
+			)
+		}
+		problems = append(problems, problem)
+	}
+
+	if v.versionDB && v.versionDBVersion > 0 && v.versionDBVersion < v.appVersion {
+		problems = append(problems, fmt.Sprintf(
+			"versiondb version %d lags behind %s version %d, the node refuses to start, "+
+				"run `cronosd rollback --hard --height %d`, or catch up versiondb with change sets",
+			v.versionDBVersion, v.appName, v.appVersion, v.versionDBVersion,
+		))
+	}
+
+	return problems
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/server"
+)
+
+const (
+	flagCompactDBs       = "dbs"
+	flagCompactRateLimit = "rate-limit"
+)
+
+// MaintenanceCmd returns the command group for the heavy maintenance tasks, which are intended to run in the
+// scheduled maintenance windows.
+func MaintenanceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Heavy maintenance tasks for the scheduled maintenance windows, the node must be stopped",
+	}
+	cmd.AddCommand(CompactAllCmd())
+	return cmd
+}
+
+// CompactAllCmd compacts the databases of the node sequentially.
+func CompactAllCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compact-all",
+		Short: "Compact application.db, versiondb and tx_index sequentially, the node must be stopped",
+		Long: `Run a full compaction on application.db, versiondb (including the shards) and tx_index one by one, to
+reclaim the disk space of the deleted and overwritten data, the size of each db is reported while compacting.
+The compaction IO of the rocksdb dbs can be throttled with --rate-limit, it's not supported by goleveldb.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			names, err := cmd.Flags().GetStringSlice(flagCompactDBs)
+			if err != nil {
+				return err
+			}
+			rateLimit, err := cmd.Flags().GetInt64(flagCompactRateLimit)
+			if err != nil {
+				return err
+			}
+			// MiB/s to bytes/s
+			rateLimit <<= 20
+
+			w := cmd.ErrOrStderr()
+			home := ctx.Config.RootDir
+			dataDir := filepath.Join(home, "data")
+			for _, name := range names {
+				switch name {
+				case "application", "tx_index":
+					backend := server.GetAppDBBackend(ctx.Viper)
+					if name == "tx_index" {
+						backend = dbm.BackendType(ctx.Config.DBBackend)
+					}
+					dir := filepath.Join(dataDir, name+".db")
+					if _, err := os.Stat(dir); os.IsNotExist(err) {
+						fmt.Fprintf(w, "%s.db not found, skip\n", name)
+						continue
+					}
+					if rateLimit > 0 && backend != dbm.RocksDBBackend {
+						fmt.Fprintf(w, "%s.db: rate limit is not supported by %s backend\n", name, backend)
+					}
+					if err := compactWithProgress(w, name+".db", dir, func() error {
+						return opendb.CompactDB(dataDir, name, backend, rateLimit)
+					}); err != nil {
+						return fmt.Errorf("fail to compact %s.db: %w", name, err)
+					}
+				case "versiondb":
+					shards := cast.ToStringMapString(ctx.Viper.Get("versiondb.shards"))
+					dirs := versionDBDirs(home, shards)
+					if len(dirs) == 0 {
+						fmt.Fprintln(w, "versiondb not found, skip")
+						continue
+					}
+					for _, dir := range dirs {
+						if err := compactWithProgress(w, dir, dir, func() error {
+							return compactVersionDB(dir, rateLimit)
+						}); err != nil {
+							return fmt.Errorf("fail to compact versiondb %s: %w", dir, err)
+						}
+					}
+				default:
+					return fmt.Errorf("unknown db: %s", name)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSlice(flagCompactDBs, []string{"application", "versiondb", "tx_index"}, "the dbs to compact in order, the missing ones are skipped")
+	cmd.Flags().Int64(flagCompactRateLimit, 0, "the compaction IO rate limit of rocksdb in MiB/s, 0 means unlimited")
+	return cmd
+}
+
+// compactWithProgress runs the compaction, reports the elapsed time and the size of the db directory periodically
+// in a single refreshing line, and the size change at the end.
+func compactWithProgress(w io.Writer, name, dir string, compact func() error) error {
+	before, err := dirSize(dir)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// the size could be inaccurate when the files are being replaced
+				size, _ := dirSize(dir)
+				fmt.Fprintf(w, "\r%s compacting: %s, %s", name, formatBytes(size), time.Since(start).Truncate(time.Second))
+			}
+		}
+	}()
+
+	err = compact()
+	close(done)
+	<-stopped
+	if err != nil {
+		fmt.Fprintln(w)
+		return err
+	}
+
+	after, err := dirSize(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\r%s compacted: %s -> %s, %s\n", name, formatBytes(before), formatBytes(after), time.Since(start).Truncate(time.Second))
+	return nil
+}
+
+// dirSize returns the total size of the files in the directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	memiavlstore "github.com/crypto-org-chain/cronos/store"
+	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"cosmossdk.io/log"
+	storetypes "cosmossdk.io/store/types"
+
+	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/server"
+	serverconfig "github.com/cosmos/cosmos-sdk/server/config"
+	servergrpc "github.com/cosmos/cosmos-sdk/server/grpc"
+	servertypes "github.com/cosmos/cosmos-sdk/server/types"
+)
+
+const (
+	flagQueryServerGRPC   = "grpc"
+	flagQueryServerFollow = "follow"
+)
+
+// standbyStore is implemented by the memiavl root multistore.
+type standbyStore interface {
+	CatchupWAL() error
+}
+
+// QueryServerCmd serves the grpc queries from the local dbs in read-only mode, without running consensus.
+func QueryServerCmd(appCreator servertypes.AppCreator) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "query-server",
+		Short: "Serve the grpc queries from memiavl and versiondb in read-only mode, without running consensus",
+		Long: `Open memiavl and versiondb (if enabled) in read-only mode and serve the grpc queries, without running consensus,
+it can run against a copy of the data directory to scale the read traffic horizontally off the validator machines.
+
+The dbs are opened at the latest version when started, the later writes are not visible, restart the server to serve
+the new blocks. The EVM JSON-RPC and the CometBFT RPC are not served, they depend on the consensus engine.
+
+With --follow, it runs as a hot standby of the primary writing to the same memiavl db, for example on a shared disk
+or a local copy kept current by "cronosd memiavl follow", the new versions are caught up in the interval, it's not
+supported with versiondb, which can't see the new writes in read-only mode.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			clientCtx := client.GetClientContextFromCmd(cmd)
+			address, err := cmd.Flags().GetString(flagQueryServerGRPC)
+			if err != nil {
+				return err
+			}
+			followInterval, err := cmd.Flags().GetDuration(flagQueryServerFollow)
+			if err != nil {
+				return err
+			}
+
+			if !cast.ToBool(ctx.Viper.Get(memiavlstore.FlagMemIAVL)) {
+				return errors.New("query-server requires memiavl to be enabled")
+			}
+			if followInterval > 0 && cast.ToBool(ctx.Viper.Get("versiondb.enable")) {
+				return fmt.Errorf("--%s is not supported with versiondb", flagQueryServerFollow)
+			}
+			ctx.Viper.Set(memiavlstore.FlagReadOnly, true)
+			ctx.Viper.Set(memiavlstore.FlagNoLock, true)
+			ctx.Viper.Set("versiondb.read-only", true)
+
+			cfg, err := serverconfig.GetConfig(ctx.Viper)
+			if err != nil {
+				return err
+			}
+			if len(address) > 0 {
+				cfg.GRPC.Address = address
+			}
+
+			db, err := opendb.OpenReadOnlyDB(ctx.Config.RootDir, server.GetAppDBBackend(ctx.Viper))
+			if err != nil {
+				return err
+			}
+			app := appCreator(ctx.Logger, db, nil, ctx.Viper)
+			defer func() {
+				if err := app.Close(); err != nil {
+					ctx.Logger.Error("failed to close app", "err", err)
+				}
+			}()
+
+			grpcSrv, err := servergrpc.NewGRPCServer(clientCtx, app, cfg.GRPC)
+			if err != nil {
+				return err
+			}
+
+			sigCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			if followInterval > 0 {
+				cms, ok := app.(interface {
+					CommitMultiStore() storetypes.CommitMultiStore
+				})
+				if !ok {
+					return errors.New("the app don't expose the commit multistore")
+				}
+				store, ok := cms.CommitMultiStore().(standbyStore)
+				if !ok {
+					return errors.New("the commit multistore don't support following the primary")
+				}
+				go followPrimary(sigCtx, ctx.Logger, store, followInterval)
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "serving grpc queries on %s\n", cfg.GRPC.Address)
+			// returns after graceful stop when the context is canceled
+			return servergrpc.StartGRPCServer(sigCtx, ctx.Logger.With("module", "grpc-server"), cfg.GRPC, grpcSrv)
+		},
+	}
+
+	cmd.Flags().String(flagQueryServerGRPC, "", "the grpc server address, default to grpc.address in app.toml")
+	cmd.Flags().Duration(flagQueryServerFollow, 0, "the interval to catch up the new versions committed by the primary, 0 to disable")
+	return cmd
+}
+
+// followPrimary catches up the versions committed by the primary in the interval until the context is canceled.
+func followPrimary(ctx context.Context, logger log.Logger, store standbyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.CatchupWAL(); err != nil {
+				logger.Error("failed to catch up the primary", "err", err)
+			}
+		}
+	}
+}
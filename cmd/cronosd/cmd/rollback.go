@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	cmtcmd "github.com/cometbft/cometbft/cmd/cometbft/commands"
+	cmtcfg "github.com/cometbft/cometbft/config"
+	cmtstore "github.com/cometbft/cometbft/store"
+	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/server"
+	servertypes "github.com/cosmos/cosmos-sdk/server/types"
+)
+
+const (
+	flagRollbackHeight = "height"
+	flagRollbackHard   = "hard"
+)
+
+// RollbackCmd replaces the rollback command of cosmos-sdk, which can only rollback a single block, it rollbacks the
+// application stores (memiavl or application.db), versiondb and the CometBFT block and state stores together
+// to the target height.
+func RollbackCmd(appCreator servertypes.AppCreator) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "rollback cosmos-sdk and CometBFT state to a previous height",
+		Long: `Rollback the application stores, versiondb and the CometBFT state to a previous height.
+
+Without --height, it rollbacks a single block like the cosmos-sdk command, the block is kept unless --hard is set.
+With --height, it rollbacks the application stores and versiondb first, then removes the blocks above the height
+together with the CometBFT state, must be used with --hard. If interrupted, it's safe to run it again, the node
+replays the blocks left in the block store on startup.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			height, err := cmd.Flags().GetInt64(flagRollbackHeight)
+			if err != nil {
+				return err
+			}
+			hard, err := cmd.Flags().GetBool(flagRollbackHard)
+			if err != nil {
+				return err
+			}
+
+			if height == 0 {
+				// rollback a single block, same as cosmos-sdk.
+				height, hash, err := cmtcmd.RollbackState(ctx.Config, hard)
+				if err != nil {
+					return fmt.Errorf("failed to rollback CometBFT state: %w", err)
+				}
+				if err := rollbackAppStores(ctx, appCreator, height); err != nil {
+					return err
+				}
+				fmt.Printf("Rolled back state to height %d and hash %X\n", height, hash)
+				return nil
+			}
+
+			if !hard {
+				return errors.New("rollback to a height removes the blocks above it, please confirm with --hard")
+			}
+
+			blockHeight, err := blockStoreHeight(ctx.Config)
+			if err != nil {
+				return err
+			}
+			if height <= 0 || height > blockHeight {
+				return fmt.Errorf("invalid rollback height %d, latest block height: %d", height, blockHeight)
+			}
+
+			// rollback the application stores first, so if it fails, the node is left untouched,
+			// and the node can still recover from the blocks if the later steps fail.
+			if err := rollbackAppStores(ctx, appCreator, height); err != nil {
+				return err
+			}
+
+			for ; blockHeight > height; blockHeight-- {
+				if _, _, err := cmtcmd.RollbackState(ctx.Config, true); err != nil {
+					return fmt.Errorf("failed to rollback CometBFT state at height %d: %w", blockHeight, err)
+				}
+			}
+
+			fmt.Printf("Rolled back state to height %d\n", height)
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64(flagRollbackHeight, 0, "the target height, default to rollback a single block")
+	cmd.Flags().Bool(flagRollbackHard, false, "remove the blocks as well as the state")
+	return cmd
+}
+
+// rollbackAppStores rollbacks the commit multistore of the app and the versiondb to the height.
+func rollbackAppStores(ctx *server.Context, appCreator servertypes.AppCreator, height int64) error {
+	home := ctx.Config.RootDir
+	versionDBEnabled := cast.ToBool(ctx.Viper.Get("versiondb.enable"))
+
+	db, err := opendb.OpenDB(ctx.Viper, home, server.GetAppDBBackend(ctx.Viper))
+	if err != nil {
+		return err
+	}
+
+	// versiondb is rolled back separately, don't let the app open it.
+	ctx.Viper.Set("versiondb.enable", false)
+	app := appCreator(ctx.Logger, db, nil, ctx.Viper)
+	if err := app.CommitMultiStore().RollbackToVersion(height); err != nil {
+		return errors.Join(fmt.Errorf("failed to rollback to version: %w", err), app.Close())
+	}
+	if err := app.Close(); err != nil {
+		return err
+	}
+
+	if versionDBEnabled {
+		shards := cast.ToStringMapString(ctx.Viper.Get("versiondb.shards"))
+		if err := rollbackVersionDB(home, shards, height); err != nil {
+			return fmt.Errorf("failed to rollback versiondb: %w", err)
+		}
+	}
+	return nil
+}
+
+// blockStoreHeight returns the latest height of the CometBFT block store.
+func blockStoreHeight(cfg *cmtcfg.Config) (int64, error) {
+	db, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "blockstore", Config: cfg})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return cmtstore.NewBlockStore(db).Height(), nil
+}
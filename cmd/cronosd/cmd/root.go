@@ -10,6 +10,8 @@ import (
 	cmtcli "github.com/cometbft/cometbft/libs/cli"
 	dbm "github.com/cosmos/cosmos-db"
 	rosettaCmd "github.com/cosmos/rosetta/cmd"
+	memiavlstore "github.com/crypto-org-chain/cronos/store"
+	memiavlclient "github.com/crypto-org-chain/cronos/store/client"
 	memiavlcfg "github.com/crypto-org-chain/cronos/store/config"
 	"github.com/crypto-org-chain/cronos/v2/app"
 	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
@@ -21,6 +23,7 @@ import (
 	servercfg "github.com/evmos/ethermint/server/config"
 	srvflags "github.com/evmos/ethermint/server/flags"
 	ethermint "github.com/evmos/ethermint/types"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -52,6 +55,9 @@ import (
 
 const EnvPrefix = "CRONOS"
 
+// flagFromMemIAVL is added to the export command, to export the historical states from memiavl in read-only mode.
+const flagFromMemIAVL = "from-memiavl"
+
 var ChainID string
 
 // NewRootCmd creates a new root command for simd. It is called once in the
@@ -177,11 +183,20 @@ func initRootCmd(
 		DBOpener:        opendb.OpenDB,
 	}
 	ethermintserver.AddCommands(rootCmd, opts, appExport, addModuleInitFlags)
+	replaceCommand(rootCmd, RollbackCmd(newApp))
+	if exportCmd, _, err := rootCmd.Find([]string{"export"}); err == nil && exportCmd != rootCmd {
+		exportCmd.Flags().Bool(flagFromMemIAVL, false, "load the state at --height from the nearest memiavl snapshot and WAL in read-only mode, without rolling back the node, the node must be stopped")
+	}
+	rootCmd.AddCommand(DoctorCmd(), ConvertDBCmd(), QueryServerCmd(newApp), MaintenanceCmd())
 
 	changeSetCmd := ChangeSetCmd()
 	if changeSetCmd != nil {
 		rootCmd.AddCommand(changeSetCmd)
 	}
+	memiavlCmd := memiavlclient.MemIAVLGroupCmd()
+	// the commands need the CometBFT dbs
+	memiavlCmd.AddCommand(AppHashCmd())
+	rootCmd.AddCommand(memiavlCmd)
 
 	// add keybase, auxiliary RPC, query, and tx child commands
 	rootCmd.AddCommand(
@@ -201,6 +216,16 @@ func initRootCmd(
 	rootCmd.AddCommand(rosettaCmd.RosettaCommand(encodingConfig.InterfaceRegistry, encodingConfig.Codec))
 }
 
+// replaceCommand replaces the sub-command with the same name, or adds it if not exists.
+func replaceCommand(parent *cobra.Command, cmd *cobra.Command) {
+	for _, c := range parent.Commands() {
+		if c.Name() == cmd.Name() {
+			parent.RemoveCommand(c)
+		}
+	}
+	parent.AddCommand(cmd)
+}
+
 // genesisCommand builds genesis-related `simd genesis` command. Users may provide application specific commands as a parameter
 func genesisCommand(txConfig client.TxConfig, basicManager module.BasicManager, cmds ...*cobra.Command) *cobra.Command {
 	cmd := genutilcli.Commands(txConfig, basicManager, app.DefaultNodeHome)
@@ -327,6 +352,16 @@ func appExport(
 	viperAppOpts.Set(server.FlagInvCheckPeriod, 1)
 	appOpts = viperAppOpts
 
+	if cast.ToBool(appOpts.Get(flagFromMemIAVL)) {
+		if height == -1 {
+			return servertypes.ExportedApp{}, errors.New("--from-memiavl requires --height")
+		}
+		viperAppOpts.Set(memiavlstore.FlagMemIAVL, true)
+		viperAppOpts.Set(memiavlstore.FlagReadOnly, true)
+		// versiondb is not needed for export.
+		viperAppOpts.Set("versiondb.enable", false)
+	}
+
 	var cronosApp *app.App
 	if height != -1 {
 		cronosApp = app.New(logger, db, traceStore, false, appOpts)
@@ -4,11 +4,14 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/crypto-org-chain/cronos/v2/app"
 	"github.com/crypto-org-chain/cronos/v2/cmd/cronosd/opendb"
 	versiondbclient "github.com/crypto-org-chain/cronos/versiondb/client"
+	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
 	"github.com/linxGnu/grocksdb"
 	"github.com/spf13/cobra"
 )
@@ -29,3 +32,78 @@ func ChangeSetCmd() *cobra.Command {
 		},
 	})
 }
+
+// rollbackVersionDB rollbacks the default versiondb and the shards to the target version.
+func rollbackVersionDB(home string, shards map[string]string, version int64) error {
+	for _, dir := range versionDBDirs(home, shards) {
+		if err := tsrocksdb.RollbackToVersion(dir, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionDBLatestVersion returns the minimal latest version of the default versiondb and the shards.
+func versionDBLatestVersion(home string, shards map[string]string) (int64, error) {
+	var latest int64 = -1
+	for _, dir := range versionDBDirs(home, shards) {
+		db, cfHandle, err := tsrocksdb.OpenVersionDBForReadOnly(dir, false)
+		if err != nil {
+			return 0, err
+		}
+		version, err := tsrocksdb.NewStoreWithDB(db, cfHandle).GetLatestVersion()
+		db.Close()
+		if err != nil {
+			return 0, err
+		}
+		if latest < 0 || version < latest {
+			latest = version
+		}
+	}
+	if latest < 0 {
+		return 0, nil
+	}
+	return latest, nil
+}
+
+// compactVersionDB runs a full compaction on the versiondb in the directory, the compaction IO is throttled by
+// the rate limit in bytes per second if positive.
+func compactVersionDB(dir string, rateLimit int64) error {
+	var (
+		db       *grocksdb.DB
+		cfHandle *grocksdb.ColumnFamilyHandle
+		err      error
+	)
+	if rateLimit > 0 {
+		db, cfHandle, err = tsrocksdb.OpenVersionDBWithRateLimit(dir, rateLimit)
+	} else {
+		db, cfHandle, err = tsrocksdb.OpenVersionDB(dir)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return tsrocksdb.NewStoreWithDB(db, cfHandle).Compact()
+}
+
+// versionDBDirs returns the existing directories of the default versiondb and the shards, deduplicated.
+func versionDBDirs(home string, shards map[string]string) []string {
+	dirs := []string{filepath.Join(home, "data", "versiondb")}
+	for _, dir := range app.ResolveVersionDBShards(home, shards) {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	result := make([]string, 0, len(dirs))
+	for i, dir := range dirs {
+		if i > 0 && dir == dirs[i-1] {
+			// shared by multiple stores
+			continue
+		}
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		result = append(result, dir)
+	}
+	return result
+}
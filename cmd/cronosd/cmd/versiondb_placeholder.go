@@ -4,9 +4,27 @@
 package cmd
 
 import (
+	"errors"
+
 	"github.com/spf13/cobra"
 )
 
 func ChangeSetCmd() *cobra.Command {
 	return nil
 }
+
+func rollbackVersionDB(home string, shards map[string]string, version int64) error {
+	return errors.New("versiondb is not supported in this binary")
+}
+
+func versionDBLatestVersion(home string, shards map[string]string) (int64, error) {
+	return 0, errors.New("versiondb is not supported in this binary")
+}
+
+func compactVersionDB(dir string, rateLimit int64) error {
+	return errors.New("versiondb is not supported in this binary")
+}
+
+func versionDBDirs(home string, shards map[string]string) []string {
+	return nil
+}
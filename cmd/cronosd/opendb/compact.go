@@ -0,0 +1,17 @@
+package opendb
+
+import (
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// compactGoLevelDB runs a full compaction on the goleveldb in the data directory.
+func compactGoLevelDB(dataDir, name string) error {
+	db, err := dbm.NewGoLevelDB(name, dataDir, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DB().CompactRange(util.Range{})
+}
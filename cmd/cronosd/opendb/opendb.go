@@ -4,6 +4,7 @@
 package opendb
 
 import (
+	"fmt"
 	"path/filepath"
 
 	dbm "github.com/cosmos/cosmos-db"
@@ -20,3 +21,12 @@ func OpenDB(_ types.AppOptions, home string, backendType dbm.BackendType) (dbm.D
 func OpenReadOnlyDB(home string, backendType dbm.BackendType) (dbm.DB, error) {
 	return OpenDB(nil, home, backendType)
 }
+
+// CompactDB runs a full compaction on the db `<name>.db` in the data directory, the rate limit is only supported by
+// rocksdb backend.
+func CompactDB(dataDir, name string, backendType dbm.BackendType, _ int64) error {
+	if backendType != dbm.GoLevelDBBackend {
+		return fmt.Errorf("unsupported db backend: %s", backendType)
+	}
+	return compactGoLevelDB(dataDir, name)
+}
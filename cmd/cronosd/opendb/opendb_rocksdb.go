@@ -4,6 +4,7 @@
 package opendb
 
 import (
+	"fmt"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -137,3 +138,40 @@ func NewRocksdbOptions(opts *grocksdb.Options, sstFileWriter bool) *grocksdb.Opt
 	opts.SetBottommostCompressionOptions(compressOpts, true)
 	return opts
 }
+
+// CompactDB runs a full compaction on the db `<name>.db` in the data directory, the compaction IO of rocksdb is
+// throttled by the rate limit in bytes per second if positive.
+func CompactDB(dataDir, name string, backendType dbm.BackendType, rateLimit int64) error {
+	if backendType != dbm.RocksDBBackend {
+		if backendType != dbm.GoLevelDBBackend {
+			return fmt.Errorf("unsupported db backend: %s", backendType)
+		}
+		return compactGoLevelDB(dataDir, name)
+	}
+
+	dir := filepath.Join(dataDir, name+".db")
+	opts, err := loadLatestOptions(dir)
+	if err != nil {
+		return err
+	}
+	if name == "application" {
+		opts = NewRocksdbOptions(opts, false)
+	} else if opts == nil {
+		opts = grocksdb.NewDefaultOptions()
+	}
+	if rateLimit > 0 {
+		opts.SetRateLimiter(grocksdb.NewRateLimiter(rateLimit, 100*1000, 10))
+	}
+
+	db, err := grocksdb.OpenDb(opts, dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	compactOpts := grocksdb.NewCompactRangeOptions()
+	defer compactOpts.Destroy()
+	compactOpts.SetBottommostLevelCompaction(grocksdb.KForceOptimized)
+	db.CompactRangeOpt(grocksdb.Range{}, compactOpts)
+	return nil
+}
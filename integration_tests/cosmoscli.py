@@ -1842,8 +1842,19 @@ class CosmosCLI:
             rsp = self.event_query_tx_for(rsp["txhash"])
         return rsp
 
-    def rollback(self):
-        self.raw("rollback", home=self.data_dir)
+    def rollback(self, height=None):
+        "rollback a single block, or to the height with the blocks above it removed"
+        if height is None:
+            return self.raw("rollback", home=self.data_dir).decode()
+        return self.raw(
+            "rollback", "--hard", height=height, home=self.data_dir
+        ).decode()
+
+    def app_hash(self, height):
+        "recompute the app hash from memiavl at the height"
+        return json.loads(
+            self.raw("app-hash", "--json", height=height, home=self.data_dir)
+        )
 
     def changeset_dump(self, changeset_dir, **kwargs):
         default_kwargs = {
@@ -0,0 +1,91 @@
+package memiavl
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// arenaChunkSize is the number of nodes allocated in one chunk.
+const arenaChunkSize = 1024
+
+// memNodeSize is the heap size of a `MemNode`, not including the key and value.
+const memNodeSize = uint64(unsafe.Sizeof(MemNode{}))
+
+// nodeArena allocates the new `MemNode`s of a tree, and tracks the approximate heap usage of them.
+//
+// If chunked, the nodes are allocated in chunks, to reduce the number of the heap objects the GC has to track during
+// the WAL replay and the block execution. The nodes are never freed individually, a chunk is reclaimed by the GC
+// once none of its nodes is referenced, the arena is dropped along with the tree at snapshot switch, so the chunks
+// are freed wholesale.
+//
+// It's not thread-safe, each tree owns its own arena, a nil arena falls back to the normal heap allocation.
+type nodeArena struct {
+	chunked bool
+	chunk   []MemNode
+	// the leaves don't retain their hashes, and the new keys share the prefixes with the neighbor keys, see
+	// `flagCompactLeaf` and `MemNode.keyPrefix`.
+	compact bool
+
+	// the approximate heap bytes of the nodes allocated so far, including the leaf keys and values, the replaced
+	// nodes are not subtracted, so it's an upper bound.
+	bytes uint64
+}
+
+func newNodeArena(chunked bool) *nodeArena {
+	return &nodeArena{chunked: chunked}
+}
+
+// newNode returns a zero node.
+func (a *nodeArena) newNode() *MemNode {
+	if a == nil {
+		return &MemNode{}
+	}
+	a.bytes += memNodeSize
+	if !a.chunked {
+		return &MemNode{}
+	}
+	if len(a.chunk) == 0 {
+		a.chunk = make([]MemNode, arenaChunkSize)
+	}
+	node := &a.chunk[0]
+	a.chunk = a.chunk[1:]
+	return node
+}
+
+// newLeafNode is the arena version of `newLeafNode`.
+func (a *nodeArena) newLeafNode(key, value []byte, version uint32) *MemNode {
+	node := a.newNode()
+	node.key, node.value, node.version, node.size = key, value, version, 1
+	if a != nil {
+		a.bytes += uint64(len(key) + len(value))
+		if a.compact {
+			node.flags |= flagCompactLeaf
+		}
+	}
+	return node
+}
+
+// minSharedKeyPrefix is the minimal length of the prefix shared with the neighbor key, the shorter ones are not worth
+// the copy of the suffix.
+const minSharedKeyPrefix = 8
+
+// newLeafNodeNear is like `newLeafNode`, except that in compact mode, the key shares the prefix with the key of the
+// neighbor node if long enough, only the suffix is copied, so the buffer of the key is not retained. The prefix points
+// to the buffer of the neighbor key, or the prefix of it if it's split too, the buffers are never modified, so it's
+// safe to share them.
+func (a *nodeArena) newLeafNodeNear(key, value []byte, version uint32, neighbor Node) *MemNode {
+	if a == nil || !a.compact {
+		return a.newLeafNode(key, value, version)
+	}
+	prefix, suffix := splitKey(neighbor)
+	if len(prefix) == 0 {
+		prefix = suffix
+	}
+	n := commonPrefixLen(prefix, key)
+	if n < minSharedKeyPrefix {
+		return a.newLeafNode(key, value, version)
+	}
+	node := a.newLeafNode(bytes.Clone(key[n:]), value, version)
+	node.hash = prefix[:n:n]
+	return node
+}
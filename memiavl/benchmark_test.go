@@ -3,7 +3,9 @@ package memiavl
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"math/rand"
+	"runtime"
 	"sort"
 	"testing"
 
@@ -20,6 +22,7 @@ func BenchmarkByteCompare(b *testing.B) {
 }
 
 func BenchmarkRandomGet(b *testing.B) {
+	b.ReportAllocs()
 	amount := 1000000
 	items := genRandItems(amount)
 	targetKey := items[500].key
@@ -67,7 +70,7 @@ func BenchmarkRandomGet(b *testing.B) {
 	b.Run("memiavl-disk-cache-miss", func(b *testing.B) {
 		diskTree := NewFromSnapshot(snapshot, true, 0)
 		// enforce an empty cache to emulate cache miss
-		diskTree.cache = iavlcache.New(0)
+		diskTree.cache = newCache(0, 1)
 		require.Equal(b, targetValue, diskTree.Get(targetKey))
 
 		b.ResetTimer()
@@ -186,6 +189,149 @@ func BenchmarkRandomSet(b *testing.B) {
 	})
 }
 
+// BenchmarkNodeArena replays blocks of random writes on top of a large tree loaded from snapshot, and reports the GC
+// pauses with and without the node arena.
+func BenchmarkNodeArena(b *testing.B) {
+	const (
+		blocks    = 20
+		blockSize = 10000
+	)
+	items := genRandItems(1000000)
+
+	// the nodes loaded from snapshot are cloned on write, like the block execution on top of the latest snapshot
+	base := New(0)
+	for _, item := range items {
+		base.set(item.key, item.value)
+	}
+	_, _, err := base.SaveVersion(true)
+	require.NoError(b, err)
+	snapshotDir := b.TempDir()
+	require.NoError(b, base.WriteSnapshot(snapshotDir))
+	base = nil
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(b, err)
+	defer snapshot.Close()
+
+	for _, arena := range []bool{false, true} {
+		b.Run(fmt.Sprintf("arena=%v", arena), func(b *testing.B) {
+			var pauses, gcs uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				tree := NewFromSnapshot(snapshot, true, 0)
+				tree.SetNodeArena(arena)
+				r := rand.New(rand.NewSource(0))
+				for j := 0; j < blocks; j++ {
+					for k := 0; k < blockSize; k++ {
+						item := items[r.Intn(len(items))]
+						tree.set(item.key, item.value)
+					}
+					_, _, err := tree.SaveVersion(true)
+					require.NoError(b, err)
+				}
+
+				runtime.ReadMemStats(&after)
+				pauses += after.PauseTotalNs - before.PauseTotalNs
+				gcs += uint64(after.NumGC - before.NumGC)
+				runtime.KeepAlive(tree)
+			}
+			b.ReportMetric(float64(pauses)/float64(b.N), "gc-pause-ns/op")
+			b.ReportMetric(float64(gcs)/float64(b.N), "gc/op")
+		})
+	}
+}
+
+// BenchmarkCompactNodes loads a tree of EVM-like storage slots from snapshot, replays blocks of writes on top of it,
+// and reports the heap retained by the tree with and without the compact nodes, the writes are half new slots of the
+// existing contracts and half updates of the existing ones.
+func BenchmarkCompactNodes(b *testing.B) {
+	const (
+		contracts = 100
+		slots     = 200000
+		blocks    = 20
+		blockSize = 5000
+	)
+
+	// storage prefix + contract address + slot hash
+	r := rand.New(rand.NewSource(0))
+	addresses := make([][]byte, contracts)
+	for i := range addresses {
+		addresses[i] = make([]byte, 21)
+		addresses[i][0] = 0x03
+		r.Read(addresses[i][1:])
+	}
+	randKey := func(r *rand.Rand) []byte {
+		key := make([]byte, 53)
+		copy(key, addresses[r.Intn(contracts)])
+		r.Read(key[21:])
+		return key
+	}
+	randValue := func(r *rand.Rand) []byte {
+		value := make([]byte, 32)
+		r.Read(value)
+		return value
+	}
+
+	keys := make([][]byte, slots)
+	base := New(0)
+	for i := range keys {
+		keys[i] = randKey(r)
+		base.set(keys[i], randValue(r))
+	}
+	_, _, err := base.SaveVersion(true)
+	require.NoError(b, err)
+	snapshotDir := b.TempDir()
+	require.NoError(b, base.WriteSnapshot(snapshotDir))
+	base = nil
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(b, err)
+	defer snapshot.Close()
+
+	var baseline float64
+	for _, compact := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compact=%v", compact), func(b *testing.B) {
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				tree := NewFromSnapshot(snapshot, true, 0)
+				tree.SetCompactNodes(compact)
+				r := rand.New(rand.NewSource(1))
+				for j := 0; j < blocks; j++ {
+					// the change sets are decoded into new buffers, like the WAL replay
+					var changes ChangeSet
+					for k := 0; k < blockSize; k++ {
+						key := randKey(r)
+						if k%2 == 1 {
+							key = bytes.Clone(keys[r.Intn(len(keys))])
+						}
+						changes.Pairs = append(changes.Pairs, &KVPair{Key: key, Value: randValue(r)})
+					}
+					tree.ApplyChangeSet(changes)
+					_, _, err := tree.SaveVersion(true)
+					require.NoError(b, err)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(tree)
+			}
+			avg := float64(retained) / float64(b.N)
+			b.ReportMetric(avg/(blocks*blockSize), "heap-B/write")
+			if !compact {
+				baseline = avg
+			} else if baseline > 0 {
+				b.ReportMetric(100*(1-avg/baseline), "reduction-%")
+			}
+		})
+	}
+}
+
 type itemT struct {
 	key, value []byte
 }
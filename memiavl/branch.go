@@ -0,0 +1,326 @@
+package memiavl
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/tidwall/btree"
+)
+
+// branchParent is the state a BranchView reads from and merges into.
+type branchParent interface {
+	branchGet(name string, key []byte) []byte
+	branchIterator(name string, start, end []byte, ascending bool) KVIterator
+	branchApply(changeSets []*NamedChangeSet) error
+}
+
+// KVIterator is the iterator interface shared by the tree and the branch view, a subset of dbm.Iterator.
+type KVIterator interface {
+	Valid() bool
+	Next()
+	Key() []byte
+	Value() []byte
+	Close() error
+}
+
+var (
+	_ branchParent = (*MultiTree)(nil)
+	_ branchParent = (*DB)(nil)
+	_ branchParent = (*BranchView)(nil)
+)
+
+// BranchView is an isolated read/write overlay on top of a MultiTree, a DB or another BranchView, the writes are
+// buffered in memory until they are merged into the parent or discarded, it's cheap to create one for each
+// transaction in block-STM style parallel execution.
+//
+// A BranchView is not safe for concurrent use, the views of the same parent can be used concurrently as long as the
+// parent is not modified, so the merges must be done after the concurrent executions, validating the conflicts
+// between the views is the responsibility of the executor.
+type BranchView struct {
+	parent branchParent
+	// the pending writes of each store, the deletions are kept as tombstones to shadow the parent.
+	stores map[string]*btree.BTreeG[*KVPair]
+}
+
+// BranchView creates an isolated overlay on top of the tree, the merged writes are applied to the tree directly.
+func (t *MultiTree) BranchView() *BranchView {
+	return newBranchView(t)
+}
+
+// BranchView creates an isolated overlay on top of the db, the merged writes are applied through ApplyChangeSets,
+// so they are written into the WAL in next Commit call.
+func (db *DB) BranchView() *BranchView {
+	return newBranchView(db)
+}
+
+// BranchView creates a nested overlay, the merged writes are buffered in the current view.
+func (v *BranchView) BranchView() *BranchView {
+	return newBranchView(v)
+}
+
+func newBranchView(parent branchParent) *BranchView {
+	return &BranchView{
+		parent: parent,
+		stores: make(map[string]*btree.BTreeG[*KVPair]),
+	}
+}
+
+func lessKVPair(a, b *KVPair) bool {
+	return bytes.Compare(a.Key, b.Key) < 0
+}
+
+func (v *BranchView) overlay(name string) *btree.BTreeG[*KVPair] {
+	overlay, ok := v.stores[name]
+	if !ok {
+		overlay = btree.NewBTreeGOptions(lessKVPair, btree.Options{NoLocks: true})
+		v.stores[name] = overlay
+	}
+	return overlay
+}
+
+// Get returns the value of the key in the store, returns nil if not found.
+func (v *BranchView) Get(name string, key []byte) []byte {
+	return v.branchGet(name, key)
+}
+
+// Has returns if the key exists in the store.
+func (v *BranchView) Has(name string, key []byte) bool {
+	return v.Get(name, key) != nil
+}
+
+// Set buffers the write in the view, the value must not be nil.
+func (v *BranchView) Set(name string, key, value []byte) {
+	if value == nil {
+		panic("nil value not allowed")
+	}
+	v.overlay(name).Set(&KVPair{Key: bytes.Clone(key), Value: value})
+}
+
+// Delete buffers the deletion in the view.
+func (v *BranchView) Delete(name string, key []byte) {
+	v.overlay(name).Set(&KVPair{Delete: true, Key: bytes.Clone(key)})
+}
+
+// Iterator iterates the store with the writes of the view applied, the end is exclusive.
+func (v *BranchView) Iterator(name string, start, end []byte, ascending bool) KVIterator {
+	return v.branchIterator(name, start, end, ascending)
+}
+
+// ChangeSets returns the pending writes of the view, sorted by the store names and the keys.
+func (v *BranchView) ChangeSets() []*NamedChangeSet {
+	changeSets := make([]*NamedChangeSet, 0, len(v.stores))
+	for name, overlay := range v.stores {
+		if overlay.Len() == 0 {
+			continue
+		}
+		pairs := make([]*KVPair, 0, overlay.Len())
+		overlay.Scan(func(pair *KVPair) bool {
+			pairs = append(pairs, pair)
+			return true
+		})
+		changeSets = append(changeSets, &NamedChangeSet{Name: name, Changeset: ChangeSet{Pairs: pairs}})
+	}
+	sort.Slice(changeSets, func(i, j int) bool {
+		return changeSets[i].Name < changeSets[j].Name
+	})
+	return changeSets
+}
+
+// Merge applies the pending writes to the parent and resets the view.
+func (v *BranchView) Merge() error {
+	if err := v.parent.branchApply(v.ChangeSets()); err != nil {
+		return err
+	}
+	v.Discard()
+	return nil
+}
+
+// Discard drops the pending writes.
+func (v *BranchView) Discard() {
+	v.stores = make(map[string]*btree.BTreeG[*KVPair])
+}
+
+func (v *BranchView) branchGet(name string, key []byte) []byte {
+	if overlay, ok := v.stores[name]; ok {
+		if pair, found := overlay.Get(&KVPair{Key: key}); found {
+			if pair.Delete {
+				return nil
+			}
+			return pair.Value
+		}
+	}
+	return v.parent.branchGet(name, key)
+}
+
+func (v *BranchView) branchIterator(name string, start, end []byte, ascending bool) KVIterator {
+	var pairs []*KVPair
+	if overlay, ok := v.stores[name]; ok {
+		pairs = overlayRange(overlay, start, end, ascending)
+	}
+	return newMergedIterator(v.parent.branchIterator(name, start, end, ascending), pairs, ascending)
+}
+
+func (v *BranchView) branchApply(changeSets []*NamedChangeSet) error {
+	for _, cs := range changeSets {
+		overlay := v.overlay(cs.Name)
+		for _, pair := range cs.Changeset.Pairs {
+			overlay.Set(pair)
+		}
+	}
+	return nil
+}
+
+func (t *MultiTree) branchGet(name string, key []byte) []byte {
+	tree := t.TreeByName(name)
+	if tree == nil {
+		return nil
+	}
+	return tree.Get(key)
+}
+
+func (t *MultiTree) branchIterator(name string, start, end []byte, ascending bool) KVIterator {
+	tree := t.TreeByName(name)
+	if tree == nil {
+		tree = New(0)
+	}
+	return tree.Iterator(start, end, ascending)
+}
+
+func (t *MultiTree) branchApply(changeSets []*NamedChangeSet) error {
+	return t.ApplyChangeSets(changeSets)
+}
+
+func (db *DB) branchGet(name string, key []byte) []byte {
+	tree := db.TreeByName(name)
+	if tree == nil {
+		return nil
+	}
+	return tree.Get(key)
+}
+
+func (db *DB) branchIterator(name string, start, end []byte, ascending bool) KVIterator {
+	tree := db.TreeByName(name)
+	if tree == nil {
+		tree = New(0)
+	}
+	return tree.Iterator(start, end, ascending)
+}
+
+func (db *DB) branchApply(changeSets []*NamedChangeSet) error {
+	return db.ApplyChangeSets(changeSets)
+}
+
+// overlayRange collects the pairs in the range [start, end) in iteration order.
+func overlayRange(overlay *btree.BTreeG[*KVPair], start, end []byte, ascending bool) []*KVPair {
+	var pairs []*KVPair
+	if ascending {
+		iter := func(pair *KVPair) bool {
+			if end != nil && bytes.Compare(pair.Key, end) >= 0 {
+				return false
+			}
+			pairs = append(pairs, pair)
+			return true
+		}
+		if start == nil {
+			overlay.Scan(iter)
+		} else {
+			overlay.Ascend(&KVPair{Key: start}, iter)
+		}
+		return pairs
+	}
+
+	iter := func(pair *KVPair) bool {
+		if end != nil && bytes.Compare(pair.Key, end) >= 0 {
+			// the pivot is inclusive
+			return true
+		}
+		if start != nil && bytes.Compare(pair.Key, start) < 0 {
+			return false
+		}
+		pairs = append(pairs, pair)
+		return true
+	}
+	if end == nil {
+		overlay.Reverse(iter)
+	} else {
+		overlay.Descend(&KVPair{Key: end}, iter)
+	}
+	return pairs
+}
+
+// mergedIterator merges the pending writes of a view with the iterator of the parent.
+type mergedIterator struct {
+	parent    KVIterator
+	pairs     []*KVPair
+	ascending bool
+
+	key, value []byte
+	valid      bool
+}
+
+func newMergedIterator(parent KVIterator, pairs []*KVPair, ascending bool) *mergedIterator {
+	iter := &mergedIterator{
+		parent:    parent,
+		pairs:     pairs,
+		ascending: ascending,
+	}
+	iter.Next()
+	return iter
+}
+
+func (iter *mergedIterator) Valid() bool {
+	return iter.valid
+}
+
+func (iter *mergedIterator) Key() []byte {
+	return iter.key
+}
+
+func (iter *mergedIterator) Value() []byte {
+	return iter.value
+}
+
+func (iter *mergedIterator) Next() {
+	for {
+		parentValid := iter.parent.Valid()
+		if !parentValid && len(iter.pairs) == 0 {
+			iter.valid = false
+			return
+		}
+
+		var cmp int
+		switch {
+		case len(iter.pairs) == 0:
+			cmp = -1
+		case !parentValid:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(iter.parent.Key(), iter.pairs[0].Key)
+			if !iter.ascending {
+				cmp = -cmp
+			}
+		}
+
+		if cmp < 0 {
+			iter.key, iter.value, iter.valid = iter.parent.Key(), iter.parent.Value(), true
+			iter.parent.Next()
+			return
+		}
+
+		// the pending write shadows the parent
+		pair := iter.pairs[0]
+		iter.pairs = iter.pairs[1:]
+		if cmp == 0 {
+			iter.parent.Next()
+		}
+		if pair.Delete {
+			continue
+		}
+		iter.key, iter.value, iter.valid = pair.Key, pair.Value, true
+		return
+	}
+}
+
+func (iter *mergedIterator) Close() error {
+	return iter.parent.Close()
+}
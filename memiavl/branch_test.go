@@ -0,0 +1,65 @@
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectKVIterator(iter KVIterator) []string {
+	var result []string
+	for ; iter.Valid(); iter.Next() {
+		result = append(result, string(iter.Key())+"="+string(iter.Value()))
+	}
+	_ = iter.Close()
+	return result
+}
+
+func TestBranchView(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: []*KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte("3")},
+	}}}}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	view := db.BranchView()
+	view.Set("test", []byte("b"), []byte("20"))
+	view.Delete("test", []byte("c"))
+	view.Set("test", []byte("d"), []byte("4"))
+
+	// the view is isolated from the parent
+	require.Equal(t, []byte("20"), view.Get("test", []byte("b")))
+	require.False(t, view.Has("test", []byte("c")))
+	require.Equal(t, []byte("3"), db.TreeByName("test").Get([]byte("c")))
+
+	require.Equal(t, []string{"a=1", "b=20", "d=4"}, collectKVIterator(view.Iterator("test", nil, nil, true)))
+	require.Equal(t, []string{"d=4", "b=20", "a=1"}, collectKVIterator(view.Iterator("test", nil, nil, false)))
+	require.Equal(t, []string{"b=20"}, collectKVIterator(view.Iterator("test", []byte("b"), []byte("d"), true)))
+	require.Equal(t, []string{"b=20", "a=1"}, collectKVIterator(view.Iterator("test", nil, []byte("c"), false)))
+
+	// the nested view is merged into the parent view only
+	nested := view.BranchView()
+	nested.Set("test", []byte("a"), []byte("10"))
+	require.NoError(t, nested.Merge())
+	require.Equal(t, []byte("10"), view.Get("test", []byte("a")))
+	require.Equal(t, []byte("1"), db.TreeByName("test").Get([]byte("a")))
+
+	discarded := view.BranchView()
+	discarded.Delete("test", []byte("a"))
+	discarded.Discard()
+	require.Empty(t, discarded.ChangeSets())
+
+	require.NoError(t, view.Merge())
+	require.Empty(t, view.ChangeSets())
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	tree := db.TreeByName("test")
+	require.Equal(t, []string{"a=10", "b=20", "d=4"}, collectKVIterator(tree.Iterator(nil, nil, true)))
+}
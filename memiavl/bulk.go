@@ -0,0 +1,186 @@
+package memiavl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// BulkLoad commits the change sets as the first version of an empty db by writing the snapshot files directly,
+// bypassing the in-memory trees and the WAL, it's much faster than `ApplyChangeSets` and `Commit` for the large
+// genesis states. The trees are built bottom-up in the same shape as inserting the sorted keys one by one, so the root
+// hashes are the same as applying the sorted and deduplicated pairs in the normal path, the shape of the AVL tree
+// depends on the insertion order, so it differs from applying the pairs in other orders. The db is reloaded from the
+// new snapshot, the stores not in the change sets are empty.
+func (db *DB) BulkLoad(changeSets []*NamedChangeSet) (int64, error) {
+	v, commitInfo, hooks, err := db.bulkLoad(changeSets)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, hook := range hooks {
+		hook(v, changeSets, commitInfo)
+	}
+	return v, nil
+}
+
+func (db *DB) bulkLoad(changeSets []*NamedChangeSet) (int64, *CommitInfo, []CommitHook, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if db.readOnly {
+		return 0, nil, nil, errReadOnly
+	}
+	if db.closed {
+		return 0, nil, nil, errClosed
+	}
+	if db.MultiTree.Version() != 0 || len(db.pendingLog.Changesets) > 0 {
+		return 0, nil, nil, errors.New("bulk load is only supported on an empty db")
+	}
+
+	pairsByName := make(map[string][]*KVPair, len(changeSets))
+	for _, cs := range changeSets {
+		if db.MultiTree.TreeByName(cs.Name) == nil {
+			return 0, nil, nil, fmt.Errorf("unknown tree name %s", cs.Name)
+		}
+		pairsByName[cs.Name] = append(pairsByName[cs.Name], cs.Changeset.Pairs...)
+	}
+
+	version := nextVersion(0, db.initialVersion)
+	snapshotDir := snapshotName(version)
+	tmpDir := filepath.Join(db.dir, snapshotDir+TmpSuffix)
+	if err := db.writeBulkSnapshot(tmpDir, uint32(version), pairsByName); err != nil {
+		return 0, nil, nil, errors.Join(err, os.RemoveAll(tmpDir))
+	}
+	if err := os.Rename(tmpDir, filepath.Join(db.dir, snapshotDir)); err != nil {
+		return 0, nil, nil, err
+	}
+	if err := updateCurrent(db.dir, snapshotDir); err != nil {
+		return 0, nil, nil, err
+	}
+
+	// the initial upgrades are included in the snapshot
+	db.pendingLog = WALEntry{}
+	if err := db.reload(); err != nil {
+		return 0, nil, nil, err
+	}
+	db.emitCommitMetrics(version)
+
+	commitInfo := *db.MultiTree.LastCommitInfo()
+	return version, &commitInfo, db.commitHooks, nil
+}
+
+// writeBulkSnapshot writes the trees into a new snapshot directory, the pairs are sorted and the last write of each
+// key wins.
+func (db *DB) writeBulkSnapshot(dir string, version uint32, pairsByName map[string][]*KVPair) error {
+	for _, entry := range db.trees {
+		pairs := sortedPairs(pairsByName[entry.Name])
+		if err := writeSnapshot(context.Background(), filepath.Join(dir, entry.Name), version, db.snapshotWriteOptions(), bulkSnapshotSizes(pairs, db.commitmentOnly), func(w *snapshotWriter) (uint32, error) {
+			if len(pairs) == 0 {
+				return 0, nil
+			}
+			if _, err := w.writeSorted(pairs, version); err != nil {
+				return 0, err
+			}
+			return w.leafCounter, nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the first WAL entry is the next version, the same as the imported snapshots
+	return updateMetadataFile(dir, int64(version))
+}
+
+// bulkSnapshotSizes computes the exact file sizes of the snapshot of the sorted pairs.
+func bulkSnapshotSizes(pairs []*KVPair, commitmentOnly bool) snapshotFileSizes {
+	if len(pairs) == 0 {
+		return snapshotFileSizes{}
+	}
+	sizes := snapshotFileSizes{
+		nodes:  int64(len(pairs)-1) * SizeNode,
+		leaves: int64(len(pairs)) * SizeLeaf,
+	}
+	for _, pair := range pairs {
+		valueLen := len(pair.Value)
+		if commitmentOnly {
+			valueLen = sha256.Size
+		}
+		// both the key and value are prefixed with the 4 bytes length
+		sizes.kvs += int64(8 + len(pair.Key) + valueLen)
+	}
+	return sizes
+}
+
+// sortedPairs sorts the pairs by key, only the last write of each key is kept, and the deletions are dropped.
+func sortedPairs(pairs []*KVPair) []*KVPair {
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].Key, pairs[j].Key) < 0
+	})
+
+	result := pairs[:0]
+	for i, pair := range pairs {
+		if i+1 < len(pairs) && bytes.Equal(pair.Key, pairs[i+1].Key) {
+			continue
+		}
+		if !pair.Delete {
+			result = append(result, pair)
+		}
+	}
+	return result
+}
+
+// writeSorted writes the tree of the sorted leaves in post-order, the shape is the same as inserting the keys one by
+// one in order: a perfect subtree of `p` leaves on the left, where `p` is the largest power of two that `3p < 2n`,
+// and the rest of the leaves on the right recursively. It returns a stub node which only carries the height and hash,
+// so the memory usage is bounded by the tree height.
+func (w *snapshotWriter) writeSorted(pairs []*KVPair, version uint32) (*MemNode, error) {
+	if len(pairs) == 1 {
+		pair := pairs[0]
+		hash := newLeafNode(pair.Key, pair.Value, version).Hash()
+		value := pair.Value
+		if w.commitmentOnly {
+			valueHash := sha256.Sum256(value)
+			value = valueHash[:]
+		}
+		if err := w.writeLeaf(version, pair.Key, value, hash); err != nil {
+			return nil, err
+		}
+		return &MemNode{hash: hash}, nil
+	}
+
+	// doubling while `3p < n` stops at the largest `p` that `3p < 2n`
+	p := 1
+	for 3*p < len(pairs) {
+		p *= 2
+	}
+
+	preTrees := uint8(w.leafCounter - w.branchCounter)
+	left, err := w.writeSorted(pairs[:p], version)
+	if err != nil {
+		return nil, err
+	}
+	keyLeaf := w.leafCounter
+	right, err := w.writeSorted(pairs[p:], version)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &MemNode{
+		height:  max(left.height, right.height) + 1,
+		size:    int64(len(pairs)),
+		version: version,
+		left:    left,
+		right:   right,
+	}
+	hash := node.Hash()
+	if err := w.writeBranch(version, uint32(node.size), node.height, preTrees, keyLeaf, hash); err != nil {
+		return nil, err
+	}
+	return &MemNode{height: node.height, hash: hash}, nil
+}
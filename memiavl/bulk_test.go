@@ -0,0 +1,72 @@
+package memiavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 5, 7, 13, 100, 1000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			// random order with duplicated keys and deletions
+			r := rand.New(rand.NewSource(int64(n)))
+			var pairs []*KVPair
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key-%06d", r.Intn(n*2)))
+				pairs = append(pairs, &KVPair{Key: key, Value: []byte(fmt.Sprint(i))})
+				if i%10 == 0 {
+					pairs = append(pairs, &KVPair{Key: key, Delete: true})
+				}
+			}
+			changeSets := []*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: pairs}}}
+
+			ref, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test", "empty"}})
+			require.NoError(t, err)
+			defer ref.Close()
+			// the bulk loaded trees are the same as inserting the sorted keys one by one
+			sorted := sortedPairs(append([]*KVPair(nil), pairs...))
+			require.NoError(t, ref.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: sorted}}}))
+			refVersion, err := ref.Commit()
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test", "empty"}})
+			require.NoError(t, err)
+			var hooked int64
+			db.RegisterCommitHook(func(version int64, _ []*NamedChangeSet, _ *CommitInfo) {
+				hooked = version
+			})
+			v, err := db.BulkLoad(changeSets)
+			require.NoError(t, err)
+			require.Equal(t, refVersion, v)
+			require.Equal(t, v, hooked)
+			require.Equal(t, ref.LastCommitInfo().Hash(), db.LastCommitInfo().Hash())
+			require.Equal(t, ref.TreeByName("test").RootHash(), db.TreeByName("test").RootHash())
+
+			_, err = db.BulkLoad(changeSets)
+			require.Error(t, err)
+
+			// commit on top of the bulk loaded snapshot and reload from the WAL
+			for _, d := range []*DB{ref, db} {
+				require.NoError(t, d.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+				_, err := d.Commit()
+				require.NoError(t, err)
+			}
+			require.NoError(t, db.Close())
+			db, err = Load(dir, Options{})
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, ref.Version(), db.Version())
+			require.Equal(t, ref.LastCommitInfo().Hash(), db.LastCommitInfo().Hash())
+
+			iter := ref.TreeByName("test").Iterator(nil, nil, true)
+			defer iter.Close()
+			for ; iter.Valid(); iter.Next() {
+				require.Equal(t, iter.Value(), db.TreeByName("test").Get(iter.Key()))
+			}
+		})
+	}
+}
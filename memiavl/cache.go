@@ -0,0 +1,415 @@
+package memiavl
+
+import (
+	"bytes"
+	"hash/maphash"
+	"math/bits"
+	"sync"
+)
+
+// Cache is a fixed capacity LRU cache of the key-value pairs, it's thread-safe. The keys are partitioned into the
+// shards by the hashes, each one is an independent LRU cache with its own lock, so the concurrent queries of a hot
+// store contend less, the capacity is split evenly among the shards.
+type Cache struct {
+	seed   maphash.Seed
+	size   int
+	shards []cacheShard
+	// the shard index is the highest bits of the hash, the lowest ones are used by the hash tables of the shards.
+	shift uint
+}
+
+type cacheShard struct {
+	mtx sync.Mutex
+	lruCache
+	// avoid the false sharing of the locks
+	_ [64]byte
+}
+
+// NewCache creates a cache with the max number of entries, returns nil if it's zero.
+func NewCache(cacheSize int) *Cache {
+	return NewShardedCache(cacheSize, 1)
+}
+
+// NewShardedCache creates a cache with the max number of entries split into the shards, the number of shards is
+// rounded up to a power of two, 0 or 1 means a single shard. Returns nil if the cache size is zero.
+func NewShardedCache(cacheSize, shards int) *Cache {
+	if cacheSize == 0 {
+		return nil
+	}
+	return newCache(cacheSize, shards)
+}
+
+func newCache(cacheSize, shards int) *Cache {
+	shardBits := cacheShardBits(shards)
+	shards = 1 << shardBits
+	c := &Cache{
+		seed:   maphash.MakeSeed(),
+		size:   cacheSize,
+		shards: make([]cacheShard, shards),
+		shift:  uint(64 - shardBits),
+	}
+	shardSize := (cacheSize + shards - 1) / shards
+	for i := range c.shards {
+		c.shards[i].size = shardSize
+		c.shards[i].purge()
+	}
+	return c
+}
+
+// cacheShardBits returns the log2 of the number of shards rounded up to a power of two.
+func cacheShardBits(shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	return bits.Len(uint(shards - 1))
+}
+
+// Shards returns the number of shards, 0 if the cache is nil.
+func (c *Cache) Shards() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.shards)
+}
+
+func (c *Cache) shard(hash uint64) *cacheShard {
+	// the shift is 64 for a single shard, which yields 0
+	return &c.shards[hash>>c.shift]
+}
+
+// Len returns the number of the cached entries.
+func (c *Cache) Len() int {
+	var n int
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mtx.Lock()
+		n += shard.len
+		shard.mtx.Unlock()
+	}
+	return n
+}
+
+// Bytes returns the total length of the cached keys and values.
+func (c *Cache) Bytes() uint64 {
+	var n uint64
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mtx.Lock()
+		n += shard.bytes
+		shard.mtx.Unlock()
+	}
+	return n
+}
+
+// Evictions returns the number of the entries evicted to make room for the new ones since the cache is created.
+func (c *Cache) Evictions() uint64 {
+	var n uint64
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mtx.Lock()
+		n += shard.evictions
+		shard.mtx.Unlock()
+	}
+	return n
+}
+
+// Purge removes all the entries, and releases the key buffers.
+func (c *Cache) Purge() {
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mtx.Lock()
+		shard.purge()
+		shard.mtx.Unlock()
+	}
+}
+
+// Get returns the cached value of the key and marks it as the most recently used.
+func (c *Cache) Get(key []byte) ([]byte, bool) {
+	hash := maphash.Bytes(c.seed, key)
+	shard := c.shard(hash)
+	shard.mtx.Lock()
+	value, ok := shard.get(hash, key)
+	shard.mtx.Unlock()
+	return value, ok
+}
+
+// Add inserts or updates the key, the least recently used entry of the shard is evicted if it's full, the key is
+// copied.
+func (c *Cache) Add(key, value []byte) {
+	hash := maphash.Bytes(c.seed, key)
+	shard := c.shard(hash)
+	shard.mtx.Lock()
+	shard.add(hash, key, value)
+	shard.mtx.Unlock()
+}
+
+// Remove deletes the key from the cache if it exists.
+func (c *Cache) Remove(key []byte) {
+	hash := maphash.Bytes(c.seed, key)
+	shard := c.shard(hash)
+	shard.mtx.Lock()
+	shard.remove(hash, key)
+	shard.mtx.Unlock()
+}
+
+// lruCache is a fixed capacity LRU cache, the entries are allocated upfront and the key buffers of the evicted entries
+// are reused, so neither the lookups nor the insertions allocate once the cache is warmed up. The values are not
+// copied, in zero-copy mode they point to the mmap-ed snapshot files. The hashes of the keys are computed by the
+// caller.
+//
+// The entries are allocated on the first insertion, so the caches of the copied trees and the stores never read don't
+// take the memory.
+//
+// It's not thread-safe.
+type lruCache struct {
+	// the max number of entries
+	size    int
+	entries []cacheEntry
+	// open addressing hash table with linear probing, the values are the entry indexes plus one, zero means empty.
+	slots []int32
+	mask  uint64
+
+	// the doubly linked list of the used entries, the most recently used first, -1 means nil.
+	head, tail int32
+	// the singly linked list of the unused entries, linked by `next`.
+	free int32
+	len  int
+	// the total length of the cached keys and values
+	bytes uint64
+	// the number of the least recently used entries evicted, not reset by purge
+	evictions uint64
+}
+
+type cacheEntry struct {
+	hash       uint64
+	key, value []byte
+	prev, next int32
+}
+
+// alloc allocates the entries and the hash table.
+func (c *lruCache) alloc() {
+	// keep the load factor of the hash table under 0.5
+	slots := 1 << bits.Len(uint(c.size*2))
+	c.entries = make([]cacheEntry, c.size)
+	c.slots = make([]int32, slots)
+	c.mask = uint64(slots - 1)
+	c.purge()
+}
+
+func (c *lruCache) purge() {
+	clear(c.slots)
+	c.head, c.tail, c.free = -1, -1, -1
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		c.entries[i] = cacheEntry{next: c.free}
+		c.free = int32(i)
+	}
+	c.len, c.bytes = 0, 0
+}
+
+func (c *lruCache) get(hash uint64, key []byte) ([]byte, bool) {
+	e := c.find(hash, key)
+	if e < 0 {
+		return nil, false
+	}
+	c.moveToFront(e)
+	return c.entries[e].value, true
+}
+
+func (c *lruCache) add(hash uint64, key, value []byte) {
+	if c.entries == nil {
+		if c.size == 0 {
+			return
+		}
+		c.alloc()
+	}
+
+	if e := c.find(hash, key); e >= 0 {
+		c.bytes += uint64(len(value)) - uint64(len(c.entries[e].value))
+		c.entries[e].value = value
+		c.moveToFront(e)
+		return
+	}
+
+	if c.free < 0 {
+		c.removeEntry(c.tail)
+		c.evictions++
+	}
+	e := c.free
+	entry := &c.entries[e]
+	c.free = entry.next
+
+	entry.hash = hash
+	entry.key = append(entry.key[:0], key...)
+	entry.value = value
+
+	i := hash & c.mask
+	for c.slots[i] != 0 {
+		i = (i + 1) & c.mask
+	}
+	c.slots[i] = e + 1
+	c.pushFront(e)
+	c.len++
+	c.bytes += uint64(len(key) + len(value))
+}
+
+func (c *lruCache) remove(hash uint64, key []byte) {
+	if e := c.find(hash, key); e >= 0 {
+		c.removeEntry(e)
+	}
+}
+
+// find returns the entry index of the key, or -1 if not found.
+func (c *lruCache) find(hash uint64, key []byte) int32 {
+	if c.len == 0 {
+		return -1
+	}
+	for i := hash & c.mask; c.slots[i] != 0; i = (i + 1) & c.mask {
+		e := c.slots[i] - 1
+		if c.entries[e].hash == hash && bytes.Equal(c.entries[e].key, key) {
+			return e
+		}
+	}
+	return -1
+}
+
+// removeEntry unlinks the entry and puts it into the free list, the key buffer is kept for reuse.
+func (c *lruCache) removeEntry(e int32) {
+	entry := &c.entries[e]
+
+	i := entry.hash & c.mask
+	for c.slots[i] != e+1 {
+		i = (i + 1) & c.mask
+	}
+	c.deleteSlot(i)
+
+	c.unlink(e)
+	c.bytes -= uint64(len(entry.key) + len(entry.value))
+	entry.key = entry.key[:0]
+	entry.value = nil
+	entry.next = c.free
+	c.free = e
+	c.len--
+}
+
+// deleteSlot clears the slot, and shifts back the following entries of the probe sequence, so the lookups don't
+// need tombstones.
+func (c *lruCache) deleteSlot(i uint64) {
+	for {
+		c.slots[i] = 0
+		j := i
+		for {
+			j = (j + 1) & c.mask
+			if c.slots[j] == 0 {
+				return
+			}
+			// the entry can't be moved if its ideal slot is cyclically in (i, j]
+			k := c.entries[c.slots[j]-1].hash & c.mask
+			if i <= j {
+				if i < k && k <= j {
+					continue
+				}
+			} else if i < k || k <= j {
+				continue
+			}
+			break
+		}
+		c.slots[i] = c.slots[j]
+		i = j
+	}
+}
+
+func (c *lruCache) pushFront(e int32) {
+	entry := &c.entries[e]
+	entry.prev = -1
+	entry.next = c.head
+	if c.head >= 0 {
+		c.entries[c.head].prev = e
+	}
+	c.head = e
+	if c.tail < 0 {
+		c.tail = e
+	}
+}
+
+func (c *lruCache) unlink(e int32) {
+	entry := &c.entries[e]
+	if entry.prev >= 0 {
+		c.entries[entry.prev].next = entry.next
+	} else {
+		c.head = entry.next
+	}
+	if entry.next >= 0 {
+		c.entries[entry.next].prev = entry.prev
+	} else {
+		c.tail = entry.prev
+	}
+}
+
+func (c *lruCache) moveToFront(e int32) {
+	if c.head == e {
+		return
+	}
+	c.unlink(e)
+	c.pushFront(e)
+}
+
+// keyRing records the most recently accessed keys in a ring buffer, the key buffers are reused once it's full.
+type keyRing struct {
+	keys [][]byte
+	next int
+	full bool
+}
+
+func newKeyRing(size int) *keyRing {
+	return &keyRing{keys: make([][]byte, size)}
+}
+
+func (r *keyRing) add(key []byte) {
+	r.keys[r.next] = append(r.keys[r.next][:0], key...)
+	r.next++
+	if r.next == len(r.keys) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// snapshot returns the copies of the keys from the oldest to the newest.
+func (r *keyRing) snapshot() [][]byte {
+	var keys [][]byte
+	if r.full {
+		for _, key := range r.keys[r.next:] {
+			keys = append(keys, bytes.Clone(key))
+		}
+	}
+	for _, key := range r.keys[:r.next] {
+		keys = append(keys, bytes.Clone(key))
+	}
+	return keys
+}
+
+// workingSet records the unique keys read from a tree in a window of blocks, along with the sizes of the key-value
+// pairs, to estimate the cache size to hold them. The keys are tracked by the hashes to bound the memory, the rare
+// collisions only make the estimate slightly smaller.
+type workingSet struct {
+	seed  maphash.Seed
+	sizes map[uint64]uint32
+}
+
+func newWorkingSet() *workingSet {
+	return &workingSet{seed: maphash.MakeSeed(), sizes: make(map[uint64]uint32)}
+}
+
+func (w *workingSet) add(key, value []byte) {
+	w.sizes[maphash.Bytes(w.seed, key)] = uint32(len(key) + len(value))
+}
+
+// reset returns the number of the unique keys and the total bytes of them recorded since the last call.
+func (w *workingSet) reset() (int, uint64) {
+	var bytes uint64
+	for _, size := range w.sizes {
+		bytes += uint64(size)
+	}
+	keys := len(w.sizes)
+	clear(w.sizes)
+	return keys, bytes
+}
@@ -0,0 +1,129 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// MaxKeyLength is the maximum key length the snapshot files can encode.
+	MaxKeyLength = math.MaxUint32
+	// MaxValueLength is the maximum value length the snapshot files can encode, the larger lengths collide with the
+	// blob reference flag.
+	MaxValueLength = blobRefFlag - 1
+)
+
+// ErrInvalidChangeSet is wrapped by the errors of the change set and WAL entry validations.
+var ErrInvalidChangeSet = errors.New("invalid change set")
+
+// Validate checks the change set is well-formed, the pairs must not be nil, the keys must not be empty, the lengths
+// must fit in the snapshot files, and the deletions must not carry values. The keys could be repeated, the pairs are
+// applied in order, the same as they are applied to the tree when written.
+func (cs *ChangeSet) Validate() error {
+	if err := cs.validateStructure(); err != nil {
+		return err
+	}
+	for i, pair := range cs.Pairs {
+		if len(pair.Key) == 0 {
+			return fmt.Errorf("%w: pair %d has empty key", ErrInvalidChangeSet, i)
+		}
+		if pair.Delete && len(pair.Value) > 0 {
+			return fmt.Errorf("%w: pair %d deletes key %X with a value", ErrInvalidChangeSet, i, pair.Key)
+		}
+	}
+	return nil
+}
+
+// validateStructure only checks the pairs are not nil and the lengths fit in the snapshot files, it's used for the
+// local WAL files, which are written by the node itself.
+func (cs *ChangeSet) validateStructure() error {
+	for i, pair := range cs.Pairs {
+		if pair == nil {
+			return fmt.Errorf("%w: pair %d is nil", ErrInvalidChangeSet, i)
+		}
+		if uint64(len(pair.Key)) > MaxKeyLength {
+			return fmt.Errorf("%w: pair %d key length %d exceeds %d", ErrInvalidChangeSet, i, len(pair.Key), uint64(MaxKeyLength))
+		}
+		if !pair.Delete && uint64(len(pair.Value)) > MaxValueLength {
+			return fmt.Errorf("%w: pair %d value length %d exceeds %d", ErrInvalidChangeSet, i, len(pair.Value), MaxValueLength)
+		}
+	}
+	return nil
+}
+
+// Validate checks the change sets and the upgrades of the entry are well-formed, the store names must not be empty.
+func (m *WALEntry) Validate() error {
+	return m.validate(false)
+}
+
+// validateStructure is the lenient version of `Validate` for the local WAL files, see `ChangeSet.validateStructure`.
+func (m *WALEntry) validateStructure() error {
+	return m.validate(true)
+}
+
+func (m *WALEntry) validate(structureOnly bool) error {
+	for i, cs := range m.Changesets {
+		if cs == nil {
+			return fmt.Errorf("%w: change set %d is nil", ErrInvalidChangeSet, i)
+		}
+		if len(cs.Name) == 0 {
+			return fmt.Errorf("%w: change set %d has empty store name", ErrInvalidChangeSet, i)
+		}
+		validate := cs.Changeset.Validate
+		if structureOnly {
+			validate = cs.Changeset.validateStructure
+		}
+		if err := validate(); err != nil {
+			return fmt.Errorf("store %s: %w", cs.Name, err)
+		}
+	}
+	for i, upgrade := range m.Upgrades {
+		if upgrade == nil {
+			return fmt.Errorf("%w: upgrade %d is nil", ErrInvalidChangeSet, i)
+		}
+		if len(upgrade.Name) == 0 {
+			return fmt.Errorf("%w: upgrade %d has empty tree name", ErrInvalidChangeSet, i)
+		}
+		if upgrade.Delete && len(upgrade.RenameFrom) > 0 {
+			return fmt.Errorf("%w: upgrade %d both deletes and renames tree %s", ErrInvalidChangeSet, i, upgrade.Name)
+		}
+	}
+	return nil
+}
+
+// DecodeChangeSet decodes and validates a change set from untrusted input.
+func DecodeChangeSet(bz []byte) (*ChangeSet, error) {
+	var cs ChangeSet
+	if err := cs.Unmarshal(bz); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChangeSet, err)
+	}
+	if err := cs.Validate(); err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+// DecodeWALEntry decodes and validates a WAL entry from untrusted input, like the network or the archives.
+func DecodeWALEntry(bz []byte) (*WALEntry, error) {
+	var entry WALEntry
+	if err := entry.Unmarshal(bz); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChangeSet, err)
+	}
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// decodeLocalWALEntry decodes a WAL entry from the local WAL files, only the structure is validated.
+func decodeLocalWALEntry(bz []byte) (*WALEntry, error) {
+	var entry WALEntry
+	if err := entry.Unmarshal(bz); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChangeSet, err)
+	}
+	if err := entry.validateStructure(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,197 @@
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeSetValidate(t *testing.T) {
+	for _, changes := range ChangeSets {
+		require.NoError(t, changes.Validate())
+	}
+
+	// the repeated keys are applied in order
+	cs := ChangeSet{Pairs: []*KVPair{{Key: []byte("hello"), Value: []byte("world")}, {Key: []byte("hello"), Delete: true}}}
+	require.NoError(t, cs.Validate())
+
+	testCases := []struct {
+		name  string
+		pairs []*KVPair
+		err   string
+	}{
+		{"nil pair", []*KVPair{{Key: []byte("hello"), Value: []byte("world")}, nil}, "pair 1 is nil"},
+		{"empty key", []*KVPair{{Value: []byte("world")}}, "pair 0 has empty key"},
+		{"delete with value", []*KVPair{{Key: []byte("hello"), Value: []byte("world"), Delete: true}}, "with a value"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := ChangeSet{Pairs: tc.pairs}
+			err := cs.Validate()
+			require.ErrorIs(t, err, ErrInvalidChangeSet)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestDecodeWALEntry(t *testing.T) {
+	entry := WALEntry{
+		Changesets: mockNameChangeSet("test", "hello", "world"),
+		Upgrades:   []*TreeNameUpgrade{{Name: "new"}, {Name: "renamed", RenameFrom: "old"}},
+	}
+	bz, err := entry.Marshal()
+	require.NoError(t, err)
+	decoded, err := DecodeWALEntry(bz)
+	require.NoError(t, err)
+	require.Equal(t, entry, *decoded)
+
+	// truncated input
+	_, err = DecodeWALEntry(bz[:len(bz)-1])
+	require.ErrorIs(t, err, ErrInvalidChangeSet)
+
+	// the length of the first field overflows the input
+	_, err = DecodeWALEntry([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	require.ErrorIs(t, err, ErrInvalidChangeSet)
+
+	testCases := []struct {
+		name  string
+		entry WALEntry
+		err   string
+	}{
+		{"empty store name", WALEntry{Changesets: mockNameChangeSet("", "hello", "world")}, "empty store name"},
+		{
+			"invalid pair",
+			WALEntry{Changesets: []*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: []*KVPair{{Value: []byte("world")}}}}}},
+			"store test: invalid change set: pair 0 has empty key",
+		},
+		{"empty tree name", WALEntry{Upgrades: []*TreeNameUpgrade{{RenameFrom: "old"}}}, "empty tree name"},
+		{"delete and rename", WALEntry{Upgrades: []*TreeNameUpgrade{{Name: "new", RenameFrom: "old", Delete: true}}}, "both deletes and renames"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bz, err := tc.entry.Marshal()
+			require.NoError(t, err)
+			_, err = DecodeWALEntry(bz)
+			require.ErrorIs(t, err, ErrInvalidChangeSet)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func FuzzDecodeWALEntry(f *testing.F) {
+	for _, changes := range ChangeSets {
+		entry := WALEntry{Changesets: []*NamedChangeSet{{Name: "test", Changeset: changes}}}
+		bz, err := entry.Marshal()
+		require.NoError(f, err)
+		f.Add(bz)
+	}
+
+	f.Fuzz(func(t *testing.T, bz []byte) {
+		entry, err := DecodeWALEntry(bz)
+		if err != nil {
+			require.ErrorIs(t, err, ErrInvalidChangeSet)
+			return
+		}
+
+		// the valid entries can be applied
+		tree := New(0)
+		for _, cs := range entry.Changesets {
+			tree.ApplyChangeSet(cs.Changeset)
+		}
+	})
+}
+
+func TestRewriteKeyInVersion(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSet("test", ChangeSet{Pairs: mockKVPairs("a", "1", "b", "2", "c", "3", "d", "4", "e", "5")}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	// the deletion and the re-insertion of a key could change the shape of the tree, compared to an update in place,
+	// so the WAL entry must keep all the writes in order.
+	writes := [][]*KVPair{
+		{{Key: []byte("b"), Delete: true}, {Key: []byte("f"), Value: []byte("6")}},
+		{{Key: []byte("b"), Value: []byte("7")}, {Key: []byte("d"), Value: []byte("8")}},
+		{{Key: []byte("d"), Delete: true}},
+	}
+	var pairs []*KVPair
+	for _, w := range writes {
+		require.NoError(t, db.ApplyChangeSet("test", ChangeSet{Pairs: w}))
+		pairs = append(pairs, w...)
+	}
+	require.Len(t, db.pendingLog.Changesets, 1)
+	require.Equal(t, pairs, db.pendingLog.Changesets[0].Changeset.Pairs)
+	require.NoError(t, db.pendingLog.Validate())
+	_, err = db.Commit()
+	require.NoError(t, err)
+	hash := db.TreeByName("test").RootHash()
+	appHash := db.LastCommitInfo().Hash()
+	require.NoError(t, db.Close())
+
+	// replay the WAL
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, int64(2), db.Version())
+	require.Equal(t, hash, db.TreeByName("test").RootHash())
+	require.Equal(t, appHash, db.LastCommitInfo().Hash())
+	require.Equal(t, []byte("7"), db.TreeByName("test").Get([]byte("b")))
+	require.Nil(t, db.TreeByName("test").Get([]byte("d")))
+}
+
+func TestReplayRepeatedKeysWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSet("test", ChangeSet{Pairs: mockKVPairs("hello", "world")}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// the rewrites of a version are merged into a single change set
+	pairs := []*KVPair{
+		{Key: []byte("hello"), Value: []byte("world1")},
+		{Key: []byte("hello1"), Value: []byte("world1")},
+		{Key: []byte("hello"), Delete: true},
+		{Key: []byte("hello2"), Value: []byte("world2")},
+		{Key: []byte("hello1"), Value: []byte("world2")},
+	}
+	entry := WALEntry{Changesets: []*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: pairs}}}}
+	bz, err := entry.Marshal()
+	require.NoError(t, err)
+	decoded, err := DecodeWALEntry(bz)
+	require.NoError(t, err)
+	require.Equal(t, entry, *decoded)
+
+	wal, err := OpenWAL(walPath(dir), nil)
+	require.NoError(t, err)
+	lastIndex, err := wal.LastIndex()
+	require.NoError(t, err)
+	require.NoError(t, wal.Write(lastIndex+1, bz))
+	require.NoError(t, wal.Close())
+
+	ref := New(0)
+	ref.ApplyChangeSet(ChangeSet{Pairs: mockKVPairs("hello", "world")})
+	_, _, err = ref.SaveVersion(true)
+	require.NoError(t, err)
+	ref.ApplyChangeSet(ChangeSet{Pairs: pairs})
+	refHash, _, err := ref.SaveVersion(true)
+	require.NoError(t, err)
+
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, int64(2), db.Version())
+	require.Equal(t, refHash, db.TreeByName("test").RootHash())
+	require.Nil(t, db.TreeByName("test").Get([]byte("hello")))
+	require.Equal(t, []byte("world2"), db.TreeByName("test").Get([]byte("hello1")))
+
+	require.NoError(t, IterateWAL(dir, 2, 0, func(version int64, entry *WALEntry) (bool, error) {
+		require.NoError(t, entry.Validate())
+		require.Len(t, entry.Changesets, 1)
+		require.Equal(t, pairs, entry.Changesets[0].Changeset.Pairs)
+		return true, nil
+	}))
+}
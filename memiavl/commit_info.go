@@ -0,0 +1,59 @@
+package memiavl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+const (
+	leafPrefix  byte = 0
+	innerPrefix byte = 1
+)
+
+// Hash returns the app hash of the commit info, it's the same as the `CommitInfo.Hash` of cosmos-sdk root multistore,
+// the root of the simple merkle tree of the store hashes sorted by name, the stores not managed by memiavl are not
+// included.
+func (ci *CommitInfo) Hash() []byte {
+	infos := make([]StoreInfo, len(ci.StoreInfos))
+	copy(infos, ci.StoreInfos)
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	leaves := make([][]byte, len(infos))
+	for i, info := range infos {
+		valueHash := sha256.Sum256(info.CommitId.Hash)
+		leaves[i] = encodeKVPair([]byte(info.Name), valueHash[:])
+	}
+	return hashFromByteSlices(leaves)
+}
+
+// encodeKVPair encodes the key and value with the uvarint length prefixes.
+func encodeKVPair(key, value []byte) []byte {
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(key)+len(value))
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// hashFromByteSlices computes the RFC-6962 merkle root of the items, the same as cometbft's `merkle.HashFromByteSlices`.
+func hashFromByteSlices(items [][]byte) []byte {
+	switch len(items) {
+	case 0:
+		emptyHash := sha256.Sum256(nil)
+		return emptyHash[:]
+	case 1:
+		leafHash := sha256.Sum256(append([]byte{leafPrefix}, items[0]...))
+		return leafHash[:]
+	default:
+		// the largest power of 2 less than the length
+		k := 1 << (bits.Len(uint(len(items)-1)) - 1)
+		left := hashFromByteSlices(items[:k])
+		right := hashFromByteSlices(items[k:])
+		innerHash := sha256.Sum256(append(append([]byte{innerPrefix}, left...), right...))
+		return innerHash[:]
+	}
+}
@@ -0,0 +1,135 @@
+package memiavl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// CurrentFileName is the file holding the name of the current snapshot, it replaces the `current` symlink on windows,
+// where creating the symlinks requires the privileges.
+const CurrentFileName = "CURRENT"
+
+// useCurrentFile is whether the current snapshot is pointed by the `CURRENT` file rather than the symlink, the other
+// one is still read as a fallback, so a db copied from another platform could be opened by the tools.
+var useCurrentFile = runtime.GOOS == "windows"
+
+func currentPath(root string) string {
+	return filepath.Join(root, "current")
+}
+
+func currentTmpPath(root string) string {
+	return filepath.Join(root, "current-tmp")
+}
+
+func currentFilePath(root string) string {
+	return filepath.Join(root, CurrentFileName)
+}
+
+func currentFileTmpPath(root string) string {
+	return filepath.Join(root, CurrentFileName+TmpSuffix)
+}
+
+// CurrentSnapshot returns the name of the current snapshot directory, from the `current` symlink or the `CURRENT`
+// file, the error satisfies `os.IsNotExist` if neither exists.
+func CurrentSnapshot(root string) (string, error) {
+	readLink := func() (string, error) { return os.Readlink(currentPath(root)) }
+	readFile := func() (string, error) {
+		bz, err := os.ReadFile(currentFilePath(root))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(bz)), nil
+	}
+	read, fallback := readLink, readFile
+	if useCurrentFile {
+		read, fallback = readFile, readLink
+	}
+
+	name, err := read()
+	if errors.Is(err, os.ErrNotExist) {
+		if name, err2 := fallback(); err2 == nil {
+			return name, nil
+		}
+	}
+	return name, err
+}
+
+// currentSnapshotPath returns the path of the current snapshot directory.
+func currentSnapshotPath(root string) (string, error) {
+	name, err := CurrentSnapshot(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, name), nil
+}
+
+// readCurrentMetadata reads the metadata of the current snapshot.
+func readCurrentMetadata(root string) (*MultiTreeMetadata, error) {
+	path, err := currentSnapshotPath(root)
+	if err != nil {
+		return nil, err
+	}
+	return readMetadata(path)
+}
+
+func currentVersion(root string) (int64, error) {
+	name, err := CurrentSnapshot(root)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := parseVersion(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// updateCurrent points the current snapshot to the new one atomically, with the symlink, or the `CURRENT` file on
+// windows. It could fail under concurrent usage for tmp file conflicts.
+// The directory is synced after the swap, which also persists the renaming of the new snapshot directory before it,
+// so the pointer never points to a snapshot lost in a power failure.
+func updateCurrent(dir, snapshot string) error {
+	if useCurrentFile {
+		return updateCurrentFile(dir, snapshot)
+	}
+	return updateCurrentSymlink(dir, snapshot)
+}
+
+// updateCurrentSymlink creates or replace the current symblic link atomically.
+func updateCurrentSymlink(dir, snapshot string) error {
+	tmpPath := currentTmpPath(dir)
+	if err := os.Symlink(snapshot, tmpPath); err != nil {
+		return err
+	}
+	// assuming file renaming operation is atomic
+	if err := os.Rename(tmpPath, currentPath(dir)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// updateCurrentFile writes the snapshot name into a tmp file and renames it to `CURRENT`, the content is synced before
+// the rename, so the file is never seen partially written.
+func updateCurrentFile(dir, snapshot string) error {
+	tmpPath := currentFileTmpPath(dir)
+	fp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fp.WriteString(snapshot + "\n")
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err = errors.Join(err, fp.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, currentFilePath(dir)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
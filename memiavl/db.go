@@ -4,16 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alitto/pond"
 	"github.com/tidwall/wal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -21,9 +28,20 @@ const (
 	LockFileName               = "LOCK"
 	DefaultSnapshotWriterLimit = 4
 	TmpSuffix                  = "-tmp"
+
+	// DefaultWALReplayRate is the WAL replay throughput in bytes per second assumed by `SnapshotMaxReplayTime`, if
+	// too little WAL is replayed on startup to measure it.
+	DefaultWALReplayRate = 16 << 20
+	// minReplayBytesToMeasure is the min size of the WAL replayed on startup to measure the replay throughput.
+	minReplayBytesToMeasure = 1 << 20
 )
 
-var errReadOnly = errors.New("db is read-only")
+var (
+	errReadOnly = errors.New("db is read-only")
+	errClosed   = errors.New("db is closed")
+
+	errCommitmentOnly = errors.New("the snapshot is commitment-only, the values are not available")
+)
 
 // DB implements DB-like functionalities on top of MultiTree:
 // - async snapshot rewriting
@@ -40,14 +58,21 @@ var errReadOnly = errors.New("db is read-only")
 // >  acc
 // >  ... other stores
 // > wal
+// > upgrades.jsonl
 // ```
 type DB struct {
 	MultiTree
 	dir      string
 	logger   Logger
+	metrics  Metrics
 	fileLock FileLock
 	readOnly bool
 
+	// the loggers of the subsystems, filtered by their log levels
+	walLogger, snapshotLogger, pruneLogger, commitLogger Logger
+	// the latency distributions of the commits, exported by the prometheus collectors
+	histograms *commitHistograms
+
 	// result channel of snapshot rewrite goroutine
 	snapshotRewriteChan chan snapshotResult
 	// context cancel function to cancel the snapshot rewrite goroutine
@@ -60,26 +85,118 @@ type DB struct {
 	// make sure only one snapshot rewrite is running
 	pruneSnapshotLock      sync.Mutex
 	triggerStateSyncExport func(height int64)
+	// the versions protected from the pruning
+	pins versionPins
 
 	// invariant: the LastIndex always match the current version of MultiTree
 	wal         *wal.Log
 	walChanSize int
 	walChan     chan *walEntry
 	walQuit     chan error
+	// wait for the WAL entry of the previous version to be persisted before the next commit
+	waitDurableBeforeCommit bool
+
+	// the last WAL index persisted by the async commit goroutine, and the error it exits with,
+	// protected by durableCond.L.
+	durableCond  *sync.Cond
+	durableIndex uint64
+	durableErr   error
 
 	// pending changes, will be written into WAL in next Commit call
 	pendingLog WALEntry
 
+	// the hooks called after each Commit
+	commitHooks []CommitHook
+
+	// the db is closed, the commits are rejected
+	closed bool
+
+	// the rewritten snapshots store the value hashes only, the values are served by an external storage
+	commitmentOnly bool
+
+	// the access pattern advices of the snapshot files
+	nodesMmapAdvice, kvsMmapAdvice MmapAdvice
+	hugePageNodes                  bool
+
+	// tune the writing of the snapshot files
+	writeBufferSize int
+	preallocate     bool
+	blobThreshold   int
+	prefixKeys      bool
+
+	// the memory usage to trigger the snapshot rewrite ahead of schedule, 0 means disabled
+	memoryBudget uint64
+
+	// the free disk space below which the snapshot rewrites are refused, 0 means disabled
+	minFreeDiskBytes uint64
+	lowDiskSpace     atomic.Bool
+
+	// the WAL lag to alert in async commit mode, 0 means disabled
+	walLagThreshold uint64
+	walLagExceeded  atomic.Bool
+
+	// the estimated WAL replay time to trigger the snapshot rewrite, 0 means the fixed snapshot interval is used
+	maxReplayTime time.Duration
+	// the WAL replay throughput in bytes per second
+	replayRate float64
+	// the size of the WAL entries after the current snapshot, and the part of it covered by the in-flight rewrite
+	walBytes, rewriteWALBytes uint64
+
+	// the duration without progress to cancel the background rewrite, 0 means disabled, and whether to retry the
+	// cancelled rewrite on the next commit
+	rewriteStallTimeout time.Duration
+	retryStalledRewrite bool
+	rewriteRetry        atomic.Bool
+
+	// the number of blocks in each window of the cache advisor, 0 means disabled
+	cacheAdvisorWindow uint32
+	// the working sets of the stores in the current window, keyed by the store names
+	workingSets map[string]*workingSet
+	// the read statistics of the stores, nil if disabled
+	queryStats map[string]*queryStats
+
 	// The assumptions to concurrency:
-	// - The methods on DB are protected by a mutex
+	// - The methods on DB are protected by a mutex, except the read accessors `TreeByName`, `Version` and
+	//   `LastCommitInfo`, which load the view published after each change.
 	// - Each call of Load loads a separate instance, in query scenarios,
 	//   it should be immutable, the cache stores will handle the temporary writes.
 	// - The DB for the state machine will handle writes through the Commit call,
 	//   this method is the sole entry point for tree modifications, and there's no concurrency internally
 	//   (the background snapshot rewrite is handled separately), so we don't need locks in the Tree.
 	mtx sync.Mutex
+	// the view of the trees and the last commit info, replaced under the mutex, loaded without it.
+	view atomic.Pointer[dbView]
+	// the references of the current trees, replaced along with them on the snapshot switch.
+	treesRef *treesRef
 	// worker goroutine IdleTimeout = 5s
 	snapshotWriterPool *pond.WorkerPool
+	// bounds the number of trees written in parallel, adjustable at runtime
+	snapshotWriterLimiter *writerLimiter
+
+	// read by the prometheus collector without locking
+	commits   atomic.Uint64
+	rewriting atomic.Bool
+	// serves the prometheus metrics, nil if disabled
+	metricsServer *http.Server
+	// records the inputs to the trace file, nil if disabled
+	trace *traceRecorder
+	// stops the goroutine writing the status file, nil if disabled
+	stopStatusWriter func()
+
+	// the durations of the phases of the pending commit, and the thresholds to report the slow commits
+	phases     commitPhases
+	slowCommit slowCommitConfig
+
+	// the status of the background tasks, read by `Health` without the db lock
+	health *healthTracker
+	// the progress of the background snapshot rewrite, shared with the copy writing the snapshot
+	rewriteProgress *rewriteProgress
+	// the number of the WAL entries queued or being written in async commit mode, updated atomically
+	walQueued int64
+
+	// receives the snapshot lifecycle events, nil if disabled
+	snapshotEvents   SnapshotEventHandler
+	snapshotEventLog *snapshotEventLog
 
 	// reusable write batch
 	wbatch wal.Batch
@@ -87,9 +204,13 @@ type DB struct {
 
 type Options struct {
 	Logger          Logger
+	Metrics         Metrics
 	CreateIfMissing bool
 	InitialVersion  uint32
 	ReadOnly        bool
+	// NoLock opens the read-only db without the shared lock, for the long-lived readers alongside a writer, like the
+	// historical queries and the query server, so they never keep the writer from (re)starting.
+	NoLock bool
 	// the initial stores when initialize the empty instance
 	InitialStores          []string
 	SnapshotKeepRecent     uint32
@@ -100,16 +221,140 @@ type Options struct {
 	// Buffer size for the asynchronous commit queue, -1 means synchronous commit,
 	// default to 0.
 	AsyncCommitBuffer int
+	// WaitDurableBeforeCommit if true, in async commit mode, Commit waits for the WAL entry of the previous version
+	// to be persisted before committing the next one, so the WAL writing of a block overlaps with the execution of
+	// the next block, and at most one committed version is not persisted on crash.
+	WaitDurableBeforeCommit bool
 	// ZeroCopy if true, the get and iterator methods could return a slice pointing to mmaped blob files.
 	ZeroCopy bool
 	// CacheSize defines the cache's max entry size for each memiavl store.
 	CacheSize int
+	// CacheShards is the number of shards of the cache of each store, the keys are partitioned by the hashes and each
+	// shard has its own lock, so the concurrent queries of a hot store contend less, the cache size is split evenly
+	// among them. It's rounded up to a power of two, 0 or 1 means a single shard.
+	CacheShards int
 	// LoadForOverwriting if true rollbacks the state, specifically the Load method will
 	// truncate the versions after the `TargetVersion`, the `TargetVersion` becomes the latest version.
 	// it do nothing if the target version is `0`.
 	LoadForOverwriting bool
+	// CommitmentOnly if true, the rewritten snapshots store the sha256 hashes of the values instead of the values, the
+	// root hashes are not changed, the caller is responsible to serve the values from another storage, like versiondb.
+	// The reads of the trees return the value hashes for the keys persisted in snapshots.
+	CommitmentOnly bool
 
+	// SnapshotWriterLimit is the max number of trees written in parallel by the snapshot rewrites, it could be changed
+	// at runtime with `DB.SetSnapshotWriterLimit`.
 	SnapshotWriterLimit int
+	// SnapshotWriterPool if not nil, the snapshots are written with the pool instead of a new one created with
+	// `SnapshotWriterLimit`, so the processes opening multiple dbs can share it to bound the concurrency, the pool is
+	// owned by the caller.
+	SnapshotWriterPool *pond.WorkerPool
+	// NodesMmapAdvice and KVsMmapAdvice set the expected access patterns of the mmap-ed snapshot files, the nodes
+	// advice applies to the branch and leaf nodes files, the kvs advice applies to the key-value pairs file, the files
+	// are mmap-ed with `MADV_RANDOM` by default.
+	NodesMmapAdvice MmapAdvice
+	KVsMmapAdvice   MmapAdvice
+	// PrefaultNodes if true, the nodes files are loaded into the page cache on startup, it avoids the page faults of
+	// the first blocks, at the cost of the startup time.
+	PrefaultNodes bool
+	// HugePageNodes if true, the nodes files are mmap-ed with the `MADV_HUGEPAGE` advice on linux, so they could be
+	// backed by the transparent huge pages, which reduces the TLB misses of the random tree traversals on a very large
+	// state. It requires the kernel support of the huge pages for the page cache, otherwise it has no effect.
+	HugePageNodes bool
+	// MetricsAddress if not empty, the prometheus metrics of the db internals are served at `/metrics` on the address,
+	// independent of the telemetry of the host application, use `NewPrometheusCollector` to register them into an
+	// existing registry instead. It's ignored in read-only mode.
+	MetricsAddress string
+	// TraceFile if not empty, the inputs of the db, the change sets, the upgrades and the commits, are appended to the
+	// trace file, so the exact sequence can be reproduced with `ReplayTrace` on a copy of the db, to debug the
+	// non-deterministic app hash bugs. It's expensive, only enable it for debugging.
+	TraceFile string
+	// StatusInterval if positive, the status of the db, the version, the snapshots, the WAL range, the background tasks
+	// and the disk usage, is written into the `status.json` file in the db directory every interval, for the monitoring
+	// agents without rpc access. It's ignored in read-only mode.
+	StatusInterval time.Duration
+	// QueryMetrics if true, the gets and iterators of each store and their latencies are reported through the metrics
+	// and the prometheus collector, to find out which module's queries dominate the load, it adds a clock read per read.
+	QueryMetrics bool
+	// LogLevels are the log levels of the subsystems, the logs of all of them are passed to the logger by default.
+	LogLevels LogLevels
+	// MinFreeDiskBytes if positive, the snapshot rewrites are refused when the free space of the file system is below
+	// it, and an error is logged once the free space drops below it after a commit, so the operators are alerted
+	// before the disk actually fills. It's only supported on linux and macos.
+	MinFreeDiskBytes uint64
+	// WALLagAlertThreshold if positive, a warning is logged once the number of the committed versions not persisted
+	// yet exceeds it in async commit mode, and the db is reported unhealthy until the lag recovers, so the crash
+	// exposure is bounded by alerting, 0 means disabled.
+	WALLagAlertThreshold uint64
+	// OnCriticalError if not nil, is called with the failures of the WAL writing, the background snapshot rewrite and
+	// the pruning, so the operators could be paged rather than discovering them in the logs.
+	OnCriticalError CriticalErrorHandler
+	// SlowCommitThreshold and SlowCommitPhaseThreshold if positive, the commits taking longer than the threshold, or
+	// having any phase taking longer than the phase threshold, are logged with the durations of the phases and the
+	// store with the largest change set, 0 means disabled.
+	SlowCommitThreshold      time.Duration
+	SlowCommitPhaseThreshold time.Duration
+	// SlowCommitHandler if not nil, it's called with the slow commits.
+	SlowCommitHandler SlowCommitHandler
+	// SnapshotEventLog if not empty, the snapshot lifecycle events are appended to the JSONL file at the path, like
+	// the started and finished rewrites, the pruned snapshots and the WAL truncations, so the external automation could
+	// react to them.
+	SnapshotEventLog string
+	// SnapshotEventHandler if not nil, it's called with the snapshot lifecycle events, along with the event log.
+	SnapshotEventHandler SnapshotEventHandler
+	// NodeArena if true, the new nodes created by the WAL replay and the block execution are allocated in chunks, which
+	// reduces the GC pressure, the replaced nodes are only reclaimed after the next snapshot switch, so it trades some
+	// memory for the shorter GC pauses.
+	NodeArena bool
+	// CompactNodes if true, the in-memory leaves created by the WAL replay and the block execution don't retain their
+	// hashes, they are recomputed when the parents are rehashed or the snapshot is rewritten, and the new keys share
+	// the prefixes with the neighbor keys, it trades some CPU for the lower memory usage between the snapshot rewrites.
+	CompactNodes bool
+	// HashConcurrency is the max number of goroutines to compute the root hash of each tree in commit, the dirty
+	// subtrees are hashed in parallel, the result is the same as the sequential one, 0 or 1 means sequential.
+	HashConcurrency int
+	// MemoryBudgetBytes if not zero, when the approximate memory usage of the in-memory nodes created since the last
+	// snapshot plus the caches exceeds it, a snapshot rewrite is triggered ahead of the snapshot interval, if one is
+	// already in progress, the caches of the cold stores are purged, so the nodes with small RAM don't OOM between
+	// the scheduled rewrites.
+	MemoryBudgetBytes uint64
+	// CachePrewarmSize is the number of the most recently accessed keys recorded for each store, they are loaded into
+	// the caches of the new trees in the background snapshot rewrite before switching, so the queries don't hit the
+	// cold caches after the switch, 0 means disabled, it only works if `CacheSize` is not zero.
+	CachePrewarmSize int
+	// SnapshotWriteBufferSize is the write buffer size of each snapshot file, default to 4KiB if zero, the larger
+	// buffers reduce the number of the syscalls when rewriting the large snapshots.
+	SnapshotWriteBufferSize int
+	// PreallocateSnapshot if true, the snapshot files are preallocated to their estimated sizes with `fallocate`
+	// before writing, which reduces the file system fragmentation, it's only supported on linux.
+	PreallocateSnapshot bool
+	// SnapshotMaxReplayTime if not zero, the snapshot rewrite is triggered when the estimated time to replay the WAL
+	// after the current snapshot exceeds it, instead of every `SnapshotInterval` blocks, so the restart time stays
+	// roughly the same regardless of the traffic. The estimate is the size of the WAL entries divided by the replay
+	// throughput measured on startup, or `DefaultWALReplayRate` if too little is replayed to measure it.
+	SnapshotMaxReplayTime time.Duration
+	// RewriteStallTimeout if positive, the background snapshot rewrite is cancelled if no leaves are written for the
+	// duration, with the diagnostics logged, so a stuck rewrite don't block the future ones forever, it should be well
+	// above the time to write `CancelCheckInterval` leaves, including the waiting for the snapshot writer limit.
+	RewriteStallTimeout time.Duration
+	// RetryStalledRewrite if true, the rewrite cancelled by `RewriteStallTimeout` is retried on the next commit,
+	// otherwise on the next scheduled one.
+	RetryStalledRewrite bool
+	// WALReplayConcurrency is the max number of stores to apply the change sets of a WAL entry concurrently when
+	// catching up the WAL on startup, after the background snapshot rewrite, and in the read-only standby, the stores
+	// are independent, 0 or 1 means sequential.
+	WALReplayConcurrency int
+	// CacheAdvisorWindow if not zero, the unique keys read from each store are recorded, and every
+	// `CacheAdvisorWindow` blocks, the size of the working set and the recommended `CacheSize` to hold it are logged
+	// and reported in the metrics, so the cache could be sized from the real access patterns.
+	CacheAdvisorWindow uint32
+	// BlobThreshold if not zero, the values larger than it are stored in a separate blobs file in the rewritten
+	// snapshots, deduplicated by the hashes, so the large values like the contract codes don't dilute the kvs file
+	// in the page cache. The snapshots in the old formats are still readable, it's ignored in commitment-only mode.
+	BlobThreshold int
+	// PrefixCompressKeys if true, the keys in the kvs file of the rewritten snapshots are prefix compressed, which
+	// saves much space for the stores whose keys share long prefixes, at the cost of decoding the keys on reads.
+	PrefixCompressKeys bool
 }
 
 func (opts Options) Validate() error {
@@ -129,6 +374,10 @@ func (opts *Options) FillDefaults() {
 		opts.Logger = NewNopLogger()
 	}
 
+	if opts.Metrics == nil {
+		opts.Metrics = NewNopMetrics()
+	}
+
 	if opts.SnapshotInterval == 0 {
 		opts.SnapshotInterval = DefaultSnapshotInterval
 	}
@@ -143,7 +392,7 @@ const (
 	SnapshotDirLen = len(SnapshotPrefix) + 20
 )
 
-func Load(dir string, opts Options) (*DB, error) {
+func Load(dir string, opts Options) (_ *DB, err error) {
 	if err := opts.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid options: %w", err)
 	}
@@ -155,12 +404,15 @@ func Load(dir string, opts Options) (*DB, error) {
 		}
 	}
 
-	var (
-		err      error
-		fileLock FileLock
-	)
+	var fileLock FileLock
+	defer func() {
+		// release the lock if failed, so the db could be loaded again in the same process
+		if err != nil && fileLock != nil {
+			err = errors.Join(err, fileLock.Unlock())
+		}
+	}()
 	if !opts.ReadOnly {
-		fileLock, err = LockFile(filepath.Join(dir, LockFileName))
+		fileLock, err = LockFile(filepath.Join(dir, LockFileName), LockExclusive)
 		if err != nil {
 			return nil, fmt.Errorf("fail to lock db: %w", err)
 		}
@@ -169,10 +421,22 @@ func Load(dir string, opts Options) (*DB, error) {
 		if err := removeTmpDirs(dir); err != nil {
 			return nil, fmt.Errorf("fail to cleanup tmp directories: %w", err)
 		}
+	} else if !opts.NoLock {
+		// the shared lock keeps the writers from starting while the read-only tools are reading, but a read-only db
+		// could also follow a running writer, so it's opened without the lock in that case.
+		if lock, err := LockFile(filepath.Join(dir, LockFileName), LockShared); err != nil {
+			opts.Logger.Debug("fail to take the shared lock, open without it", "err", err)
+		} else {
+			fileLock = lock
+		}
 	}
 
-	snapshot := "current"
-	if opts.TargetVersion > 0 {
+	var snapshot string
+	if opts.TargetVersion == 0 {
+		if snapshot, err = CurrentSnapshot(dir); err != nil {
+			return nil, fmt.Errorf("fail to read current snapshot: %w", err)
+		}
+	} else {
 		// find the biggest snapshot version that's less than or equal to the target version
 		snapshotVersion, err := seekSnapshot(dir, opts.TargetVersion)
 		if err != nil {
@@ -186,20 +450,42 @@ func Load(dir string, opts Options) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !opts.CommitmentOnly && mtree.commitmentOnly() {
+		return nil, errors.Join(errCommitmentOnly, mtree.Close())
+	}
+	// fail fast rather than starting a node which forks immediately
+	if err := mtree.checkCommitInfo(); err != nil {
+		return nil, errors.Join(err, mtree.Close())
+	}
+	if err := mtree.advise(opts.NodesMmapAdvice, opts.KVsMmapAdvice, opts.HugePageNodes, opts.PrefaultNodes); err != nil {
+		return nil, errors.Join(err, mtree.Close())
+	}
+	mtree.SetNodeArena(opts.NodeArena)
+	mtree.SetCompactNodes(opts.CompactNodes)
+	mtree.SetHashConcurrency(opts.HashConcurrency)
+	mtree.SetCacheShards(opts.CacheShards)
+	mtree.SetCachePrewarmSize(opts.CachePrewarmSize)
+	mtree.SetWALReplayConcurrency(opts.WALReplayConcurrency)
 
 	wal, err := OpenWAL(walPath(dir), &wal.Options{NoCopy: true, NoSync: true})
 	if err != nil {
 		return nil, err
 	}
 
+	var (
+		replayed   uint64
+		replayTime time.Duration
+	)
 	if opts.TargetVersion == 0 || int64(opts.TargetVersion) > mtree.Version() {
-		if err := mtree.CatchupWAL(wal, int64(opts.TargetVersion)); err != nil {
+		start := time.Now()
+		if replayed, err = mtree.catchupWAL(wal, int64(opts.TargetVersion)); err != nil {
 			return nil, errors.Join(err, wal.Close())
 		}
+		replayTime = time.Since(start)
 	}
 
 	if opts.LoadForOverwriting && opts.TargetVersion > 0 {
-		currentSnapshot, err := os.Readlink(currentPath(dir))
+		currentSnapshot, err := CurrentSnapshot(dir)
 		if err != nil {
 			return nil, fmt.Errorf("fail to read current version: %w", err)
 		}
@@ -207,7 +493,7 @@ func Load(dir string, opts Options) (*DB, error) {
 		if snapshot != currentSnapshot {
 			// downgrade `"current"` link first
 			opts.Logger.Info("downgrade current link to", "snapshot", snapshot)
-			if err := updateCurrentSymlink(dir, snapshot); err != nil {
+			if err := updateCurrent(dir, snapshot); err != nil {
 				return nil, fmt.Errorf("fail to update current snapshot link: %w", err)
 			}
 		}
@@ -235,20 +521,84 @@ func Load(dir string, opts Options) (*DB, error) {
 		}
 	}
 	// create worker pool. recv tasks to write snapshot
-	workerPool := pond.New(opts.SnapshotWriterLimit, opts.SnapshotWriterLimit*10)
+	workerPool := opts.SnapshotWriterPool
+	if workerPool == nil {
+		// leave the room to raise the limit at runtime
+		workerPool = NewSnapshotWriterPool(max(opts.SnapshotWriterLimit, runtime.NumCPU()))
+	}
+
+	var eventLog *snapshotEventLog
+	if opts.SnapshotEventLog != "" {
+		if eventLog, err = openSnapshotEventLog(opts.SnapshotEventLog); err != nil {
+			return nil, errors.Join(err, wal.Close(), mtree.Close())
+		}
+	}
 
 	db := &DB{
-		MultiTree:              *mtree,
-		logger:                 opts.Logger,
-		dir:                    dir,
-		fileLock:               fileLock,
-		readOnly:               opts.ReadOnly,
-		wal:                    wal,
-		walChanSize:            opts.AsyncCommitBuffer,
-		snapshotKeepRecent:     opts.SnapshotKeepRecent,
-		snapshotInterval:       opts.SnapshotInterval,
-		triggerStateSyncExport: opts.TriggerStateSyncExport,
-		snapshotWriterPool:     workerPool,
+		MultiTree:               *mtree,
+		logger:                  opts.Logger,
+		walLogger:               withLogLevel(opts.Logger, opts.LogLevels.WAL),
+		snapshotLogger:          withLogLevel(opts.Logger, opts.LogLevels.Snapshot),
+		pruneLogger:             withLogLevel(opts.Logger, opts.LogLevels.Prune),
+		commitLogger:            withLogLevel(opts.Logger, opts.LogLevels.Commit),
+		metrics:                 opts.Metrics,
+		histograms:              newCommitHistograms(),
+		dir:                     dir,
+		fileLock:                fileLock,
+		readOnly:                opts.ReadOnly,
+		wal:                     wal,
+		walChanSize:             opts.AsyncCommitBuffer,
+		waitDurableBeforeCommit: opts.WaitDurableBeforeCommit,
+		durableCond:             sync.NewCond(&sync.Mutex{}),
+		snapshotKeepRecent:      opts.SnapshotKeepRecent,
+		snapshotInterval:        opts.SnapshotInterval,
+		triggerStateSyncExport:  opts.TriggerStateSyncExport,
+		snapshotWriterPool:      workerPool,
+		snapshotWriterLimiter:   newWriterLimiter(min(opts.SnapshotWriterLimit, workerPool.MaxWorkers())),
+		commitmentOnly:          opts.CommitmentOnly,
+		nodesMmapAdvice:         opts.NodesMmapAdvice,
+		kvsMmapAdvice:           opts.KVsMmapAdvice,
+		hugePageNodes:           opts.HugePageNodes,
+		memoryBudget:            opts.MemoryBudgetBytes,
+		minFreeDiskBytes:        opts.MinFreeDiskBytes,
+		walLagThreshold:         opts.WALLagAlertThreshold,
+		writeBufferSize:         opts.SnapshotWriteBufferSize,
+		preallocate:             opts.PreallocateSnapshot,
+		blobThreshold:           opts.BlobThreshold,
+		prefixKeys:              opts.PrefixCompressKeys,
+		maxReplayTime:           opts.SnapshotMaxReplayTime,
+		rewriteStallTimeout:     opts.RewriteStallTimeout,
+		retryStalledRewrite:     opts.RetryStalledRewrite,
+		replayRate:              DefaultWALReplayRate,
+		walBytes:                replayed,
+		cacheAdvisorWindow:      opts.CacheAdvisorWindow,
+		workingSets:             make(map[string]*workingSet),
+		treesRef:                newTreesRef(),
+		health:                  &healthTracker{onCritical: opts.OnCriticalError},
+		rewriteProgress:         &rewriteProgress{},
+		snapshotEvents:          snapshotEventHandler(eventLog, opts.SnapshotEventHandler),
+		snapshotEventLog:        eventLog,
+		slowCommit: slowCommitConfig{
+			threshold:      opts.SlowCommitThreshold,
+			phaseThreshold: opts.SlowCommitPhaseThreshold,
+			handler:        opts.SlowCommitHandler,
+		},
+	}
+	if replayed >= minReplayBytesToMeasure && replayTime > 0 {
+		db.replayRate = float64(replayed) / replayTime.Seconds()
+	}
+	if opts.QueryMetrics {
+		db.queryStats = make(map[string]*queryStats)
+	}
+
+	db.publishView()
+	db.attachTreeStats()
+
+	// before the initial upgrade, so it's recorded too
+	if opts.TraceFile != "" && !opts.ReadOnly {
+		if db.trace, err = openTraceRecorder(opts.TraceFile, opts.Logger, db.LastCommitInfo()); err != nil {
+			return nil, errors.Join(err, db.Close())
+		}
 	}
 
 	if !db.readOnly && db.Version() == 0 && len(opts.InitialStores) > 0 {
@@ -262,6 +612,16 @@ func Load(dir string, opts Options) (*DB, error) {
 		}
 	}
 
+	if opts.MetricsAddress != "" && !db.readOnly {
+		if db.metricsServer, err = serveMetrics(opts.MetricsAddress, db); err != nil {
+			return nil, errors.Join(err, db.Close())
+		}
+	}
+
+	if opts.StatusInterval > 0 && !db.readOnly {
+		db.stopStatusWriter = db.startStatusWriter(opts.StatusInterval)
+	}
+
 	return db, nil
 }
 
@@ -307,6 +667,7 @@ func (db *DB) SetInitialVersion(initialVersion int64) error {
 	if err := db.MultiTree.SetInitialVersion(initialVersion); err != nil {
 		return err
 	}
+	db.trace.initialVersion(initialVersion)
 
 	return initEmptyDB(db.dir, db.initialVersion)
 }
@@ -321,9 +682,12 @@ func (db *DB) ApplyUpgrades(upgrades []*TreeNameUpgrade) error {
 		return errReadOnly
 	}
 
+	db.trace.upgrades(upgrades)
 	if err := db.MultiTree.ApplyUpgrades(upgrades); err != nil {
 		return err
 	}
+	db.publishView()
+	db.attachTreeStats()
 
 	db.pendingLog.Upgrades = append(db.pendingLog.Upgrades, upgrades...)
 	return nil
@@ -343,9 +707,20 @@ func (db *DB) ApplyChangeSets(changeSets []*NamedChangeSet) error {
 		return errReadOnly
 	}
 
+	start := time.Now()
+	defer func() {
+		db.phases.apply += db.measurePhase(commitPhaseApply, start)
+	}()
+
+	db.trace.changeSets(changeSets)
 	if len(db.pendingLog.Changesets) == 0 {
 		db.pendingLog.Changesets = changeSets
-		return db.MultiTree.ApplyChangeSets(changeSets)
+		for _, cs := range changeSets {
+			if err := db.applyTreeChangeSet(cs.Name, cs.Changeset); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
 	// slow path, merge into exist changesets one store at a time,
@@ -368,6 +743,12 @@ func (db *DB) ApplyChangeSet(name string, changeSet ChangeSet) error {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
+	start := time.Now()
+	defer func() {
+		db.phases.apply += db.measurePhase(commitPhaseApply, start)
+	}()
+
+	db.trace.changeSets([]*NamedChangeSet{{Name: name, Changeset: changeSet}})
 	return db.applyChangeSet(name, changeSet)
 }
 
@@ -399,7 +780,17 @@ func (db *DB) applyChangeSet(name string, changeSet ChangeSet) error {
 		})
 	}
 
-	return db.MultiTree.ApplyChangeSet(name, changeSet)
+	return db.applyTreeChangeSet(name, changeSet)
+}
+
+// applyTreeChangeSet applies the change set to the tree, and observes the apply time of the store.
+func (db *DB) applyTreeChangeSet(name string, changeSet ChangeSet) error {
+	start := time.Now()
+	if err := db.MultiTree.ApplyChangeSet(name, changeSet); err != nil {
+		return err
+	}
+	db.histograms.observeStoreApply(name, time.Since(start))
+	return nil
 }
 
 // checkAsyncTasks checks the status of background tasks non-blocking-ly and process the result
@@ -441,6 +832,8 @@ func (db *DB) checkBackgroundSnapshotRewrite() error {
 	case result := <-db.snapshotRewriteChan:
 		db.snapshotRewriteChan = nil
 		db.snapshotRewriteCancel = nil
+		// the goroutine only clears it after the result is received, so it's not reported running after the switch
+		db.rewriting.Store(false)
 
 		if result.mtree == nil {
 			if result.err != nil {
@@ -467,14 +860,25 @@ func (db *DB) checkBackgroundSnapshotRewrite() error {
 
 		// catchup the remaining wal
 		if err := result.mtree.CatchupWAL(db.wal, 0); err != nil {
+			db.health.rewriteFailed(err)
 			return fmt.Errorf("catchup failed: %w", err)
 		}
 
 		// do the switch
 		if err := db.reloadMultiTree(result.mtree); err != nil {
+			db.health.rewriteFailed(err)
 			return fmt.Errorf("switch multitree failed: %w", err)
 		}
-		db.logger.Info("switched to new snapshot", "version", db.MultiTree.Version())
+		db.health.rewriteSucceeded(db.SnapshotVersion())
+		// the WAL entries before the new snapshot are not replayed on restart anymore
+		db.walBytes -= db.rewriteWALBytes
+		db.snapshotLogger.Info("switched to new snapshot", "version", db.MultiTree.Version())
+		db.metrics.IncrCounter(1, "store", "memiavl", "snapshot_switch")
+		db.snapshotEvents.emit(SnapshotEvent{
+			Type:    SnapshotEventSwitched,
+			Version: db.SnapshotVersion(),
+			Path:    filepath.Join(db.dir, snapshotName(db.SnapshotVersion())),
+		})
 
 		db.pruneSnapshots()
 
@@ -496,15 +900,41 @@ func (db *DB) pruneSnapshots() {
 	go func() {
 		defer db.pruneSnapshotLock.Unlock()
 
-		currentVersion, err := currentVersion(db.dir)
+		_, span := tracer.Start(context.Background(), "memiavl.PruneSnapshots")
+		defer span.End()
+
+		db.health.pruneStarted()
+		// the running flag is cleared before the lock is released, including the panics
+		var current int64
+		defer func() { db.health.pruneFinished(current) }()
+		defer func() {
+			// the pruning is retried after the next snapshot switch, the failure is reported by `Health`
+			if r := recover(); r != nil {
+				err := panicError("snapshot pruning", r)
+				db.pruneLogger.Error("snapshot pruning panicked", "err", err)
+				db.health.pruneFailed(err)
+			}
+		}()
+
+		current, err := currentVersion(db.dir)
 		if err != nil {
-			db.logger.Error("failed to read current snapshot version", "err", err)
+			db.pruneLogger.Error("failed to read current snapshot version", "err", err)
+			span.RecordError(err)
+			db.health.pruneFailed(err)
 			return
 		}
 
+		// collect the snapshots to remove under the lock, the removal and the WAL truncation could be slow, and the
+		// pinning waits for the lock.
+		var pruning []int64
+		db.pins.mtx.Lock()
 		counter := db.snapshotKeepRecent
-		if err := traverseSnapshots(db.dir, false, func(version int64) (bool, error) {
-			if version >= currentVersion {
+		// the previous snapshot in the descending order, the pinned versions in [version, next) are loaded from the
+		// snapshot of version
+		next := int64(math.MaxInt64)
+		err = traverseSnapshots(db.dir, false, func(version int64) (bool, error) {
+			defer func() { next = version }()
+			if version >= current {
 				// ignore any newer snapshot directories, there could be ongoning snapshot rewrite.
 				return false, nil
 			}
@@ -514,88 +944,220 @@ func (db *DB) pruneSnapshots() {
 				return false, nil
 			}
 
-			name := snapshotName(version)
-			db.logger.Info("prune snapshot", "name", name)
-
-			if err := atomicRemoveDir(filepath.Join(db.dir, name)); err != nil {
-				db.logger.Error("failed to prune snapshot", "err", err)
+			if db.pins.pinnedBetween(version, next) {
+				db.pruneLogger.Info("keep pinned snapshot", "name", snapshotName(version))
+				return false, nil
 			}
 
+			pruning = append(pruning, version)
 			return false, nil
-		}); err != nil {
-			db.logger.Error("fail to prune snapshots", "err", err)
+		})
+		if len(pruning) > 0 {
+			db.pins.pruning = make(map[int64]struct{}, len(pruning))
+			for _, version := range pruning {
+				db.pins.pruning[version] = struct{}{}
+			}
+		}
+		db.pins.mtx.Unlock()
+		defer func() {
+			db.pins.mtx.Lock()
+			db.pins.pruning = nil
+			db.pins.mtx.Unlock()
+		}()
+		if err != nil {
+			db.pruneLogger.Error("fail to prune snapshots", "err", err)
+			span.RecordError(err)
+			db.health.pruneFailed(err)
 			return
 		}
 
+		for _, version := range pruning {
+			name := snapshotName(version)
+			db.pruneLogger.Info("prune snapshot", "name", name)
+			span.AddEvent("prune snapshot", trace.WithAttributes(attribute.Int64("version", version)))
+
+			path := filepath.Join(db.dir, name)
+			size, _ := treeSize(path)
+			if err := atomicRemoveDir(path); err != nil {
+				db.pruneLogger.Error("failed to prune snapshot", "err", err)
+				span.RecordError(err)
+				db.health.pruneFailed(err)
+			} else {
+				db.snapshotEvents.emit(SnapshotEvent{Type: SnapshotEventPruned, Version: version, Path: path, Size: size})
+			}
+		}
+
 		// truncate WAL until the earliest remaining snapshot
 		earliestVersion, err := firstSnapshotVersion(db.dir)
 		if err != nil {
-			db.logger.Error("failed to find first snapshot", "err", err)
+			db.pruneLogger.Error("failed to find first snapshot", "err", err)
+			db.health.pruneFailed(err)
 		}
 
 		if err := db.wal.TruncateFront(walIndex(earliestVersion+1, db.initialVersion)); err != nil {
-			db.logger.Error("failed to truncate wal", "err", err, "version", earliestVersion+1)
+			db.walLogger.Error("failed to truncate wal", "err", err, "version", earliestVersion+1)
+			db.health.pruneFailed(err)
+		} else if db.snapshotEvents != nil {
+			size, _ := dirSize(walPath(db.dir))
+			db.snapshotEvents.emit(SnapshotEvent{Type: SnapshotEventWALTruncated, Version: earliestVersion + 1, Size: size})
 		}
+
+		db.emitDiskUsage()
 	}()
 }
 
-// Commit wraps SaveVersion to bump the version and writes the pending changes into log files to persist on disk
+// Commit wraps SaveVersion to bump the version and writes the pending changes into log files to persist on disk,
+// the registered commit hooks are called after the commit.
 func (db *DB) Commit() (int64, error) {
+	start := time.Now()
+	_, span := tracer.Start(context.Background(), "memiavl.Commit")
+	v, changeSets, commitInfo, hooks, err := db.commit()
+	span.SetAttributes(attribute.Int64("version", v))
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+	db.metrics.MeasureSince(start, "store", "memiavl", "commit")
+	db.histograms.observeCommit(time.Since(start))
+
+	for _, hook := range hooks {
+		hook(v, changeSets, commitInfo)
+	}
+	return v, nil
+}
+
+func (db *DB) commit() (int64, []*NamedChangeSet, *CommitInfo, []CommitHook, error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
 	if db.readOnly {
-		return 0, errReadOnly
+		return 0, nil, nil, nil, errReadOnly
+	}
+	if db.closed {
+		return 0, nil, nil, nil, errClosed
 	}
 
+	start := time.Now()
+	if db.waitDurableBeforeCommit && db.walChan != nil {
+		if err := db.waitDurable(walIndex(db.lastCommitInfo.Version, db.initialVersion)); err != nil {
+			return 0, nil, nil, nil, err
+		}
+		db.phases.waitDurable = db.measurePhase(commitPhaseWaitDurable, start)
+	}
+
+	hashStart := time.Now()
 	v, err := db.MultiTree.SaveVersion(true)
 	if err != nil {
-		return 0, err
+		return 0, nil, nil, nil, err
 	}
+	db.publishView()
+	db.commits.Add(1)
+	db.trace.commit(&db.lastCommitInfo)
+	db.phases.hash = db.measurePhase(commitPhaseHash, hashStart)
 
 	// write logs if enabled
 	if db.wal != nil {
 		entry := walEntry{index: walIndex(v, db.initialVersion), data: db.pendingLog}
 		if db.walChanSize >= 0 {
 			if db.walChan == nil {
-				db.initAsyncCommit()
+				if err := db.initAsyncCommit(); err != nil {
+					return 0, nil, nil, nil, err
+				}
 			}
 
 			// async wal writing
+			atomic.AddInt64(&db.walQueued, 1)
 			db.walChan <- &entry
 		} else {
 			lastIndex, err := db.wal.LastIndex()
 			if err != nil {
-				return 0, err
+				return 0, nil, nil, nil, err
 			}
 
 			db.wbatch.Clear()
-			if err := writeEntry(&db.wbatch, db.logger, lastIndex, &entry); err != nil {
-				return 0, err
+			if err := db.writeEntry(&db.wbatch, lastIndex, &entry); err != nil {
+				return 0, nil, nil, nil, err
 			}
 
-			if err := db.wal.WriteBatch(&db.wbatch); err != nil {
-				return 0, err
+			writeStart := time.Now()
+			if err := writeWALBatch(db.wal, db.dir, &db.wbatch); err != nil {
+				db.health.walFailed(err)
+				return 0, nil, nil, nil, err
 			}
+			db.phases.walWrite = db.measurePhase(commitPhaseWALWrite, writeStart)
 		}
 	}
 
+	if len(db.pendingLog.Upgrades) > 0 {
+		// the audit trail doesn't fail the commit, the upgrades are still recorded in the WAL
+		if err := appendUpgradeHistory(db.dir, v, db.pendingLog.Upgrades); err != nil {
+			db.commitLogger.Error("failed to record the upgrade history", "version", v, "err", err)
+		}
+	}
+	if db.maxReplayTime > 0 {
+		db.walBytes += uint64(db.pendingLog.Size())
+	}
+	changeSets := db.pendingLog.Changesets
 	db.pendingLog = WALEntry{}
 
 	if err := db.checkAsyncTasks(); err != nil {
-		return 0, err
+		return 0, nil, nil, nil, err
 	}
+	db.monitorDiskSpace()
 	db.rewriteIfApplicable(v)
+	// before the metrics pop the cache statistics
+	db.enforceMemoryBudget()
+	db.emitCommitMetrics(v)
+	db.emitQueryMetrics()
+	db.adviseCacheSizes(v)
 
-	return v, nil
+	hooks := db.commitHooks
+	if hook := db.checkSlowCommit(v, time.Since(start), changeSets); hook != nil {
+		// the handler is called along with the hooks, without holding the lock
+		hooks = append(hooks[:len(hooks):len(hooks)], hook)
+	}
+
+	commitInfo := *db.MultiTree.LastCommitInfo()
+	return v, changeSets, &commitInfo, hooks, nil
+}
+
+// CommitHook is called after each Commit with the committed version, the change sets and the commit info,
+// the change sets are the same ones written to the WAL, they must not be modified.
+type CommitHook func(version int64, changeSets []*NamedChangeSet, commitInfo *CommitInfo)
+
+// RegisterCommitHook registers a hook to be called synchronously after each Commit, in the order of registration,
+// the hooks are called without holding the db lock, so they can query the db, but in async commit mode, the WAL entry
+// of the version might not be persisted yet when the hook is called.
+func (db *DB) RegisterCommitHook(hook CommitHook) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.commitHooks = append(db.commitHooks, hook)
 }
 
-func (db *DB) initAsyncCommit() {
+func (db *DB) initAsyncCommit() error {
+	lastIndex, err := db.wal.LastIndex()
+	if err != nil {
+		return err
+	}
+	db.setDurable(lastIndex, nil)
+
 	walChan := make(chan *walEntry, db.walChanSize)
 	walQuit := make(chan error)
 
+	db.health.setAsyncCommit(true)
 	go func() {
+		setGoroutineLabels(profileAsyncWAL)
 		defer close(walQuit)
+		defer db.health.setAsyncCommit(false)
+		defer func() {
+			// reported by the next commit, like the write errors
+			if r := recover(); r != nil {
+				err := panicError("async wal writing", r)
+				db.setDurable(0, err)
+				walQuit <- err
+			}
+		}()
 
 		batch := wal.Batch{}
 		for {
@@ -607,27 +1169,79 @@ func (db *DB) initAsyncCommit() {
 
 			lastIndex, err := db.wal.LastIndex()
 			if err != nil {
+				db.setDurable(0, err)
 				walQuit <- err
 				return
 			}
 
 			for _, entry := range entries {
-				if err := writeEntry(&batch, db.logger, lastIndex, entry); err != nil {
+				if err := db.writeEntry(&batch, lastIndex, entry); err != nil {
+					db.setDurable(0, err)
 					walQuit <- err
 					return
 				}
 			}
 
-			if err := db.wal.WriteBatch(&batch); err != nil {
+			writeStart := time.Now()
+			if err := writeWALBatch(db.wal, db.dir, &batch); err != nil {
+				db.setDurable(0, err)
 				walQuit <- err
 				return
 			}
+			db.measurePhase(commitPhaseWALWrite, writeStart)
+			atomic.AddInt64(&db.walQueued, -int64(len(entries)))
 			batch.Clear()
+			db.setDurable(entries[len(entries)-1].index, nil)
 		}
 	}()
 
 	db.walChan = walChan
 	db.walQuit = walQuit
+	return nil
+}
+
+// setDurable records the progress of the async commit goroutine and wakes up the waiters,
+// the index is ignored if err is not nil.
+func (db *DB) setDurable(index uint64, err error) {
+	if err != nil {
+		db.health.walFailed(err)
+	}
+	db.durableCond.L.Lock()
+	defer db.durableCond.L.Unlock()
+
+	if err != nil {
+		db.durableErr = err
+	} else {
+		db.durableIndex = index
+		db.durableErr = nil
+	}
+	db.durableCond.Broadcast()
+}
+
+// waitDurable blocks until the WAL entry at the index is persisted by the async commit goroutine,
+// or the goroutine exits with error.
+func (db *DB) waitDurable(index uint64) error {
+	db.durableCond.L.Lock()
+	defer db.durableCond.L.Unlock()
+
+	for db.durableIndex < index && db.durableErr == nil {
+		db.durableCond.Wait()
+	}
+	return db.durableErr
+}
+
+// WaitDurable blocks until the WAL entries of all the committed versions are persisted,
+// it returns immediately in sync commit mode.
+func (db *DB) WaitDurable() error {
+	db.mtx.Lock()
+	async := db.walChan != nil
+	index := walIndex(db.lastCommitInfo.Version, db.initialVersion)
+	db.mtx.Unlock()
+
+	if !async {
+		return nil
+	}
+	return db.waitDurable(index)
 }
 
 // WaitAsyncCommit waits for the completion of async commit
@@ -661,11 +1275,36 @@ func (db *DB) Copy() *DB {
 func (db *DB) copy(cacheSize int) *DB {
 	mtree := db.MultiTree.Copy(cacheSize)
 
-	return &DB{
+	cloned := &DB{
 		MultiTree:          *mtree,
 		logger:             db.logger,
+		metrics:            db.metrics,
 		dir:                db.dir,
 		snapshotWriterPool: db.snapshotWriterPool,
+		// shared, so the limit changes apply to the in-flight rewrite
+		snapshotWriterLimiter: db.snapshotWriterLimiter,
+		commitmentOnly:        db.commitmentOnly,
+		writeBufferSize:       db.writeBufferSize,
+		preallocate:           db.preallocate,
+		blobThreshold:         db.blobThreshold,
+		prefixKeys:            db.prefixKeys,
+		// the copied trees share the snapshots with the db, which owns them
+		treesRef: newTreesRef(),
+		health:   &healthTracker{},
+	}
+	cloned.publishView()
+	return cloned
+}
+
+func (db *DB) snapshotWriteOptions() snapshotWriteOptions {
+	return snapshotWriteOptions{
+		commitmentOnly: db.commitmentOnly,
+		bufferSize:     db.writeBufferSize,
+		preallocate:    db.preallocate,
+		blobThreshold:  db.blobThreshold,
+		prefixKeys:     db.prefixKeys,
+		limiter:        db.snapshotWriterLimiter,
+		progress:       db.rewriteProgress,
 	}
 }
 
@@ -675,24 +1314,37 @@ func (db *DB) RewriteSnapshot() error {
 }
 
 // RewriteSnapshotWithContext writes the current version of memiavl into a snapshot, and update the `current` symlink.
-func (db *DB) RewriteSnapshotWithContext(ctx context.Context) error {
+func (db *DB) RewriteSnapshotWithContext(ctx context.Context) (err error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
 	if db.readOnly {
 		return errReadOnly
 	}
+	if err := db.checkDiskSpace(); err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "memiavl.RewriteSnapshot",
+		trace.WithAttributes(attribute.Int64("version", db.lastCommitInfo.Version)))
+	defer func() { endSpan(span, err) }()
 
 	snapshotDir := snapshotName(db.lastCommitInfo.Version)
 	tmpDir := snapshotDir + TmpSuffix
 	path := filepath.Join(db.dir, tmpDir)
-	if err := db.MultiTree.WriteSnapshotWithContext(ctx, path, db.snapshotWriterPool); err != nil {
+	if err := db.MultiTree.writeSnapshot(ctx, path, db.snapshotWriterPool, db.snapshotWriteOptions()); err != nil {
 		return errors.Join(err, os.RemoveAll(path))
 	}
+	if err := fault(FaultSnapshotRename); err != nil {
+		return err
+	}
 	if err := os.Rename(path, filepath.Join(db.dir, snapshotDir)); err != nil {
 		return err
 	}
-	return updateCurrentSymlink(db.dir, snapshotDir)
+	if err := fault(FaultBeforeSymlinkSwap); err != nil {
+		return err
+	}
+	return updateCurrent(db.dir, snapshotDir)
 }
 
 func (db *DB) Reload() error {
@@ -703,7 +1355,11 @@ func (db *DB) Reload() error {
 }
 
 func (db *DB) reload() error {
-	mtree, err := LoadMultiTree(currentPath(db.dir), db.zeroCopy, db.cacheSize)
+	path, err := currentSnapshotPath(db.dir)
+	if err != nil {
+		return err
+	}
+	mtree, err := LoadMultiTree(path, db.zeroCopy, db.cacheSize)
 	if err != nil {
 		return err
 	}
@@ -711,31 +1367,100 @@ func (db *DB) reload() error {
 }
 
 func (db *DB) reloadMultiTree(mtree *MultiTree) error {
-	if err := db.MultiTree.Close(); err != nil {
-		return err
-	}
-
+	if err := mtree.advise(db.nodesMmapAdvice, db.kvsMmapAdvice, db.hugePageNodes, false); err != nil {
+		return errors.Join(err, mtree.Close())
+	}
+	// the old arenas are dropped along with the old trees
+	mtree.SetNodeArena(db.nodeArena)
+	mtree.SetCompactNodes(db.compact)
+	mtree.SetHashConcurrency(db.hashConcurrency)
+	mtree.SetCacheShards(db.cacheShards)
+	mtree.SetCachePrewarmSize(db.cachePrewarmSize)
+	mtree.SetWALReplayConcurrency(db.walReplayConcurrency)
+	// catch-up the pending changes before the switch, so the readers never see the new trees behind
+	if err := mtree.applyWALEntry(db.pendingLog); err != nil {
+		return errors.Join(err, mtree.Close())
+	}
+
+	old := db.MultiTree
+	ref := db.treesRef
 	db.MultiTree = *mtree
-	// catch-up the pending changes
-	return db.applyWALEntry(db.pendingLog)
+	db.treesRef = newTreesRef()
+	db.publishView()
+	db.attachTreeStats()
+	// the old trees are closed after the in-flight readers release them
+	return ref.retire(&old)
 }
 
-// rewriteIfApplicable execute the snapshot rewrite strategy according to current height
+// rewriteIfApplicable execute the snapshot rewrite strategy according to current height, or the estimated WAL replay
+// time if `maxReplayTime` is set.
 func (db *DB) rewriteIfApplicable(height int64) {
-	if height%int64(db.snapshotInterval) != 0 {
+	if db.snapshotRewriteChan == nil && db.rewriteRetry.CompareAndSwap(true, false) {
+		db.snapshotLogger.Info("retry the stalled snapshot rewrite")
+		if err := db.rewriteSnapshotBackground(); err != nil {
+			db.snapshotLogger.Error("failed to rewrite snapshot in background", "err", err)
+		}
+		return
+	}
+
+	if db.maxReplayTime > 0 {
+		estimate := db.estimatedReplayTime()
+		db.metrics.SetGauge(float32(estimate.Milliseconds()), "store", "memiavl", "wal_replay_estimate_ms")
+		if estimate < db.maxReplayTime || db.snapshotRewriteChan != nil {
+			return
+		}
+		db.snapshotLogger.Info("estimated wal replay time exceeded, rewrite snapshot", "estimate", estimate, "max", db.maxReplayTime)
+	} else if height%int64(db.snapshotInterval) != 0 {
+		return
+	}
+
+	if err := db.rewriteSnapshotBackground(); err != nil {
+		db.snapshotLogger.Error("failed to rewrite snapshot in background", "err", err)
+	}
+}
+
+// enforceMemoryBudget triggers a snapshot rewrite if the memory usage exceeds the budget, the new snapshot drops the
+// in-memory nodes, if a rewrite is already in progress, the caches of the cold stores are purged instead.
+func (db *DB) enforceMemoryBudget() {
+	if db.memoryBudget == 0 {
 		return
 	}
 
+	usage := db.MultiTree.memoryUsage()
+	db.metrics.SetGauge(float32(usage), "store", "memiavl", "memory_usage")
+	if usage <= db.memoryBudget {
+		return
+	}
+
+	if db.snapshotRewriteChan != nil {
+		db.MultiTree.evictColdCaches()
+		return
+	}
+
+	db.snapshotLogger.Info("memory budget exceeded, rewrite snapshot", "usage", usage, "budget", db.memoryBudget)
+	db.metrics.IncrCounter(1, "store", "memiavl", "memory_budget_exceeded")
 	if err := db.rewriteSnapshotBackground(); err != nil {
-		db.logger.Error("failed to rewrite snapshot in background", "err", err)
+		db.snapshotLogger.Error("failed to rewrite snapshot in background", "err", err)
 	}
 }
 
+// estimatedReplayTime estimates the time to replay the WAL after the current snapshot on restart.
+func (db *DB) estimatedReplayTime() time.Duration {
+	return time.Duration(float64(db.walBytes) / db.replayRate * float64(time.Second))
+}
+
 type snapshotResult struct {
 	mtree *MultiTree
 	err   error
 }
 
+// rewriteInFlight returns whether a background snapshot rewrite is in flight or not switched to yet.
+func (db *DB) rewriteInFlight() bool {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.snapshotRewriteChan != nil
+}
+
 // RewriteSnapshotBackground rewrite snapshot in a background goroutine,
 // `Commit` will check the complete status, and switch to the new snapshot.
 func (db *DB) RewriteSnapshotBackground() error {
@@ -753,62 +1478,177 @@ func (db *DB) rewriteSnapshotBackground() error {
 	if db.snapshotRewriteChan != nil {
 		return errors.New("there's another ongoing snapshot rewriting process")
 	}
+	if err := db.checkDiskSpace(); err != nil {
+		db.health.rewriteFailed(err)
+		db.metrics.IncrCounter(1, "store", "memiavl", "snapshot_rewrite_refused")
+		return err
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	ch := make(chan snapshotResult)
 	db.snapshotRewriteChan = ch
 	db.snapshotRewriteCancel = cancel
+	db.rewriteWALBytes = db.walBytes
 
 	cloned := db.copy(0)
+	cloned.rewriteProgress = db.rewriteProgress
+	progress := db.rewriteProgress
+	progress.start(db.lastCommitInfo.Version)
 	wal := db.wal
+	metrics := db.metrics
+	recentKeys := db.MultiTree.recentKeys()
+	rewriting := &db.rewriting
+	rewriting.Store(true)
+	events := db.snapshotEvents
+	health := db.health
+	logger := db.snapshotLogger
+	if db.rewriteStallTimeout > 0 {
+		go db.watchRewrite(ctx, cancel)
+	}
 	go func() {
+		setGoroutineLabels(profileSnapshotRewrite)
+		// stops the watchdog
+		defer cancel()
 		defer close(ch)
+		defer rewriting.Store(false)
+		defer func() {
+			// fails the next commit, like the other errors after the snapshot is written
+			if r := recover(); r != nil {
+				err := panicError("snapshot rewrite", r)
+				logger.Error("snapshot rewrite panicked", "err", err)
+				health.rewriteFailed(err)
+				ch <- snapshotResult{err: err}
+			}
+		}()
 
-		cloned.logger.Info("start rewriting snapshot", "version", cloned.Version())
+		start := time.Now()
+		version := cloned.Version()
+		path := filepath.Join(cloned.dir, snapshotName(version))
+		logger.Info("start rewriting snapshot", "version", version)
+		events.emit(SnapshotEvent{Type: SnapshotEventRewriteStarted, Version: version, Path: path})
 		if err := cloned.RewriteSnapshotWithContext(ctx); err != nil {
 			// write error log but don't stop the client, it could happen when load an old version.
-			cloned.logger.Error("failed to rewrite snapshot", "err", err)
+			logger.Error("failed to rewrite snapshot", "err", err)
+			metrics.IncrCounter(1, "store", "memiavl", "snapshot_rewrite_failed")
+			health.rewriteFailed(err)
+			events.emit(SnapshotEvent{
+				Type:      SnapshotEventRewriteFailed,
+				Version:   version,
+				Path:      path,
+				ElapsedMs: time.Since(start).Milliseconds(),
+				Error:     err.Error(),
+			})
 			return
 		}
-		cloned.logger.Info("finished rewriting snapshot", "version", cloned.Version())
-		mtree, err := LoadMultiTree(currentPath(cloned.dir), cloned.zeroCopy, 0)
+		logger.Info("finished rewriting snapshot", "version", version)
+		metrics.MeasureSince(start, "store", "memiavl", "snapshot_rewrite")
+		progress.setPhase(RewritePhaseLoading)
+		if events != nil {
+			size, _ := treeSize(path)
+			events.emit(SnapshotEvent{
+				Type:      SnapshotEventRewriteFinished,
+				Version:   version,
+				Path:      path,
+				Size:      size,
+				ElapsedMs: time.Since(start).Milliseconds(),
+			})
+		}
+		// the new trees keep the caches, so they could be pre-warmed before switching
+		mtree, err := LoadMultiTree(path, cloned.zeroCopy, cloned.cacheSize)
 		if err != nil {
+			health.rewriteFailed(err)
 			ch <- snapshotResult{err: err}
 			return
 		}
+		mtree.SetNodeArena(cloned.nodeArena)
+		mtree.SetCompactNodes(cloned.compact)
+		mtree.SetWALReplayConcurrency(cloned.walReplayConcurrency)
+		mtree.SetHashConcurrency(cloned.hashConcurrency)
+		mtree.SetCacheShards(cloned.cacheShards)
 
 		// do a best effort catch-up, will do another final catch-up in main thread.
+		progress.setPhase(RewritePhaseCatchingUp)
 		if err := mtree.CatchupWAL(wal, 0); err != nil {
+			health.rewriteFailed(err)
 			ch <- snapshotResult{err: err}
 			return
 		}
 
-		cloned.logger.Info("finished best-effort WAL catchup", "version", cloned.Version(), "latest", mtree.Version())
+		logger.Info("finished best-effort WAL catchup", "version", cloned.Version(), "latest", mtree.Version())
+
+		if len(recentKeys) > 0 {
+			// the values are loaded from the new snapshot, the final catch-up updates the caches along with the trees
+			prewarmStart := time.Now()
+			progress.setPhase(RewritePhasePrewarming)
+			mtree.prewarmCaches(recentKeys)
+			metrics.MeasureSince(prewarmStart, "store", "memiavl", "cache_prewarm")
+		}
 
+		progress.setPhase(RewritePhaseReady)
 		ch <- snapshotResult{mtree: mtree}
 	}()
 
 	return nil
 }
 
+// Close closes the db, the in-flight snapshot rewrite is cancelled.
 func (db *DB) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return db.Shutdown(ctx)
+}
+
+// Shutdown stops accepting commits, waits for the async WAL writes, and waits for the in-flight snapshot rewrite
+// to complete until the context is done, then cancels it, and closes the db. The completed snapshot is loaded
+// on next startup, so the work is not wasted.
+func (db *DB) Shutdown(ctx context.Context) error {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.health.setClosed()
+
+	if db.stopStatusWriter != nil {
+		db.stopStatusWriter()
+		db.stopStatusWriter = nil
+	}
+
 	errs := []error{db.waitAsyncCommit()}
+	if db.metricsServer != nil {
+		errs = append(errs, db.metricsServer.Close())
+		db.metricsServer = nil
+	}
 
 	if db.snapshotRewriteChan != nil {
+		select {
+		case result := <-db.snapshotRewriteChan:
+			if result.mtree != nil {
+				db.snapshotLogger.Info("snapshot rewrite completed before shutdown", "version", result.mtree.SnapshotVersion())
+				errs = append(errs, result.mtree.Close())
+			} else if result.err != nil {
+				db.snapshotLogger.Error("snapshot rewrite failed before shutdown", "err", result.err)
+			}
+		case <-ctx.Done():
+			db.snapshotRewriteCancel()
+			if result := <-db.snapshotRewriteChan; result.mtree != nil {
+				errs = append(errs, result.mtree.Close())
+			}
+		}
 		db.snapshotRewriteCancel()
-		<-db.snapshotRewriteChan
 		db.snapshotRewriteChan = nil
 		db.snapshotRewriteCancel = nil
 	}
 
+	mtree := db.MultiTree
 	errs = append(errs,
-		db.MultiTree.Close(),
+		db.treesRef.retire(&mtree),
 		db.wal.Close(),
 	)
+	db.publishView()
 
 	db.wal = nil
 
@@ -817,31 +1657,188 @@ func (db *DB) Close() error {
 		db.fileLock = nil
 	}
 
+	if db.snapshotEventLog != nil {
+		errs = append(errs, db.snapshotEventLog.Close())
+		db.snapshotEventLog = nil
+	}
+
+	errs = append(errs, db.trace.Close())
+	db.trace = nil
+
 	return errors.Join(errs...)
 }
 
-// TreeByName wraps MultiTree.TreeByName to add a lock.
-func (db *DB) TreeByName(name string) *Tree {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
+// dbView is the immutable view of the db for the read accessors, a new one is published after each change of the
+// trees or the last commit info.
+type dbView struct {
+	lastCommitInfo CommitInfo
+	initialVersion uint32
+	trees          map[string]*Tree
+	ref            *treesRef
+}
 
-	return db.MultiTree.TreeByName(name)
+// treesRef counts the references of the trees loaded from a snapshot, the db holds one until they are replaced by
+// the next snapshot or the db is closed, and each acquired view holds one, the trees are closed after all of them are
+// released, so the snapshot switch doesn't wait for the readers, nor close the trees under them.
+type treesRef struct {
+	// the reference of the db counts as `dbTreesRef`, so the new views can't be acquired once it's dropped
+	refs int32
+	// the trees to close, set when the db drops its reference
+	mtree *MultiTree
 }
 
-// Version wraps MultiTree.Version to add a lock.
-func (db *DB) Version() int64 {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
+const dbTreesRef = 1 << 30
 
-	return db.MultiTree.Version()
+func newTreesRef() *treesRef {
+	return &treesRef{refs: dbTreesRef}
 }
 
-// LastCommitInfo returns the last commit info.
-func (db *DB) LastCommitInfo() *CommitInfo {
+// acquire adds a reference, it fails if the db has dropped its reference.
+func (r *treesRef) acquire() bool {
+	for {
+		refs := atomic.LoadInt32(&r.refs)
+		if refs < dbTreesRef {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&r.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (r *treesRef) release() error {
+	return r.drop(1)
+}
+
+// retire drops the reference of the db, the trees are closed immediately if no views hold them.
+func (r *treesRef) retire(mtree *MultiTree) error {
+	// the atomic decrement publishes it to the goroutine releasing the last reference
+	r.mtree = mtree
+	return r.drop(dbTreesRef)
+}
+
+func (r *treesRef) drop(n int32) error {
+	if atomic.AddInt32(&r.refs, -n) != 0 {
+		return nil
+	}
+	return r.mtree.Close()
+}
+
+// View is a read-only view of the trees and the last commit info, the trees are kept open until it's released, even
+// if the db switches to the next snapshot or is closed meanwhile. The trees are still modified by the later commits,
+// the same as the ones returned by `DB.TreeByName`.
+type View struct {
+	view *dbView
+}
+
+// AcquireView returns the view of the current trees without locking, it must be released after use, returns nil if
+// the db is closed.
+func (db *DB) AcquireView() *View {
+	for {
+		view := db.view.Load()
+		if view.ref.acquire() {
+			return &View{view: view}
+		}
+		// the switch publishes the new view before closing the old trees, so the latest view is always open unless
+		// the db is closed
+		if db.view.Load() == view {
+			return nil
+		}
+	}
+}
+
+// CopyView is like `AcquireView`, except that the trees are copied, so they are not modified by the later commits or
+// the WAL catch-up either, it's used by the readers running concurrently with them, like the queries of a hot standby.
+func (db *DB) CopyView() *View {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
-	return db.MultiTree.LastCommitInfo()
+	view := db.AcquireView()
+	if view == nil {
+		return nil
+	}
+	copied := *view.view
+	copied.trees = make(map[string]*Tree, len(view.view.trees))
+	for name, tree := range view.view.trees {
+		copied.trees[name] = tree.Copy(0)
+	}
+	view.view = &copied
+	return view
+}
+
+// TreeByName returns the tree by name, nil if not found.
+func (v *View) TreeByName(name string) *Tree {
+	return v.view.trees[name]
+}
+
+// Version returns the last committed version when the view is acquired.
+func (v *View) Version() int64 {
+	return v.view.lastCommitInfo.Version
+}
+
+// LastCommitInfo returns the last commit info when the view is acquired, it must not be modified.
+func (v *View) LastCommitInfo() *CommitInfo {
+	return &v.view.lastCommitInfo
+}
+
+// Release drops the reference of the trees, the ones replaced by the snapshot switch are closed after the last view
+// holding them is released. It's safe to call it more than once.
+func (v *View) Release() error {
+	if v.view == nil {
+		return nil
+	}
+	ref := v.view.ref
+	v.view = nil
+	return ref.release()
+}
+
+// Close implements `io.Closer`, it's the same as `Release`.
+func (v *View) Close() error {
+	return v.Release()
+}
+
+// publishView publishes the current trees and the last commit info to the read accessors, it must be called with
+// the mutex held, or before the db is shared. The trees map is reused if the trees are not changed, so the commits
+// don't allocate it.
+func (db *DB) publishView() {
+	view := &dbView{lastCommitInfo: db.MultiTree.lastCommitInfo, initialVersion: db.initialVersion, ref: db.treesRef}
+	if old := db.view.Load(); old != nil && old.ref == db.treesRef && sameTrees(old.trees, db.trees) {
+		view.trees = old.trees
+	} else {
+		view.trees = make(map[string]*Tree, len(db.trees))
+		for _, entry := range db.trees {
+			view.trees[entry.Name] = entry.Tree
+		}
+	}
+	db.view.Store(view)
+}
+
+func sameTrees(m map[string]*Tree, trees []NamedTree) bool {
+	if len(m) != len(trees) {
+		return false
+	}
+	for _, entry := range trees {
+		if m[entry.Name] != entry.Tree {
+			return false
+		}
+	}
+	return true
+}
+
+// TreeByName returns the tree by name in the last published view without locking, so it doesn't wait for the
+// in-flight commit. The tree could be closed by a concurrent snapshot switch, use `AcquireView` to keep it open.
+func (db *DB) TreeByName(name string) *Tree {
+	return db.view.Load().trees[name]
+}
+
+// Version returns the last committed version without locking.
+func (db *DB) Version() int64 {
+	return db.view.Load().lastCommitInfo.Version
+}
+
+// LastCommitInfo returns the last commit info without locking, it must not be modified.
+func (db *DB) LastCommitInfo() *CommitInfo {
+	return &db.view.Load().lastCommitInfo
 }
 
 func (db *DB) SaveVersion(updateCommitInfo bool) (int64, error) {
@@ -852,7 +1849,12 @@ func (db *DB) SaveVersion(updateCommitInfo bool) (int64, error) {
 		return 0, errReadOnly
 	}
 
-	return db.MultiTree.SaveVersion(updateCommitInfo)
+	v, err := db.MultiTree.SaveVersion(updateCommitInfo)
+	if err != nil {
+		return 0, err
+	}
+	db.publishView()
+	return v, nil
 }
 
 func (db *DB) WorkingCommitInfo() *CommitInfo {
@@ -862,6 +1864,12 @@ func (db *DB) WorkingCommitInfo() *CommitInfo {
 	return db.MultiTree.WorkingCommitInfo()
 }
 
+// WorkingHash returns the app hash of the working state, including the change sets applied but not committed yet,
+// so the apps using the FinalizeBlock-then-Commit flow can return the app hash before the WAL is written.
+func (db *DB) WorkingHash() []byte {
+	return db.WorkingCommitInfo().Hash()
+}
+
 // UpdateCommitInfo wraps MultiTree.UpdateCommitInfo to add a lock.
 func (db *DB) UpdateCommitInfo() {
 	db.mtx.Lock()
@@ -872,6 +1880,7 @@ func (db *DB) UpdateCommitInfo() {
 	}
 
 	db.MultiTree.UpdateCommitInfo()
+	db.publishView()
 }
 
 // WriteSnapshot wraps MultiTree.WriteSnapshot to add a lock.
@@ -884,35 +1893,13 @@ func (db *DB) WriteSnapshotWithContext(ctx context.Context, dir string) error {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
-	return db.MultiTree.WriteSnapshotWithContext(ctx, dir, db.snapshotWriterPool)
+	return db.MultiTree.writeSnapshot(ctx, dir, db.snapshotWriterPool, db.snapshotWriteOptions())
 }
 
 func snapshotName(version int64) string {
 	return fmt.Sprintf("%s%020d", SnapshotPrefix, version)
 }
 
-func currentPath(root string) string {
-	return filepath.Join(root, "current")
-}
-
-func currentTmpPath(root string) string {
-	return filepath.Join(root, "current-tmp")
-}
-
-func currentVersion(root string) (int64, error) {
-	name, err := os.Readlink(currentPath(root))
-	if err != nil {
-		return 0, err
-	}
-
-	version, err := parseVersion(name)
-	if err != nil {
-		return 0, err
-	}
-
-	return version, nil
-}
-
 func parseVersion(name string) (int64, error) {
 	if !isSnapshotName(name) {
 		return 0, fmt.Errorf("invalid snapshot name %s", name)
@@ -972,6 +1959,28 @@ func walPath(root string) string {
 	return filepath.Join(root, "wal")
 }
 
+// SetSnapshotWriterLimit changes the max number of trees written in parallel by the snapshot rewrites, including the
+// in-flight one, so the operators could lower it when the node is struggling and raise it in the off-peak hours
+// without a restart. The lowered limit applies when the trees being written finish. It's capped by the max workers of
+// the pool, the effective limit is returned.
+func (db *DB) SetSnapshotWriterLimit(limit int) int {
+	limit = max(1, min(limit, db.snapshotWriterPool.MaxWorkers()))
+	db.snapshotWriterLimiter.setLimit(limit)
+	db.snapshotLogger.Info("snapshot writer limit changed", "limit", limit)
+	return limit
+}
+
+// SnapshotWriterLimit returns the current max number of trees written in parallel by the snapshot rewrites.
+func (db *DB) SnapshotWriterLimit() int {
+	return db.snapshotWriterLimiter.getLimit()
+}
+
+// NewSnapshotWriterPool creates the worker pool to write the snapshots with at most `limit` trees in parallel, it can
+// be shared by multiple dbs with the `SnapshotWriterPool` option.
+func NewSnapshotWriterPool(limit int) *pond.WorkerPool {
+	return pond.New(limit, limit*10)
+}
+
 // init a empty memiavl db
 //
 // ```
@@ -985,24 +1994,30 @@ func initEmptyDB(dir string, initialVersion uint32) error {
 	tmp := NewEmptyMultiTree(initialVersion, 0)
 	snapshotDir := snapshotName(0)
 	// create tmp worker pool
-	pool := pond.New(DefaultSnapshotWriterLimit, DefaultSnapshotWriterLimit*10)
+	pool := NewSnapshotWriterPool(DefaultSnapshotWriterLimit)
 	defer pool.Stop()
 
 	if err := tmp.WriteSnapshot(filepath.Join(dir, snapshotDir), pool); err != nil {
 		return err
 	}
-	return updateCurrentSymlink(dir, snapshotDir)
+	return updateCurrent(dir, snapshotDir)
 }
 
-// updateCurrentSymlink creates or replace the current symblic link atomically.
-// it could fail under concurrent usage for tmp file conflicts.
-func updateCurrentSymlink(dir, snapshot string) error {
-	tmpPath := currentTmpPath(dir)
-	if err := os.Symlink(snapshot, tmpPath); err != nil {
+// syncDir fsyncs the directory to persist the entries created, renamed or removed in it, it's a no-op on windows,
+// which doesn't support syncing the directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fp, err := os.Open(dir)
+	if err != nil {
 		return err
 	}
-	// assuming file renaming operation is atomic
-	return os.Rename(tmpPath, currentPath(dir))
+	err = fp.Sync()
+	if err1 := fp.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
 }
 
 // traverseSnapshots traverse the snapshot list in specified order.
@@ -1044,6 +2059,12 @@ func traverseSnapshots(dir string, ascending bool, callback func(int64) (bool, e
 	return nil
 }
 
+// panicError converts the recovered panic of a background task into an error with the stack trace, so it's surfaced
+// through the error channels of the task, rather than crashing the process at a random point or being lost.
+func panicError(task string, r interface{}) error {
+	return fmt.Errorf("%s panicked: %v\n%s", task, r, debug.Stack())
+}
+
 // atomicRemoveDir is equavalent to `mv snapshot snapshot-tmp && rm -r snapshot-tmp`
 func atomicRemoveDir(path string) error {
 	tmpPath := path + TmpSuffix
@@ -1056,7 +2077,10 @@ func atomicRemoveDir(path string) error {
 
 // createDBIfNotExist detects if db does not exist and try to initialize an empty one.
 func createDBIfNotExist(dir string, initialVersion uint32) error {
-	_, err := os.Stat(filepath.Join(dir, "current", MetadataFileName))
+	path, err := currentSnapshotPath(dir)
+	if err == nil {
+		_, err = os.Stat(filepath.Join(path, MetadataFileName))
+	}
 	if err != nil && os.IsNotExist(err) {
 		return initEmptyDB(dir, initialVersion)
 	}
@@ -1076,7 +2100,7 @@ func isSnapshotName(name string) bool {
 // it's needed for upgrade module to check store upgrades,
 // it returns 0 if db don't exists or is empty.
 func GetLatestVersion(dir string) (int64, error) {
-	metadata, err := readMetadata(currentPath(dir))
+	metadata, err := readCurrentMetadata(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return 0, nil
@@ -1095,6 +2119,27 @@ func GetLatestVersion(dir string) (int64, error) {
 	return walVersion(lastIndex, uint32(metadata.InitialVersion)), nil
 }
 
+// GetEarliestVersion returns the earliest version that can be loaded from the db, which is the version of the
+// earliest snapshot, because the WAL entries before it are truncated.
+func GetEarliestVersion(dir string) (int64, error) {
+	var (
+		earliest int64
+		found    bool
+	)
+	if err := traverseSnapshots(dir, true, func(version int64) (bool, error) {
+		earliest = version
+		found = true
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if !found {
+		return 0, errors.New("empty memiavl db")
+	}
+	return earliest, nil
+}
+
 func channelBatchRecv[T any](ch <-chan *T) []*T {
 	// block if channel is empty
 	item := <-ch
@@ -1113,16 +2158,20 @@ func channelBatchRecv[T any](ch <-chan *T) []*T {
 	return result
 }
 
-func writeEntry(batch *wal.Batch, logger Logger, lastIndex uint64, entry *walEntry) error {
-	bz, err := entry.data.Marshal()
+func (db *DB) writeEntry(batch *wal.Batch, lastIndex uint64, entry *walEntry) error {
+	start := time.Now()
+	buf, err := marshalPooled(&entry.data)
 	if err != nil {
 		return err
 	}
+	// the batch copies the data, so the buffer can be reused right away
+	defer releaseMarshalBuffer(buf)
+	db.measurePhase(commitPhaseMarshal, start)
 
 	if entry.index <= lastIndex {
-		logger.Error("commit old version idempotently", "lastIndex", lastIndex, "version", entry.index)
+		db.walLogger.Error("commit old version idempotently", "lastIndex", lastIndex, "version", entry.index)
 	} else {
-		batch.Write(entry.index, bz)
+		batch.Write(entry.index, *buf)
 	}
 	return nil
 }
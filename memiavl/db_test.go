@@ -1,17 +1,27 @@
 package memiavl
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	fmt "fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	storetypes "cosmossdk.io/store/types"
 )
 
 func TestRewriteSnapshot(t *testing.T) {
@@ -68,10 +78,11 @@ func TestRemoveSnapshotDir(t *testing.T) {
 	err = os.MkdirAll(tmpDir, os.ModePerm)
 	require.NoError(t, err)
 
-	_, err = Load(dbDir, Options{
+	db, err = Load(dbDir, Options{
 		ReadOnly: true,
 	})
 	require.NoError(t, err)
+	require.NoError(t, db.Close())
 
 	_, err = os.Stat(tmpDir)
 	require.False(t, os.IsNotExist(err))
@@ -120,8 +131,8 @@ func TestRewriteSnapshotBackground(t *testing.T) {
 	entries, err := os.ReadDir(db.dir)
 	require.NoError(t, err)
 
-	// three files: snapshot, current link, wal, LOCK
-	require.Equal(t, 4, len(entries))
+	// snapshot, current link, wal, LOCK, upgrade history
+	require.Equal(t, 5, len(entries))
 }
 
 func TestWAL(t *testing.T) {
@@ -577,3 +588,1569 @@ func testIdempotentWrite(t *testing.T, asyncCommit bool) {
 	require.NoError(t, err)
 	require.Equal(t, commitInfo, *db.LastCommitInfo())
 }
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{
+		CreateIfMissing:    true,
+		InitialStores:      []string{"test"},
+		SnapshotKeepRecent: 10,
+	})
+	require.NoError(t, err)
+
+	for i, changes := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+
+		if i == len(ChangeSets)/2 {
+			require.NoError(t, db.RewriteSnapshot())
+			require.NoError(t, db.Reload())
+		}
+	}
+	commitInfo := *db.LastCommitInfo()
+	require.NoError(t, db.Close())
+
+	result, err := Verify(dir, NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, commitInfo, *result)
+
+	// corrupt the last value in the snapshot
+	snapshotVersion := int64(len(ChangeSets)/2 + 1)
+	kvsFile := filepath.Join(dir, snapshotName(snapshotVersion), "test", FileNameKVs)
+	bz, err := os.ReadFile(kvsFile)
+	require.NoError(t, err)
+	bz[len(bz)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(kvsFile, bz, 0o600))
+
+	_, err = Verify(dir, NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{
+		CreateIfMissing:    true,
+		InitialStores:      []string{"test"},
+		SnapshotKeepRecent: 100,
+	})
+	require.NoError(t, err)
+
+	for _, changes := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+		require.NoError(t, db.RewriteSnapshot())
+		require.NoError(t, db.Reload())
+	}
+	latest := db.Version()
+	require.NoError(t, db.Close())
+
+	result, err := PruneSnapshots(dir, 1, true)
+	require.NoError(t, err)
+	require.Equal(t, int(latest)-1, len(result.Snapshots))
+	require.Equal(t, int64(0), result.Snapshots[0])
+	require.Positive(t, result.SnapshotBytes)
+
+	// dry run don't remove anything
+	earliest, err := GetEarliestVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), earliest)
+
+	_, err = PruneSnapshots(dir, 1, false)
+	require.NoError(t, err)
+	earliest, err = GetEarliestVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, latest-1, earliest)
+
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, latest, db.Version())
+	require.NoError(t, db.Close())
+}
+
+func TestIterateWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	// read the WAL of the live db
+	latest, err := LatestWALVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(ChangeSets)), latest)
+	require.NoError(t, db.Close())
+
+	var versions []int64
+	require.NoError(t, IterateWAL(dir, 2, 4, func(version int64, entry *WALEntry) (bool, error) {
+		versions = append(versions, version)
+		require.Equal(t, ChangeSets[version-1], entry.Changesets[0].Changeset)
+		return true, nil
+	}))
+	require.Equal(t, []int64{2, 3, 4}, versions)
+
+	require.Error(t, IterateWAL(dir, 0, 0, func(int64, *WALEntry) (bool, error) {
+		return true, nil
+	}))
+}
+
+func TestInstallSnapshot(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	snapshotDir := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, db.WriteSnapshot(snapshotDir))
+	hash := db.TreeByName("test").RootHash()
+	require.NoError(t, db.Close())
+
+	dir := t.TempDir()
+	version, err := InstallSnapshot(dir, snapshotDir)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(ChangeSets)), version)
+
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, version, db.Version())
+	require.Equal(t, hash, db.TreeByName("test").RootHash())
+
+	// commit after restore
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+		{Name: "test", Changeset: ChangeSets[0]},
+	}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = InstallSnapshot(dir, snapshotDir)
+	require.Error(t, err)
+}
+
+func TestSnapshotStats(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{
+		CreateIfMissing:    true,
+		InitialStores:      []string{"test", "test2"},
+		SnapshotKeepRecent: 100,
+	})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets[:3] {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	require.NoError(t, db.Close())
+
+	versions, err := SnapshotVersions(dir)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 3}, versions)
+
+	stats, err := SnapshotStats(dir, 3)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(stats))
+	require.Equal(t, "test", stats[0].Name)
+	// hello, hello1, hello2, hello3
+	require.Equal(t, int64(4), stats[0].Keys)
+	require.Positive(t, stats[0].Bytes)
+	require.Equal(t, "test2", stats[1].Name)
+	require.Equal(t, int64(0), stats[1].Keys)
+
+	// the initial empty snapshot
+	stats, err = SnapshotStats(dir, 0)
+	require.NoError(t, err)
+	require.Empty(t, stats)
+}
+
+func TestSalvage(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{
+		CreateIfMissing:    true,
+		InitialStores:      []string{"test"},
+		SnapshotKeepRecent: 100,
+	})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets[:3] {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+			{Name: "test", Changeset: changes},
+		}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	hash := db.TreeByName("test").RootHash()
+	require.NoError(t, db.Close())
+
+	// a half-written snapshot, and a checksum-failing one
+	require.NoError(t, os.Mkdir(filepath.Join(dir, snapshotName(4)+TmpSuffix), os.ModePerm))
+	kvsFile := filepath.Join(dir, snapshotName(3), "test", FileNameKVs)
+	bz, err := os.ReadFile(kvsFile)
+	require.NoError(t, err)
+	bz[len(bz)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(kvsFile, bz, 0o600))
+
+	// only the structure is checked without verifying the hashes
+	result, err := Salvage(dir, "", false, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), result.Current)
+	require.Len(t, result.Damaged, 1)
+
+	quarantine := t.TempDir()
+	result, err = Salvage(dir, quarantine, true, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), result.Current)
+	require.True(t, result.Repointed)
+	require.False(t, result.WALReset)
+	require.Len(t, result.Damaged, 2)
+	require.DirExists(t, filepath.Join(quarantine, snapshotName(3)))
+
+	// the WAL is replayed on the valid snapshot
+	db, err = Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), db.Version())
+	require.Equal(t, hash, db.TreeByName("test").RootHash())
+	require.NoError(t, db.Close())
+}
+
+func TestCommitHook(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	var (
+		versions   []int64
+		changeSets [][]*NamedChangeSet
+	)
+	db.RegisterCommitHook(func(version int64, cs []*NamedChangeSet, commitInfo *CommitInfo) {
+		require.Equal(t, version, commitInfo.Version)
+		// the hook can query the db
+		require.Equal(t, version, db.LastCommitInfo().Version)
+		versions = append(versions, version)
+		changeSets = append(changeSets, cs)
+	})
+
+	cs := mockNameChangeSet("test", "hello", "world")
+	require.NoError(t, db.ApplyChangeSets(cs))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	require.Equal(t, []int64{1, 2}, versions)
+	require.Equal(t, [][]*NamedChangeSet{cs, nil}, changeSets)
+}
+
+func TestWaitDurable(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:         true,
+		InitialStores:           []string{"test"},
+		AsyncCommitBuffer:       10,
+		WaitDurableBeforeCommit: true,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", fmt.Sprintf("world%d", i))))
+		v, err := db.Commit()
+		require.NoError(t, err)
+
+		// at most one version is not persisted
+		committed, err := db.CommittedVersion()
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, committed, v-1)
+	}
+
+	require.NoError(t, db.WaitDurable())
+	committed, err := db.CommittedVersion()
+	require.NoError(t, err)
+	require.Equal(t, db.Version(), committed)
+}
+
+type recordMetrics struct {
+	nopMetrics
+	mtx      sync.Mutex
+	counters map[string]float32
+	measures map[string]int
+}
+
+func (m *recordMetrics) IncrCounter(val float32, keys ...string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.counters[strings.Join(keys, ".")] += val
+}
+
+func (m *recordMetrics) MeasureSince(_ time.Time, keys ...string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.measures == nil {
+		m.measures = make(map[string]int)
+	}
+	m.measures[strings.Join(keys, ".")]++
+}
+
+func TestCommitMetrics(t *testing.T) {
+	metrics := &recordMetrics{counters: make(map[string]float32)}
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, CacheSize: 10, Metrics: metrics})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	tree := db.TreeByName("test")
+	// the written value is cached
+	require.Equal(t, []byte("world"), tree.Get([]byte("hello")))
+	require.Nil(t, tree.Get([]byte("missing")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	require.Equal(t, float32(1), metrics.counters["store.memiavl.cache_miss.test"])
+	require.Equal(t, float32(1), metrics.counters["store.memiavl.cache_hit.test"])
+}
+
+func TestCommitPhaseMetrics(t *testing.T) {
+	metrics := &recordMetrics{counters: make(map[string]float32)}
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, AsyncCommitBuffer: -1, Metrics: metrics})
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", fmt.Sprint(i))))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+
+	for _, phase := range []string{"commit", "commit_apply", "commit_hash", "commit_wal_marshal", "commit_wal_write"} {
+		require.Equal(t, 3, metrics.measures["store.memiavl."+phase], phase)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	v, err := db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshotBackground())
+
+	// the in-flight snapshot rewrite completes before shutdown
+	require.NoError(t, db.Shutdown(context.Background()))
+	current, err := currentVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, v, current)
+
+	_, err = db.Commit()
+	require.ErrorIs(t, err, errClosed)
+	require.NoError(t, db.Close())
+}
+
+func TestStandby(t *testing.T) {
+	dir := t.TempDir()
+	primary, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+
+	commit := func(db *DB, i int) {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSets[i]}}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		commit(primary, i)
+	}
+	// the WAL is written asynchronously, wait for it before reading from the standby
+	require.NoError(t, primary.WaitDurable())
+
+	standby, err := Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer standby.Close()
+	require.Equal(t, int64(3), standby.Version())
+
+	for i := 3; i < 5; i++ {
+		commit(primary, i)
+	}
+	require.NoError(t, primary.WaitDurable())
+	require.NoError(t, standby.CatchupWAL())
+	require.Equal(t, int64(5), standby.Version())
+	require.Equal(t, RefHashes[4], standby.TreeByName("test").RootHash())
+	// the copied view is not modified by the catch-up, nor closed by the snapshot switch
+	view := standby.CopyView()
+	require.NotNil(t, view)
+
+	// the newer snapshot is reloaded
+	require.NoError(t, primary.RewriteSnapshot())
+	commit(primary, 5)
+	require.NoError(t, primary.WaitDurable())
+	require.NoError(t, standby.CatchupWAL())
+	require.Equal(t, int64(6), standby.Version())
+	require.Equal(t, RefHashes[5], standby.TreeByName("test").RootHash())
+
+	require.Equal(t, int64(5), view.Version())
+	require.Equal(t, RefHashes[4], view.TreeByName("test").RootHash())
+	require.NoError(t, view.Close())
+
+	require.ErrorIs(t, primary.CatchupWAL(), errNotReadOnly)
+	require.NoError(t, primary.Close())
+}
+
+func TestWorkingHash(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test1", "test2", "test3"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	// compatible with the sdk's root multistore
+	sdkHash := func(ci *CommitInfo) []byte {
+		sdkInfo := &storetypes.CommitInfo{Version: ci.Version}
+		for _, info := range ci.StoreInfos {
+			sdkInfo.StoreInfos = append(sdkInfo.StoreInfos, storetypes.StoreInfo{
+				Name:     info.Name,
+				CommitId: storetypes.CommitID{Version: info.CommitId.Version, Hash: info.CommitId.Hash},
+			})
+		}
+		return sdkInfo.Hash()
+	}
+	require.Equal(t, sdkHash(&CommitInfo{}), (&CommitInfo{}).Hash())
+
+	for i, changes := range ChangeSets[:5] {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: fmt.Sprintf("test%d", i%3+1), Changeset: changes}}))
+		workingHash := db.WorkingHash()
+		require.Equal(t, sdkHash(db.WorkingCommitInfo()), workingHash)
+
+		_, err := db.Commit()
+		require.NoError(t, err)
+		require.Equal(t, workingHash, db.LastCommitInfo().Hash())
+	}
+}
+
+func TestCommitmentOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, CommitmentOnly: true})
+	require.NoError(t, err)
+	ref, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer ref.Close()
+
+	for i, changes := range ChangeSets {
+		for _, d := range []*DB{db, ref} {
+			require.NoError(t, d.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: changes}}))
+			_, err := d.Commit()
+			require.NoError(t, err)
+		}
+		require.Equal(t, RefHashes[i], db.TreeByName("test").RootHash())
+
+		// rewrite on top of the commitment-only snapshot don't hash the values twice
+		require.NoError(t, db.RewriteSnapshot())
+		require.NoError(t, db.Reload())
+		require.True(t, db.TreeByName("test").snapshot.CommitmentOnly())
+		require.Equal(t, RefHashes[i], db.TreeByName("test").RootHash())
+	}
+
+	iter := ref.TreeByName("test").Iterator(nil, nil, true)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		valueHash := sha256.Sum256(iter.Value())
+		require.Equal(t, valueHash[:], db.TreeByName("test").Get(iter.Key()))
+	}
+	require.NoError(t, db.Close())
+
+	_, err = Load(dir, Options{})
+	require.ErrorIs(t, err, errCommitmentOnly)
+	_, err = NewMultiTreeExporter(dir, uint32(len(ChangeSets)), false)
+	require.ErrorIs(t, err, errCommitmentOnly)
+}
+
+func TestSharedSnapshotWriterPool(t *testing.T) {
+	pool := NewSnapshotWriterPool(2)
+	defer pool.Stop()
+
+	var dbs []*DB
+	for i := 0; i < 3; i++ {
+		db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test1", "test2"}, SnapshotWriterPool: pool})
+		require.NoError(t, err)
+		defer db.Close()
+		require.Same(t, pool, db.snapshotWriterPool)
+		dbs = append(dbs, db)
+	}
+
+	for _, changes := range ChangeSets[:3] {
+		for _, db := range dbs {
+			require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test1", Changeset: changes}}))
+			_, err := db.Commit()
+			require.NoError(t, err)
+			require.NoError(t, db.RewriteSnapshot())
+			require.NoError(t, db.Reload())
+		}
+	}
+	for _, db := range dbs {
+		require.Equal(t, RefHashes[2], db.TreeByName("test1").RootHash())
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	metrics := &recordMetrics{counters: make(map[string]float32)}
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:   true,
+		InitialStores:     []string{"test", "cold"},
+		SnapshotInterval:  1000,
+		CacheSize:         10,
+		MemoryBudgetBytes: 4096,
+		Metrics:           metrics,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("cold", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.Nil(t, db.snapshotRewriteChan)
+	require.Equal(t, 1, db.TreeByName("cold").cache.Len())
+
+	// exceed the budget
+	for i := 0; db.snapshotRewriteChan == nil; i++ {
+		require.Less(t, i, 100)
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", fmt.Sprint(i), strings.Repeat("a", 100))))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	require.Equal(t, float32(1), metrics.counters["store.memiavl.memory_budget_exceeded"])
+
+	// the cold caches are purged while the rewrite is in progress
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	// or the new trees have empty caches if the rewrite is already done
+	require.Zero(t, db.TreeByName("cold").cache.Len())
+
+	// the in-memory nodes are dropped after the snapshot switch
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.checkAsyncTasks())
+	}
+	require.Less(t, db.MultiTree.memoryUsage(), uint64(4096))
+}
+
+func TestCachePrewarm(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:  true,
+		InitialStores:    []string{"test"},
+		CacheSize:        10,
+		CachePrewarmSize: 2,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", fmt.Sprint(i), fmt.Sprint(i))))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+
+	// only the last two accessed keys are recorded
+	tree := db.TreeByName("test")
+	for _, key := range []string{"0", "1", "2"} {
+		require.Equal(t, []byte(key), tree.Get([]byte(key)))
+	}
+
+	require.NoError(t, db.RewriteSnapshotBackground())
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.checkAsyncTasks())
+	}
+
+	cache := db.TreeByName("test").cache
+	require.Equal(t, 2, cache.Len())
+	for _, key := range []string{"1", "2"} {
+		value, ok := cache.Get([]byte(key))
+		require.True(t, ok)
+		require.Equal(t, []byte(key), value)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:  true,
+		InitialStores:    []string{"test"},
+		SnapshotInterval: 5,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v := db.Version()
+				if v < last || db.LastCommitInfo().Version < v || db.TreeByName("test") == nil {
+					t.Errorf("inconsistent view at version %d", v)
+					return
+				}
+				last = v
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", fmt.Sprint(i), fmt.Sprint(i))))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	close(done)
+	wg.Wait()
+
+	require.Equal(t, int64(50), db.Version())
+	require.Equal(t, db.MultiTree.LastCommitInfo().Hash(), db.LastCommitInfo().Hash())
+}
+
+func TestAdaptiveSnapshotInterval(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		CreateIfMissing:       true,
+		InitialStores:         []string{"test"},
+		SnapshotMaxReplayTime: time.Second,
+	}
+	db, err := Load(dir, opts)
+	require.NoError(t, err)
+	require.Equal(t, float64(DefaultWALReplayRate), db.replayRate)
+
+	// replay 1KiB per second, the rewrite is triggered after 1KiB of WAL, long before the snapshot interval
+	db.replayRate = 1024
+	for i := 0; db.snapshotRewriteChan == nil; i++ {
+		require.Less(t, i, 100)
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", fmt.Sprint(i), strings.Repeat("a", 100))))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	walBytes := db.walBytes
+	require.GreaterOrEqual(t, walBytes, uint64(1024))
+
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.checkAsyncTasks())
+	}
+	require.Equal(t, db.Version(), db.SnapshotVersion())
+	require.Zero(t, db.walBytes)
+
+	// the WAL after the snapshot is counted again on restart
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	walBytes = db.walBytes
+	require.NotZero(t, walBytes)
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, walBytes, db.walBytes)
+}
+
+func TestWALReplayConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	stores := []string{"a", "b", "c"}
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: stores})
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		var changeSets []*NamedChangeSet
+		for _, name := range stores {
+			changeSets = append(changeSets, mockNameChangeSet(name, fmt.Sprint(i), name+fmt.Sprint(i))...)
+		}
+		require.NoError(t, db.ApplyChangeSets(changeSets))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	commitInfo := *db.LastCommitInfo()
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, Options{WALReplayConcurrency: 2})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, commitInfo.Version, db.Version())
+	require.Equal(t, commitInfo.Hash(), db.LastCommitInfo().Hash())
+}
+
+func TestCacheAdvisor(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:    true,
+		InitialStores:      []string{"test"},
+		CacheSize:          10,
+		CacheAdvisorWindow: 2,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	// the unique keys found are recorded, regardless of the cache hits
+	tree := db.TreeByName("test")
+	for i := 0; i < 2; i++ {
+		require.Equal(t, []byte("world"), tree.Get([]byte("hello")))
+	}
+	require.Nil(t, tree.Get([]byte("missing")))
+	ws := db.workingSets["test"]
+	require.Len(t, ws.sizes, 1)
+
+	// the working set is kept across the snapshot switch
+	require.NoError(t, db.RewriteSnapshotBackground())
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.checkAsyncTasks())
+	}
+	require.Same(t, ws, db.TreeByName("test").workingSet)
+	require.Len(t, ws.sizes, 1)
+
+	// reset at the end of the window
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.Empty(t, ws.sizes)
+}
+
+func TestGetPinned(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the value is in memory before the snapshot is rewritten
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	value, err := db.GetPinned("test", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), value.Bytes())
+	require.NoError(t, value.Release())
+
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+
+	value, err = db.GetPinned("test", []byte("hello"))
+	require.NoError(t, err)
+	snapshot := db.TreeByName("test").snapshot
+	require.NotNil(t, snapshot.kvs)
+
+	// the pinned snapshot is kept mapped after the switch
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world1")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	require.NotNil(t, snapshot.kvs)
+	require.Equal(t, []byte("world"), value.Bytes())
+
+	require.NoError(t, value.Release())
+	require.Nil(t, snapshot.kvs)
+	require.Nil(t, value.Bytes())
+	require.NoError(t, value.Release())
+	require.False(t, snapshot.pin())
+
+	value, err = db.GetPinned("test", []byte("missing"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	_, err = db.GetPinned("unknown", []byte("hello"))
+	require.Error(t, err)
+
+	// the closed tree is detected
+	tree := db.TreeByName("test")
+	require.NoError(t, tree.Close())
+	_, err = tree.GetPinned([]byte("hello"))
+	require.ErrorIs(t, err, errTreeClosed)
+}
+
+func TestHugePageNodes(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, HugePageNodes: true})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	// the advice is best effort, it doesn't fail where the huge pages are not supported
+	require.NoError(t, db.Reload())
+	require.Equal(t, []byte("world"), db.TreeByName("test").Get([]byte("hello")))
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, Options{HugePageNodes: true, PrefaultNodes: true})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, []byte("world"), db.TreeByName("test").Get([]byte("hello")))
+}
+
+func TestAcquireView(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+
+	view := db.AcquireView()
+	require.NotNil(t, view)
+	require.Equal(t, int64(1), view.Version())
+	tree := view.TreeByName("test")
+
+	// the old trees are kept open across the switch until the view is released
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world1")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	require.NotSame(t, tree, db.TreeByName("test"))
+	require.NotNil(t, tree.snapshot)
+	require.Equal(t, []byte("world1"), tree.Get([]byte("hello")))
+	require.Equal(t, int64(1), view.Version())
+	require.Equal(t, int64(2), db.Version())
+
+	require.NoError(t, view.Release())
+	require.Nil(t, tree.snapshot)
+	require.NoError(t, view.Release())
+
+	// the trees are closed after the last view is released, even if the db is closed first
+	view = db.AcquireView()
+	require.NotNil(t, view)
+	tree = view.TreeByName("test")
+	require.NoError(t, db.Close())
+	require.Nil(t, db.AcquireView())
+	require.Equal(t, []byte("world1"), tree.Get([]byte("hello")))
+	require.NoError(t, view.Release())
+	require.Nil(t, tree.snapshot)
+}
+
+func TestSetSnapshotWriterLimit(t *testing.T) {
+	pool := NewSnapshotWriterPool(2)
+	defer pool.Stop()
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:     true,
+		InitialStores:       []string{"test1", "test2", "test3"},
+		SnapshotWriterLimit: 1,
+		SnapshotWriterPool:  pool,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 1, db.SnapshotWriterLimit())
+
+	// capped by the pool
+	require.Equal(t, 2, db.SetSnapshotWriterLimit(8))
+	require.Equal(t, 2, db.SnapshotWriterLimit())
+	require.Equal(t, 1, db.SetSnapshotWriterLimit(0))
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test1", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	require.Equal(t, []byte("world"), db.TreeByName("test1").Get([]byte("hello")))
+}
+
+func TestWriterLimiter(t *testing.T) {
+	limiter := newWriterLimiter(1)
+	require.NoError(t, limiter.acquire(context.Background()))
+
+	// the waiter is woken up by raising the limit
+	acquired := make(chan error)
+	go func() {
+		acquired <- limiter.acquire(context.Background())
+	}()
+	limiter.setLimit(2)
+	require.NoError(t, <-acquired)
+
+	// lowering the limit doesn't revoke the permits in use
+	limiter.setLimit(1)
+	limiter.release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, limiter.acquire(ctx), context.DeadlineExceeded)
+
+	limiter.release()
+	require.NoError(t, limiter.acquire(context.Background()))
+}
+
+func TestSnapshotEvents(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "events.jsonl")
+	var (
+		mtx    sync.Mutex
+		events []SnapshotEvent
+	)
+	db, err := Load(dir, Options{
+		CreateIfMissing:  true,
+		InitialStores:    []string{"test"},
+		SnapshotEventLog: logPath,
+		SnapshotEventHandler: func(event SnapshotEvent) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			events = append(events, event)
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshotBackground())
+	// the commits switch to the new snapshot once it's ready, the WAL entries after it are kept
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	// wait for the pruning
+	db.pruneSnapshotLock.Lock()
+	defer db.pruneSnapshotLock.Unlock()
+	require.NoError(t, db.Close())
+
+	expTypes := []string{
+		SnapshotEventRewriteStarted,
+		SnapshotEventRewriteFinished,
+		SnapshotEventSwitched,
+		SnapshotEventPruned,
+		SnapshotEventWALTruncated,
+	}
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	require.Equal(t, expTypes, types)
+	require.Equal(t, int64(1), events[1].Version)
+	require.Equal(t, filepath.Join(dir, snapshotName(1)), events[1].Path)
+	require.Positive(t, events[1].Size)
+	require.Equal(t, int64(0), events[3].Version)
+
+	// the same events are appended to the log file
+	bz, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bz)), "\n")
+	require.Len(t, lines, len(events))
+	for i, line := range lines {
+		var event SnapshotEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		require.Equal(t, events[i].Type, event.Type)
+		require.Equal(t, events[i].Version, event.Version)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, AsyncCommitBuffer: 10})
+	require.NoError(t, err)
+
+	health := db.Health()
+	require.True(t, health.Healthy)
+	require.False(t, health.AsyncCommit.Running)
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.True(t, db.Health().AsyncCommit.Running)
+
+	// the rewrite fails to create the temporary directory, it's reported without waiting for the next commit
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotName(1)+TmpSuffix), nil, 0o600))
+	require.NoError(t, db.RewriteSnapshotBackground())
+	require.Eventually(t, func() bool {
+		return db.Health().SnapshotRewrite.LastError != ""
+	}, 10*time.Second, time.Millisecond)
+	health = db.Health()
+	require.False(t, health.Healthy)
+	require.False(t, health.SnapshotRewrite.LastErrorTime.IsZero())
+
+	// the next success clears the failure, the failed rewrite has removed the temporary path already
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, snapshotName(1)+TmpSuffix)))
+	for db.rewriteInFlight() {
+		db.mtx.Lock()
+		err := db.checkAsyncTasks()
+		db.mtx.Unlock()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshotBackground())
+	// the WAL entries after the snapshot are kept by the truncation
+	for db.rewriteInFlight() {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	// wait for the pruning
+	db.pruneSnapshotLock.Lock()
+	defer db.pruneSnapshotLock.Unlock()
+	health = db.Health()
+	require.True(t, health.Healthy, health)
+	require.Equal(t, int64(1), health.SnapshotRewrite.LastSuccessVersion)
+	require.Empty(t, health.SnapshotRewrite.LastError)
+	require.Equal(t, int64(1), health.SnapshotPrune.LastSuccessVersion)
+
+	require.NoError(t, db.Close())
+	health = db.Health()
+	require.False(t, health.Healthy)
+	require.True(t, health.Closed)
+	require.False(t, health.AsyncCommit.Running)
+}
+
+func TestRewriteStallWatchdog(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:     true,
+		InitialStores:       []string{"test"},
+		SnapshotWriterLimit: 1,
+		RewriteStallTimeout: 50 * time.Millisecond,
+		RetryStalledRewrite: true,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	// the rewrite waits for the writer permit forever
+	require.NoError(t, db.snapshotWriterLimiter.acquire(context.Background()))
+	require.NoError(t, db.RewriteSnapshotBackground())
+	require.Eventually(t, func() bool {
+		return !db.rewriting.Load()
+	}, 10*time.Second, time.Millisecond)
+	require.True(t, db.rewriteRetry.Load())
+	require.Contains(t, db.Health().SnapshotRewrite.LastError, context.Canceled.Error())
+
+	// retried on the next commit
+	db.snapshotWriterLimiter.release()
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world1")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.False(t, db.rewriteRetry.Load())
+	require.NotNil(t, db.snapshotRewriteChan)
+	for db.snapshotRewriteChan != nil {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world2")))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(2), db.SnapshotVersion())
+}
+
+func TestStatusFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, StatusInterval: time.Millisecond})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+		_, err = db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.WaitDurable())
+
+	var status Status
+	require.Eventually(t, func() bool {
+		bz, err := os.ReadFile(filepath.Join(dir, StatusFileName))
+		if err != nil {
+			return false
+		}
+		require.NoError(t, json.Unmarshal(bz, &status))
+		return status.WALLastVersion == 2
+	}, 10*time.Second, time.Millisecond)
+	require.Equal(t, int64(2), status.Version)
+	require.Equal(t, []int64{0}, status.Snapshots)
+	require.Equal(t, int64(1), status.WALFirstVersion)
+	require.Positive(t, status.Disk.SnapshotBytes)
+	require.True(t, status.Health.Healthy)
+
+	// the writer is stopped on close
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, StatusFileName)))
+	time.Sleep(10 * time.Millisecond)
+	require.NoFileExists(t, filepath.Join(dir, StatusFileName))
+}
+
+func TestQueryMetrics(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, QueryMetrics: true})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+
+	// the statistics are attached to the reloaded tree
+	tree := db.TreeByName("test")
+	require.Equal(t, []byte("world"), tree.Get([]byte("hello")))
+	require.True(t, tree.Has([]byte("hello")))
+	iter := tree.Iterator(nil, nil, true)
+	require.True(t, iter.Valid())
+	require.NoError(t, iter.Close())
+	require.NoError(t, iter.Close())
+
+	totals := db.queryStats["test"].totals()
+	require.Equal(t, uint64(2), totals.gets)
+	require.Positive(t, totals.getNanos)
+	require.Equal(t, uint64(1), totals.iterators)
+
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.Equal(t, totals, db.queryStats["test"].reported)
+}
+
+func TestWALLagAlert(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, WALLagAlertThreshold: 1})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	v, err := db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.WaitDurable())
+	require.Zero(t, db.walLag(v))
+	require.Equal(t, uint64(2), db.walLag(v+2))
+
+	db.monitorWALLag(db.walLag(v + 2))
+	health := db.Health()
+	require.True(t, health.AsyncCommit.LagExceeded)
+	require.False(t, health.Healthy)
+
+	db.monitorWALLag(db.walLag(v))
+	health = db.Health()
+	require.False(t, health.AsyncCommit.LagExceeded)
+	require.True(t, health.Healthy)
+}
+
+func TestSlowCommit(t *testing.T) {
+	var slowCommits []SlowCommit
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:          true,
+		InitialStores:            []string{"small", "large"},
+		SlowCommitPhaseThreshold: time.Nanosecond,
+		SlowCommitHandler: func(commit SlowCommit) {
+			slowCommits = append(slowCommits, commit)
+		},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("small", "hello", "world")))
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{
+		Name: "large",
+		Changeset: ChangeSet{Pairs: []*KVPair{
+			{Key: []byte("hello"), Value: bytes.Repeat([]byte("x"), 100)},
+			{Key: []byte("world"), Value: bytes.Repeat([]byte("x"), 100)},
+		}},
+	}}))
+	v, err := db.Commit()
+	require.NoError(t, err)
+
+	require.Len(t, slowCommits, 1)
+	slow := slowCommits[0]
+	require.Equal(t, v, slow.Version)
+	require.Positive(t, slow.Apply)
+	require.Positive(t, slow.Hash)
+	require.Equal(t, "large", slow.LargestStore)
+	require.Equal(t, 210, slow.LargestStoreBytes)
+	require.Equal(t, 2, slow.LargestStorePairs)
+
+	// the phases are reset after each commit
+	db.slowCommit.phaseThreshold = time.Hour
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.Len(t, slowCommits, 1)
+	require.Zero(t, db.phases)
+}
+
+func TestMinFreeDiskBytes(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("free disk space is not supported")
+	}
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, MinFreeDiskBytes: math.MaxUint64})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.True(t, db.Health().LowDiskSpace)
+	require.False(t, db.Health().Healthy)
+	require.ErrorIs(t, db.RewriteSnapshot(), errInsufficientDiskSpace)
+	require.ErrorIs(t, db.RewriteSnapshotBackground(), errInsufficientDiskSpace)
+
+	db.minFreeDiskBytes = 1
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.False(t, db.Health().LowDiskSpace)
+	require.NoError(t, db.RewriteSnapshot())
+
+	usage, err := db.DiskUsage()
+	require.NoError(t, err)
+	require.Positive(t, usage.SnapshotBytes)
+	require.Positive(t, usage.WALBytes)
+	require.Positive(t, usage.FreeBytes)
+}
+
+func TestBackgroundTasks(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, AsyncCommitBuffer: 10})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, BackgroundTasks{}, db.BackgroundTasks())
+
+	for _, cs := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: cs}}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	tasks := db.BackgroundTasks()
+	require.True(t, tasks.AsyncCommit.Running)
+	require.Equal(t, 10, tasks.AsyncCommit.QueueCapacity)
+
+	// the new snapshot waits for the next commit to switch to it
+	require.NoError(t, db.RewriteSnapshotBackground())
+	require.Eventually(t, func() bool {
+		return db.BackgroundTasks().SnapshotRewrite.Phase == RewritePhaseReady
+	}, 10*time.Second, time.Millisecond)
+	rewrite := db.BackgroundTasks().SnapshotRewrite
+	require.True(t, rewrite.Running)
+	require.Equal(t, int64(len(ChangeSets)), rewrite.Version)
+	require.Equal(t, float64(100), rewrite.Percent)
+	require.Empty(t, rewrite.Stores)
+
+	// the switch is the signal of the completion
+	for db.rewriteInFlight() {
+		db.mtx.Lock()
+		err := db.checkAsyncTasks()
+		db.mtx.Unlock()
+		require.NoError(t, err)
+	}
+	require.False(t, db.BackgroundTasks().SnapshotRewrite.Running)
+
+	// the pruning triggered by the switch is not reported running after it finishes
+	db.pruneSnapshotLock.Lock()
+	defer db.pruneSnapshotLock.Unlock()
+	require.False(t, db.BackgroundTasks().SnapshotPrune.Running)
+}
+
+func TestRewriteProgress(t *testing.T) {
+	progress := &rewriteProgress{}
+	progress.start(10)
+	progress.addTotalLeaves(4000)
+	progress.storeStarted("acc")
+	progress.storeStarted("bank")
+	progress.addLeaves(1000)
+
+	status := progress.status()
+	require.Equal(t, RewritePhaseWriting, status.Phase)
+	require.Equal(t, int64(10), status.Version)
+	require.Equal(t, []string{"acc", "bank"}, status.Stores)
+	require.Equal(t, float64(25), status.Percent)
+
+	progress.storeFinished("acc")
+	progress.addLeaves(3000)
+	status = progress.status()
+	require.Equal(t, []string{"bank"}, status.Stores)
+	require.Equal(t, float64(100), status.Percent)
+
+	var nilProgress *rewriteProgress
+	nilProgress.addLeaves(1)
+	nilProgress.storeStarted("acc")
+}
+
+func TestUpgradeHistory(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"a", "b"}})
+	require.NoError(t, err)
+
+	history, err := db.UpgradeHistory()
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyUpgrades([]*TreeNameUpgrade{
+		{Name: "c", RenameFrom: "b"},
+		{Name: "a", Delete: true},
+	}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	// no upgrades in this version
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	history, err = db.UpgradeHistory()
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	for i, exp := range []UpgradeRecord{
+		{Version: 1, Name: "a"},
+		{Version: 1, Name: "b"},
+		{Version: 2, Name: "c", RenameFrom: "b"},
+		{Version: 2, Name: "a", Delete: true},
+	} {
+		require.False(t, history[i].Time.IsZero())
+		history[i].Time = time.Time{}
+		require.Equal(t, exp, history[i])
+	}
+}
+
+// panicNode panics on any method call
+type panicNode struct {
+	Node
+}
+
+func TestSnapshotWritePanic(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	// the panic of the tree writer is returned as an error, rather than swallowed by the worker pool
+	db.TreeByName("test").root = panicNode{}
+	err = db.RewriteSnapshot()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "writing snapshot of test panicked")
+}
+
+func TestOnCriticalError(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		contexts []string
+	)
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing:     true,
+		InitialStores:       []string{"test"},
+		SnapshotWriterLimit: 1,
+		RewriteStallTimeout: 50 * time.Millisecond,
+		OnCriticalError: func(err error, context string) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			contexts = append(contexts, context)
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	// the stalled rewrite is cancelled by the watchdog
+	require.NoError(t, db.snapshotWriterLimiter.acquire(context.Background()))
+	require.NoError(t, db.RewriteSnapshotBackground())
+	require.Eventually(t, func() bool {
+		return !db.rewriting.Load()
+	}, 10*time.Second, time.Millisecond)
+	db.snapshotWriterLimiter.release()
+
+	mtx.Lock()
+	require.Equal(t, []string{CriticalErrorSnapshotRewrite}, contexts)
+	mtx.Unlock()
+
+	// not alerted after closed
+	require.NoError(t, db.Close())
+	db.health.pruneFailed(errors.New("closed"))
+	mtx.Lock()
+	require.Equal(t, []string{CriticalErrorSnapshotRewrite}, contexts)
+	mtx.Unlock()
+}
+
+func TestStats(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test", "test2"}, CacheSize: 10})
+	require.NoError(t, err)
+
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{
+		{Name: "test", Changeset: ChangeSet{Pairs: mockKVPairs("hello", "world")}},
+		{Name: "test2", Changeset: ChangeSet{Pairs: mockKVPairs("hello", "world")}},
+	}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	require.Equal(t, []byte("world"), db.TreeByName("test").Get([]byte("hello")))
+	require.Nil(t, db.TreeByName("test2").Get([]byte("missing")))
+
+	stats := db.Stats()
+	require.Equal(t, CacheStats{Hits: 1, Entries: 1, Bytes: 10}, stats.Stores["test"])
+	require.Equal(t, CacheStats{Misses: 1, Entries: 1, Bytes: 10}, stats.Stores["test2"])
+	require.Equal(t, CacheStats{Hits: 1, Misses: 1, Entries: 2, Bytes: 20}, stats.Cache)
+
+	require.NoError(t, db.Close())
+	require.Empty(t, db.Stats().Stores)
+}
+
+func TestCurrentFile(t *testing.T) {
+	useCurrentFile = true
+	defer func() { useCurrentFile = false }()
+
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	require.NoError(t, db.Close())
+
+	_, err = os.Lstat(filepath.Join(dir, "current"))
+	require.True(t, os.IsNotExist(err))
+	bz, err := os.ReadFile(filepath.Join(dir, CurrentFileName))
+	require.NoError(t, err)
+	require.Equal(t, snapshotName(1)+"\n", string(bz))
+
+	// the symlink platforms fall back to the file
+	useCurrentFile = false
+	version, err := GetLatestVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), db.TreeByName("test").Get([]byte("hello")))
+	require.NoError(t, db.Close())
+}
+
+func TestSharedFileLock(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// the read-only opens don't block each other, but the writers
+	reader1, err := Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	require.NotNil(t, reader1.fileLock)
+	reader2, err := Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	require.NotNil(t, reader2.fileLock)
+	_, err = Load(dir, Options{})
+	require.Error(t, err)
+	require.NoError(t, reader1.Close())
+	require.NoError(t, reader2.Close())
+
+	// the long-lived readers don't lock, so the writer could start while they are open
+	reader, err := Load(dir, Options{ReadOnly: true, NoLock: true})
+	require.NoError(t, err)
+	require.Nil(t, reader.fileLock)
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, reader.Close())
+
+	// a read-only db could still follow a running writer, without the lock
+	db, err = Load(dir, Options{})
+	require.NoError(t, err)
+	defer db.Close()
+	reader, err = Load(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	require.Nil(t, reader.fileLock)
+	require.NoError(t, reader.Close())
+
+	// the writer still blocks the other writers
+	_, err = LockFile(filepath.Join(dir, LockFileName), LockExclusive)
+	require.Error(t, err)
+}
+
+func TestPinVersion(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	commit := func(rewrite bool) {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", fmt.Sprintf("world%d", db.Version()))))
+		_, err := db.Commit()
+		require.NoError(t, err)
+		if rewrite {
+			require.NoError(t, db.RewriteSnapshot())
+			require.NoError(t, db.Reload())
+			db.pruneSnapshots()
+			// wait for the pruning
+			db.pruneSnapshotLock.Lock()
+			db.pruneSnapshotLock.Unlock()
+		}
+	}
+
+	commit(true)
+	commit(false)
+	commit(false)
+	require.Error(t, db.PinVersion(4))
+	require.NoError(t, db.PinVersion(2))
+	require.NoError(t, db.PinVersion(2))
+
+	// the snapshot of the pinned version and the WAL after it are kept
+	commit(true)
+	require.DirExists(t, filepath.Join(dir, snapshotName(1)))
+	require.NoError(t, db.Unpin(2))
+	commit(true)
+	require.DirExists(t, filepath.Join(dir, snapshotName(1)))
+	historical, err := Load(dir, Options{ReadOnly: true, TargetVersion: 2})
+	require.NoError(t, err)
+	require.Equal(t, []byte("world1"), historical.TreeByName("test").Get([]byte("hello")))
+	require.NoError(t, historical.Close())
+
+	// the snapshots are removed outside of the lock, the versions loaded from them can't be pinned meanwhile
+	db.pins.mtx.Lock()
+	db.pins.pruning = map[int64]struct{}{1: {}}
+	db.pins.mtx.Unlock()
+	require.Error(t, db.PinVersion(3))
+	db.pins.mtx.Lock()
+	db.pins.pruning = nil
+	db.pins.mtx.Unlock()
+
+	// pruned after all the references are released
+	require.NoError(t, db.Unpin(2))
+	require.Error(t, db.Unpin(2))
+	commit(true)
+	earliest, err := GetEarliestVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), earliest)
+	require.Error(t, db.PinVersion(2))
+}
+
+func TestCommitInfoMismatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test", "test2"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Close())
+
+	// tamper the persisted commit info
+	path := filepath.Join(dir, snapshotName(1))
+	metadata, err := readMetadata(path)
+	require.NoError(t, err)
+	metadata.CommitInfo.StoreInfos[1].CommitId.Hash = []byte("invalid")
+	bz, err := metadata.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, MetadataFileName), bz, 0o600))
+
+	_, err = Load(dir, Options{})
+	var mismatch *CommitInfoMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	require.Equal(t, int64(1), mismatch.Version)
+	require.Len(t, mismatch.Stores, 1)
+	require.Contains(t, mismatch.Stores[0], "store test2: root hash mismatch")
+}
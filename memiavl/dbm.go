@@ -0,0 +1,215 @@
+package memiavl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	dbm "github.com/cosmos/cosmos-db"
+)
+
+var (
+	errKeyEmpty    = errors.New("key cannot be empty")
+	errValueNil    = errors.New("value cannot be nil")
+	errBatchClosed = errors.New("batch has been written or closed")
+)
+
+var (
+	_ dbm.DB    = (*DBAdapter)(nil)
+	_ dbm.Batch = (*adapterBatch)(nil)
+)
+
+// DBAdapter presents the latest state of a store through the `dbm.DB` interface, so the existing tooling which only
+// speaks dbm can operate on memiavl data. The reads see the working state, the writes are applied to the db as the
+// pending change set of the store, they are persisted in the next `DB.Commit` call, which is the responsibility of
+// the caller.
+//
+// Like the tree itself, the store must not be written while iterating, use a batch to delete the iterated keys.
+type DBAdapter struct {
+	db   *DB
+	name string
+}
+
+// NewDBAdapter returns a `dbm.DB` view of the store in the db.
+func NewDBAdapter(db *DB, name string) *DBAdapter {
+	return &DBAdapter{db: db, name: name}
+}
+
+func (a *DBAdapter) tree() *Tree {
+	tree := a.db.TreeByName(a.name)
+	if tree == nil {
+		// the store don't exist yet, reads see an empty store
+		return New(0)
+	}
+	return tree
+}
+
+// Get implements `dbm.DB` interface.
+func (a *DBAdapter) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	return a.tree().Get(key), nil
+}
+
+// Has implements `dbm.DB` interface.
+func (a *DBAdapter) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	return a.tree().Has(key), nil
+}
+
+// Set implements `dbm.DB` interface.
+func (a *DBAdapter) Set(key, value []byte) error {
+	return a.apply([]*KVPair{{Key: bytes.Clone(key), Value: bytes.Clone(value)}})
+}
+
+// SetSync implements `dbm.DB` interface, it's the same as `Set`, the durability is decided by the commit.
+func (a *DBAdapter) SetSync(key, value []byte) error {
+	return a.Set(key, value)
+}
+
+// Delete implements `dbm.DB` interface.
+func (a *DBAdapter) Delete(key []byte) error {
+	return a.apply([]*KVPair{{Key: bytes.Clone(key), Delete: true}})
+}
+
+// DeleteSync implements `dbm.DB` interface, it's the same as `Delete`, the durability is decided by the commit.
+func (a *DBAdapter) DeleteSync(key []byte) error {
+	return a.Delete(key)
+}
+
+func (a *DBAdapter) apply(pairs []*KVPair) error {
+	for _, pair := range pairs {
+		if len(pair.Key) == 0 {
+			return errKeyEmpty
+		}
+		if !pair.Delete && pair.Value == nil {
+			return errValueNil
+		}
+	}
+	// check in advance, the db don't rollback the pending change set on failure
+	if a.db.TreeByName(a.name) == nil {
+		return fmt.Errorf("unknown tree name %s", a.name)
+	}
+	return a.db.ApplyChangeSet(a.name, ChangeSet{Pairs: pairs})
+}
+
+// Iterator implements `dbm.DB` interface.
+func (a *DBAdapter) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	return a.tree().Iterator(start, end, true), nil
+}
+
+// ReverseIterator implements `dbm.DB` interface.
+func (a *DBAdapter) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	return a.tree().Iterator(start, end, false), nil
+}
+
+// Close implements `dbm.DB` interface, the db is owned by the caller, so it's not closed.
+func (a *DBAdapter) Close() error {
+	return nil
+}
+
+// NewBatch implements `dbm.DB` interface.
+func (a *DBAdapter) NewBatch() dbm.Batch {
+	return &adapterBatch{adapter: a}
+}
+
+// NewBatchWithSize implements `dbm.DB` interface.
+func (a *DBAdapter) NewBatchWithSize(size int) dbm.Batch {
+	return &adapterBatch{adapter: a, pairs: make([]*KVPair, 0, size)}
+}
+
+// Print implements `dbm.DB` interface.
+func (a *DBAdapter) Print() error {
+	iter := a.tree().Iterator(nil, nil, true)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		fmt.Printf("[%X]:\t[%X]\n", iter.Key(), iter.Value())
+	}
+	return nil
+}
+
+// Stats implements `dbm.DB` interface.
+func (a *DBAdapter) Stats() map[string]string {
+	tree := a.tree()
+	var size int64
+	if tree.root != nil {
+		size = tree.root.Size()
+	}
+	return map[string]string{
+		"store":   a.name,
+		"version": fmt.Sprint(tree.Version()),
+		"size":    fmt.Sprint(size),
+	}
+}
+
+// adapterBatch buffers the writes, and applies them as a single change set on write.
+type adapterBatch struct {
+	adapter *DBAdapter
+	pairs   []*KVPair
+	size    int
+	closed  bool
+}
+
+func (b *adapterBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.pairs = append(b.pairs, &KVPair{Key: bytes.Clone(key), Value: bytes.Clone(value)})
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *adapterBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.pairs = append(b.pairs, &KVPair{Key: bytes.Clone(key), Delete: true})
+	b.size += len(key)
+	return nil
+}
+
+func (b *adapterBatch) Write() error {
+	if b.closed {
+		return errBatchClosed
+	}
+	b.closed = true
+	if len(b.pairs) == 0 {
+		return nil
+	}
+	return b.adapter.apply(b.pairs)
+}
+
+func (b *adapterBatch) WriteSync() error {
+	return b.Write()
+}
+
+func (b *adapterBatch) Close() error {
+	b.closed = true
+	b.pairs = nil
+	return nil
+}
+
+func (b *adapterBatch) GetByteSize() (int, error) {
+	if b.closed {
+		return 0, errBatchClosed
+	}
+	return b.size, nil
+}
@@ -0,0 +1,47 @@
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBAdapter(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	adapter := NewDBAdapter(db, "test")
+	require.NoError(t, adapter.Set([]byte("a"), []byte("1")))
+	require.NoError(t, adapter.Set([]byte("b"), []byte("2")))
+	require.Error(t, adapter.Set(nil, []byte("1")))
+	require.Error(t, adapter.Set([]byte("c"), nil))
+
+	// the reads see the pending writes
+	value, err := adapter.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+
+	batch := adapter.NewBatch()
+	require.NoError(t, batch.Set([]byte("c"), []byte("3")))
+	require.NoError(t, batch.Delete([]byte("a")))
+	has, err := adapter.Has([]byte("c"))
+	require.NoError(t, err)
+	require.False(t, has)
+	require.NoError(t, batch.Write())
+	require.Error(t, batch.Write())
+
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	iter, err := adapter.ReverseIterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c=3", "b=2"}, collectKVIterator(iter))
+
+	// the store not exists is empty
+	empty := NewDBAdapter(db, "unknown")
+	value, err = empty.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	require.Error(t, empty.Set([]byte("a"), []byte("1")))
+}
@@ -0,0 +1,91 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var errInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// DiskUsage is the disk space used by the db, and available on its file system.
+type DiskUsage struct {
+	// the total size of the snapshots, including the ones to be pruned
+	SnapshotBytes uint64 `json:"snapshot_bytes"`
+	WALBytes      uint64 `json:"wal_bytes"`
+	// the bytes available on the file system, 0 if unsupported on the platform
+	FreeBytes uint64 `json:"free_bytes"`
+}
+
+// DiskUsage returns the disk space used by the snapshots and the WAL, it scans the files without the db lock, the files
+// removed concurrently by the pruning are skipped.
+func (db *DB) DiskUsage() (DiskUsage, error) {
+	var usage DiskUsage
+	if err := traverseSnapshots(db.dir, true, func(version int64) (bool, error) {
+		size, _ := treeSize(filepath.Join(db.dir, snapshotName(version)))
+		usage.SnapshotBytes += uint64(size)
+		return false, nil
+	}); err != nil {
+		return usage, err
+	}
+	walBytes, err := dirSize(walPath(db.dir))
+	if err != nil {
+		return usage, err
+	}
+	usage.WALBytes = uint64(walBytes)
+	if free, err := diskFree(db.dir); err == nil {
+		usage.FreeBytes = free
+	}
+	return usage, nil
+}
+
+// checkDiskSpace returns an error if the free space of the file system is below the minimum, it's a no-op if the
+// minimum is not set or the platform is not supported.
+func (db *DB) checkDiskSpace() error {
+	if db.minFreeDiskBytes == 0 {
+		return nil
+	}
+	free, err := diskFree(db.dir)
+	if err != nil {
+		return nil
+	}
+	if free < db.minFreeDiskBytes {
+		return fmt.Errorf("%w: %d bytes free, the minimum is %d", errInsufficientDiskSpace, free, db.minFreeDiskBytes)
+	}
+	return nil
+}
+
+// monitorDiskSpace checks the free space after each commit, it logs an error once the free space drops below the
+// minimum, and once it recovers, so the operators are alerted before the disk actually fills.
+func (db *DB) monitorDiskSpace() {
+	if db.minFreeDiskBytes == 0 {
+		return
+	}
+	free, err := diskFree(db.dir)
+	if err != nil {
+		return
+	}
+	db.metrics.SetGauge(float32(free), "store", "memiavl", "disk_free_bytes")
+
+	low := free < db.minFreeDiskBytes
+	if low == db.lowDiskSpace.Load() {
+		return
+	}
+	db.lowDiskSpace.Store(low)
+	if low {
+		db.commitLogger.Error("LOW DISK SPACE, the snapshot rewrites are refused until the space is freed",
+			"dir", db.dir, "free", free, "minimum", db.minFreeDiskBytes)
+	} else {
+		db.commitLogger.Info("disk space recovered, the snapshot rewrites are resumed", "free", free, "minimum", db.minFreeDiskBytes)
+	}
+}
+
+// emitDiskUsage reports the disk usage, it scans the snapshot directories, so it's only called after the pruning.
+func (db *DB) emitDiskUsage() {
+	usage, err := db.DiskUsage()
+	if err != nil {
+		return
+	}
+	db.metrics.SetGauge(float32(usage.SnapshotBytes), "store", "memiavl", "disk_snapshot_bytes")
+	db.metrics.SetGauge(float32(usage.WALBytes), "store", "memiavl", "disk_wal_bytes")
+}
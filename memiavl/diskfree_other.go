@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package memiavl
+
+import "errors"
+
+// diskFree is not supported on the other platforms, the low space protection is disabled there.
+func diskFree(string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
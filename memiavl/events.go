@@ -0,0 +1,122 @@
+package memiavl
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The types of the snapshot lifecycle events.
+const (
+	SnapshotEventRewriteStarted  = "rewrite_started"
+	SnapshotEventRewriteFinished = "rewrite_finished"
+	SnapshotEventRewriteFailed   = "rewrite_failed"
+	SnapshotEventSwitched        = "switched"
+	SnapshotEventPruned          = "pruned"
+	SnapshotEventWALTruncated    = "wal_truncated"
+)
+
+// SnapshotEvent is a machine-readable event of the snapshot lifecycle, so the external automation could react to them,
+// like uploading the snapshot once the rewrite is finished.
+type SnapshotEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// the version of the snapshot, or the first version kept in the WAL after the truncation
+	Version int64 `json:"version"`
+	// the directory of the snapshot, empty for the WAL events
+	Path string `json:"path,omitempty"`
+	// the size of the snapshot files, or the remaining WAL files after the truncation
+	Size int64 `json:"size,omitempty"`
+	// the duration of the rewrite in milliseconds
+	ElapsedMs int64  `json:"elapsed_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SnapshotEventHandler is called with the snapshot lifecycle events, it's called from the background goroutines, so
+// it must be thread-safe and should not block.
+type SnapshotEventHandler func(event SnapshotEvent)
+
+// emit sets the timestamp of the event and calls the handler, it's a no-op if the handler is nil.
+func (h SnapshotEventHandler) emit(event SnapshotEvent) {
+	if h == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	h(event)
+}
+
+// snapshotEventLog appends the snapshot events to a JSONL file.
+type snapshotEventLog struct {
+	mtx  sync.Mutex
+	file *os.File
+}
+
+func openSnapshotEventLog(path string) (*snapshotEventLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotEventLog{file: file}, nil
+}
+
+// write appends the event as a single line, the events after the close are dropped, the write errors are ignored, so
+// the event log never fails the db.
+func (l *snapshotEventLog) write(event SnapshotEvent) {
+	bz, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	bz = append(bz, '\n')
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file != nil {
+		_, _ = l.file.Write(bz)
+	}
+}
+
+func (l *snapshotEventLog) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// snapshotEventHandler combines the event log and the callback, nil if neither is set.
+func snapshotEventHandler(log *snapshotEventLog, handler SnapshotEventHandler) SnapshotEventHandler {
+	switch {
+	case log == nil:
+		return handler
+	case handler == nil:
+		return log.write
+	default:
+		return func(event SnapshotEvent) {
+			log.write(event)
+			handler(event)
+		}
+	}
+}
+
+// treeSize returns the total size of the regular files in the directory recursively.
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -51,6 +51,9 @@ func NewMultiTreeExporter(dir string, version uint32, supportExportNonSnapshotVe
 		if err != nil {
 			return nil, fmt.Errorf("snapshot don't exists: height: %d, %w", version, err)
 		}
+		if mtree.commitmentOnly() {
+			return nil, errors.Join(errCommitmentOnly, mtree.Close())
+		}
 	}
 
 	return &MultiTreeExporter{
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package memiavl
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate reserves the disk blocks of the file up to the size, it's best effort, the file systems not supporting
+// it are ignored.
+func fallocate(fp *os.File, size int64) error {
+	err := unix.Fallocate(int(fp.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	return err
+}
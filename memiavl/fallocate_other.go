@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package memiavl
+
+import "os"
+
+// fallocate is not supported on the platform, the files are not preallocated.
+func fallocate(*os.File, int64) error {
+	return nil
+}
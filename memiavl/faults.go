@@ -0,0 +1,103 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tidwall/wal"
+)
+
+// FaultPoint is a point in the write paths where a failure can be injected with `InjectFault`, which only exists in
+// the builds with the `memiavl_faults` tag, so the recovery paths can be tested systematically. The injected failure
+// behaves like a crash at the point, the db must not be used after it except for closing, then the directory is
+// reloaded and checked with `CheckInvariants`.
+type FaultPoint string
+
+const (
+	// FaultWALPartialWrite tears the tail of the WAL after writing the entries of a commit, like a crash in the middle
+	// of the write.
+	FaultWALPartialWrite FaultPoint = "wal-partial-write"
+	// FaultSnapshotRename fails the renaming of the new snapshot from the temporary directory.
+	FaultSnapshotRename FaultPoint = "snapshot-rename"
+	// FaultBeforeSymlinkSwap fails after the new snapshot is written and renamed, before `current` is switched to it.
+	FaultBeforeSymlinkSwap FaultPoint = "before-symlink-swap"
+)
+
+// ErrInjectedFault is returned by the write paths when an injected fault fires.
+var ErrInjectedFault = errors.New("injected fault")
+
+// writeWALBatch writes the batch to the WAL, the injected partial write tears the last entry written.
+func writeWALBatch(log *wal.Log, dir string, batch *wal.Batch) error {
+	if err := log.WriteBatch(batch); err != nil {
+		return err
+	}
+	if err := fault(FaultWALPartialWrite); err != nil {
+		return errors.Join(err, tearWALTail(walPath(dir)))
+	}
+	return nil
+}
+
+// tearWALTail truncates the last byte of the last WAL segment, so the last entry is corrupted.
+func tearWALTail(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var lastSeg string
+	for _, entry := range entries {
+		if entry.IsDir() || len(entry.Name()) < 20 {
+			continue
+		}
+		lastSeg = entry.Name()
+	}
+	if lastSeg == "" {
+		return errors.New("no wal segment")
+	}
+	path := filepath.Join(dir, lastSeg)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	return os.Truncate(path, info.Size()-1)
+}
+
+// CheckInvariants checks the db directory is consistent after a crash, it must run offline:
+//   - `current` points to a loadable snapshot, the half-written snapshots don't interfere with the loading.
+//   - the snapshots and the WAL are consistent with each other, see `Verify`.
+//   - if `durable` is not nil, which is the commit info of the last version known to be persisted before the crash,
+//     the version is not lost, and replaying to it reproduces the same root hashes.
+func CheckInvariants(dir string, durable *CommitInfo) error {
+	db, err := Load(dir, Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("load current snapshot: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	latest, err := Verify(dir, NewNopLogger())
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if durable == nil {
+		return nil
+	}
+	if latest.Version < durable.Version {
+		return fmt.Errorf("durable version %d is lost, recovered to version %d", durable.Version, latest.Version)
+	}
+
+	db, err = Load(dir, Options{ReadOnly: true, TargetVersion: uint32(durable.Version)})
+	if err != nil {
+		return fmt.Errorf("load durable version %d: %w", durable.Version, err)
+	}
+	defer db.Close()
+	if err := compareCommitInfo(db.LastCommitInfo(), durable); err != nil {
+		return fmt.Errorf("durable version %d: %w", durable.Version, err)
+	}
+	return nil
+}
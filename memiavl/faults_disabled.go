@@ -0,0 +1,7 @@
+//go:build !memiavl_faults
+// +build !memiavl_faults
+
+package memiavl
+
+// fault never fires without the `memiavl_faults` build tag.
+func fault(FaultPoint) error { return nil }
@@ -0,0 +1,30 @@
+//go:build memiavl_faults
+// +build memiavl_faults
+
+package memiavl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// injectedFaults are the armed fault points, shared by all the dbs in the process.
+var injectedFaults sync.Map
+
+// InjectFault arms the fault point, the next time a write path reaches it, it fails with `ErrInjectedFault`, the
+// point is disarmed after firing once.
+func InjectFault(point FaultPoint) {
+	injectedFaults.Store(point, struct{}{})
+}
+
+// ClearFaults disarms all the fault points.
+func ClearFaults() {
+	injectedFaults.Clear()
+}
+
+func fault(point FaultPoint) error {
+	if _, ok := injectedFaults.LoadAndDelete(point); ok {
+		return fmt.Errorf("%w: %s", ErrInjectedFault, point)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build memiavl_faults
+// +build memiavl_faults
+
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjection(t *testing.T) {
+	for _, tc := range []struct {
+		point FaultPoint
+		// the faulted operation, run after 3 versions are committed
+		run func(db *DB) error
+		// the version recovered after the crash
+		recovered int64
+	}{
+		{FaultWALPartialWrite, func(db *DB) error {
+			require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "crash")))
+			_, err := db.Commit()
+			return err
+		}, 3},
+		{FaultSnapshotRename, func(db *DB) error { return db.RewriteSnapshot() }, 3},
+		{FaultBeforeSymlinkSwap, func(db *DB) error { return db.RewriteSnapshot() }, 3},
+	} {
+		t.Run(string(tc.point), func(t *testing.T) {
+			defer ClearFaults()
+
+			dir := t.TempDir()
+			db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, AsyncCommitBuffer: -1})
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", string(rune('a'+i)))))
+				_, err := db.Commit()
+				require.NoError(t, err)
+			}
+			durable := *db.LastCommitInfo()
+
+			InjectFault(tc.point)
+			require.ErrorIs(t, tc.run(db), ErrInjectedFault)
+			// the crash, nothing is written after the fault
+			_ = db.Close()
+
+			require.NoError(t, CheckInvariants(dir, &durable))
+
+			db, err = Load(dir, Options{})
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, tc.recovered, db.Version())
+			require.Equal(t, durable.Hash(), db.LastCommitInfo().Hash())
+
+			// the db keeps working after the recovery
+			require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+			_, err = db.Commit()
+			require.NoError(t, err)
+			require.NoError(t, db.RewriteSnapshot())
+		})
+	}
+}
+
+func TestCheckInvariantsLostVersion(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}, AsyncCommitBuffer: -1})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	durable := *db.LastCommitInfo()
+	require.NoError(t, db.Close())
+
+	require.NoError(t, CheckInvariants(dir, &durable))
+	durable.Version++
+	require.ErrorContains(t, CheckInvariants(dir, &durable), "is lost")
+}
@@ -2,8 +2,6 @@ package memiavl
 
 import (
 	"path/filepath"
-
-	"github.com/zbiljic/go-filelock"
 )
 
 type FileLock interface {
@@ -11,18 +9,23 @@ type FileLock interface {
 	Destroy() error
 }
 
-func LockFile(fname string) (FileLock, error) {
+// LockMode is the mode of the file lock.
+type LockMode int
+
+const (
+	// LockExclusive is held by the writers, it conflicts with any other lock.
+	LockExclusive LockMode = iota
+	// LockShared is held by the read-only opens, they don't conflict with each other, but with the writers.
+	LockShared
+)
+
+// LockFile locks the file in the mode without blocking, it fails if the file is locked in a conflicting mode by
+// another process or another open of the same process, the file is created if not exists. The `Destroy` of a shared
+// lock doesn't remove the file, which could be still locked by the other readers.
+func LockFile(fname string, mode LockMode) (FileLock, error) {
 	path, err := filepath.Abs(fname)
 	if err != nil {
 		return nil, err
 	}
-	fl, err := filelock.New(path)
-	if err != nil {
-		return nil, err
-	}
-	if _, err := fl.TryLock(); err != nil {
-		return nil, err
-	}
-
-	return fl, nil
+	return lockFile(path, mode)
 }
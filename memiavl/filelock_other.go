@@ -0,0 +1,22 @@
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package memiavl
+
+import (
+	"github.com/zbiljic/go-filelock"
+)
+
+// lockFile falls back to the exclusive lock on the platforms without the shared locks, so the read-only opens
+// conflict with each other.
+func lockFile(path string, _ LockMode) (FileLock, error) {
+	fl, err := filelock.New(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fl.TryLock(); err != nil {
+		return nil, err
+	}
+
+	return fl, nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+// +build unix
+
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixFileLock is a `flock` on the file, the lock is released by the os if the process exits, so a crashed node
+// doesn't leave a stale lock.
+type unixFileLock struct {
+	file *os.File
+	path string
+	mode LockMode
+}
+
+func lockFile(path string, mode LockMode) (FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	how := unix.LOCK_EX
+	if mode == LockShared {
+		how = unix.LOCK_SH
+	}
+	if err := unix.Flock(int(file.Fd()), how|unix.LOCK_NB); err != nil {
+		return nil, errors.Join(fmt.Errorf("fail to lock %s: %w", path, err), file.Close())
+	}
+	return &unixFileLock{file: file, path: path, mode: mode}, nil
+}
+
+// Unlock releases the lock and closes the file.
+func (l *unixFileLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	err := unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	err = errors.Join(err, l.file.Close())
+	l.file = nil
+	return err
+}
+
+// Destroy releases the lock, and removes the file if it's exclusive.
+func (l *unixFileLock) Destroy() error {
+	err := l.Unlock()
+	if l.mode == LockShared {
+		return err
+	}
+	if err1 := os.Remove(l.path); err1 != nil && !os.IsNotExist(err1) {
+		err = errors.Join(err, err1)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// windowsFileLock locks the first byte of the file with `LockFileEx`, the lock is released by the os if the process
+// exits, so a crashed node doesn't leave a stale lock.
+type windowsFileLock struct {
+	file *os.File
+	path string
+	mode LockMode
+}
+
+func lockFile(path string, mode LockMode) (FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	var ol windows.Overlapped
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if mode == LockExclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &ol); err != nil {
+		return nil, errors.Join(fmt.Errorf("fail to lock %s: %w", path, err), file.Close())
+	}
+	return &windowsFileLock{file: file, path: path, mode: mode}, nil
+}
+
+// Unlock releases the lock and closes the file.
+func (l *windowsFileLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	var ol windows.Overlapped
+	err := windows.UnlockFileEx(windows.Handle(l.file.Fd()), 0, 1, 0, &ol)
+	err = errors.Join(err, l.file.Close())
+	l.file = nil
+	return err
+}
+
+// Destroy releases the lock, and removes the file if it's exclusive, the open files can't be removed on windows, so
+// it's unlocked first.
+func (l *windowsFileLock) Destroy() error {
+	err := l.Unlock()
+	if l.mode == LockShared {
+		return err
+	}
+	return errors.Join(err, os.Remove(l.path))
+}
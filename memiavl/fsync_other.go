@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package memiavl
+
+import "os"
+
+// fdatasync falls back to the full fsync on the platforms without it.
+func fdatasync(fp *os.File) error {
+	return fp.Sync()
+}
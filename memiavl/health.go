@@ -0,0 +1,184 @@
+package memiavl
+
+import (
+	"sync"
+	"time"
+)
+
+// Health is the status of the db and its background tasks, it's structured for the liveness probes, the failures of
+// the background tasks are reported as soon as they happen, rather than on the next commit, which could be hours later
+// on a quiet chain.
+type Health struct {
+	// Healthy is false if the db is closed, the disk space is low, the WAL lag exceeds the alert threshold, or any of
+	// the background tasks has failed since its last success
+	Healthy bool `json:"healthy"`
+	Closed  bool `json:"closed"`
+	// LowDiskSpace is true if the free disk space is below the minimum on the last commit
+	LowDiskSpace bool `json:"low_disk_space"`
+
+	AsyncCommit     AsyncCommitHealth `json:"async_commit"`
+	SnapshotRewrite TaskHealth        `json:"snapshot_rewrite"`
+	SnapshotPrune   TaskHealth        `json:"snapshot_prune"`
+}
+
+// AsyncCommitHealth is the status of the goroutine writing the WAL in async commit mode.
+type AsyncCommitHealth struct {
+	// Running is true if the goroutine is started by the first commit and not exited yet
+	Running bool `json:"running"`
+	// Lag is the number of committed versions not persisted yet
+	Lag int64 `json:"lag"`
+	// LagExceeded is true if the lag exceeded the alert threshold on the last commit
+	LagExceeded bool   `json:"lag_exceeded"`
+	Error       string `json:"error,omitempty"`
+}
+
+// TaskHealth is the status of a recurring background task.
+type TaskHealth struct {
+	Running bool `json:"running"`
+	// the completion time of the last successful run, and the snapshot version it produced if applicable
+	LastSuccess        time.Time `json:"last_success,omitempty"`
+	LastSuccessVersion int64     `json:"last_success_version,omitempty"`
+	// the last failure, it's cleared by the next success
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+func (t *TaskHealth) succeed(version int64) {
+	t.LastSuccess = time.Now().UTC()
+	t.LastSuccessVersion = version
+	t.LastError = ""
+	t.LastErrorTime = time.Time{}
+}
+
+func (t *TaskHealth) fail(err error) {
+	t.LastError = err.Error()
+	t.LastErrorTime = time.Now().UTC()
+}
+
+// The contexts of the critical errors passed to `Options.OnCriticalError`.
+const (
+	CriticalErrorWAL             = "wal_write"
+	CriticalErrorSnapshotRewrite = "snapshot_rewrite"
+	CriticalErrorSnapshotPrune   = "snapshot_prune"
+)
+
+// CriticalErrorHandler is called with the failures of the WAL writing and the background tasks, and the context where
+// it happened, one of the `CriticalError*` constants. It's called from the background goroutines without the db lock,
+// so it must be thread-safe and return quickly.
+type CriticalErrorHandler func(err error, context string)
+
+// healthTracker records the status of the background tasks, it's updated by the tasks directly, so it's read without
+// the db lock.
+type healthTracker struct {
+	mtx            sync.Mutex
+	closed         bool
+	asyncCommit    bool
+	rewrite, prune TaskHealth
+	// the number of errors in the current prune run
+	pruneErrs int
+
+	// alerts the critical errors, nil if not set
+	onCritical CriticalErrorHandler
+}
+
+// critical calls the handler outside of the lock, the failures caused by the closing of the db are not alerted.
+func (h *healthTracker) critical(err error, context string, closed bool) {
+	if h.onCritical != nil && !closed {
+		h.onCritical(err, context)
+	}
+}
+
+func (h *healthTracker) setClosed() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.closed = true
+}
+
+func (h *healthTracker) setAsyncCommit(running bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.asyncCommit = running
+}
+
+func (h *healthTracker) rewriteSucceeded(version int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.rewrite.succeed(version)
+}
+
+func (h *healthTracker) rewriteFailed(err error) {
+	h.mtx.Lock()
+	h.rewrite.fail(err)
+	closed := h.closed
+	h.mtx.Unlock()
+	h.critical(err, CriticalErrorSnapshotRewrite, closed)
+}
+
+func (h *healthTracker) pruneStarted() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.prune.Running = true
+	h.pruneErrs = 0
+}
+
+func (h *healthTracker) pruneFailed(err error) {
+	h.mtx.Lock()
+	h.prune.fail(err)
+	h.pruneErrs++
+	closed := h.closed
+	h.mtx.Unlock()
+	h.critical(err, CriticalErrorSnapshotPrune, closed)
+}
+
+// walFailed alerts the failure of the WAL writing, the error itself is tracked by the db.
+func (h *healthTracker) walFailed(err error) {
+	h.mtx.Lock()
+	closed := h.closed
+	h.mtx.Unlock()
+	h.critical(err, CriticalErrorWAL, closed)
+}
+
+// pruneFinished marks the run successful if no errors are reported in it, the version is the current snapshot.
+func (h *healthTracker) pruneFinished(version int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.prune.Running = false
+	if h.pruneErrs == 0 {
+		h.prune.succeed(version)
+	}
+}
+
+// Health returns the status of the db and its background tasks, it doesn't take the db lock, so it's not blocked by
+// the in-flight commit.
+func (db *DB) Health() Health {
+	db.health.mtx.Lock()
+	health := Health{
+		Closed:          db.health.closed,
+		AsyncCommit:     AsyncCommitHealth{Running: db.health.asyncCommit},
+		SnapshotRewrite: db.health.rewrite,
+		SnapshotPrune:   db.health.prune,
+	}
+	db.health.mtx.Unlock()
+	health.SnapshotRewrite.Running = db.rewriting.Load()
+	health.LowDiskSpace = db.lowDiskSpace.Load()
+
+	db.durableCond.L.Lock()
+	durableIndex, durableErr := db.durableIndex, db.durableErr
+	db.durableCond.L.Unlock()
+	// the error is kept after the goroutine exits
+	if durableErr != nil {
+		health.AsyncCommit.Error = durableErr.Error()
+	}
+	if health.AsyncCommit.Running {
+		view := db.view.Load()
+		index := walIndex(view.lastCommitInfo.Version, view.initialVersion)
+		health.AsyncCommit.Lag = int64(index - min(durableIndex, index))
+	}
+	health.AsyncCommit.LagExceeded = db.walLagExceeded.Load()
+
+	health.Healthy = !health.Closed && !health.LowDiskSpace &&
+		health.AsyncCommit.Error == "" && !health.AsyncCommit.LagExceeded &&
+		health.SnapshotRewrite.LastError == "" &&
+		health.SnapshotPrune.LastError == ""
+	return health
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package memiavl
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseHugePage asks the kernel to back the mapping with transparent huge pages, it's best effort, the kernels
+// without the support are ignored.
+func adviseHugePage(data []byte) error {
+	err := unix.Madvise(data, unix.MADV_HUGEPAGE)
+	if errors.Is(err, unix.EINVAL) {
+		return nil
+	}
+	return err
+}
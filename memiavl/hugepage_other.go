@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package memiavl
+
+// adviseHugePage is not supported on the platform, the advice is ignored.
+func adviseHugePage([]byte) error {
+	return nil
+}
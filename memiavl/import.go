@@ -7,6 +7,8 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+
+	"github.com/tidwall/wal"
 )
 
 const NodeChannelBuffer = 2048
@@ -25,7 +27,7 @@ func NewMultiTreeImporter(dir string, height uint64) (*MultiTreeImporter, error)
 	}
 
 	var fileLock FileLock
-	fileLock, err := LockFile(filepath.Join(dir, LockFileName))
+	fileLock, err := LockFile(filepath.Join(dir, LockFileName), LockExclusive)
 	if err != nil {
 		return nil, fmt.Errorf("fail to lock db: %w", err)
 	}
@@ -85,7 +87,7 @@ func (mti *MultiTreeImporter) Finalize() error {
 		return err
 	}
 
-	return updateCurrentSymlink(mti.dir, mti.snapshotDir)
+	return updateCurrent(mti.dir, mti.snapshotDir)
 }
 
 func (mti *MultiTreeImporter) Close() error {
@@ -134,7 +136,7 @@ func doImport(dir string, version int64, nodes <-chan *ExportNode) (returnErr er
 		return fmt.Errorf("version overflows uint32: %d", version)
 	}
 
-	return writeSnapshot(context.Background(), dir, uint32(version), func(w *snapshotWriter) (uint32, error) {
+	return writeSnapshot(context.Background(), dir, uint32(version), snapshotWriteOptions{}, snapshotFileSizes{}, func(w *snapshotWriter) (uint32, error) {
 		i := &importer{
 			snapshotWriter: *w,
 		}
@@ -261,3 +263,63 @@ func updateMetadataFile(dir string, height int64) (returnErr error) {
 	}
 	return WriteFileSync(filepath.Join(dir, MetadataFileName), bz)
 }
+
+// InstallSnapshot moves the snapshot directory into an empty db, points the `current` link to it, and resets the WAL,
+// the snapshot directory must be on the same filesystem as the db, it returns the version of the snapshot.
+func InstallSnapshot(dir, snapshotDir string) (int64, error) {
+	if _, err := CurrentSnapshot(dir); err == nil {
+		return 0, fmt.Errorf("memiavl db already exists in %s", dir)
+	}
+
+	fileLock, err := LockFile(filepath.Join(dir, LockFileName), LockExclusive)
+	if err != nil {
+		return 0, fmt.Errorf("fail to lock db: %w", err)
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+		_ = fileLock.Destroy()
+	}()
+
+	metadata, err := readMetadata(snapshotDir)
+	if err != nil {
+		return 0, err
+	}
+	version := metadata.CommitInfo.Version
+	name := snapshotName(version)
+
+	// the wal entries don't match the new snapshot
+	if err := os.RemoveAll(walPath(dir)); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(snapshotDir, filepath.Join(dir, name)); err != nil {
+		return 0, err
+	}
+	if err := updateCurrent(dir, name); err != nil {
+		return 0, err
+	}
+
+	return version, resetWAL(dir, version, uint32(metadata.InitialVersion))
+}
+
+// resetWAL replaces the WAL with an empty one starting from the next version of the snapshot.
+func resetWAL(dir string, version int64, initialVersion uint32) error {
+	if err := os.RemoveAll(walPath(dir)); err != nil {
+		return err
+	}
+
+	// the WAL must start from the next version, create an empty segment file named after the first index,
+	// same as the segment files created by the WAL itself.
+	if err := os.MkdirAll(walPath(dir), os.ModePerm); err != nil {
+		return err
+	}
+	firstIndex := walIndex(version+1, initialVersion)
+	if err := os.WriteFile(filepath.Join(walPath(dir), fmt.Sprintf("%020d", firstIndex)), nil, 0o600); err != nil {
+		return err
+	}
+
+	log, err := OpenWAL(walPath(dir), &wal.Options{NoCopy: true})
+	if err != nil {
+		return err
+	}
+	return log.Close()
+}
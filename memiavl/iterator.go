@@ -1,6 +1,17 @@
 package memiavl
 
-import "bytes"
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// iteratorPool recycles the iterators released by `ReleaseIterator`, along with their stack buffers.
+var iteratorPool = sync.Pool{
+	New: func() any {
+		return &Iterator{}
+	},
+}
 
 type Iterator struct {
 	// domain of iteration, end is exclusive
@@ -12,21 +23,29 @@ type Iterator struct {
 	key, value []byte
 
 	valid bool
+	// released is set by `ReleaseIterator`, so the iterator is not put into the pool twice
+	released bool
 
 	stack []Node
+
+	// the query statistics of the tree, the iterator is observed from its creation until it's closed, nil if disabled
+	stats  *queryStats
+	opened time.Time
 }
 
 func NewIterator(start, end []byte, ascending bool, root Node, zeroCopy bool) *Iterator {
-	iter := &Iterator{
+	iter := iteratorPool.Get().(*Iterator)
+	*iter = Iterator{
 		start:     start,
 		end:       end,
 		ascending: ascending,
 		valid:     true,
 		zeroCopy:  zeroCopy,
+		stack:     iter.stack[:0],
 	}
 
 	if root != nil {
-		iter.stack = []Node{root}
+		iter.stack = append(iter.stack, root)
 	}
 
 	// cache the first key-value
@@ -71,15 +90,15 @@ func (iter *Iterator) Next() {
 		node := iter.stack[len(iter.stack)-1]
 		iter.stack = iter.stack[:len(iter.stack)-1]
 
-		key := node.Key()
-		startCmp := bytes.Compare(iter.start, key)
+		// the split keys are only joined for the leaves returned
+		startCmp := -compareNodeKey(node, iter.start)
 		afterStart := iter.start == nil || startCmp < 0
-		beforeEnd := iter.end == nil || bytes.Compare(key, iter.end) < 0
+		beforeEnd := iter.end == nil || compareNodeKey(node, iter.end) < 0
 
 		if node.IsLeaf() {
 			startOrAfter := afterStart || startCmp == 0
 			if startOrAfter && beforeEnd {
-				iter.key = key
+				iter.key = node.Key()
 				iter.value = node.Value()
 				return
 			}
@@ -109,6 +128,25 @@ func (iter *Iterator) Next() {
 // Close implements dbm.Iterator
 func (iter *Iterator) Close() error {
 	iter.valid = false
-	iter.stack = nil
+	if iter.stats != nil {
+		iter.stats.observeIterator(iter.opened)
+		iter.stats = nil
+	}
+	// drop the node references but keep the buffer for reuse
+	clear(iter.stack)
+	iter.stack = iter.stack[:0]
 	return nil
 }
+
+// ReleaseIterator closes the iterator and puts it back into the pool, so the next `NewIterator` call could reuse it
+// without allocation. The iterator must not be used after release, releasing it again before it's reused is a no-op,
+// but the holders must drop the reference, because it could be reused by another `NewIterator` call.
+func ReleaseIterator(iter *Iterator) {
+	if iter.released {
+		return
+	}
+	_ = iter.Close()
+	iter.start, iter.end, iter.key, iter.value = nil, nil, nil, nil
+	iter.released = true
+	iteratorPool.Put(iter)
+}
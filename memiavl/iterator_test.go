@@ -39,6 +39,80 @@ func TestIteratorRange(t *testing.T) {
 	require.Equal(t, reverse(expItems), collectIter(tree.Iterator([]byte("aello05"), []byte("aello10"), false)))
 }
 
+func TestReleaseIterator(t *testing.T) {
+	tree := New(0)
+	for _, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+		_, v, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+
+		// the released iterators are reused, possibly in the middle of iteration
+		iter := tree.Iterator(nil, nil, true)
+		if iter.Valid() {
+			iter.Next()
+		}
+		ReleaseIterator(iter)
+
+		iter = tree.Iterator(nil, nil, true)
+		require.Equal(t, ExpectItems[v], collectIter(iter))
+		ReleaseIterator(iter)
+
+		iter = tree.Iterator(nil, nil, false)
+		require.Equal(t, reverse(ExpectItems[v]), collectIter(iter))
+		ReleaseIterator(iter)
+	}
+}
+
+func TestReleaseIteratorTwice(t *testing.T) {
+	tree := New(0)
+	for _, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+	}
+	_, v, err := tree.SaveVersion(true)
+	require.NoError(t, err)
+
+	iter := tree.Iterator(nil, nil, true)
+	ReleaseIterator(iter)
+	ReleaseIterator(iter)
+
+	// the iterator is put into the pool only once, so it's not shared by the new iterators
+	iter1 := tree.Iterator(nil, nil, true)
+	iter2 := tree.Iterator(nil, nil, false)
+	require.NotSame(t, iter1, iter2)
+	require.Equal(t, ExpectItems[v], collectIter(iter1))
+	require.Equal(t, reverse(ExpectItems[v]), collectIter(iter2))
+	ReleaseIterator(iter1)
+	ReleaseIterator(iter2)
+}
+
+func BenchmarkIteratorPool(b *testing.B) {
+	tree := New(0)
+	for _, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+	}
+	_, _, err := tree.SaveVersion(true)
+	require.NoError(b, err)
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			iter := tree.Iterator(nil, nil, true)
+			for ; iter.Valid(); iter.Next() {
+			}
+			_ = iter.Close()
+		}
+	})
+	b.Run("release", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			iter := tree.Iterator(nil, nil, true)
+			for ; iter.Valid(); iter.Next() {
+			}
+			ReleaseIterator(iter)
+		}
+	})
+}
+
 type pair struct {
 	key, value []byte
 }
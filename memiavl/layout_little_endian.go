@@ -1,5 +1,5 @@
-//go:build !nativebyteorder
-// +build !nativebyteorder
+//go:build !nativebyteorder || !(386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+// +build !nativebyteorder !386,!amd64,!arm,!arm64,!loong64,!mips64le,!mipsle,!ppc64le,!riscv64,!wasm
 
 package memiavl
 
@@ -1,5 +1,6 @@
-//go:build nativebyteorder
+//go:build nativebyteorder && (386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
 // +build nativebyteorder
+// +build 386 amd64 arm arm64 loong64 mips64le mipsle ppc64le riscv64 wasm
 
 package memiavl
 
@@ -8,14 +9,8 @@ import (
 	"unsafe"
 )
 
-func init() {
-	buf := [2]byte{}
-	*(*uint16)(unsafe.Pointer(&buf[0])) = uint16(0xABCD)
-
-	if buf != [2]byte{0xCD, 0xAB} {
-		panic("native byte order is not little endian, please build without nativebyteorder")
-	}
-}
+// The structs are mapped to the little endian snapshot files directly, so it's only built on the little endian
+// architectures, the others fall back to the portable layout even with the nativebyteorder tag.
 
 type NodeLayout = *nodeLayout
 
@@ -0,0 +1,66 @@
+package memiavl
+
+import (
+	"context"
+	"sync"
+)
+
+// writerLimiter bounds the number of trees written in parallel by the snapshot rewrites, the limit could be changed
+// at runtime. The permits in use are not revoked when it's lowered, the new writers wait until the in-use ones drop
+// below the new limit.
+type writerLimiter struct {
+	mtx          sync.Mutex
+	limit, inUse int
+	// closed and replaced when a permit is released or the limit is changed, to wake up the waiters
+	changed chan struct{}
+}
+
+func newWriterLimiter(limit int) *writerLimiter {
+	return &writerLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire waits for a permit, it fails if the context is done first.
+func (l *writerLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mtx.Lock()
+		if l.inUse < l.limit {
+			l.inUse++
+			l.mtx.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mtx.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *writerLimiter) release() {
+	l.mtx.Lock()
+	l.inUse--
+	l.notify()
+	l.mtx.Unlock()
+}
+
+func (l *writerLimiter) setLimit(limit int) {
+	l.mtx.Lock()
+	l.limit = limit
+	l.notify()
+	l.mtx.Unlock()
+}
+
+func (l *writerLimiter) getLimit() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.limit
+}
+
+// notify wakes up the waiters, it must be called with the mutex held.
+func (l *writerLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,86 @@
+package memiavl
+
+import "fmt"
+
+// LogLevel is the minimum level of the logs of a subsystem, it only filters the logs, the logger could filter more.
+type LogLevel int
+
+const (
+	// LogLevelDefault passes all the logs to the logger.
+	LogLevelDefault LogLevel = iota
+	LogLevelDebug
+	LogLevelInfo
+	LogLevelError
+	// LogLevelNone drops all the logs.
+	LogLevelNone
+)
+
+// ParseLogLevel parses the log level from the config, the empty string is the default level.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch s {
+	case "", "default":
+		return LogLevelDefault, nil
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "error":
+		return LogLevelError, nil
+	case "none":
+		return LogLevelNone, nil
+	default:
+		return LogLevelDefault, fmt.Errorf("unknown log level: %s", s)
+	}
+}
+
+// LogLevels are the log levels of the subsystems, so they could be tuned separately, like quiet commit logs but
+// verbose snapshot logs, with the logger itself at the debug level.
+type LogLevels struct {
+	// the WAL writing and truncation
+	WAL LogLevel
+	// the snapshot rewrites and switches
+	Snapshot LogLevel
+	// the pruning of the old snapshots
+	Prune LogLevel
+	// the per-commit logs, like the slow commits, the disk space and the cache advices
+	Commit LogLevel
+}
+
+// leveledLogger drops the logs below the level.
+type leveledLogger struct {
+	Logger
+	level LogLevel
+}
+
+// withLogLevel returns the logger filtered by the level, the logger itself for the default level.
+func withLogLevel(logger Logger, level LogLevel) Logger {
+	if level == LogLevelDefault {
+		return logger
+	}
+	return leveledLogger{Logger: logger, level: level}
+}
+
+func (l leveledLogger) Debug(msg string, keyvals ...interface{}) {
+	if l.level <= LogLevelDebug {
+		l.Logger.Debug(msg, keyvals...)
+	}
+}
+
+func (l leveledLogger) Info(msg string, keyvals ...interface{}) {
+	if l.level <= LogLevelInfo {
+		l.Logger.Info(msg, keyvals...)
+	}
+}
+
+// Warn is filtered as info, it's passed to the logger at the warning level if supported.
+func (l leveledLogger) Warn(msg string, keyvals ...interface{}) {
+	if l.level <= LogLevelInfo {
+		warn(l.Logger, msg, keyvals...)
+	}
+}
+
+func (l leveledLogger) Error(msg string, keyvals ...interface{}) {
+	if l.level <= LogLevelError {
+		l.Logger.Error(msg, keyvals...)
+	}
+}
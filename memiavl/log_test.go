@@ -0,0 +1,61 @@
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordLogger struct {
+	logs []string
+}
+
+func (l *recordLogger) Debug(msg string, _ ...interface{}) { l.logs = append(l.logs, "debug: "+msg) }
+func (l *recordLogger) Info(msg string, _ ...interface{})  { l.logs = append(l.logs, "info: "+msg) }
+func (l *recordLogger) Warn(msg string, _ ...interface{})  { l.logs = append(l.logs, "warn: "+msg) }
+func (l *recordLogger) Error(msg string, _ ...interface{}) { l.logs = append(l.logs, "error: "+msg) }
+
+func TestLogLevels(t *testing.T) {
+	for _, tc := range []struct {
+		level    LogLevel
+		expected []string
+	}{
+		{LogLevelDefault, []string{"debug: msg", "info: msg", "warn: msg", "error: msg"}},
+		{LogLevelDebug, []string{"debug: msg", "info: msg", "warn: msg", "error: msg"}},
+		{LogLevelInfo, []string{"info: msg", "warn: msg", "error: msg"}},
+		{LogLevelError, []string{"error: msg"}},
+		{LogLevelNone, nil},
+	} {
+		base := &recordLogger{}
+		logger := withLogLevel(base, tc.level)
+		logger.Debug("msg")
+		logger.Info("msg")
+		warn(logger, "msg")
+		logger.Error("msg")
+		require.Equal(t, tc.expected, base.logs, "level %d", tc.level)
+	}
+
+	level, err := ParseLogLevel("error")
+	require.NoError(t, err)
+	require.Equal(t, LogLevelError, level)
+	level, err = ParseLogLevel("")
+	require.NoError(t, err)
+	require.Equal(t, LogLevelDefault, level)
+	_, err = ParseLogLevel("trace")
+	require.Error(t, err)
+
+	// the commit logs are quieted while the others are kept
+	base := &recordLogger{}
+	db, err := Load(t.TempDir(), Options{
+		CreateIfMissing: true,
+		InitialStores:   []string{"test"},
+		Logger:          base,
+		LogLevels:       LogLevels{Commit: LogLevelNone},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, Logger(base), db.snapshotLogger)
+	base.logs = nil
+	db.commitLogger.Error("msg")
+	require.Empty(t, base.logs)
+}
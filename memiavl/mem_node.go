@@ -4,22 +4,33 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"math/bits"
+	"sync"
 )
 
 type MemNode struct {
 	height  uint8
+	flags   uint8
 	size    int64
 	version uint32
-	key     []byte
-	value   []byte
-	left    Node
-	right   Node
+	// the key, or the suffix after `keyPrefix()` if the key is split.
+	key []byte
+	// the value of a leaf, or the key prefix of a branch, see `keyPrefix`.
+	value []byte
+	left  Node
+	right Node
 
+	// the hash, or the key prefix of a compact leaf, see `keyPrefix`.
 	hash []byte
 }
 
 var _ Node = (*MemNode)(nil)
 
+// flagCompactLeaf marks the leaves created in compact mode, the hash is recomputed on each access instead of being
+// retained, it's cheap for a leaf, and it saves a 32 bytes allocation per leaf. The leaf is treated as clean, the dirty
+// subtrees are still tracked by the nil hashes of the branch nodes on the mutated paths.
+const flagCompactLeaf uint8 = 1
+
 func newLeafNode(key, value []byte, version uint32) *MemNode {
 	return &MemNode{
 		key: key, value: value, version: version, size: 1,
@@ -42,11 +53,39 @@ func (node *MemNode) Version() uint32 {
 	return node.version
 }
 
+// Key returns the key, a split key is joined into a new buffer.
 func (node *MemNode) Key() []byte {
-	return node.key
+	prefix := node.keyPrefix()
+	if len(prefix) == 0 {
+		return node.key
+	}
+	key := make([]byte, 0, len(prefix)+len(node.key))
+	return append(append(key, prefix...), node.key...)
+}
+
+// keyPrefix returns the prefix of the split key, which is shared with the key of another node, `node.key` is the rest
+// of it. The keys of the new leaves are split in compact mode, and the branches share the split keys of the leaves.
+// The compact leaves keep the prefix in the hash field, and the branches keep it in the value field, so the split
+// keys don't make the node larger.
+func (node *MemNode) keyPrefix() []byte {
+	if node.IsLeaf() {
+		if node.flags&flagCompactLeaf == 0 {
+			return nil
+		}
+		return node.hash
+	}
+	return node.value
+}
+
+// setKeyOf sets the key of the branch node to the key of the other node, the split key is shared as is.
+func (node *MemNode) setKeyOf(other Node) {
+	node.value, node.key = splitKey(other)
 }
 
 func (node *MemNode) Value() []byte {
+	if !node.IsLeaf() {
+		return nil
+	}
 	return node.value
 }
 
@@ -60,13 +99,26 @@ func (node *MemNode) Right() Node {
 
 // Mutate clones the node if it's version is smaller than or equal to cowVersion, otherwise modify in-place
 func (node *MemNode) Mutate(version, cowVersion uint32) *MemNode {
+	return node.mutate(nil, version, cowVersion)
+}
+
+// mutate is `Mutate` with the clone allocated from the arena.
+func (node *MemNode) mutate(arena *nodeArena, version, cowVersion uint32) *MemNode {
 	n := node
 	if node.version <= cowVersion {
-		cloned := *node
-		n = &cloned
+		n = arena.newNode()
+		*n = *node
 	}
 	n.version = version
-	n.hash = nil
+	switch {
+	case n.flags&flagCompactLeaf != 0:
+		// the hash field keeps the key prefix
+	case arena != nil && arena.compact && n.IsLeaf():
+		n.flags |= flagCompactLeaf
+		n.hash = nil
+	default:
+		n.hash = nil
+	}
 	return n
 }
 
@@ -80,6 +132,9 @@ func (node *MemNode) Hash() []byte {
 	if node == nil {
 		return nil
 	}
+	if node.flags&flagCompactLeaf != 0 {
+		return HashNode(node)
+	}
 	if node.hash != nil {
 		return node.hash
 	}
@@ -87,6 +142,46 @@ func (node *MemNode) Hash() []byte {
 	return node.hash
 }
 
+// hashParallel computes the hashes like `Hash`, except that the dirty subtrees under the top levels are hashed
+// concurrently, at most `concurrency` of them at a time, the subtrees are disjoint, so the result is deterministic.
+func (node *MemNode) hashParallel(concurrency int) []byte {
+	// split into more subtrees than the workers, to balance the uneven dirty subtrees
+	depth := bits.Len(uint(concurrency)) + 2
+
+	var subtrees []*MemNode
+	collectDirtySubtrees(node, depth, &subtrees)
+	if len(subtrees) > 1 {
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, subtree := range subtrees {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				subtree.Hash()
+				<-sem
+			}()
+		}
+		wg.Wait()
+	}
+	return node.Hash()
+}
+
+// collectDirtySubtrees collects the roots of the subtrees at `depth` whose hashes are not computed yet, the persisted
+// nodes and the clean nodes are skipped.
+func collectDirtySubtrees(node Node, depth int, result *[]*MemNode) {
+	n, ok := node.(*MemNode)
+	if !ok || n.hash != nil || n.flags&flagCompactLeaf != 0 {
+		return
+	}
+	if depth == 0 || n.IsLeaf() {
+		*result = append(*result, n)
+		return
+	}
+	collectDirtySubtrees(n.left, depth-1, result)
+	collectDirtySubtrees(n.right, depth-1, result)
+}
+
 func (node *MemNode) updateHeightSize() {
 	node.height = max(node.left.Height(), node.right.Height()) + 1
 	node.size = node.left.Size() + node.right.Size()
@@ -100,15 +195,15 @@ func calcBalance(node Node) int {
 	return int(node.Left().Height()) - int(node.Right().Height())
 }
 
-// Invariant: node is returned by `Mutate(version)`.
+// Invariant: node is returned by `Mutate(version, cowVersion)`.
 //
 //	   S               L
 //	  / \      =>     / \
 //	 L                   S
 //	/ \                 / \
 //	  LR               LR
-func (node *MemNode) rotateRight(version, cowVersion uint32) *MemNode {
-	newSelf := node.left.Mutate(version, cowVersion)
+func (node *MemNode) rotateRight(arena *nodeArena, version, cowVersion uint32) *MemNode {
+	newSelf := mutateNode(arena, node.left, version, cowVersion)
 	node.left = node.left.Right()
 	newSelf.right = node
 	node.updateHeightSize()
@@ -123,8 +218,8 @@ func (node *MemNode) rotateRight(version, cowVersion uint32) *MemNode {
 //	    R         S
 //	   / \       / \
 //	 RL             RL
-func (node *MemNode) rotateLeft(version, cowVersion uint32) *MemNode {
-	newSelf := node.right.Mutate(version, cowVersion)
+func (node *MemNode) rotateLeft(arena *nodeArena, version, cowVersion uint32) *MemNode {
+	newSelf := mutateNode(arena, node.right, version, cowVersion)
 	node.right = node.right.Left()
 	newSelf.left = node
 	node.updateHeightSize()
@@ -133,27 +228,27 @@ func (node *MemNode) rotateLeft(version, cowVersion uint32) *MemNode {
 }
 
 // Invariant: node is returned by `Mutate(version, cowVersion)`.
-func (node *MemNode) reBalance(version, cowVersion uint32) *MemNode {
+func (node *MemNode) reBalance(arena *nodeArena, version, cowVersion uint32) *MemNode {
 	balance := node.calcBalance()
 	switch {
 	case balance > 1:
 		leftBalance := calcBalance(node.left)
 		if leftBalance >= 0 {
 			// left left
-			return node.rotateRight(version, cowVersion)
+			return node.rotateRight(arena, version, cowVersion)
 		}
 		// left right
-		node.left = node.left.Mutate(version, cowVersion).rotateLeft(version, cowVersion)
-		return node.rotateRight(version, cowVersion)
+		node.left = mutateNode(arena, node.left, version, cowVersion).rotateLeft(arena, version, cowVersion)
+		return node.rotateRight(arena, version, cowVersion)
 	case balance < -1:
 		rightBalance := calcBalance(node.right)
 		if rightBalance <= 0 {
 			// right right
-			return node.rotateLeft(version, cowVersion)
+			return node.rotateLeft(arena, version, cowVersion)
 		}
 		// right left
-		node.right = node.right.Mutate(version, cowVersion).rotateRight(version, cowVersion)
-		return node.rotateLeft(version, cowVersion)
+		node.right = mutateNode(arena, node.right, version, cowVersion).rotateRight(arena, version, cowVersion)
+		return node.rotateLeft(arena, version, cowVersion)
 	default:
 		// nothing changed
 		return node
@@ -162,7 +257,7 @@ func (node *MemNode) reBalance(version, cowVersion uint32) *MemNode {
 
 func (node *MemNode) Get(key []byte) ([]byte, uint32) {
 	if node.IsLeaf() {
-		switch bytes.Compare(node.key, key) {
+		switch compareSplitKey(node.keyPrefix(), node.key, key) {
 		case -1:
 			return nil, 1
 		case 1:
@@ -172,7 +267,7 @@ func (node *MemNode) Get(key []byte) ([]byte, uint32) {
 		}
 	}
 
-	if bytes.Compare(key, node.key) == -1 {
+	if compareSplitKey(node.keyPrefix(), node.key, key) == 1 {
 		return node.Left().Get(key)
 	}
 	right := node.Right()
@@ -183,7 +278,7 @@ func (node *MemNode) Get(key []byte) ([]byte, uint32) {
 func (node *MemNode) GetByIndex(index uint32) ([]byte, []byte) {
 	if node.IsLeaf() {
 		if index == 0 {
-			return node.key, node.value
+			return node.Key(), node.value
 		}
 		return nil, nil
 	}
@@ -198,6 +293,51 @@ func (node *MemNode) GetByIndex(index uint32) ([]byte, []byte) {
 	return right.GetByIndex(index - leftSize)
 }
 
+// splitKey returns the key of the node as the prefix and the rest, the prefix is empty if the key is not split.
+func splitKey(node Node) ([]byte, []byte) {
+	if n, ok := node.(*MemNode); ok {
+		return n.keyPrefix(), n.key
+	}
+	return nil, node.Key()
+}
+
+// compareSplitKey compares the split key `prefix + suffix` with the key, without joining them.
+func compareSplitKey(prefix, suffix, key []byte) int {
+	n := min(len(prefix), len(key))
+	if c := bytes.Compare(prefix, key[:n]); c != 0 {
+		return c
+	}
+	if n < len(prefix) {
+		// the key is a prefix of the split key
+		return 1
+	}
+	return bytes.Compare(suffix, key[n:])
+}
+
+// compareNodeKey compares the key of the node with the key, the split key is not joined.
+func compareNodeKey(node Node, key []byte) int {
+	prefix, suffix := splitKey(node)
+	return compareSplitKey(prefix, suffix, key)
+}
+
+// encodeNodeKey is like `EncodeBytes(w, node.Key())`, the split key is not joined.
+func encodeNodeKey(w io.Writer, node Node) error {
+	prefix, suffix := splitKey(node)
+	if len(prefix) == 0 {
+		return EncodeBytes(w, suffix)
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(prefix)+len(suffix)))
+	if _, err := w.Write(buf[0:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(suffix)
+	return err
+}
+
 // EncodeBytes writes a varint length-prefixed byte slice to the writer,
 // it's used for hash computation, must be compactible with the official IAVL implementation.
 func EncodeBytes(w io.Writer, bz []byte) error {
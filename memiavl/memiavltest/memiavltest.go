@@ -0,0 +1,174 @@
+// Package memiavltest provides the helpers to write the store-level tests on top of memiavl, the dbs are built from
+// literal key-value maps, and the snapshots and hashes are asserted without knowing the on-disk layout.
+package memiavltest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/stretchr/testify/require"
+)
+
+// EnvUpdateGolden is the environment variable to overwrite the golden snapshots with the actual ones.
+const EnvUpdateGolden = "MEMIAVL_UPDATE_GOLDEN"
+
+// Stores maps the store names to the key-value pairs of the stores, the empty values delete the keys.
+type Stores map[string]map[string]string
+
+// Names returns the sorted store names.
+func (s Stores) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ChangeSets converts the stores into change sets, both the stores and the keys are sorted, so the result is
+// deterministic.
+func (s Stores) ChangeSets() []*memiavl.NamedChangeSet {
+	changeSets := make([]*memiavl.NamedChangeSet, 0, len(s))
+	for _, name := range s.Names() {
+		kvs := s[name]
+		keys := make([]string, 0, len(kvs))
+		for key := range kvs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]*memiavl.KVPair, len(keys))
+		for i, key := range keys {
+			if value := kvs[key]; len(value) > 0 {
+				pairs[i] = &memiavl.KVPair{Key: []byte(key), Value: []byte(value)}
+			} else {
+				pairs[i] = &memiavl.KVPair{Key: []byte(key), Delete: true}
+			}
+		}
+		changeSets = append(changeSets, &memiavl.NamedChangeSet{Name: name, Changeset: memiavl.ChangeSet{Pairs: pairs}})
+	}
+	return changeSets
+}
+
+// NewDB creates a db in a temporary directory with the stores committed as the first version, it's closed when the
+// test finishes.
+func NewDB(t testing.TB, stores Stores) *memiavl.DB {
+	t.Helper()
+
+	db, err := memiavl.Load(t.TempDir(), memiavl.Options{
+		CreateIfMissing: true,
+		InitialStores:   stores.Names(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	Commit(t, db, stores)
+	return db
+}
+
+// Commit applies the stores as the change sets of a new version.
+func Commit(t testing.TB, db *memiavl.DB, stores Stores) int64 {
+	t.Helper()
+
+	require.NoError(t, db.ApplyChangeSets(stores.ChangeSets()))
+	v, err := db.Commit()
+	require.NoError(t, err)
+	return v
+}
+
+// RootHash computes the root hash of a single store in memory, without touching the file system.
+func RootHash(kvs map[string]string) []byte {
+	tree := memiavl.New(0)
+	tree.ApplyChangeSet(Stores{"": kvs}.ChangeSets()[0].Changeset)
+	hash, _, err := tree.SaveVersion(true)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// RequireRootHash asserts the hex encoded root hash of the store.
+func RequireRootHash(t testing.TB, db *memiavl.DB, store, expected string) {
+	t.Helper()
+
+	tree := db.TreeByName(store)
+	require.NotNil(t, tree, "store not found: %s", store)
+	require.Equal(t, expected, hex.EncodeToString(tree.RootHash()), "root hash of store %s", store)
+}
+
+// RequireAppHash asserts the hex encoded app hash of the last committed version.
+func RequireAppHash(t testing.TB, db *memiavl.DB, expected string) {
+	t.Helper()
+
+	require.Equal(t, expected, hex.EncodeToString(db.LastCommitInfo().Hash()))
+}
+
+// WriteSnapshot writes the current version of the db into a new snapshot directory and returns it, the content
+// only depends on the committed states, so it's deterministic.
+func WriteSnapshot(t testing.TB, db *memiavl.DB) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, db.WriteSnapshot(dir))
+	return dir
+}
+
+// RequireSnapshotEqual asserts the two snapshot directories have the same files.
+func RequireSnapshotEqual(t testing.TB, expected, actual string) {
+	t.Helper()
+
+	expectedFiles := readFiles(t, expected)
+	actualFiles := readFiles(t, actual)
+	require.Equal(t, len(expectedFiles), len(actualFiles), "number of files")
+	for name, bz := range expectedFiles {
+		actualBz, ok := actualFiles[name]
+		require.True(t, ok, "file not found: %s", name)
+		require.True(t, bytes.Equal(bz, actualBz), "file content mismatch: %s", name)
+	}
+}
+
+// RequireGoldenSnapshot asserts the snapshot of the current version of the db is the same as the golden one in
+// `golden`, usually a directory in `testdata`, set the `MEMIAVL_UPDATE_GOLDEN` environment variable to write the
+// golden snapshot instead.
+func RequireGoldenSnapshot(t testing.TB, db *memiavl.DB, golden string) {
+	t.Helper()
+
+	if len(os.Getenv(EnvUpdateGolden)) > 0 {
+		require.NoError(t, os.RemoveAll(golden))
+		require.NoError(t, db.WriteSnapshot(golden))
+		return
+	}
+
+	RequireSnapshotEqual(t, golden, WriteSnapshot(t, db))
+}
+
+// readFiles reads the regular files in the directory recursively, keyed by the relative paths.
+func readFiles(t testing.TB, dir string) map[string][]byte {
+	t.Helper()
+
+	files := make(map[string][]byte)
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		bz, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = bz
+		return nil
+	}))
+	return files
+}
@@ -0,0 +1,32 @@
+package memiavltest
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDB(t *testing.T) {
+	stores := Stores{
+		"bank":    {"alice": "100", "bob": "200"},
+		"staking": {"validator": "1"},
+	}
+	db := NewDB(t, stores)
+	require.Equal(t, int64(1), db.Version())
+	RequireRootHash(t, db, "bank", hex.EncodeToString(RootHash(stores["bank"])))
+	RequireRootHash(t, db, "staking", hex.EncodeToString(RootHash(stores["staking"])))
+
+	// the snapshots only depend on the committed states
+	other := NewDB(t, Stores{
+		"staking": {"validator": "1"},
+		"bank":    {"bob": "200", "alice": "100"},
+	})
+	RequireAppHash(t, other, hex.EncodeToString(db.LastCommitInfo().Hash()))
+	RequireSnapshotEqual(t, WriteSnapshot(t, db), WriteSnapshot(t, other))
+
+	// the empty values delete the keys
+	Commit(t, db, Stores{"bank": {"alice": ""}})
+	require.Nil(t, db.TreeByName("bank").Get([]byte("alice")))
+	RequireRootHash(t, db, "bank", hex.EncodeToString(RootHash(map[string]string{"bob": "200"})))
+}
@@ -0,0 +1,249 @@
+package memiavl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the measurements of the db, the method signatures match the sdk's telemetry package,
+// so the sdk's telemetry can be plugged in directly.
+type Metrics interface {
+	MeasureSince(start time.Time, keys ...string)
+	SetGauge(val float32, keys ...string)
+	IncrCounter(val float32, keys ...string)
+}
+
+type nopMetrics struct{}
+
+// Interface assertions
+var _ Metrics = (*nopMetrics)(nil)
+
+// NewNopMetrics returns a metrics sink that doesn't do anything.
+func NewNopMetrics() Metrics { return &nopMetrics{} }
+
+func (nopMetrics) MeasureSince(time.Time, ...string) {}
+func (nopMetrics) SetGauge(float32, ...string)       {}
+func (nopMetrics) IncrCounter(float32, ...string)    {}
+
+// emitCommitMetrics reports the cache statistics of each store and the WAL lag after a commit.
+func (db *DB) emitCommitMetrics(version int64) {
+	for _, entry := range db.trees {
+		hits, misses := entry.Tree.popCacheStats()
+		db.metrics.IncrCounter(float32(hits), "store", "memiavl", "cache_hit", entry.Name)
+		db.metrics.IncrCounter(float32(misses), "store", "memiavl", "cache_miss", entry.Name)
+		if entry.Tree.cache != nil {
+			db.metrics.SetGauge(float32(entry.Tree.cache.Len()), "store", "memiavl", "cache_size", entry.Name)
+		}
+	}
+
+	if db.walChan != nil {
+		lag := db.walLag(version)
+		db.metrics.SetGauge(float32(lag), "store", "memiavl", "wal_lag")
+		db.monitorWALLag(lag)
+	}
+}
+
+// walLag returns the number of the committed versions up to the version which are not persisted yet, it's the
+// versions lost on a crash in async commit mode.
+func (db *DB) walLag(version int64) uint64 {
+	db.durableCond.L.Lock()
+	durableIndex := db.durableIndex
+	db.durableCond.L.Unlock()
+
+	index := walIndex(version, db.initialVersion)
+	return index - min(durableIndex, index)
+}
+
+// monitorWALLag logs once the WAL lag exceeds the alert threshold, and once it recovers.
+func (db *DB) monitorWALLag(lag uint64) {
+	if db.walLagThreshold == 0 {
+		return
+	}
+	exceeded := lag > db.walLagThreshold
+	if exceeded == db.walLagExceeded.Load() {
+		return
+	}
+	db.walLagExceeded.Store(exceeded)
+	if exceeded {
+		db.metrics.IncrCounter(1, "store", "memiavl", "wal_lag_exceeded")
+		warn(db.walLogger, "WAL durability lag exceeded the threshold, the versions not persisted yet are lost on a crash",
+			"lag", lag, "threshold", db.walLagThreshold)
+	} else {
+		db.walLogger.Info("WAL durability lag recovered", "lag", lag, "threshold", db.walLagThreshold)
+	}
+}
+
+// attachTreeStats attaches the working sets of the cache advisor and the query statistics to the trees, including the
+// reloaded ones and the ones added by upgrades, so the statistics are kept across the snapshot switches.
+func (db *DB) attachTreeStats() {
+	for _, entry := range db.trees {
+		if db.cacheAdvisorWindow > 0 {
+			ws, ok := db.workingSets[entry.Name]
+			if !ok {
+				ws = newWorkingSet()
+				db.workingSets[entry.Name] = ws
+			}
+			entry.workingSet = ws
+		}
+		if db.queryStats != nil {
+			qs, ok := db.queryStats[entry.Name]
+			if !ok {
+				qs = &queryStats{}
+				db.queryStats[entry.Name] = qs
+			}
+			entry.queryStats = qs
+		}
+	}
+}
+
+// queryStats counts the reads of a store and their latencies, it's owned by the db, so it survives the snapshot
+// switches, the counters are updated atomically by the readers.
+type queryStats struct {
+	gets, getNanos           atomic.Uint64
+	iterators, iteratorNanos atomic.Uint64
+
+	// the totals reported on the last commit, accessed with the db lock
+	reported queryTotals
+}
+
+type queryTotals struct {
+	gets, getNanos, iterators, iteratorNanos uint64
+}
+
+func (s *queryStats) observeGet(start time.Time) {
+	s.gets.Add(1)
+	s.getNanos.Add(uint64(time.Since(start)))
+}
+
+func (s *queryStats) observeIterator(start time.Time) {
+	s.iterators.Add(1)
+	s.iteratorNanos.Add(uint64(time.Since(start)))
+}
+
+func (s *queryStats) totals() queryTotals {
+	return queryTotals{
+		gets:          s.gets.Load(),
+		getNanos:      s.getNanos.Load(),
+		iterators:     s.iterators.Load(),
+		iteratorNanos: s.iteratorNanos.Load(),
+	}
+}
+
+// emitQueryMetrics reports the reads of each store since the last commit, and their average latencies.
+func (db *DB) emitQueryMetrics() {
+	for name, stats := range db.queryStats {
+		totals := stats.totals()
+		gets, getNanos := totals.gets-stats.reported.gets, totals.getNanos-stats.reported.getNanos
+		iterators, iteratorNanos := totals.iterators-stats.reported.iterators, totals.iteratorNanos-stats.reported.iteratorNanos
+		stats.reported = totals
+
+		db.metrics.IncrCounter(float32(gets), "store", "memiavl", "query_get", name)
+		db.metrics.IncrCounter(float32(iterators), "store", "memiavl", "query_iterator", name)
+		if gets > 0 {
+			db.metrics.SetGauge(float32(getNanos/gets)/1e3, "store", "memiavl", "query_get_latency_us", name)
+		}
+		if iterators > 0 {
+			db.metrics.SetGauge(float32(iteratorNanos/iterators)/1e3, "store", "memiavl", "query_iterator_latency_us", name)
+		}
+	}
+}
+
+// adviseCacheSizes reports the working set of each store at the end of each window of the cache advisor, the
+// recommended cache size holds the unique keys read in the window with 25% headroom.
+func (db *DB) adviseCacheSizes(version int64) {
+	if db.cacheAdvisorWindow == 0 || version%int64(db.cacheAdvisorWindow) != 0 {
+		return
+	}
+	for _, entry := range db.trees {
+		if entry.workingSet == nil {
+			continue
+		}
+		keys, bytes := entry.workingSet.reset()
+		recommended := keys + keys/4
+		db.metrics.SetGauge(float32(keys), "store", "memiavl", "working_set_keys", entry.Name)
+		db.metrics.SetGauge(float32(bytes), "store", "memiavl", "working_set_bytes", entry.Name)
+		db.metrics.SetGauge(float32(recommended), "store", "memiavl", "recommended_cache_size", entry.Name)
+		db.commitLogger.Info("cache size advice", "store", entry.Name, "blocks", db.cacheAdvisorWindow, "uniqueKeys", keys,
+			"bytes", bytes, "cacheSize", db.cacheSize, "recommended", recommended)
+	}
+}
+
+// popCacheStats returns the cache hits and misses since last call, and accumulates them into the totals.
+func (t *Tree) popCacheStats() (uint64, uint64) {
+	hits, misses := atomic.SwapUint64(&t.cacheHits, 0), atomic.SwapUint64(&t.cacheMisses, 0)
+	atomic.AddUint64(&t.cacheHitsTotal, hits)
+	atomic.AddUint64(&t.cacheMissesTotal, misses)
+	return hits, misses
+}
+
+// CacheStats is the statistics of the value cache of a store, or the sum of all the stores.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	// the number of the cached entries and the total length of the keys and values
+	Entries int    `json:"entries"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func (s *CacheStats) add(other CacheStats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Evictions += other.Evictions
+	s.Entries += other.Entries
+	s.Bytes += other.Bytes
+}
+
+// HitRatio returns the ratio of the hits in the lookups, 0 if there are none.
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// CacheStats returns the cache statistics since the tree is loaded, including the lookups after the last commit. It's
+// thread-safe, all zero if the cache is disabled.
+func (t *Tree) CacheStats() CacheStats {
+	if t.cache == nil {
+		return CacheStats{}
+	}
+	hits, misses := t.cacheStatsTotal()
+	return CacheStats{
+		Hits:      hits + atomic.LoadUint64(&t.cacheHits),
+		Misses:    misses + atomic.LoadUint64(&t.cacheMisses),
+		Evictions: t.cache.Evictions(),
+		Entries:   t.cache.Len(),
+		Bytes:     t.cache.Bytes(),
+	}
+}
+
+// Stats is the statistics of the db for tuning.
+type Stats struct {
+	// the sum of the cache statistics of the stores
+	Cache CacheStats `json:"cache"`
+	// the cache statistics of each store
+	Stores map[string]CacheStats `json:"stores"`
+}
+
+// Stats returns the cache statistics of the current trees, it doesn't take the db lock, the trees loaded from a new
+// snapshot start from zero. It's empty if the db is closed.
+func (db *DB) Stats() Stats {
+	stats := Stats{Stores: make(map[string]CacheStats)}
+	view := db.AcquireView()
+	if view == nil {
+		return stats
+	}
+	defer view.Release()
+	for name, tree := range view.view.trees {
+		cacheStats := tree.CacheStats()
+		stats.Stores[name] = cacheStats
+		stats.Cache.add(cacheStats)
+	}
+	return stats
+}
+
+// cacheStatsTotal returns the cache hits and misses accumulated until the last commit.
+func (t *Tree) cacheStatsTotal() (uint64, uint64) {
+	return atomic.LoadUint64(&t.cacheHitsTotal), atomic.LoadUint64(&t.cacheMissesTotal)
+}
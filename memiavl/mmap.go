@@ -2,11 +2,45 @@ package memiavl
 
 import (
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/ledgerwatch/erigon-lib/mmap"
 )
 
+// MmapAdvice is the expected access pattern of the mmap-ed files, passed to the kernel with `madvise`.
+type MmapAdvice int
+
+const (
+	// MmapAdviceDefault keeps the advice set when the file is mmap-ed.
+	MmapAdviceDefault MmapAdvice = iota
+	MmapAdviceNormal
+	MmapAdviceRandom
+	MmapAdviceSequential
+	MmapAdviceWillNeed
+)
+
+// ParseMmapAdvice parses the advice from the config, the empty string is the default advice.
+func ParseMmapAdvice(s string) (MmapAdvice, error) {
+	switch s {
+	case "", "default":
+		return MmapAdviceDefault, nil
+	case "normal":
+		return MmapAdviceNormal, nil
+	case "random":
+		return MmapAdviceRandom, nil
+	case "sequential":
+		return MmapAdviceSequential, nil
+	case "willneed":
+		return MmapAdviceWillNeed, nil
+	default:
+		return MmapAdviceDefault, fmt.Errorf("unknown mmap advice: %s", s)
+	}
+}
+
+// prefaultSink prevents the compiler from optimizing out the reads in Prefault.
+var prefaultSink byte
+
 // MmapFile manage the resources of a mmap-ed file
 type MmapFile struct {
 	file *os.File
@@ -50,6 +84,32 @@ func (m *MmapFile) Data() []byte {
 	return m.data
 }
 
+// Advise sets the expected access pattern of the file.
+func (m *MmapFile) Advise(advice MmapAdvice) error {
+	if len(m.data) == 0 {
+		return nil
+	}
+	return madvise(m.data, advice)
+}
+
+// AdviseHugePage asks the kernel to back the file with transparent huge pages, it's ignored if not supported.
+func (m *MmapFile) AdviseHugePage() error {
+	if len(m.data) == 0 {
+		return nil
+	}
+	return adviseHugePage(m.data)
+}
+
+// Prefault reads every page of the file to load it into the page cache, so the later accesses don't page fault.
+func (m *MmapFile) Prefault() {
+	pageSize := os.Getpagesize()
+	var sum byte
+	for i := 0; i < len(m.data); i += pageSize {
+		sum += m.data[i]
+	}
+	prefaultSink = sum
+}
+
 func Mmap(f *os.File) ([]byte, *[mmap.MaxMapSize]byte, error) {
 	fi, err := f.Stat()
 	if err != nil || fi.Size() == 0 {
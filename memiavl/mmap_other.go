@@ -0,0 +1,9 @@
+//go:build !unix
+// +build !unix
+
+package memiavl
+
+// madvise is not supported on the platform, the advice is ignored.
+func madvise([]byte, MmapAdvice) error {
+	return nil
+}
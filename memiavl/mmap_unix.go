@@ -0,0 +1,23 @@
+//go:build unix
+// +build unix
+
+package memiavl
+
+import "golang.org/x/sys/unix"
+
+func madvise(data []byte, advice MmapAdvice) error {
+	var flag int
+	switch advice {
+	case MmapAdviceNormal:
+		flag = unix.MADV_NORMAL
+	case MmapAdviceRandom:
+		flag = unix.MADV_RANDOM
+	case MmapAdviceSequential:
+		flag = unix.MADV_SEQUENTIAL
+	case MmapAdviceWillNeed:
+		flag = unix.MADV_WILLNEED
+	default:
+		return nil
+	}
+	return unix.Madvise(data, flag)
+}
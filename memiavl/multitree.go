@@ -1,16 +1,23 @@
 package memiavl
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime/pprof"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/alitto/pond"
 	"github.com/tidwall/wal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slices"
 )
 
@@ -43,6 +50,14 @@ type MultiTree struct {
 
 	zeroCopy  bool
 	cacheSize int
+	nodeArena bool
+	compact   bool
+
+	hashConcurrency  int
+	cacheShards      int
+	cachePrewarmSize int
+	// the max number of trees to apply the change sets concurrently when replaying the WAL
+	walReplayConcurrency int
 
 	trees          []NamedTree    // always ordered by tree name
 	treesByName    map[string]int // index of the trees by name
@@ -61,7 +76,10 @@ func NewEmptyMultiTree(initialVersion uint32, cacheSize int) *MultiTree {
 	}
 }
 
-func LoadMultiTree(dir string, zeroCopy bool, cacheSize int) (*MultiTree, error) {
+func LoadMultiTree(dir string, zeroCopy bool, cacheSize int) (_ *MultiTree, err error) {
+	_, span := tracer.Start(context.Background(), "memiavl.LoadMultiTree", trace.WithAttributes(attribute.String("dir", dir)))
+	defer func() { endSpan(span, err) }()
+
 	metadata, err := readMetadata(dir)
 	if err != nil {
 		return nil, err
@@ -152,6 +170,39 @@ func (t *MultiTree) setInitialVersion(initialVersion uint32) {
 	}
 }
 
+// advise sets the expected access patterns of the mmap-ed snapshot files of the trees, and optionally load the nodes
+// into the page cache.
+func (t *MultiTree) advise(nodes, kvs MmapAdvice, hugePageNodes, prefaultNodes bool) error {
+	for _, entry := range t.trees {
+		if entry.snapshot == nil {
+			continue
+		}
+		if err := entry.snapshot.Advise(nodes, kvs); err != nil {
+			return fmt.Errorf("fail to advise the snapshot of %s: %w", entry.Name, err)
+		}
+		// before the prefaulting, so the pages are faulted in as the huge pages
+		if hugePageNodes {
+			if err := entry.snapshot.AdviseHugePageNodes(); err != nil {
+				return fmt.Errorf("fail to advise huge pages for the snapshot of %s: %w", entry.Name, err)
+			}
+		}
+		if prefaultNodes {
+			entry.snapshot.PrefaultNodes()
+		}
+	}
+	return nil
+}
+
+// commitmentOnly returns if any of the trees is loaded from a commitment-only snapshot.
+func (t *MultiTree) commitmentOnly() bool {
+	for _, entry := range t.trees {
+		if entry.snapshot != nil && entry.snapshot.commitmentOnly {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *MultiTree) SetZeroCopy(zeroCopy bool) {
 	t.zeroCopy = zeroCopy
 	for _, entry := range t.trees {
@@ -159,6 +210,88 @@ func (t *MultiTree) SetZeroCopy(zeroCopy bool) {
 	}
 }
 
+// memoryUsage returns the approximate heap bytes of the nodes created since the snapshot is loaded, plus the caches.
+func (t *MultiTree) memoryUsage() uint64 {
+	var usage uint64
+	for _, entry := range t.trees {
+		usage += entry.memoryUsage()
+	}
+	return usage
+}
+
+// evictColdCaches purges the caches of the stores which have no cache hits since the last commit.
+func (t *MultiTree) evictColdCaches() {
+	for _, entry := range t.trees {
+		if entry.cache != nil && atomic.LoadUint64(&entry.cacheHits) == 0 {
+			entry.cache.Purge()
+		}
+	}
+}
+
+// SetHashConcurrency sets the max number of goroutines to compute the root hash of each tree, including the ones
+// added by later upgrades.
+func (t *MultiTree) SetHashConcurrency(concurrency int) {
+	t.hashConcurrency = concurrency
+	for _, entry := range t.trees {
+		entry.SetHashConcurrency(concurrency)
+	}
+}
+
+// SetCacheShards sets the number of shards of the cache of each tree, including the ones added by later upgrades.
+func (t *MultiTree) SetCacheShards(shards int) {
+	t.cacheShards = shards
+	for _, entry := range t.trees {
+		entry.SetCacheShards(shards)
+	}
+}
+
+// SetCachePrewarmSize sets the number of the recently accessed keys to record for each tree, including the ones
+// added by later upgrades, 0 means disabled.
+func (t *MultiTree) SetCachePrewarmSize(size int) {
+	t.cachePrewarmSize = size
+	for _, entry := range t.trees {
+		entry.SetCachePrewarmSize(size)
+	}
+}
+
+// recentKeys returns the copies of the recently accessed keys of each tree.
+func (t *MultiTree) recentKeys() map[string][][]byte {
+	keys := make(map[string][][]byte)
+	for _, entry := range t.trees {
+		if entry.recentKeys != nil {
+			keys[entry.Name] = entry.recentKeys.snapshot()
+		}
+	}
+	return keys
+}
+
+// prewarmCaches loads the values of the keys into the caches of the trees.
+func (t *MultiTree) prewarmCaches(keys map[string][][]byte) {
+	for name, treeKeys := range keys {
+		if tree := t.TreeByName(name); tree != nil {
+			tree.prewarmCache(treeKeys)
+		}
+	}
+}
+
+// SetNodeArena enables or disables the arena allocation of the new nodes for all the trees, including the ones added
+// by later upgrades.
+func (t *MultiTree) SetNodeArena(enable bool) {
+	t.nodeArena = enable
+	for _, entry := range t.trees {
+		entry.SetNodeArena(enable)
+	}
+}
+
+// SetCompactNodes enables or disables the compact mode of the new leaves for all the trees, including the ones added
+// by later upgrades.
+func (t *MultiTree) SetCompactNodes(enable bool) {
+	t.compact = enable
+	for _, entry := range t.trees {
+		entry.SetCompactNodes(enable)
+	}
+}
+
 // Copy returns a snapshot of the tree which won't be corrupted by further modifications on the main tree.
 func (t *MultiTree) Copy(cacheSize int) *MultiTree {
 	trees := make([]NamedTree, len(t.trees))
@@ -191,9 +324,49 @@ func (t *MultiTree) applyWALEntry(entry WALEntry) error {
 	if err := t.ApplyUpgrades(entry.Upgrades); err != nil {
 		return err
 	}
+	if t.walReplayConcurrency > 1 && len(entry.Changesets) > 1 {
+		return t.applyChangeSetsConcurrently(entry.Changesets)
+	}
 	return t.ApplyChangeSets(entry.Changesets)
 }
 
+// applyChangeSetsConcurrently applies the change sets of different trees concurrently, at most
+// `walReplayConcurrency` trees at a time, the trees are independent, the change sets of the same tree are applied
+// in order.
+func (t *MultiTree) applyChangeSetsConcurrently(changeSets []*NamedChangeSet) error {
+	byTree := make(map[int][]*NamedChangeSet, len(changeSets))
+	for _, cs := range changeSets {
+		i, found := t.treesByName[cs.Name]
+		if !found {
+			return fmt.Errorf("unknown tree name %s", cs.Name)
+		}
+		byTree[i] = append(byTree[i], cs)
+	}
+
+	sem := make(chan struct{}, t.walReplayConcurrency)
+	var wg sync.WaitGroup
+	for i, treeChangeSets := range byTree {
+		tree := t.trees[i].Tree
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			for _, cs := range treeChangeSets {
+				tree.ApplyChangeSet(cs.Changeset)
+			}
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// SetWALReplayConcurrency sets the max number of trees to apply the change sets concurrently when replaying the WAL,
+// 0 or 1 means sequential.
+func (t *MultiTree) SetWALReplayConcurrency(concurrency int) {
+	t.walReplayConcurrency = concurrency
+}
+
 // ApplyUpgrades store name upgrades
 func (t *MultiTree) ApplyUpgrades(upgrades []*TreeNameUpgrade) error {
 	if len(upgrades) == 0 {
@@ -226,6 +399,11 @@ func (t *MultiTree) ApplyUpgrades(upgrades []*TreeNameUpgrade) error {
 		default:
 			// add tree
 			tree := NewWithInitialVersion(uint32(nextVersion(t.Version(), t.initialVersion)), t.cacheSize)
+			tree.SetNodeArena(t.nodeArena)
+			tree.SetCompactNodes(t.compact)
+			tree.SetHashConcurrency(t.hashConcurrency)
+			tree.SetCacheShards(t.cacheShards)
+			tree.SetCachePrewarmSize(t.cachePrewarmSize)
 			t.trees = append(t.trees, NamedTree{Tree: tree, Name: upgrade.Name})
 		}
 	}
@@ -307,6 +485,63 @@ func (t *MultiTree) buildCommitInfo(version int64) *CommitInfo {
 	}
 }
 
+// CommitInfoMismatchError is returned by `Load` if the root hashes of the trees loaded from the snapshot don't match
+// the commit info persisted with it, the snapshot is corrupted or assembled from different versions.
+type CommitInfoMismatchError struct {
+	Version int64
+	// the app hashes of the persisted commit info and the one recomputed from the trees
+	Expected, Actual []byte
+	// the descriptions of the mismatched stores
+	Stores []string
+}
+
+func (e *CommitInfoMismatchError) Error() string {
+	return fmt.Sprintf("commit info mismatch at version %d, persisted app hash %X, recomputed %X:\n%s",
+		e.Version, e.Expected, e.Actual, strings.Join(e.Stores, "\n"))
+}
+
+// checkCommitInfo recomputes the app hash from the root hashes of the trees, and compares it with the persisted
+// commit info, the root hashes of the trees loaded from the snapshots are persisted, so it's cheap.
+func (t *MultiTree) checkCommitInfo() error {
+	expected := &t.lastCommitInfo
+	actual := t.buildCommitInfo(expected.Version)
+	if bytes.Equal(expected.Hash(), actual.Hash()) {
+		return nil
+	}
+
+	infos := make(map[string]CommitID, len(expected.StoreInfos))
+	for _, info := range expected.StoreInfos {
+		infos[info.Name] = info.CommitId
+	}
+	var stores []string
+	for _, info := range actual.StoreInfos {
+		commitID, ok := infos[info.Name]
+		if !ok {
+			stores = append(stores, fmt.Sprintf("store %s: not in the commit info", info.Name))
+			continue
+		}
+		delete(infos, info.Name)
+		if !bytes.Equal(commitID.Hash, info.CommitId.Hash) {
+			stores = append(stores, fmt.Sprintf("store %s: root hash mismatch, persisted %X, recomputed %X",
+				info.Name, commitID.Hash, info.CommitId.Hash))
+		}
+	}
+	missing := make([]string, 0, len(infos))
+	for name := range infos {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		stores = append(stores, fmt.Sprintf("store %s: tree missing", name))
+	}
+	return &CommitInfoMismatchError{
+		Version:  expected.Version,
+		Expected: expected.Hash(),
+		Actual:   actual.Hash(),
+		Stores:   stores,
+	}
+}
+
 // UpdateCommitInfo update lastCommitInfo based on current status of trees.
 // it's needed if `updateCommitInfo` is set to `false` in `ApplyChangeSet`.
 func (t *MultiTree) UpdateCommitInfo() {
@@ -315,15 +550,28 @@ func (t *MultiTree) UpdateCommitInfo() {
 
 // CatchupWAL replay the new entries in the WAL on the tree to catch-up to the target or latest version.
 func (t *MultiTree) CatchupWAL(wal *wal.Log, endVersion int64) error {
+	_, err := t.catchupWAL(wal, endVersion)
+	return err
+}
+
+// catchupWAL is the same as CatchupWAL, it also returns the total size of the replayed entries.
+func (t *MultiTree) catchupWAL(wal *wal.Log, endVersion int64) (replayed uint64, err error) {
+	_, span := tracer.Start(context.Background(), "memiavl.CatchupWAL",
+		trace.WithAttributes(attribute.Int64("startVersion", t.Version())))
+	defer func() {
+		span.SetAttributes(attribute.Int64("endVersion", t.Version()), attribute.Int64("replayedBytes", int64(replayed)))
+		endSpan(span, err)
+	}()
+
 	lastIndex, err := wal.LastIndex()
 	if err != nil {
-		return fmt.Errorf("read wal last index failed, %w", err)
+		return 0, fmt.Errorf("read wal last index failed, %w", err)
 	}
 
 	firstIndex := walIndex(nextVersion(t.Version(), t.initialVersion), t.initialVersion)
 	if firstIndex > lastIndex {
 		// already up-to-date
-		return nil
+		return 0, nil
 	}
 
 	var endIndex uint64
@@ -334,7 +582,7 @@ func (t *MultiTree) CatchupWAL(wal *wal.Log, endVersion int64) error {
 	}
 
 	if endIndex < firstIndex {
-		return fmt.Errorf("target index %d is pruned", endIndex)
+		return 0, fmt.Errorf("target index %d is pruned", endIndex)
 	}
 
 	if endIndex > lastIndex {
@@ -344,21 +592,22 @@ func (t *MultiTree) CatchupWAL(wal *wal.Log, endVersion int64) error {
 	for i := firstIndex; i <= endIndex; i++ {
 		bz, err := wal.Read(i)
 		if err != nil {
-			return fmt.Errorf("read wal log failed, %w", err)
+			return 0, fmt.Errorf("read wal log failed, %w", err)
 		}
-		var entry WALEntry
-		if err := entry.Unmarshal(bz); err != nil {
-			return fmt.Errorf("unmarshal wal log failed, %w", err)
+		entry, err := decodeLocalWALEntry(bz)
+		if err != nil {
+			return 0, fmt.Errorf("decode wal log %d failed, %w", i, err)
 		}
-		if err := t.applyWALEntry(entry); err != nil {
-			return fmt.Errorf("replay wal entry failed, %w", err)
+		if err := t.applyWALEntry(*entry); err != nil {
+			return 0, fmt.Errorf("replay wal entry failed, %w", err)
 		}
 		if _, err := t.SaveVersion(false); err != nil {
-			return fmt.Errorf("replay change set failed, %w", err)
+			return 0, fmt.Errorf("replay change set failed, %w", err)
 		}
+		replayed += uint64(len(bz))
 	}
 	t.UpdateCommitInfo()
-	return nil
+	return replayed, nil
 }
 
 func (t *MultiTree) WriteSnapshot(dir string, wp *pond.WorkerPool) error {
@@ -366,6 +615,10 @@ func (t *MultiTree) WriteSnapshot(dir string, wp *pond.WorkerPool) error {
 }
 
 func (t *MultiTree) WriteSnapshotWithContext(ctx context.Context, dir string, wp *pond.WorkerPool) error {
+	return t.writeSnapshot(ctx, dir, wp, snapshotWriteOptions{})
+}
+
+func (t *MultiTree) writeSnapshot(ctx context.Context, dir string, wp *pond.WorkerPool, opts snapshotWriteOptions) error {
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return err
 	}
@@ -375,8 +628,34 @@ func (t *MultiTree) WriteSnapshotWithContext(ctx context.Context, dir string, wp
 
 	for _, entry := range t.trees {
 		tree, name := entry.Tree, entry.Name
-		group.Submit(func() error {
-			return tree.WriteSnapshotWithContext(ctx, filepath.Join(dir, name))
+		// the permit is acquired before submitting, so the waiting trees don't occupy the workers of a shared pool
+		if opts.limiter != nil {
+			if err := opts.limiter.acquire(ctx); err != nil {
+				return errors.Join(err, group.Wait())
+			}
+		}
+		group.Submit(func() (err error) {
+			if opts.limiter != nil {
+				defer opts.limiter.release()
+			}
+			defer func() {
+				// the panics are swallowed by the worker pool otherwise
+				if r := recover(); r != nil {
+					err = panicError(fmt.Sprintf("writing snapshot of %s", name), r)
+				}
+			}()
+			ctx, span := tracer.Start(ctx, "memiavl.WriteTreeSnapshot", trace.WithAttributes(attribute.String("store", name)))
+			defer func() { endSpan(span, err) }()
+			if tree.root != nil {
+				opts.progress.addTotalLeaves(uint64(tree.root.Size()))
+			}
+			opts.progress.storeStarted(name)
+			defer opts.progress.storeFinished(name)
+			// the worker's labels are restored after the task
+			pprof.Do(ctx, pprof.Labels("subsystem", profileSnapshotWriter, "store", name), func(ctx context.Context) {
+				err = tree.writeSnapshot(ctx, filepath.Join(dir, name), opts)
+			})
+			return err
 		})
 	}
 
@@ -396,7 +675,8 @@ func (t *MultiTree) WriteSnapshotWithContext(ctx context.Context, dir string, wp
 	return WriteFileSync(filepath.Join(dir, MetadataFileName), bz)
 }
 
-// WriteFileSync calls `f.Sync` after before closing the file
+// WriteFileSync calls `fdatasync` before closing the file, then syncs the parent directory, which also persists the
+// entries created in it before, like the tree snapshots written ahead of the metadata file.
 func WriteFileSync(name string, data []byte) error {
 	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 	if err != nil {
@@ -404,12 +684,15 @@ func WriteFileSync(name string, data []byte) error {
 	}
 	_, err = f.Write(data)
 	if err == nil {
-		err = f.Sync()
+		err = fdatasync(f)
 	}
 	if err1 := f.Close(); err1 != nil && err == nil {
 		err = err1
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
 }
 
 func (t *MultiTree) Close() error {
@@ -31,37 +31,44 @@ type Node interface {
 	GetByIndex(uint32) ([]byte, []byte)
 }
 
+// mutateNode is `Node.Mutate` with the new nodes allocated from the arena, the arena is internal to the tree, so it's
+// not part of the interface, the other implementations of `Node` allocate on heap.
+func mutateNode(arena *nodeArena, node Node, version, cowVersion uint32) *MemNode {
+	switch n := node.(type) {
+	case *MemNode:
+		return n.mutate(arena, version, cowVersion)
+	case PersistedNode:
+		return n.mutate(arena, version)
+	default:
+		return node.Mutate(version, cowVersion)
+	}
+}
+
 // setRecursive do set operation.
 // it always do modification and return new `MemNode`, even if the value is the same.
 // also returns if it's an update or insertion, if update, the tree height and balance is not changed.
-func setRecursive(node Node, key, value []byte, version, cowVersion uint32) (*MemNode, bool) {
+func setRecursive(arena *nodeArena, node Node, key, value []byte, version, cowVersion uint32) (*MemNode, bool) {
 	if node == nil {
-		return newLeafNode(key, value, version), true
+		return arena.newLeafNode(key, value, version), true
 	}
 
-	nodeKey := node.Key()
 	if node.IsLeaf() {
-		switch bytes.Compare(key, nodeKey) {
-		case -1:
-			return &MemNode{
-				height:  1,
-				size:    2,
-				version: version,
-				key:     nodeKey,
-				left:    newLeafNode(key, value, version),
-				right:   node,
-			}, false
+		switch compareNodeKey(node, key) {
 		case 1:
-			return &MemNode{
-				height:  1,
-				size:    2,
-				version: version,
-				key:     key,
-				left:    node,
-				right:   newLeafNode(key, value, version),
-			}, false
+			newNode := arena.newNode()
+			newNode.height, newNode.size, newNode.version = 1, 2, version
+			newNode.setKeyOf(node)
+			newNode.left, newNode.right = arena.newLeafNodeNear(key, value, version, node), node
+			return newNode, false
+		case -1:
+			leaf := arena.newLeafNodeNear(key, value, version, node)
+			newNode := arena.newNode()
+			newNode.height, newNode.size, newNode.version = 1, 2, version
+			newNode.setKeyOf(leaf)
+			newNode.left, newNode.right = node, leaf
+			return newNode, false
 		default:
-			newNode := node.Mutate(version, cowVersion)
+			newNode := mutateNode(arena, node, version, cowVersion)
 			newNode.value = value
 			return newNode, true
 		}
@@ -70,19 +77,19 @@ func setRecursive(node Node, key, value []byte, version, cowVersion uint32) (*Me
 			newChild, newNode *MemNode
 			updated           bool
 		)
-		if bytes.Compare(key, nodeKey) == -1 {
-			newChild, updated = setRecursive(node.Left(), key, value, version, cowVersion)
-			newNode = node.Mutate(version, cowVersion)
+		if compareNodeKey(node, key) == 1 {
+			newChild, updated = setRecursive(arena, node.Left(), key, value, version, cowVersion)
+			newNode = mutateNode(arena, node, version, cowVersion)
 			newNode.left = newChild
 		} else {
-			newChild, updated = setRecursive(node.Right(), key, value, version, cowVersion)
-			newNode = node.Mutate(version, cowVersion)
+			newChild, updated = setRecursive(arena, node.Right(), key, value, version, cowVersion)
+			newNode = mutateNode(arena, node, version, cowVersion)
 			newNode.right = newChild
 		}
 
 		if !updated {
 			newNode.updateHeightSize()
-			newNode = newNode.reBalance(version, cowVersion)
+			newNode = newNode.reBalance(arena, version, cowVersion)
 		}
 
 		return newNode, updated
@@ -91,35 +98,37 @@ func setRecursive(node Node, key, value []byte, version, cowVersion uint32) (*Me
 
 // removeRecursive returns:
 // - (nil, origNode, nil) -> nothing changed in subtree
-// - (value, nil, newKey) -> leaf node is removed
-// - (value, new node, newKey) -> subtree changed
-func removeRecursive(node Node, key []byte, version, cowVersion uint32) ([]byte, Node, []byte) {
+// - (value, nil, newKeyNode) -> leaf node is removed
+// - (value, new node, newKeyNode) -> subtree changed
+//
+// newKeyNode is the node which has the new key of the parent, if not nil.
+func removeRecursive(arena *nodeArena, node Node, key []byte, version, cowVersion uint32) ([]byte, Node, Node) {
 	if node == nil {
 		return nil, nil, nil
 	}
 
 	if node.IsLeaf() {
-		if bytes.Equal(node.Key(), key) {
+		if compareNodeKey(node, key) == 0 {
 			return node.Value(), nil, nil
 		}
 		return nil, node, nil
 	}
 
-	if bytes.Compare(key, node.Key()) == -1 {
-		value, newLeft, newKey := removeRecursive(node.Left(), key, version, cowVersion)
+	if compareNodeKey(node, key) == 1 {
+		value, newLeft, newKeyNode := removeRecursive(arena, node.Left(), key, version, cowVersion)
 		if value == nil {
 			return nil, node, nil
 		}
 		if newLeft == nil {
-			return value, node.Right(), node.Key()
+			return value, node.Right(), node
 		}
-		newNode := node.Mutate(version, cowVersion)
+		newNode := mutateNode(arena, node, version, cowVersion)
 		newNode.left = newLeft
 		newNode.updateHeightSize()
-		return value, newNode.reBalance(version, cowVersion), newKey
+		return value, newNode.reBalance(arena, version, cowVersion), newKeyNode
 	}
 
-	value, newRight, newKey := removeRecursive(node.Right(), key, version, cowVersion)
+	value, newRight, newKeyNode := removeRecursive(arena, node.Right(), key, version, cowVersion)
 	if value == nil {
 		return nil, node, nil
 	}
@@ -127,13 +136,13 @@ func removeRecursive(node Node, key []byte, version, cowVersion uint32) ([]byte,
 		return value, node.Left(), nil
 	}
 
-	newNode := node.Mutate(version, cowVersion)
+	newNode := mutateNode(arena, node, version, cowVersion)
 	newNode.right = newRight
-	if newKey != nil {
-		newNode.key = newKey
+	if newKeyNode != nil {
+		newNode.setKeyOf(newKeyNode)
 	}
 	newNode.updateHeightSize()
-	return value, newNode.reBalance(version, cowVersion), nil
+	return value, newNode.reBalance(arena, version, cowVersion), nil
 }
 
 // Writes the node's hash to the given `io.Writer`. This function recursively calls
@@ -160,7 +169,7 @@ func writeHashBytes(node Node, w io.Writer) error {
 	// Key is not written for inner nodes, unlike writeBytes.
 
 	if node.IsLeaf() {
-		if err := EncodeBytes(w, node.Key()); err != nil {
+		if err := encodeNodeKey(w, node); err != nil {
 			return fmt.Errorf("writing key, %w", err)
 		}
 
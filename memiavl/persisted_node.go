@@ -3,7 +3,6 @@ package memiavl
 import (
 	"bytes"
 	"crypto/sha256"
-	"sort"
 )
 
 const (
@@ -143,25 +142,20 @@ func (node PersistedNode) Hash() []byte {
 }
 
 func (node PersistedNode) Mutate(version, _ uint32) *MemNode {
+	return node.mutate(nil, version)
+}
+
+// mutate is `Mutate` with the new node allocated from the arena.
+func (node PersistedNode) mutate(arena *nodeArena, version uint32) *MemNode {
 	if node.isLeaf {
 		key, value := node.snapshot.LeafKeyValue(node.index)
-		return &MemNode{
-			height:  0,
-			size:    1,
-			version: version,
-			key:     key,
-			value:   value,
-		}
+		return arena.newLeafNode(key, value, version)
 	}
 	data := node.branchNode()
-	return &MemNode{
-		height:  data.Height(),
-		size:    int64(data.Size()),
-		version: version,
-		key:     node.Key(),
-		left:    node.Left(),
-		right:   node.Right(),
-	}
+	n := arena.newNode()
+	n.height, n.size, n.version = data.Height(), int64(data.Size()), version
+	n.key, n.left, n.right = node.Key(), node.Left(), node.Right()
+	return n
 }
 
 func (node PersistedNode) Get(key []byte) ([]byte, uint32) {
@@ -176,11 +170,18 @@ func (node PersistedNode) Get(key []byte) ([]byte, uint32) {
 		start = getStartLeaf(node.index, count, preTrees)
 	}
 
-	// binary search in the leaf node array
-	i := uint32(sort.Search(int(count), func(i int) bool {
-		leafKey := node.snapshot.LeafKey(start + uint32(i))
-		return bytes.Compare(leafKey, key) >= 0
-	}))
+	// binary search in the leaf node array, written by hand rather than `sort.Search` to keep the hot path free of
+	// closures and indirect calls, the prefix compressed keys are decoded into the buffer on stack.
+	var buf [64]byte
+	i, j := uint32(0), count
+	for i < j {
+		h := (i + j) >> 1
+		if bytes.Compare(node.snapshot.leafKeyTo(buf[:0], start+h), key) < 0 {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
 
 	leaf := i + start
 	if leaf >= start+count {
@@ -188,12 +189,11 @@ func (node PersistedNode) Get(key []byte) ([]byte, uint32) {
 		return nil, i
 	}
 
-	nodeKey, value := node.snapshot.LeafKeyValue(leaf)
-	if !bytes.Equal(nodeKey, key) {
+	if !bytes.Equal(node.snapshot.leafKeyTo(buf[:0], leaf), key) {
 		return nil, i
 	}
 
-	return value, i
+	return node.snapshot.leafValue(leaf), i
 }
 
 func (node PersistedNode) GetByIndex(leafIndex uint32) ([]byte, []byte) {
@@ -0,0 +1,136 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var errTreeClosed = errors.New("the tree is closed")
+
+// PinnedValue is a zero-copy value which keeps the snapshot it points to mapped until released, the zero-copy slices
+// are invalid after the snapshot switch otherwise, so it could be passed to the response encoders safely without
+// copying. It must be released after use, the old snapshot files stay mapped until then.
+type PinnedValue struct {
+	value    []byte
+	snapshot *Snapshot
+}
+
+// Bytes returns the value, it must not be modified, or used after the release.
+func (v *PinnedValue) Bytes() []byte {
+	if v == nil {
+		return nil
+	}
+	return v.value
+}
+
+// Release unpins the snapshot, it's safe to call it on nil or more than once.
+func (v *PinnedValue) Release() error {
+	if v == nil || v.snapshot == nil {
+		return nil
+	}
+	snapshot := v.snapshot
+	v.value, v.snapshot = nil, nil
+	return snapshot.unpin()
+}
+
+// GetPinned returns the zero-copy value of the key pinned to the snapshot regardless of the zero-copy setting, nil if
+// not found. It reads the nodes directly rather than the cache, whose values may be copies. It fails if the tree is
+// closed.
+func (t *Tree) GetPinned(key []byte) (*PinnedValue, error) {
+	root, snapshot := t.root, t.snapshot
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return nil, errTreeClosed
+	}
+	// the tree not backed by a snapshot only references the heap memory
+	if snapshot != nil && !snapshot.pin() {
+		return nil, errTreeClosed
+	}
+	var value []byte
+	if root != nil {
+		value, _ = root.Get(key)
+	}
+	if value == nil {
+		return nil, (&PinnedValue{snapshot: snapshot}).Release()
+	}
+	return &PinnedValue{value: value, snapshot: snapshot}, nil
+}
+
+// GetPinned returns the pinned value of the key in the store, nil if not found. It reads the current trees without
+// locking, which are kept open by the view until the snapshot is pinned.
+func (db *DB) GetPinned(name string, key []byte) (*PinnedValue, error) {
+	view := db.AcquireView()
+	if view == nil {
+		return nil, errClosed
+	}
+	tree := view.TreeByName(name)
+	if tree == nil {
+		return nil, errors.Join(fmt.Errorf("unknown tree name %s", name), view.Release())
+	}
+	value, err := tree.GetPinned(key)
+	return value, errors.Join(err, view.Release())
+}
+
+// versionPins counts the references of the pinned versions, the snapshots needed to load them are kept by the
+// pruning, so are the WAL entries after them. The pruning marks the snapshots to remove under the lock, and removes
+// them after releasing it, the versions loaded from the marked snapshots can't be pinned.
+type versionPins struct {
+	mtx  sync.Mutex
+	refs map[int64]int
+	// the snapshot versions being removed by the pruning
+	pruning map[int64]struct{}
+}
+
+// pinnedBetween returns whether any version in [low, high) is pinned, the lock must be held.
+func (p *versionPins) pinnedBetween(low, high int64) bool {
+	for version := range p.refs {
+		if version >= low && version < high {
+			return true
+		}
+	}
+	return false
+}
+
+// PinVersion protects the version from the pruning until it's unpinned, the snapshot it's loaded from and the WAL
+// entries after that are kept, so the long-running readers of the version, like the exports, the state-sync chunk
+// serving and the historical queries, are not broken by the background pruning. It's reference counted, each call
+// must be paired with an `Unpin`. It fails if the version is not committed or already pruned.
+func (db *DB) PinVersion(version int64) error {
+	db.pins.mtx.Lock()
+	defer db.pins.mtx.Unlock()
+
+	if version <= 0 || version > db.Version() {
+		return fmt.Errorf("version %d is not committed", version)
+	}
+	snapshotVersion, err := seekSnapshot(db.dir, uint32(version))
+	if err != nil {
+		return err
+	}
+	if _, ok := db.pins.pruning[snapshotVersion]; ok {
+		return fmt.Errorf("target version is pruned: %d", version)
+	}
+	if db.pins.refs == nil {
+		db.pins.refs = make(map[int64]int)
+	}
+	db.pins.refs[version]++
+	return nil
+}
+
+// Unpin releases a reference of the pinned version, the snapshot is pruned by the next pruning after all the
+// references are released.
+func (db *DB) Unpin(version int64) error {
+	db.pins.mtx.Lock()
+	defer db.pins.mtx.Unlock()
+
+	refs, ok := db.pins.refs[version]
+	if !ok {
+		return fmt.Errorf("version %d is not pinned", version)
+	}
+	if refs == 1 {
+		delete(db.pins.refs, version)
+	} else {
+		db.pins.refs[version] = refs - 1
+	}
+	return nil
+}
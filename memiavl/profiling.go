@@ -0,0 +1,21 @@
+package memiavl
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// the values of the "subsystem" pprof label of the background goroutines, so the CPU profiles attribute the time to
+// the memiavl subsystems rather than the anonymous goroutines.
+const (
+	profileSnapshotWriter  = "memiavl-snapshot-writer"
+	profileSnapshotRewrite = "memiavl-snapshot-rewrite"
+	profileAsyncWAL        = "memiavl-async-wal"
+)
+
+// setGoroutineLabels labels the current goroutine with the subsystem and the extra key-value pairs, the goroutines
+// started by it inherit the labels, it's only for the dedicated goroutines, the pooled workers use `pprof.Do` instead.
+func setGoroutineLabels(subsystem string, kvs ...string) {
+	labels := pprof.Labels(append([]string{"subsystem", subsystem}, kvs...)...)
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), labels))
+}
@@ -0,0 +1,196 @@
+package memiavl
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// The phases of the background snapshot rewrite.
+const (
+	RewritePhaseWriting    = "writing"
+	RewritePhaseLoading    = "loading"
+	RewritePhaseCatchingUp = "catching_up"
+	RewritePhasePrewarming = "prewarming"
+	// the new snapshot is ready, waiting for the next commit to switch to it
+	RewritePhaseReady = "ready"
+)
+
+// BackgroundTasks is the state of the background tasks of the db, for display in the dashboards.
+type BackgroundTasks struct {
+	SnapshotRewrite SnapshotRewriteStatus `json:"snapshot_rewrite"`
+	SnapshotPrune   SnapshotPruneStatus   `json:"snapshot_prune"`
+	AsyncCommit     AsyncCommitStatus     `json:"async_commit"`
+}
+
+// SnapshotRewriteStatus is the state of the background snapshot rewrite.
+type SnapshotRewriteStatus struct {
+	Running bool `json:"running"`
+	// the fields below are only set if running
+	Phase   string    `json:"phase,omitempty"`
+	Version int64     `json:"version,omitempty"`
+	Started time.Time `json:"started,omitempty"`
+	// the stores being written, in parallel up to the snapshot writer limit
+	Stores []string `json:"stores,omitempty"`
+	// the percentage of the leaves written, of all the stores
+	Percent float64 `json:"percent,omitempty"`
+}
+
+// SnapshotPruneStatus is the state of the pruning of the old snapshots.
+type SnapshotPruneStatus struct {
+	Running bool `json:"running"`
+}
+
+// AsyncCommitStatus is the state of the goroutine writing the WAL in async commit mode.
+type AsyncCommitStatus struct {
+	Running bool `json:"running"`
+	// the number of the committed versions queued or being written, and the buffer size of the queue
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+}
+
+// rewriteProgress tracks the progress of the background snapshot rewrite, it's shared by the db and the copy writing
+// the snapshot, the methods are no-op on nil.
+type rewriteProgress struct {
+	mtx     sync.Mutex
+	phase   string
+	version int64
+	started time.Time
+	stores  []string
+
+	totalLeaves, writtenLeaves uint64
+}
+
+func (p *rewriteProgress) start(version int64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.phase = RewritePhaseWriting
+	p.version = version
+	p.started = time.Now()
+	p.stores = nil
+	atomic.StoreUint64(&p.totalLeaves, 0)
+	atomic.StoreUint64(&p.writtenLeaves, 0)
+}
+
+func (p *rewriteProgress) setPhase(phase string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.phase = phase
+}
+
+// addTotalLeaves accumulates the leaves of the stores as they start, the size of a broken tree could panic, so it's
+// computed by the recovered workers.
+func (p *rewriteProgress) addTotalLeaves(n uint64) {
+	if p != nil {
+		atomic.AddUint64(&p.totalLeaves, n)
+	}
+}
+
+func (p *rewriteProgress) addLeaves(n uint64) {
+	if p != nil {
+		atomic.AddUint64(&p.writtenLeaves, n)
+	}
+}
+
+func (p *rewriteProgress) storeStarted(name string) {
+	if p == nil {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.stores = append(p.stores, name)
+}
+
+func (p *rewriteProgress) storeFinished(name string) {
+	if p == nil {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if i := slices.Index(p.stores, name); i >= 0 {
+		p.stores = slices.Delete(p.stores, i, i+1)
+	}
+}
+
+func (p *rewriteProgress) status() SnapshotRewriteStatus {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	status := SnapshotRewriteStatus{
+		Phase:   p.phase,
+		Version: p.version,
+		Started: p.started,
+		Stores:  slices.Clone(p.stores),
+		Percent: 100,
+	}
+	if p.phase == RewritePhaseWriting {
+		status.Percent = 0
+		if total := atomic.LoadUint64(&p.totalLeaves); total > 0 {
+			status.Percent = min(100, float64(atomic.LoadUint64(&p.writtenLeaves))*100/float64(total))
+		}
+	}
+	return status
+}
+
+// watchRewrite cancels the background rewrite if no leaves are written for the stall timeout, until the writing
+// phase is finished or the context is done, which is cancelled when the rewrite goroutine exits.
+func (db *DB) watchRewrite(ctx context.Context, cancel context.CancelFunc) {
+	timeout := db.rewriteStallTimeout
+	ticker := time.NewTicker(max(timeout/10, time.Millisecond))
+	defer ticker.Stop()
+
+	written := atomic.LoadUint64(&db.rewriteProgress.writtenLeaves)
+	lastProgress := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status := db.rewriteProgress.status()
+		if status.Phase != RewritePhaseWriting {
+			return
+		}
+		if n := atomic.LoadUint64(&db.rewriteProgress.writtenLeaves); n != written {
+			written, lastProgress = n, time.Now()
+			continue
+		}
+		if time.Since(lastProgress) < timeout {
+			continue
+		}
+
+		db.snapshotLogger.Error("snapshot rewrite stalled, cancel it", "version", status.Version,
+			"elapsed", time.Since(status.Started), "stalled", time.Since(lastProgress), "stores", status.Stores,
+			"writtenLeaves", written, "totalLeaves", atomic.LoadUint64(&db.rewriteProgress.totalLeaves),
+			"retry", db.retryStalledRewrite)
+		db.metrics.IncrCounter(1, "store", "memiavl", "snapshot_rewrite_stalled")
+		if db.retryStalledRewrite {
+			db.rewriteRetry.Store(true)
+		}
+		cancel()
+		return
+	}
+}
+
+// BackgroundTasks returns the state of the background tasks without the db lock.
+func (db *DB) BackgroundTasks() BackgroundTasks {
+	var tasks BackgroundTasks
+	if db.rewriting.Load() {
+		tasks.SnapshotRewrite = db.rewriteProgress.status()
+		tasks.SnapshotRewrite.Running = true
+	}
+
+	db.health.mtx.Lock()
+	tasks.SnapshotPrune.Running = db.health.prune.Running
+	tasks.AsyncCommit.Running = db.health.asyncCommit
+	db.health.mtx.Unlock()
+
+	if tasks.AsyncCommit.Running {
+		tasks.AsyncCommit.QueueDepth = int(atomic.LoadInt64(&db.walQueued))
+		tasks.AsyncCommit.QueueCapacity = db.walChanSize
+	}
+	return tasks
+}
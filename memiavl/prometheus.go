@@ -0,0 +1,248 @@
+package memiavl
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// prometheusCollector exposes the internals of the db independent of the telemetry of the host application, the
+// values are read on each scrape without taking the db lock.
+type prometheusCollector struct {
+	db *DB
+
+	commits       *prometheus.Desc
+	version       *prometheus.Desc
+	walBytes      *prometheus.Desc
+	walLag        *prometheus.Desc
+	walLagAlert   *prometheus.Desc
+	snapshotBytes *prometheus.Desc
+	diskFreeBytes *prometheus.Desc
+	snapshots     *prometheus.Desc
+	rewriting     *prometheus.Desc
+	cacheHits     *prometheus.Desc
+	cacheMisses   *prometheus.Desc
+	cacheEvicts   *prometheus.Desc
+	mmapBytes     *prometheus.Desc
+	gets          *prometheus.Desc
+	getSeconds    *prometheus.Desc
+	iterators     *prometheus.Desc
+	iterSeconds   *prometheus.Desc
+}
+
+// the phase label values of the commit histogram, the telemetry metrics of the phases are named "commit_<phase>"
+const (
+	commitPhaseApply       = "apply"
+	commitPhaseWaitDurable = "wait_durable"
+	commitPhaseHash        = "hash"
+	commitPhaseMarshal     = "wal_marshal"
+	commitPhaseWALWrite    = "wal_write"
+)
+
+// commitHistograms are the latency distributions of the commits, observed by the db and exported by the collectors,
+// so the alerts could target the specific phase that regresses. The methods are no-op on a nil receiver.
+type commitHistograms struct {
+	total      prometheus.Histogram
+	phases     *prometheus.HistogramVec
+	storeApply *prometheus.HistogramVec
+}
+
+func newCommitHistograms() *commitHistograms {
+	// 100us to ~6.5s
+	buckets := prometheus.ExponentialBuckets(0.0001, 2, 17)
+	return &commitHistograms{
+		total: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "memiavl_commit_seconds",
+			Help:    "The durations of the commits, excluding the apply phase.",
+			Buckets: buckets,
+		}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memiavl_commit_phase_seconds",
+			Help:    "The durations of the commit phases, the wal_marshal and wal_write phases are observed in the background in async commit mode.",
+			Buckets: buckets,
+		}, []string{"phase"}),
+		storeApply: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memiavl_store_apply_seconds",
+			Help:    "The durations of applying the change sets of the store.",
+			Buckets: buckets,
+		}, []string{"store"}),
+	}
+}
+
+func (h *commitHistograms) observeCommit(d time.Duration) {
+	if h != nil {
+		h.total.Observe(d.Seconds())
+	}
+}
+
+func (h *commitHistograms) observePhase(phase string, d time.Duration) {
+	if h != nil {
+		h.phases.WithLabelValues(phase).Observe(d.Seconds())
+	}
+}
+
+// measurePhase records the duration of the commit phase since start, in the telemetry metrics and the phase
+// histogram, and returns it for the slow commit tracking.
+func (db *DB) measurePhase(phase string, start time.Time) time.Duration {
+	db.metrics.MeasureSince(start, "store", "memiavl", "commit_"+phase)
+	elapsed := time.Since(start)
+	db.histograms.observePhase(phase, elapsed)
+	return elapsed
+}
+
+func (h *commitHistograms) observeStoreApply(name string, d time.Duration) {
+	if h != nil {
+		h.storeApply.WithLabelValues(name).Observe(d.Seconds())
+	}
+}
+
+// NewPrometheusCollector creates the collector of the db to register into an existing registry. The cache counters
+// of a store restart from zero after each snapshot switch, which is handled by `rate` as a counter reset.
+func NewPrometheusCollector(db *DB) prometheus.Collector {
+	return &prometheusCollector{
+		db: db,
+		commits: prometheus.NewDesc("memiavl_commits_total",
+			"The number of versions committed since the db is loaded.", nil, nil),
+		version: prometheus.NewDesc("memiavl_version",
+			"The latest committed version.", nil, nil),
+		walBytes: prometheus.NewDesc("memiavl_wal_bytes",
+			"The total size of the WAL segment files.", nil, nil),
+		walLag: prometheus.NewDesc("memiavl_wal_durability_lag",
+			"The number of committed versions not persisted yet in async commit mode, lost on a crash.", nil, nil),
+		walLagAlert: prometheus.NewDesc("memiavl_wal_durability_lag_threshold",
+			"The alert threshold of the WAL durability lag, only exported if it's set.", nil, nil),
+		snapshotBytes: prometheus.NewDesc("memiavl_snapshot_bytes",
+			"The total size of the snapshots on disk.", nil, nil),
+		diskFreeBytes: prometheus.NewDesc("memiavl_disk_free_bytes",
+			"The bytes available on the file system of the db.", nil, nil),
+		snapshots: prometheus.NewDesc("memiavl_snapshots",
+			"The number of snapshots on disk.", nil, nil),
+		rewriting: prometheus.NewDesc("memiavl_snapshot_rewrite_in_progress",
+			"1 if a background snapshot rewrite is in progress.", nil, nil),
+		cacheHits: prometheus.NewDesc("memiavl_cache_hits_total",
+			"The cache hits of the store, updated on commit.", []string{"store"}, nil),
+		cacheMisses: prometheus.NewDesc("memiavl_cache_misses_total",
+			"The cache misses of the store, updated on commit.", []string{"store"}, nil),
+		cacheEvicts: prometheus.NewDesc("memiavl_cache_evictions_total",
+			"The entries evicted from the cache of the store.", []string{"store"}, nil),
+		mmapBytes: prometheus.NewDesc("memiavl_mmap_bytes",
+			"The total size of the mmap-ed snapshot files of the store.", []string{"store"}, nil),
+		gets: prometheus.NewDesc("memiavl_store_gets_total",
+			"The gets of the store, only exported if the query metrics are enabled.", []string{"store"}, nil),
+		getSeconds: prometheus.NewDesc("memiavl_store_get_seconds_total",
+			"The total duration of the gets of the store.", []string{"store"}, nil),
+		iterators: prometheus.NewDesc("memiavl_store_iterators_total",
+			"The closed iterators of the store, only exported if the query metrics are enabled.", []string{"store"}, nil),
+		iterSeconds: prometheus.NewDesc("memiavl_store_iterator_seconds_total",
+			"The total duration of the iterators of the store, from the creation until closed.", []string{"store"}, nil),
+	}
+}
+
+func (c *prometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.commits
+	ch <- c.version
+	ch <- c.walBytes
+	ch <- c.walLag
+	ch <- c.walLagAlert
+	ch <- c.snapshotBytes
+	ch <- c.diskFreeBytes
+	ch <- c.snapshots
+	ch <- c.rewriting
+	ch <- c.cacheHits
+	ch <- c.cacheMisses
+	ch <- c.cacheEvicts
+	ch <- c.mmapBytes
+	ch <- c.gets
+	ch <- c.getSeconds
+	ch <- c.iterators
+	ch <- c.iterSeconds
+	c.db.histograms.total.Describe(ch)
+	c.db.histograms.phases.Describe(ch)
+	c.db.histograms.storeApply.Describe(ch)
+}
+
+func (c *prometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	db := c.db
+	db.histograms.total.Collect(ch)
+	db.histograms.phases.Collect(ch)
+	db.histograms.storeApply.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(c.commits, prometheus.CounterValue, float64(db.commits.Load()))
+	ch <- prometheus.MustNewConstMetric(c.version, prometheus.GaugeValue, float64(db.Version()))
+	var rewriting float64
+	if db.rewriting.Load() {
+		rewriting = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.rewriting, prometheus.GaugeValue, rewriting)
+	if health := db.Health(); health.AsyncCommit.Running {
+		ch <- prometheus.MustNewConstMetric(c.walLag, prometheus.GaugeValue, float64(health.AsyncCommit.Lag))
+	}
+	if db.walLagThreshold > 0 {
+		ch <- prometheus.MustNewConstMetric(c.walLagAlert, prometheus.GaugeValue, float64(db.walLagThreshold))
+	}
+
+	// the files could be removed by the pruning concurrently, skip the metrics on errors
+	if usage, err := db.DiskUsage(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.walBytes, prometheus.GaugeValue, float64(usage.WALBytes))
+		ch <- prometheus.MustNewConstMetric(c.snapshotBytes, prometheus.GaugeValue, float64(usage.SnapshotBytes))
+		if usage.FreeBytes > 0 {
+			ch <- prometheus.MustNewConstMetric(c.diskFreeBytes, prometheus.GaugeValue, float64(usage.FreeBytes))
+		}
+	}
+	var snapshots int
+	if err := traverseSnapshots(db.dir, true, func(int64) (bool, error) {
+		snapshots++
+		return false, nil
+	}); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.snapshots, prometheus.GaugeValue, float64(snapshots))
+	}
+
+	view := db.AcquireView()
+	if view == nil {
+		return
+	}
+	defer view.Release()
+	for name, tree := range view.view.trees {
+		hits, misses := tree.cacheStatsTotal()
+		ch <- prometheus.MustNewConstMetric(c.cacheHits, prometheus.CounterValue, float64(hits), name)
+		ch <- prometheus.MustNewConstMetric(c.cacheMisses, prometheus.CounterValue, float64(misses), name)
+		if tree.cache != nil {
+			ch <- prometheus.MustNewConstMetric(c.cacheEvicts, prometheus.CounterValue, float64(tree.cache.Evictions()), name)
+		}
+		ch <- prometheus.MustNewConstMetric(c.mmapBytes, prometheus.GaugeValue, float64(tree.snapshot.mmapBytes()), name)
+		if stats := tree.queryStats; stats != nil {
+			totals := stats.totals()
+			ch <- prometheus.MustNewConstMetric(c.gets, prometheus.CounterValue, float64(totals.gets), name)
+			ch <- prometheus.MustNewConstMetric(c.getSeconds, prometheus.CounterValue, float64(totals.getNanos)/1e9, name)
+			ch <- prometheus.MustNewConstMetric(c.iterators, prometheus.CounterValue, float64(totals.iterators), name)
+			ch <- prometheus.MustNewConstMetric(c.iterSeconds, prometheus.CounterValue, float64(totals.iteratorNanos)/1e9, name)
+		}
+	}
+}
+
+// serveMetrics serves the metrics of the db at `/metrics` on the address, with a dedicated registry.
+func serveMetrics(addr string, db *DB) (*http.Server, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(NewPrometheusCollector(db)); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// listen synchronously, so the address conflicts fail the loading
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			db.logger.Error("metrics server stopped", "err", err)
+		}
+	}()
+	db.logger.Info("serving metrics", "address", ln.Addr().String())
+	return server, nil
+}
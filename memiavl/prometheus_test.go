@@ -0,0 +1,67 @@
+package memiavl
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, CacheSize: 100})
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+	// a miss then a hit on the new tree
+	db.TreeByName("test").Get([]byte("hello"))
+	db.TreeByName("test").Get([]byte("hello"))
+	_, err = db.Commit()
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(NewPrometheusCollector(db)))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	samples := make(map[string]uint64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch {
+			case metric.GetHistogram() != nil:
+				name := family.GetName()
+				for _, label := range metric.GetLabel() {
+					name += "/" + label.GetValue()
+				}
+				samples[name] = metric.GetHistogram().GetSampleCount()
+			case metric.GetCounter() != nil:
+				values[family.GetName()] = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	require.Equal(t, float64(4), values["memiavl_commits_total"])
+	require.Equal(t, float64(4), values["memiavl_version"])
+	require.Positive(t, values["memiavl_snapshots"])
+	require.Equal(t, float64(0), values["memiavl_snapshot_rewrite_in_progress"])
+	require.Equal(t, float64(1), values["memiavl_cache_hits_total"])
+	require.Equal(t, float64(1), values["memiavl_cache_misses_total"])
+	require.Positive(t, values["memiavl_wal_bytes"])
+	require.Positive(t, values["memiavl_mmap_bytes"])
+
+	require.Equal(t, uint64(4), samples["memiavl_commit_seconds"])
+	require.Equal(t, uint64(3), samples["memiavl_commit_phase_seconds/apply"])
+	require.Equal(t, uint64(4), samples["memiavl_commit_phase_seconds/hash"])
+	require.Equal(t, uint64(3), samples["memiavl_store_apply_seconds/test"])
+	// the wal is written in the background in async commit mode
+	require.Positive(t, samples["memiavl_commit_phase_seconds/wal_marshal"])
+	require.Positive(t, samples["memiavl_commit_phase_seconds/wal_write"])
+}
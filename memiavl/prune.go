@@ -0,0 +1,168 @@
+package memiavl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/tidwall/wal"
+)
+
+// PruneResult describes the snapshots and WAL entries removed by `PruneSnapshots`.
+type PruneResult struct {
+	// the versions of the removed snapshots, in ascending order
+	Snapshots     []int64
+	SnapshotBytes int64
+
+	// the range of versions removed from WAL, inclusive, both are zero if nothing removed
+	WALStart, WALEnd int64
+	// the size of the WAL segment files removed entirely, the partially truncated segment is not counted
+	WALBytes int64
+}
+
+// PruneSnapshots removes the snapshots older than the current one, except the `keepRecent` most recent ones,
+// and truncates the WAL until the earliest remaining snapshot, same as the automatic pruning of the db.
+// It must run offline, it only computes the result without removing anything if `dryRun` is true.
+func PruneSnapshots(dir string, keepRecent uint32, dryRun bool) (*PruneResult, error) {
+	if !dryRun {
+		fileLock, err := LockFile(filepath.Join(dir, LockFileName), LockExclusive)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = fileLock.Unlock()
+			_ = fileLock.Destroy()
+		}()
+	}
+
+	current, err := currentVersion(dir)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := readCurrentMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	initialVersion := uint32(metadata.InitialVersion)
+
+	result := &PruneResult{}
+	earliest := current
+	counter := keepRecent
+	if err := traverseSnapshots(dir, false, func(version int64) (bool, error) {
+		if version >= current {
+			return false, nil
+		}
+		if counter > 0 {
+			counter--
+			earliest = version
+			return false, nil
+		}
+
+		size, err := dirSize(filepath.Join(dir, snapshotName(version)))
+		if err != nil {
+			return true, err
+		}
+		result.Snapshots = append(result.Snapshots, version)
+		result.SnapshotBytes += size
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(result.Snapshots, func(i, j int) bool {
+		return result.Snapshots[i] < result.Snapshots[j]
+	})
+
+	// the entries before the earliest remaining snapshot are not needed
+	truncateIndex := walIndex(earliest+1, initialVersion)
+	segments, err := walSegments(walPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 && segments[0].index < truncateIndex {
+		result.WALStart = walVersion(segments[0].index, initialVersion)
+		result.WALEnd = earliest
+		for i := 0; i+1 < len(segments) && segments[i+1].index <= truncateIndex; i++ {
+			result.WALBytes += segments[i].size
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	for _, version := range result.Snapshots {
+		if err := atomicRemoveDir(filepath.Join(dir, snapshotName(version))); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.WALEnd > 0 {
+		log, err := OpenWAL(walPath(dir), &wal.Options{NoCopy: true})
+		if err != nil {
+			return nil, err
+		}
+		if err := log.TruncateFront(truncateIndex); err != nil {
+			return nil, errors.Join(err, log.Close())
+		}
+		if err := log.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+type walSegment struct {
+	// the index of the first entry
+	index uint64
+	size  int64
+}
+
+// walSegments lists the segment files of the WAL in ascending order, the file names are the first indexes.
+func walSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var segments []walSegment
+	for _, entry := range entries {
+		index, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil || entry.IsDir() {
+			// not a segment file
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, walSegment{index: index, size: info.Size()})
+	}
+	return segments, nil
+}
+
+// dirSize returns the total size of the files in the directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,56 @@
+package memiavl
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// StoreStats is the statistics of a store in a snapshot.
+type StoreStats struct {
+	Name string
+	// number of the leaf nodes
+	Keys int64
+	// total size of the snapshot files of the store
+	Bytes int64
+}
+
+// SnapshotStats collects the statistics of the stores in the snapshot of the version, sorted by name.
+func SnapshotStats(dir string, version int64) ([]StoreStats, error) {
+	snapshotDir := filepath.Join(dir, snapshotName(version))
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []StoreStats
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		storeDir := filepath.Join(snapshotDir, entry.Name())
+		snapshot, err := OpenSnapshot(storeDir)
+		if err != nil {
+			return nil, err
+		}
+		keys := snapshot.leavesLen()
+		if err := snapshot.Close(); err != nil {
+			return nil, err
+		}
+		size, err := dirSize(storeDir)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, StoreStats{Name: entry.Name(), Keys: int64(keys), Bytes: size})
+	}
+	return stats, nil
+}
+
+// SnapshotVersions returns the versions of the snapshots in ascending order.
+func SnapshotVersions(dir string) ([]int64, error) {
+	var versions []int64
+	err := traverseSnapshots(dir, true, func(version int64) (bool, error) {
+		versions = append(versions, version)
+		return false, nil
+	})
+	return versions, err
+}
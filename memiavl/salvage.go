@@ -0,0 +1,188 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tidwall/wal"
+)
+
+// DamagedSnapshot is a snapshot directory which can't be loaded.
+type DamagedSnapshot struct {
+	Name   string
+	Reason string
+}
+
+// SalvageResult describes the damages found and the repairs done by `Salvage`.
+type SalvageResult struct {
+	// the damaged snapshot directories, including the half-written temporary ones
+	Damaged []DamagedSnapshot
+	// the version of the newest valid snapshot, which `current` points to after the salvage
+	Current int64
+	// `current` don't point to the newest valid snapshot, and is repointed
+	Repointed bool
+	// the corrupted tail of the WAL is truncated
+	WALTailTruncated bool
+	// the WAL don't connect to the current snapshot, it's reset to start from the next version,
+	// the versions after the current snapshot are lost, and need to be replayed from the blocks.
+	WALReset bool
+}
+
+// Salvage removes the half-written or damaged snapshot directories, or moves them into the quarantine directory if
+// it's not empty, repoints `current` to the newest valid snapshot, and truncates the WAL to be consistent with it.
+// The node hashes are verified if `verifyHashes` is true, otherwise only the structure of the snapshot files is checked.
+// It must run offline, it only computes the result without changing anything if `dryRun` is true.
+func Salvage(dir, quarantineDir string, verifyHashes, dryRun bool) (*SalvageResult, error) {
+	if !dryRun {
+		fileLock, err := LockFile(filepath.Join(dir, LockFileName), LockExclusive)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = fileLock.Unlock()
+			_ = fileLock.Destroy()
+		}()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SalvageResult{Current: -1}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, TmpSuffix) {
+			result.Damaged = append(result.Damaged, DamagedSnapshot{Name: name, Reason: "half-written temporary directory"})
+			continue
+		}
+		if !isSnapshotName(name) {
+			continue
+		}
+		if err := checkSnapshot(filepath.Join(dir, name), verifyHashes); err != nil {
+			result.Damaged = append(result.Damaged, DamagedSnapshot{Name: name, Reason: err.Error()})
+			continue
+		}
+		// the entries are sorted by name, so the last valid one is the newest
+		if result.Current, err = parseVersion(name); err != nil {
+			return nil, err
+		}
+	}
+	if result.Current < 0 {
+		return nil, errors.New("no valid snapshot found")
+	}
+
+	metadata, err := readMetadata(filepath.Join(dir, snapshotName(result.Current)))
+	if err != nil {
+		return nil, err
+	}
+	initialVersion := uint32(metadata.InitialVersion)
+
+	if current, err := currentVersion(dir); err != nil || current != result.Current {
+		result.Repointed = true
+	}
+
+	log, err := openWALReader(dir)
+	if errors.Is(err, ErrWALTailCorrupt) {
+		result.WALTailTruncated = true
+		if dryRun {
+			// the entries range can't be checked without repairing the tail
+			log, err = nil, nil
+		} else {
+			log, err = OpenWAL(walPath(dir), &wal.Options{NoCopy: true})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if log != nil {
+		firstIndex, err := log.FirstIndex()
+		if err != nil {
+			return nil, errors.Join(err, log.Close())
+		}
+		lastIndex, err := log.LastIndex()
+		if err != nil {
+			return nil, errors.Join(err, log.Close())
+		}
+		if err := log.Close(); err != nil {
+			return nil, err
+		}
+
+		// the next entry to write must follow the existing entries, and the entries after the current snapshot
+		// must be continuous to be replayed.
+		nextIndex := walIndex(result.Current+1, initialVersion)
+		result.WALReset = nextIndex < firstIndex || nextIndex > lastIndex+1
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	for _, damaged := range result.Damaged {
+		path := filepath.Join(dir, damaged.Name)
+		if len(quarantineDir) > 0 {
+			if err := os.MkdirAll(quarantineDir, os.ModePerm); err != nil {
+				return nil, err
+			}
+			err = os.Rename(path, filepath.Join(quarantineDir, damaged.Name))
+		} else if strings.HasSuffix(damaged.Name, TmpSuffix) {
+			err = os.RemoveAll(path)
+		} else {
+			err = atomicRemoveDir(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the leftovers of a interrupted pointer update
+	for _, tmpPath := range []string{currentTmpPath(dir), currentFileTmpPath(dir)} {
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if result.Repointed {
+		if err := updateCurrent(dir, snapshotName(result.Current)); err != nil {
+			return nil, err
+		}
+	}
+	if result.WALReset {
+		if err := resetWAL(dir, result.Current, initialVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// checkSnapshot checks the snapshot files can be loaded and the version matches the directory name,
+// optionally verifies the node hashes.
+func checkSnapshot(snapshotDir string, verifyHashes bool) error {
+	version, err := parseVersion(filepath.Base(snapshotDir))
+	if err != nil {
+		return err
+	}
+
+	mtree, err := LoadMultiTree(snapshotDir, false, 0)
+	if err != nil {
+		return err
+	}
+	loaded := mtree.Version()
+	if err := mtree.Close(); err != nil {
+		return err
+	}
+	if loaded != version {
+		return fmt.Errorf("version mismatch, expect %d, got %d", version, loaded)
+	}
+
+	if verifyHashes {
+		return VerifySnapshot(snapshotDir)
+	}
+	return nil
+}
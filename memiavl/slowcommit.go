@@ -0,0 +1,93 @@
+package memiavl
+
+import "time"
+
+// SlowCommit describes a commit exceeding the slow commit thresholds, along with the store which had the largest change
+// set, so the slow blocks could be correlated with the data.
+type SlowCommit struct {
+	Version int64
+	// the duration of the commit, excluding the apply phase which happens before it
+	Duration time.Duration
+	// the durations of the phases, the apply phase sums up the change sets applied since the last commit, the WAL write
+	// phase is only measured in sync commit mode
+	Apply, WaitDurable, Hash, WALWrite time.Duration
+
+	// the store with the largest change set in bytes of the keys and values
+	LargestStore      string
+	LargestStoreBytes int
+	LargestStorePairs int
+}
+
+// SlowCommitHandler is called with the slow commits, after the commit hooks, without holding the db lock.
+type SlowCommitHandler func(commit SlowCommit)
+
+type slowCommitConfig struct {
+	threshold, phaseThreshold time.Duration
+	handler                   SlowCommitHandler
+}
+
+// commitPhases is the durations of the phases of the pending commit.
+type commitPhases struct {
+	apply, waitDurable, hash, walWrite time.Duration
+}
+
+func (p commitPhases) max() time.Duration {
+	return max(p.apply, p.waitDurable, p.hash, p.walWrite)
+}
+
+// checkSlowCommit logs the commit if it exceeds the thresholds, and returns the hook to call the handler, nil if
+// the commit is not slow or the handler is not set.
+func (db *DB) checkSlowCommit(version int64, duration time.Duration, changeSets []*NamedChangeSet) CommitHook {
+	phases := db.phases
+	db.phases = commitPhases{}
+
+	cfg := db.slowCommit
+	if (cfg.threshold <= 0 || duration <= cfg.threshold) &&
+		(cfg.phaseThreshold <= 0 || phases.max() <= cfg.phaseThreshold) {
+		return nil
+	}
+
+	slow := SlowCommit{
+		Version:     version,
+		Duration:    duration,
+		Apply:       phases.apply,
+		WaitDurable: phases.waitDurable,
+		Hash:        phases.hash,
+		WALWrite:    phases.walWrite,
+	}
+	for _, cs := range changeSets {
+		var size int
+		for _, pair := range cs.Changeset.Pairs {
+			size += len(pair.Key) + len(pair.Value)
+		}
+		if size > slow.LargestStoreBytes || slow.LargestStore == "" {
+			slow.LargestStore = cs.Name
+			slow.LargestStoreBytes = size
+			slow.LargestStorePairs = len(cs.Changeset.Pairs)
+		}
+	}
+
+	warn(db.commitLogger, "slow commit", "version", version, "duration", duration, "apply", phases.apply,
+		"waitDurable", phases.waitDurable, "hash", phases.hash, "walWrite", phases.walWrite,
+		"largestStore", slow.LargestStore, "largestStoreBytes", slow.LargestStoreBytes,
+		"largestStorePairs", slow.LargestStorePairs)
+	db.metrics.IncrCounter(1, "store", "memiavl", "slow_commit")
+
+	if cfg.handler == nil {
+		return nil
+	}
+	return func(int64, []*NamedChangeSet, *CommitInfo) {
+		cfg.handler(slow)
+	}
+}
+
+// warn logs at the warning level if the logger supports it, like the sdk logger, otherwise at the info level.
+func warn(logger Logger, msg string, keyvals ...interface{}) {
+	if l, ok := logger.(interface {
+		Warn(msg string, keyvals ...interface{})
+	}); ok {
+		l.Warn(msg, keyvals...)
+		return
+	}
+	logger.Info(msg, keyvals...)
+}
@@ -2,47 +2,87 @@ package memiavl
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 const (
 	// SnapshotFileMagic is little endian encoded b"IAVL"
 	SnapshotFileMagic = 1280721225
 
-	// SnapshotFormat the initial snapshot format
+	// SnapshotFormat the initial snapshot format, the other formats are bit flags which could be combined.
 	SnapshotFormat = 0
+	// SnapshotFormatCommitmentOnly the leaves store the sha256 hash of the value instead of the value itself, the raw
+	// values are kept in an external storage, like versiondb.
+	SnapshotFormatCommitmentOnly = 1
+	// SnapshotFormatBlobs the values larger than the blob threshold are stored in the blobs file, deduplicated by the
+	// hashes, the kvs file stores the references to them, so the kvs file stays dense.
+	SnapshotFormatBlobs = 2
+
+	// blobRefFlag is set in the value length of the kvs file if the value is stored in the blobs file, the lower bits
+	// are the length of the blob, followed by the uint64 offset of it in the blobs file instead of the value.
+	blobRefFlag = 1 << 31
+	// sizeBlobRef is the size of the blob reference in the kvs file
+	sizeBlobRef = 8
+	// SnapshotFormatPrefixKeys the keys in the kvs file are prefix compressed, each key is stored as the length of
+	// the prefix shared with the previous key and the rest of it, except the restart points, which store the whole
+	// keys every `prefixRestartInterval` leaves, so a key is decoded from at most that many leaves.
+	SnapshotFormatPrefixKeys = 4
+	prefixRestartInterval    = 16
+
+	knownSnapshotFormats = SnapshotFormatCommitmentOnly | SnapshotFormatBlobs | SnapshotFormatPrefixKeys
 
 	// SizeMetadata magic: uint32, format: uint32, version: uint32
 	SizeMetadata = 12
+	// SizeLayout byte order mark: uint32, format: uint32, the layout file is written alongside the metadata file, which
+	// is kept as is for the older versions, they ignore the extra file.
+	SizeLayout = 8
+	// layoutByteOrderMark is written in the byte order of the integers in the snapshot files, which is always little
+	// endian.
+	layoutByteOrderMark = 0x01020304
 
 	FileNameNodes    = "nodes"
 	FileNameLeaves   = "leaves"
 	FileNameKVs      = "kvs"
+	FileNameBlobs    = "blobs"
 	FileNameMetadata = "metadata"
+	FileNameLayout   = "layout"
 
 	// CancelCheckInterval check for cancel every 1000 leaves
 	CancelCheckInterval = 1000
 )
 
 // Snapshot manage the lifecycle of mmap-ed files for the snapshot,
-// it must out live the objects that derived from it.
+// it must out live the objects that derived from it, unless they pin it, see `PinnedValue`.
 type Snapshot struct {
+	// the owner reference plus the pins, the files are unmapped when it drops to zero
+	refs int32
+
 	nodesMap  *MmapFile
 	leavesMap *MmapFile
 	kvsMap    *MmapFile
+	// nil if the snapshot is not in the blobs format
+	blobsMap *MmapFile
 
 	nodes  []byte
 	leaves []byte
 	kvs    []byte
+	blobs  []byte
 
 	// parsed from metadata file
-	version uint32
+	version        uint32
+	commitmentOnly bool
+	prefixKeys     bool
 
 	// wrapping the raw nodes buffer
 	nodesLayout  Nodes
@@ -54,6 +94,7 @@ type Snapshot struct {
 
 func NewEmptySnapshot(version uint32) *Snapshot {
 	return &Snapshot{
+		refs:    1,
 		version: version,
 	}
 }
@@ -72,15 +113,21 @@ func OpenSnapshot(snapshotDir string) (snapshot *Snapshot, err error) {
 
 	magic := binary.LittleEndian.Uint32(bz)
 	if magic != SnapshotFileMagic {
+		if binary.BigEndian.Uint32(bz) == SnapshotFileMagic {
+			return nil, errors.New("snapshot is written in big endian byte order, which is not supported")
+		}
 		return nil, fmt.Errorf("invalid metadata file magic: %d", magic)
 	}
 	format := binary.LittleEndian.Uint32(bz[4:])
-	if format != SnapshotFormat {
+	if format&^knownSnapshotFormats != 0 {
 		return nil, fmt.Errorf("unknown snapshot format: %d", format)
 	}
 	version := binary.LittleEndian.Uint32(bz[8:])
+	if err := checkLayout(snapshotDir, format); err != nil {
+		return nil, err
+	}
 
-	var nodesMap, leavesMap, kvsMap *MmapFile
+	var nodesMap, leavesMap, kvsMap, blobsMap *MmapFile
 	defer func() {
 		if err != nil {
 			errs := []error{err}
@@ -93,6 +140,9 @@ func OpenSnapshot(snapshotDir string) (snapshot *Snapshot, err error) {
 			if kvsMap != nil {
 				errs = append(errs, kvsMap.Close())
 			}
+			if blobsMap != nil {
+				errs = append(errs, blobsMap.Close())
+			}
 			err = errors.Join(errs...)
 		}
 	}()
@@ -106,6 +156,13 @@ func OpenSnapshot(snapshotDir string) (snapshot *Snapshot, err error) {
 	if kvsMap, err = NewMmap(filepath.Join(snapshotDir, FileNameKVs)); err != nil {
 		return nil, err
 	}
+	var blobs []byte
+	if format&SnapshotFormatBlobs != 0 {
+		if blobsMap, err = NewMmap(filepath.Join(snapshotDir, FileNameBlobs)); err != nil {
+			return nil, err
+		}
+		blobs = blobsMap.Data()
+	}
 
 	nodes := nodesMap.Data()
 	leaves := leavesMap.Data()
@@ -136,16 +193,21 @@ func OpenSnapshot(snapshotDir string) (snapshot *Snapshot, err error) {
 	}
 
 	snapshot = &Snapshot{
+		refs:      1,
 		nodesMap:  nodesMap,
 		leavesMap: leavesMap,
 		kvsMap:    kvsMap,
+		blobsMap:  blobsMap,
 
 		// cache the pointers
 		nodes:  nodes,
 		leaves: leaves,
 		kvs:    kvs,
+		blobs:  blobs,
 
-		version: version,
+		version:        version,
+		commitmentOnly: format&SnapshotFormatCommitmentOnly != 0,
+		prefixKeys:     format&SnapshotFormatPrefixKeys != 0,
 
 		nodesLayout:  nodesData,
 		leavesLayout: leavesData,
@@ -168,8 +230,59 @@ func OpenSnapshot(snapshotDir string) (snapshot *Snapshot, err error) {
 	return snapshot, nil
 }
 
-// Close closes the file and mmap handles, clears the buffers.
+// checkLayout validates the layout file against the format in the metadata, the snapshots written before the layout
+// file don't have it, they are little endian too.
+func checkLayout(snapshotDir string, format uint32) error {
+	bz, err := os.ReadFile(filepath.Join(snapshotDir, FileNameLayout))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(bz) != SizeLayout {
+		return fmt.Errorf("wrong layout file size, expected: %d, found: %d", SizeLayout, len(bz))
+	}
+	if mark := binary.LittleEndian.Uint32(bz); mark != layoutByteOrderMark {
+		if binary.BigEndian.Uint32(bz) == layoutByteOrderMark {
+			return errors.New("snapshot is written in big endian byte order, which is not supported")
+		}
+		return fmt.Errorf("invalid layout file byte order mark: %d", mark)
+	}
+	if layoutFormat := binary.LittleEndian.Uint32(bz[4:]); layoutFormat != format {
+		return fmt.Errorf("snapshot format mismatch, metadata: %d, layout: %d", format, layoutFormat)
+	}
+	return nil
+}
+
+// Close drops the owner reference, the file and mmap handles are closed and the buffers are cleared after the pins
+// are released too.
 func (snapshot *Snapshot) Close() error {
+	return snapshot.unpin()
+}
+
+// pin keeps the files mapped until unpin, it fails if the snapshot is released already.
+func (snapshot *Snapshot) pin() bool {
+	for {
+		refs := atomic.LoadInt32(&snapshot.refs)
+		if refs <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&snapshot.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (snapshot *Snapshot) unpin() error {
+	if atomic.AddInt32(&snapshot.refs, -1) != 0 {
+		return nil
+	}
+	return snapshot.release()
+}
+
+// release closes the file and mmap handles, clears the buffers.
+func (snapshot *Snapshot) release() error {
 	var errs []error
 
 	if snapshot.nodesMap != nil {
@@ -181,9 +294,15 @@ func (snapshot *Snapshot) Close() error {
 	if snapshot.kvsMap != nil {
 		errs = append(errs, snapshot.kvsMap.Close())
 	}
+	if snapshot.blobsMap != nil {
+		errs = append(errs, snapshot.blobsMap.Close())
+	}
 
-	// reset to an empty tree
-	*snapshot = *NewEmptySnapshot(snapshot.version)
+	// reset to an empty tree, field by field to leave `refs` at zero, so it can't be pinned again
+	snapshot.nodesMap, snapshot.leavesMap, snapshot.kvsMap, snapshot.blobsMap = nil, nil, nil, nil
+	snapshot.nodes, snapshot.leaves, snapshot.kvs, snapshot.blobs = nil, nil, nil, nil
+	snapshot.nodesLayout, snapshot.leavesLayout = Nodes{}, Leaves{}
+	snapshot.root = nil
 	return errors.Join(errs...)
 }
 
@@ -192,6 +311,14 @@ func (snapshot *Snapshot) IsEmpty() bool {
 	return snapshot.root == nil
 }
 
+// mmapBytes returns the total size of the mmap-ed files, 0 if the snapshot is nil.
+func (snapshot *Snapshot) mmapBytes() int {
+	if snapshot == nil {
+		return 0
+	}
+	return len(snapshot.nodes) + len(snapshot.leaves) + len(snapshot.kvs) + len(snapshot.blobs)
+}
+
 // Node returns the branch node by index
 func (snapshot *Snapshot) Node(index uint32) PersistedNode {
 	return PersistedNode{
@@ -215,6 +342,46 @@ func (snapshot *Snapshot) Version() uint32 {
 	return snapshot.version
 }
 
+// Advise sets the expected access patterns of the mmap-ed files, the nodes advice applies to both the branch and leaf
+// nodes files, the kvs advice applies to the key-value pairs file.
+func (snapshot *Snapshot) Advise(nodes, kvs MmapAdvice) error {
+	if snapshot.IsEmpty() {
+		return nil
+	}
+	errs := []error{
+		snapshot.nodesMap.Advise(nodes),
+		snapshot.leavesMap.Advise(nodes),
+		snapshot.kvsMap.Advise(kvs),
+	}
+	if snapshot.blobsMap != nil {
+		errs = append(errs, snapshot.blobsMap.Advise(kvs))
+	}
+	return errors.Join(errs...)
+}
+
+// AdviseHugePageNodes asks the kernel to back the branch and leaf nodes files with transparent huge pages, which
+// reduces the TLB misses of the random traversals on a large state.
+func (snapshot *Snapshot) AdviseHugePageNodes() error {
+	if snapshot.IsEmpty() {
+		return nil
+	}
+	return errors.Join(snapshot.nodesMap.AdviseHugePage(), snapshot.leavesMap.AdviseHugePage())
+}
+
+// PrefaultNodes loads the branch and leaf nodes files into the page cache.
+func (snapshot *Snapshot) PrefaultNodes() {
+	if snapshot.IsEmpty() {
+		return
+	}
+	snapshot.nodesMap.Prefault()
+	snapshot.leavesMap.Prefault()
+}
+
+// CommitmentOnly returns if the leaves store the value hashes instead of the values.
+func (snapshot *Snapshot) CommitmentOnly() bool {
+	return snapshot.commitmentOnly
+}
+
 // RootNode returns the root node
 func (snapshot *Snapshot) RootNode() PersistedNode {
 	if snapshot.IsEmpty() {
@@ -255,40 +422,106 @@ func (snapshot *Snapshot) ScanNodes(callback func(node PersistedNode) error) err
 	return nil
 }
 
-// Key returns a zero-copy slice of key by offset
+// Key returns a zero-copy slice of key by offset, it's not supported if the keys are prefix compressed.
 func (snapshot *Snapshot) Key(offset uint64) []byte {
 	keyLen := binary.LittleEndian.Uint32(snapshot.kvs[offset:])
 	offset += 4
 	return snapshot.kvs[offset : offset+uint64(keyLen)]
 }
 
-// KeyValue returns a zero-copy slice of key/value pair by offset
+// KeyValue returns a zero-copy slice of key/value pair by offset, it's not supported if the keys are prefix
+// compressed.
 func (snapshot *Snapshot) KeyValue(offset uint64) ([]byte, []byte) {
 	length := uint64(binary.LittleEndian.Uint32(snapshot.kvs[offset:]))
 	offset += 4
 	key := snapshot.kvs[offset : offset+length]
 	offset += length
-	length = uint64(binary.LittleEndian.Uint32(snapshot.kvs[offset:]))
+	return key, snapshot.value(offset)
+}
+
+// value returns a zero-copy slice of the value by the offset of its length in the kvs file, it's resolved from the
+// blobs file if it's a blob reference.
+func (snapshot *Snapshot) value(offset uint64) []byte {
+	length := binary.LittleEndian.Uint32(snapshot.kvs[offset:])
 	offset += 4
-	value := snapshot.kvs[offset : offset+length]
-	return key, value
+	if length&blobRefFlag != 0 {
+		blobOffset := binary.LittleEndian.Uint64(snapshot.kvs[offset:])
+		return snapshot.blobs[blobOffset : blobOffset+uint64(length&^blobRefFlag)]
+	}
+	return snapshot.kvs[offset : offset+uint64(length)]
 }
 
+// LeafKey returns the key of the leaf, it's zero-copy unless the keys are prefix compressed.
 func (snapshot *Snapshot) LeafKey(index uint32) []byte {
+	return snapshot.leafKeyTo(nil, index)
+}
+
+// leafKeyTo is the same as LeafKey, except that the prefix compressed key is decoded into buf if it's large enough.
+func (snapshot *Snapshot) leafKeyTo(buf []byte, index uint32) []byte {
 	leaf := snapshot.leavesLayout.Leaf(index)
-	offset := leaf.KeyOffset() + 4
-	return snapshot.kvs[offset : offset+uint64(leaf.KeyLength())]
+	if !snapshot.prefixKeys {
+		offset := leaf.KeyOffset() + 4
+		return snapshot.kvs[offset : offset+uint64(leaf.KeyLength())]
+	}
+
+	n := int(leaf.KeyLength())
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	} else {
+		buf = buf[:n]
+	}
+	// fill the key backward, each leaf provides the bytes after the prefix shared with the previous key, until the
+	// whole prefix is filled, at the latest at the restart point.
+	need := n
+	for {
+		offset := leaf.KeyOffset()
+		shared := int(binary.LittleEndian.Uint32(snapshot.kvs[offset:]))
+		suffixLen := uint64(binary.LittleEndian.Uint32(snapshot.kvs[offset+4:]))
+		if shared < need {
+			copy(buf[shared:need], snapshot.kvs[offset+8:offset+8+suffixLen])
+			need = shared
+		}
+		if need == 0 {
+			return buf
+		}
+		index--
+		leaf = snapshot.leavesLayout.Leaf(index)
+	}
 }
 
-func (snapshot *Snapshot) LeafKeyValue(index uint32) ([]byte, []byte) {
+// leafValue returns a zero-copy slice of the value of the leaf.
+func (snapshot *Snapshot) leafValue(index uint32) []byte {
 	leaf := snapshot.leavesLayout.Leaf(index)
-	offset := leaf.KeyOffset() + 4
-	length := uint64(leaf.KeyLength())
-	key := snapshot.kvs[offset : offset+length]
-	offset += length
-	length = uint64(binary.LittleEndian.Uint32(snapshot.kvs[offset:]))
-	offset += 4
-	return key, snapshot.kvs[offset : offset+length]
+	offset := leaf.KeyOffset()
+	if snapshot.prefixKeys {
+		offset += 4
+	}
+	offset += 4 + uint64(binary.LittleEndian.Uint32(snapshot.kvs[offset:]))
+	return snapshot.value(offset)
+}
+
+func (snapshot *Snapshot) LeafKeyValue(index uint32) ([]byte, []byte) {
+	return snapshot.LeafKey(index), snapshot.leafValue(index)
+}
+
+// ScanLeaves iterates over the key-value pairs with the prefix in ascending order of keys, the slices are zero-copy
+// unless the keys are prefix compressed, they are only valid before the snapshot is closed.
+func (snapshot *Snapshot) ScanLeaves(prefix []byte, callback func(key, value []byte) error) error {
+	n := snapshot.leavesLen()
+	// the leaves are sorted by key, seek to the first key with the prefix.
+	start := sort.Search(n, func(i int) bool {
+		return bytes.Compare(snapshot.LeafKey(uint32(i)), prefix) >= 0
+	})
+	for i := start; i < n; i++ {
+		key, value := snapshot.LeafKeyValue(uint32(i))
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		if err := callback(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Export exports the nodes from snapshot file sequentially, more efficient than a post-order traversal.
@@ -320,7 +553,7 @@ func (snapshot *Snapshot) export(callback func(*ExportNode) bool) {
 		for pendingTrees < int(node.PreTrees())+2 {
 			// add more leaf nodes
 			leaf := snapshot.leavesLayout.Leaf(j)
-			key, value := snapshot.KeyValue(leaf.KeyOffset())
+			key, value := snapshot.LeafKeyValue(j)
 			enode := &ExportNode{
 				Height:  0,
 				Version: int64(leaf.Version()),
@@ -353,7 +586,12 @@ func (t *Tree) WriteSnapshot(snapshotDir string) error {
 
 // WriteSnapshotWithContext save the IAVL tree to a new snapshot directory.
 func (t *Tree) WriteSnapshotWithContext(ctx context.Context, snapshotDir string) error {
-	return writeSnapshot(ctx, snapshotDir, t.version, func(w *snapshotWriter) (uint32, error) {
+	return t.writeSnapshot(ctx, snapshotDir, snapshotWriteOptions{})
+}
+
+// writeSnapshot save the IAVL tree to a new snapshot directory.
+func (t *Tree) writeSnapshot(ctx context.Context, snapshotDir string, opts snapshotWriteOptions) error {
+	return writeSnapshot(ctx, snapshotDir, t.version, opts, t.estimateSnapshotSizes(), func(w *snapshotWriter) (uint32, error) {
 		if t.root == nil {
 			return 0, nil
 		} else {
@@ -365,9 +603,49 @@ func (t *Tree) WriteSnapshotWithContext(ctx context.Context, snapshotDir string)
 	})
 }
 
+// estimateSnapshotSizes estimates the file sizes of the snapshot of the tree, the nodes and leaves sizes are exact,
+// the kvs size is scaled from the current snapshot, unknown if the tree is not backed by one.
+func (t *Tree) estimateSnapshotSizes() snapshotFileSizes {
+	if t.root == nil {
+		return snapshotFileSizes{}
+	}
+	leaves := t.root.Size()
+	sizes := snapshotFileSizes{
+		nodes:  (leaves - 1) * SizeNode,
+		leaves: leaves * SizeLeaf,
+	}
+	if t.snapshot != nil && t.snapshot.leavesLen() > 0 {
+		sizes.kvs = int64(len(t.snapshot.kvs)) * leaves / int64(t.snapshot.leavesLen())
+	}
+	return sizes
+}
+
+// snapshotWriteOptions tunes the writing of the snapshot files.
+type snapshotWriteOptions struct {
+	// the leaves store the value hashes instead of the values
+	commitmentOnly bool
+	// the buffer size of each file, the bufio default if zero
+	bufferSize int
+	// preallocate the files to the estimated sizes before writing
+	preallocate bool
+	// the values larger than it are stored in the blobs file, 0 means disabled, ignored in commitment-only mode
+	blobThreshold int
+	// prefix compress the keys in the kvs file
+	prefixKeys bool
+	// bounds the number of trees written in parallel, nil means bounded by the pool only
+	limiter *writerLimiter
+	// tracks the leaves written, nil if not tracked
+	progress *rewriteProgress
+}
+
+// snapshotFileSizes is the estimated sizes of the snapshot files, zero means unknown.
+type snapshotFileSizes struct {
+	nodes, leaves, kvs int64
+}
+
 func writeSnapshot(
 	ctx context.Context,
-	dir string, version uint32,
+	dir string, version uint32, opts snapshotWriteOptions, sizes snapshotFileSizes,
 	doWrite func(*snapshotWriter) (uint32, error),
 ) (returnErr error) {
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -408,15 +686,53 @@ func writeSnapshot(
 		}
 	}()
 
-	nodesWriter := bufio.NewWriter(fpNodes)
-	leavesWriter := bufio.NewWriter(fpLeaves)
-	kvsWriter := bufio.NewWriter(fpKVs)
+	var fpBlobs *os.File
+	if opts.blobThreshold > 0 && !opts.commitmentOnly {
+		fpBlobs, err = createFile(filepath.Join(dir, FileNameBlobs))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := fpBlobs.Close(); returnErr == nil {
+				returnErr = err
+			}
+		}()
+	}
+
+	if opts.preallocate {
+		for _, f := range []struct {
+			fp   *os.File
+			size int64
+		}{{fpNodes, sizes.nodes}, {fpLeaves, sizes.leaves}, {fpKVs, sizes.kvs}} {
+			if f.size <= 0 {
+				continue
+			}
+			if err := fallocate(f.fp, f.size); err != nil {
+				return fmt.Errorf("fail to preallocate %s: %w", f.fp.Name(), err)
+			}
+		}
+	}
+
+	nodesWriter := newBufferedWriter(fpNodes, opts.bufferSize)
+	leavesWriter := newBufferedWriter(fpLeaves, opts.bufferSize)
+	kvsWriter := newBufferedWriter(fpKVs, opts.bufferSize)
 
 	w := newSnapshotWriter(ctx, nodesWriter, leavesWriter, kvsWriter)
+	w.commitmentOnly = opts.commitmentOnly
+	w.prefixKeys = opts.prefixKeys
+	w.progress = opts.progress
+	var blobsWriter *bufio.Writer
+	if fpBlobs != nil {
+		blobsWriter = newBufferedWriter(fpBlobs, opts.bufferSize)
+		w.blobWriter = blobsWriter
+		w.blobThreshold = opts.blobThreshold
+		w.blobOffsets = make(map[[sha256.Size]byte]uint64)
+	}
 	leaves, err := doWrite(w)
 	if err != nil {
 		return err
 	}
+	w.progress.addLeaves(uint64(leaves % CancelCheckInterval))
 
 	if leaves > 0 {
 		if err := nodesWriter.Flush(); err != nil {
@@ -428,22 +744,36 @@ func writeSnapshot(
 		if err := kvsWriter.Flush(); err != nil {
 			return err
 		}
-
-		if err := fpKVs.Sync(); err != nil {
-			return err
-		}
-		if err := fpLeaves.Sync(); err != nil {
-			return err
+		if blobsWriter != nil {
+			if err := blobsWriter.Flush(); err != nil {
+				return err
+			}
 		}
-		if err := fpNodes.Sync(); err != nil {
-			return err
+	}
+
+	if opts.preallocate {
+		// the estimations could be larger than the actual sizes, the files are mmap-ed with their sizes later.
+		for _, fp := range []*os.File{fpNodes, fpLeaves, fpKVs} {
+			if err := truncateToOffset(fp); err != nil {
+				return err
+			}
 		}
 	}
 
 	// write metadata
 	var metadataBuf [SizeMetadata]byte
 	binary.LittleEndian.PutUint32(metadataBuf[:], SnapshotFileMagic)
-	binary.LittleEndian.PutUint32(metadataBuf[4:], SnapshotFormat)
+	format := uint32(SnapshotFormat)
+	if opts.commitmentOnly {
+		format |= SnapshotFormatCommitmentOnly
+	}
+	if fpBlobs != nil {
+		format |= SnapshotFormatBlobs
+	}
+	if opts.prefixKeys {
+		format |= SnapshotFormatPrefixKeys
+	}
+	binary.LittleEndian.PutUint32(metadataBuf[4:], format)
 	binary.LittleEndian.PutUint32(metadataBuf[8:], version)
 
 	metadataFile := filepath.Join(dir, FileNameMetadata)
@@ -461,7 +791,29 @@ func writeSnapshot(
 		return err
 	}
 
-	return fpMetadata.Sync()
+	var layoutBuf [SizeLayout]byte
+	binary.LittleEndian.PutUint32(layoutBuf[:], layoutByteOrderMark)
+	binary.LittleEndian.PutUint32(layoutBuf[4:], format)
+	fpLayout, err := createFile(filepath.Join(dir, FileNameLayout))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := fpLayout.Close(); returnErr == nil {
+			returnErr = err
+		}
+	}()
+	if _, err := fpLayout.Write(layoutBuf[:]); err != nil {
+		return err
+	}
+
+	// the directory is only published by the renaming after it's written completely, so the files are synced in one
+	// batch at the end, followed by the directory itself to persist the new entries.
+	files := []*os.File{fpNodes, fpLeaves, fpKVs, fpMetadata, fpLayout}
+	if fpBlobs != nil {
+		files = append(files, fpBlobs)
+	}
+	return syncFiles(dir, files...)
 }
 
 type snapshotWriter struct {
@@ -475,6 +827,22 @@ type snapshotWriter struct {
 
 	// record the current writing offset in kvs file
 	kvsOffset uint64
+
+	// write the value hashes instead of the values
+	commitmentOnly bool
+
+	// the values larger than the threshold are written into the blobs file, deduplicated by the hashes, nil if
+	// disabled
+	blobWriter    io.Writer
+	blobThreshold int
+	blobsOffset   uint64
+	blobOffsets   map[[sha256.Size]byte]uint64
+
+	// prefix compress the keys, the previous key is kept to compute the shared prefix
+	prefixKeys bool
+	lastKey    []byte
+
+	progress *rewriteProgress
 }
 
 func newSnapshotWriter(ctx context.Context, nodesWriter, leavesWriter, kvsWriter io.Writer) *snapshotWriter {
@@ -490,6 +858,22 @@ func newSnapshotWriter(ctx context.Context, nodesWriter, leavesWriter, kvsWriter
 func (w *snapshotWriter) writeKeyValue(key, value []byte) error {
 	var numBuf [4]byte
 
+	if w.prefixKeys {
+		// it's called once for each leaf, before the leaf counter is increased
+		var shared int
+		if w.leafCounter%prefixRestartInterval != 0 {
+			shared = commonPrefixLen(w.lastKey, key)
+		}
+		w.lastKey = append(w.lastKey[:0], key...)
+
+		binary.LittleEndian.PutUint32(numBuf[:], uint32(shared))
+		if _, err := w.kvWriter.Write(numBuf[:]); err != nil {
+			return err
+		}
+		w.kvsOffset += 4
+		key = key[shared:]
+	}
+
 	binary.LittleEndian.PutUint32(numBuf[:], uint32(len(key)))
 	if _, err := w.kvWriter.Write(numBuf[:]); err != nil {
 		return err
@@ -498,6 +882,21 @@ func (w *snapshotWriter) writeKeyValue(key, value []byte) error {
 		return err
 	}
 
+	if w.blobWriter != nil && len(value) > w.blobThreshold {
+		blobOffset, err := w.writeBlob(value)
+		if err != nil {
+			return err
+		}
+		var refBuf [4 + sizeBlobRef]byte
+		binary.LittleEndian.PutUint32(refBuf[:], uint32(len(value))|blobRefFlag)
+		binary.LittleEndian.PutUint64(refBuf[4:], blobOffset)
+		if _, err := w.kvWriter.Write(refBuf[:]); err != nil {
+			return err
+		}
+		w.kvsOffset += 4 + uint64(len(key)) + uint64(len(refBuf))
+		return nil
+	}
+
 	binary.LittleEndian.PutUint32(numBuf[:], uint32(len(value)))
 	if _, err := w.kvWriter.Write(numBuf[:]); err != nil {
 		return err
@@ -510,6 +909,34 @@ func (w *snapshotWriter) writeKeyValue(key, value []byte) error {
 	return nil
 }
 
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// writeBlob appends the value to the blobs file unless the same one is written already, returns the offset of it.
+func (w *snapshotWriter) writeBlob(value []byte) (uint64, error) {
+	if uint64(len(value)) >= blobRefFlag {
+		return 0, fmt.Errorf("value too large: %d", len(value))
+	}
+	hash := sha256.Sum256(value)
+	if offset, ok := w.blobOffsets[hash]; ok {
+		return offset, nil
+	}
+	if _, err := w.blobWriter.Write(value); err != nil {
+		return 0, err
+	}
+	offset := w.blobsOffset
+	w.blobOffsets[hash] = offset
+	w.blobsOffset += uint64(len(value))
+	return offset, nil
+}
+
 func (w *snapshotWriter) writeLeaf(version uint32, key, value, hash []byte) error {
 	if w.leafCounter%CancelCheckInterval == 0 {
 		select {
@@ -536,6 +963,9 @@ func (w *snapshotWriter) writeLeaf(version uint32, key, value, hash []byte) erro
 	}
 
 	w.leafCounter++
+	if w.leafCounter%CancelCheckInterval == 0 {
+		w.progress.addLeaves(CancelCheckInterval)
+	}
 	return nil
 }
 
@@ -562,7 +992,20 @@ func (w *snapshotWriter) writeBranch(version, size uint32, height, preTrees uint
 // returns `(nodeIndex, err)`.
 func (w *snapshotWriter) writeRecursive(node Node) error {
 	if node.IsLeaf() {
-		return w.writeLeaf(node.Version(), node.Key(), node.Value(), node.Hash())
+		value := node.Value()
+		// the value of the leaf loaded from a commitment-only snapshot is already hashed
+		hashed := false
+		if pnode, ok := node.(PersistedNode); ok {
+			hashed = pnode.snapshot.commitmentOnly
+		}
+		switch {
+		case w.commitmentOnly && !hashed:
+			valueHash := sha256.Sum256(value)
+			value = valueHash[:]
+		case !w.commitmentOnly && hashed:
+			return errCommitmentOnly
+		}
+		return w.writeLeaf(node.Version(), node.Key(), value, node.Hash())
 	}
 
 	// record the number of pending subtrees before the current one,
@@ -580,6 +1023,40 @@ func (w *snapshotWriter) writeRecursive(node Node) error {
 	return w.writeBranch(node.Version(), uint32(node.Size()), node.Height(), preTrees, keyLeaf, node.Hash())
 }
 
+// syncFiles flushes the data of the files in the directory concurrently, then the directory entries.
+func syncFiles(dir string, files ...*os.File) error {
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for i, fp := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fdatasync(fp)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
 func createFile(name string) (*os.File, error) {
 	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
 }
+
+func newBufferedWriter(fp *os.File, size int) *bufio.Writer {
+	if size <= 0 {
+		return bufio.NewWriter(fp)
+	}
+	return bufio.NewWriterSize(fp, size)
+}
+
+// truncateToOffset truncates the file to the current write offset, it drops the preallocated space not written.
+func truncateToOffset(fp *os.File) error {
+	offset, err := fp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return fp.Truncate(offset)
+}
@@ -1,7 +1,12 @@
 package memiavl
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -86,6 +91,37 @@ func TestSnapshotExport(t *testing.T) {
 	require.Equal(t, expNodes, nodes)
 }
 
+func TestSnapshotScanLeaves(t *testing.T) {
+	tree := New(0)
+	for _, changes := range ChangeSets[:3] {
+		tree.ApplyChangeSet(changes)
+		_, _, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+	}
+
+	snapshotDir := t.TempDir()
+	require.NoError(t, tree.WriteSnapshot(snapshotDir))
+
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	scan := func(prefix string) []string {
+		var keys []string
+		require.NoError(t, snapshot.ScanLeaves([]byte(prefix), func(key, value []byte) error {
+			require.Equal(t, "world1", string(value))
+			keys = append(keys, string(key))
+			return nil
+		}))
+		return keys
+	}
+
+	require.Equal(t, []string{"hello", "hello1", "hello2", "hello3"}, scan(""))
+	require.Equal(t, []string{"hello1"}, scan("hello1"))
+	require.Empty(t, scan("hello4"))
+	require.Empty(t, scan("a"))
+}
+
 func TestSnapshotImportExport(t *testing.T) {
 	// setup test tree
 	tree := New(0)
@@ -193,3 +229,216 @@ func testSnapshotRoundTrip(t *testing.T, db *DB) {
 	_, err = db2.Commit()
 	require.NoError(t, err)
 }
+
+func TestSnapshotMmapAdvice(t *testing.T) {
+	advice, err := ParseMmapAdvice("willneed")
+	require.NoError(t, err)
+	require.Equal(t, MmapAdviceWillNeed, advice)
+	_, err = ParseMmapAdvice("unknown")
+	require.Error(t, err)
+
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test", "empty"}})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: changes}}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, Options{
+		NodesMmapAdvice: MmapAdviceWillNeed,
+		KVsMmapAdvice:   MmapAdviceSequential,
+		PrefaultNodes:   true,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, RefHashes[len(RefHashes)-1], db.TreeByName("test").RootHash())
+	require.NoError(t, db.Reload())
+	require.Equal(t, RefHashes[len(RefHashes)-1], db.TreeByName("test").RootHash())
+}
+
+func TestSnapshotPreallocate(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test", "empty"}})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets[:len(ChangeSets)-1] {
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: changes}}))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+
+	// the last version is applied on top of the snapshot, so the estimated sizes are not exact
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSets[len(ChangeSets)-1]}}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	expected := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, db.WriteSnapshot(expected))
+	require.NoError(t, db.Close())
+
+	db, err = Load(dir, Options{SnapshotWriteBufferSize: 16, PreallocateSnapshot: true})
+	require.NoError(t, err)
+	defer db.Close()
+	actual := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, db.WriteSnapshot(actual))
+
+	for _, name := range []string{"test", "empty"} {
+		for _, file := range []string{FileNameNodes, FileNameLeaves, FileNameKVs, FileNameMetadata} {
+			bz, err := os.ReadFile(filepath.Join(expected, name, file))
+			require.NoError(t, err)
+			actualBz, err := os.ReadFile(filepath.Join(actual, name, file))
+			require.NoError(t, err)
+			require.Equal(t, bz, actualBz, "%s/%s", name, file)
+		}
+	}
+}
+
+func TestSnapshotBlobs(t *testing.T) {
+	code := bytes.Repeat([]byte("code"), 64)
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test", "empty"}, BlobThreshold: 100})
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: []*KVPair{
+		{Key: []byte("a"), Value: code},
+		{Key: []byte("b"), Value: []byte("small")},
+		{Key: []byte("c"), Value: code},
+	}}}}))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	hash := db.TreeByName("test").RootHash()
+
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Reload())
+
+	snapshot := db.TreeByName("test").snapshot
+	require.Equal(t, hash, snapshot.RootHash())
+	// the identical values are stored once
+	require.Len(t, snapshot.blobs, len(code))
+	require.Less(t, len(snapshot.kvs), len(code))
+
+	tree := db.TreeByName("test")
+	require.Equal(t, code, tree.Get([]byte("a")))
+	require.Equal(t, []byte("small"), tree.Get([]byte("b")))
+	require.Equal(t, code, tree.Get([]byte("c")))
+
+	var values [][]byte
+	require.NoError(t, snapshot.ScanLeaves(nil, func(_, value []byte) error {
+		values = append(values, value)
+		return nil
+	}))
+	require.Equal(t, [][]byte{code, []byte("small"), code}, values)
+}
+
+func TestSnapshotPrefixKeys(t *testing.T) {
+	var pairs []*KVPair
+	for i := 0; i < 100; i++ {
+		pairs = append(pairs, &KVPair{
+			Key:   []byte(fmt.Sprintf("balances/cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5%04d", i*7)),
+			Value: []byte(fmt.Sprintf("value%d", i)),
+		})
+	}
+
+	snapshots := make([]*Snapshot, 2)
+	for i, prefixKeys := range []bool{false, true} {
+		db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}, PrefixCompressKeys: prefixKeys})
+		require.NoError(t, err)
+		defer db.Close()
+		require.NoError(t, db.ApplyChangeSets([]*NamedChangeSet{{Name: "test", Changeset: ChangeSet{Pairs: pairs}}}))
+		_, err = db.Commit()
+		require.NoError(t, err)
+		require.NoError(t, db.RewriteSnapshot())
+		require.NoError(t, db.Reload())
+
+		tree := db.TreeByName("test")
+		for _, pair := range pairs {
+			require.Equal(t, pair.Value, tree.Get(pair.Key))
+		}
+		require.Nil(t, tree.Get([]byte("balances/cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc50001")))
+		snapshots[i] = tree.snapshot
+	}
+
+	plain, compressed := snapshots[0], snapshots[1]
+	require.True(t, compressed.prefixKeys)
+	require.Equal(t, plain.RootHash(), compressed.RootHash())
+	require.Less(t, len(compressed.kvs), len(plain.kvs)/2)
+
+	var keys [][]byte
+	require.NoError(t, compressed.ScanLeaves([]byte("balances/"), func(key, value []byte) error {
+		keys = append(keys, bytes.Clone(key))
+		return nil
+	}))
+	require.Len(t, keys, len(pairs))
+	for i, pair := range pairs {
+		require.Equal(t, pair.Key, keys[i])
+	}
+
+	collect := func(snapshot *Snapshot) (nodes []*ExportNode) {
+		exporter := snapshot.Export()
+		defer exporter.Close()
+		for {
+			node, err := exporter.Next()
+			if errors.Is(err, ErrorExportDone) {
+				return nodes
+			}
+			require.NoError(t, err)
+			nodes = append(nodes, node)
+		}
+	}
+	require.Equal(t, collect(plain), collect(compressed))
+}
+
+func TestSnapshotByteOrder(t *testing.T) {
+	db, err := Load(t.TempDir(), Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Close())
+
+	snapshotDir := filepath.Join(db.dir, snapshotName(1), "test")
+	bz, err := os.ReadFile(filepath.Join(snapshotDir, FileNameMetadata))
+	require.NoError(t, err)
+	// the format is compatible with the older versions
+	require.Equal(t, uint32(SnapshotFormat), binary.LittleEndian.Uint32(bz[4:]))
+
+	// the byte order and the format are recorded in the layout file
+	layout, err := os.ReadFile(filepath.Join(snapshotDir, FileNameLayout))
+	require.NoError(t, err)
+	require.Equal(t, uint32(layoutByteOrderMark), binary.LittleEndian.Uint32(layout))
+	require.Equal(t, uint32(SnapshotFormat), binary.LittleEndian.Uint32(layout[4:]))
+
+	writeLayout := func(order binary.ByteOrder, format uint32) {
+		var buf [SizeLayout]byte
+		order.PutUint32(buf[:], layoutByteOrderMark)
+		order.PutUint32(buf[4:], format)
+		require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, FileNameLayout), buf[:], 0o600))
+	}
+
+	writeLayout(binary.BigEndian, SnapshotFormat)
+	_, err = OpenSnapshot(snapshotDir)
+	require.ErrorContains(t, err, "big endian")
+
+	writeLayout(binary.LittleEndian, SnapshotFormatBlobs)
+	_, err = OpenSnapshot(snapshotDir)
+	require.ErrorContains(t, err, "format mismatch")
+
+	// the snapshots written by the older versions don't have the layout file
+	require.NoError(t, os.Remove(filepath.Join(snapshotDir, FileNameLayout)))
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Close())
+
+	// rewrite the metadata in big endian byte order
+	for i := 0; i < len(bz); i += 4 {
+		binary.BigEndian.PutUint32(bz[i:], binary.LittleEndian.Uint32(bz[i:]))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, FileNameMetadata), bz, 0o600))
+	_, err = OpenSnapshot(snapshotDir)
+	require.ErrorContains(t, err, "big endian")
+}
@@ -0,0 +1,54 @@
+package memiavl
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errNotReadOnly = errors.New("db is not read-only")
+
+// CatchupWAL applies the versions committed by the primary since the last call to a read-only db, it's used by a hot
+// standby following the primary which writes to the same directory, for example, a shared disk or a local copy
+// kept current by the `follow` command. If the primary has rewritten a newer snapshot, it's reloaded first, so the
+// standby keeps up after the WAL is pruned.
+func (db *DB) CatchupWAL() error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	defer db.publishView()
+
+	if !db.readOnly {
+		return errNotReadOnly
+	}
+
+	if err := db.reloadNewerSnapshot(); err != nil {
+		return err
+	}
+
+	if err := IterateWAL(db.dir, nextVersion(db.MultiTree.Version(), db.initialVersion), 0, func(_ int64, entry *WALEntry) (bool, error) {
+		if err := db.MultiTree.applyWALEntry(*entry); err != nil {
+			return false, fmt.Errorf("replay wal entry failed, %w", err)
+		}
+		if _, err := db.MultiTree.SaveVersion(false); err != nil {
+			return false, fmt.Errorf("replay change set failed, %w", err)
+		}
+		return true, nil
+	}); err != nil && !errors.Is(err, ErrWALTailCorrupt) {
+		// the corrupted tail could be an entry being written, retry in next call
+		return err
+	}
+
+	db.MultiTree.UpdateCommitInfo()
+	return nil
+}
+
+// reloadNewerSnapshot reloads the current snapshot if it's newer than the in-memory version.
+func (db *DB) reloadNewerSnapshot() error {
+	version, err := currentVersion(db.dir)
+	if err != nil {
+		return err
+	}
+	if version <= db.MultiTree.Version() {
+		return nil
+	}
+	return db.reload()
+}
@@ -0,0 +1,105 @@
+package memiavl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StatusFileName is the status file written into the db directory periodically if `StatusInterval` is set.
+const StatusFileName = "status.json"
+
+// Status is the snapshot of the db state written into the status file, for the lightweight monitoring agents which
+// can't access the node by rpc.
+type Status struct {
+	// the time the status is collected
+	Time    time.Time `json:"time"`
+	Version int64     `json:"version"`
+	// the versions of the snapshots on disk, in ascending order
+	Snapshots []int64 `json:"snapshots"`
+	// the range of the versions in the WAL, zero if it's empty
+	WALFirstVersion int64           `json:"wal_first_version"`
+	WALLastVersion  int64           `json:"wal_last_version"`
+	Tasks           BackgroundTasks `json:"tasks"`
+	Disk            DiskUsage       `json:"disk"`
+	Health          Health          `json:"health"`
+}
+
+// Status collects the state of the db without the db lock.
+func (db *DB) Status() (Status, error) {
+	view := db.view.Load()
+	status := Status{
+		Time:    time.Now().UTC(),
+		Version: view.lastCommitInfo.Version,
+		Tasks:   db.BackgroundTasks(),
+		Health:  db.Health(),
+	}
+	if err := traverseSnapshots(db.dir, true, func(version int64) (bool, error) {
+		status.Snapshots = append(status.Snapshots, version)
+		return false, nil
+	}); err != nil {
+		return status, err
+	}
+	if db.wal != nil {
+		first, err := db.wal.FirstIndex()
+		if err != nil {
+			return status, err
+		}
+		last, err := db.wal.LastIndex()
+		if err != nil {
+			return status, err
+		}
+		if last > 0 {
+			status.WALFirstVersion = walVersion(first, view.initialVersion)
+			status.WALLastVersion = walVersion(last, view.initialVersion)
+		}
+	}
+	var err error
+	status.Disk, err = db.DiskUsage()
+	return status, err
+}
+
+// writeStatusFile writes the status file atomically, so the readers never see a partial one.
+func (db *DB) writeStatusFile() error {
+	status, err := db.Status()
+	if err != nil {
+		return err
+	}
+	bz, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(db.dir, StatusFileName)
+	tmpPath := path + TmpSuffix
+	if err := os.WriteFile(tmpPath, bz, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// startStatusWriter writes the status file every interval in a goroutine, until the returned function is called, which
+// waits for the goroutine to exit.
+func (db *DB) startStatusWriter(interval time.Duration) func() {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := db.writeStatusFile(); err != nil {
+				db.logger.Error("failed to write the status file", "err", err)
+			}
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
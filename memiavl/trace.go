@@ -0,0 +1,273 @@
+package memiavl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tidwall/wal"
+)
+
+// the types of the records in the trace file
+const (
+	// the db is loaded, the payload is the commit info of the loaded version
+	traceLoad byte = iota + 1
+	// the payload is a `WALEntry` with the change sets only
+	traceChangeSets
+	// the payload is a `WALEntry` with the upgrades only
+	traceUpgrades
+	// the payload is the commit info produced by the commit
+	traceCommit
+	// the payload is the initial version in uvarint
+	traceInitialVersion
+)
+
+// traceRecorder appends the inputs of the db to the trace file, so the exact sequence can be replayed with
+// `ReplayTrace`. Each record is the type byte and the uvarint length prefixed payload, the buffer is flushed on each
+// commit. The methods are called with the db lock held, they are no-op on a nil receiver.
+type traceRecorder struct {
+	file   *os.File
+	w      *bufio.Writer
+	logger Logger
+}
+
+// openTraceRecorder opens the trace file in append mode, so the traces of the restarts are kept in the same file,
+// each starts with the load record.
+func openTraceRecorder(path string, logger Logger, commitInfo *CommitInfo) (*traceRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &traceRecorder{file: file, w: bufio.NewWriter(file), logger: logger}
+	r.record(traceLoad, commitInfo)
+	r.flush()
+	return r, nil
+}
+
+type traceMarshaler interface {
+	Size() int
+	MarshalTo([]byte) (int, error)
+}
+
+func (r *traceRecorder) record(typ byte, msg traceMarshaler) {
+	if r == nil || r.w == nil {
+		return
+	}
+	payload := make([]byte, msg.Size())
+	if _, err := msg.MarshalTo(payload); err != nil {
+		r.fail(err)
+		return
+	}
+	r.writeRecord(typ, payload)
+}
+
+func (r *traceRecorder) writeRecord(typ byte, payload []byte) {
+	if r == nil || r.w == nil {
+		return
+	}
+	var header [1 + binary.MaxVarintLen64]byte
+	header[0] = typ
+	n := binary.PutUvarint(header[1:], uint64(len(payload)))
+	if _, err := r.w.Write(header[:1+n]); err != nil {
+		r.fail(err)
+		return
+	}
+	if _, err := r.w.Write(payload); err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *traceRecorder) changeSets(changeSets []*NamedChangeSet) {
+	r.record(traceChangeSets, &WALEntry{Changesets: changeSets})
+}
+
+func (r *traceRecorder) upgrades(upgrades []*TreeNameUpgrade) {
+	r.record(traceUpgrades, &WALEntry{Upgrades: upgrades})
+}
+
+func (r *traceRecorder) initialVersion(initialVersion int64) {
+	r.writeRecord(traceInitialVersion, binary.AppendUvarint(nil, uint64(initialVersion)))
+}
+
+func (r *traceRecorder) commit(commitInfo *CommitInfo) {
+	r.record(traceCommit, commitInfo)
+	r.flush()
+}
+
+func (r *traceRecorder) flush() {
+	if r == nil || r.w == nil {
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		r.fail(err)
+	}
+}
+
+// fail stops the recording, the trace is only for debugging, so the failures don't fail the db.
+func (r *traceRecorder) fail(err error) {
+	r.logger.Error("failed to record the trace, stop recording", "file", r.file.Name(), "err", err)
+	r.w = nil
+}
+
+func (r *traceRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	var err error
+	if r.w != nil {
+		err = r.w.Flush()
+	}
+	return errors.Join(err, r.file.Close())
+}
+
+// ReplayResult is the result of a successful `ReplayTrace`.
+type ReplayResult struct {
+	// the number of the loads, changes sets, upgrades and commits replayed
+	Loads, ChangeSets, Upgrades, Commits int
+	// the commit info of the last version replayed
+	LastCommitInfo *CommitInfo
+}
+
+// ReplayTrace reproduces the inputs recorded in the trace file in memory, on the states loaded from the db directory,
+// and compares the commit infos with the recorded ones, it returns the error on the first mismatch, so the
+// non-deterministic app hash bugs can be reproduced from the trace and a copy of the db. The db directory is never
+// written, the snapshots of the loaded versions must be kept, or reachable by replaying the WAL.
+func ReplayTrace(dir, tracePath string, logger Logger) (*ReplayResult, error) {
+	file, err := os.Open(tracePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var (
+		result ReplayResult
+		mtree  *MultiTree
+	)
+	defer func() {
+		if mtree != nil {
+			mtree.Close()
+		}
+	}()
+	for {
+		typ, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read trace record: %w", err)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			// the tail is lost if the node is killed while recording
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Info("truncated trace record at the end, ignored")
+				break
+			}
+			return nil, err
+		}
+
+		if typ != traceLoad && mtree == nil {
+			return nil, errors.New("trace doesn't start with a load record")
+		}
+		switch typ {
+		case traceLoad:
+			var commitInfo CommitInfo
+			if err := commitInfo.Unmarshal(payload); err != nil {
+				return nil, err
+			}
+			// the restart continues from the replayed state if it's the same version
+			if mtree == nil || mtree.Version() != commitInfo.Version {
+				if mtree != nil {
+					mtree.Close()
+				}
+				if mtree, err = loadReplayTree(dir, commitInfo.Version); err != nil {
+					return nil, fmt.Errorf("load version %d: %w", commitInfo.Version, err)
+				}
+			}
+			if err := compareCommitInfo(mtree.LastCommitInfo(), &commitInfo); err != nil {
+				return nil, fmt.Errorf("loaded version %d: %w", commitInfo.Version, err)
+			}
+			logger.Info("replaying trace", "version", commitInfo.Version)
+			result.Loads++
+		case traceChangeSets:
+			entry, err := DecodeWALEntry(payload)
+			if err != nil {
+				return nil, err
+			}
+			if err := mtree.ApplyChangeSets(entry.Changesets); err != nil {
+				return nil, err
+			}
+			result.ChangeSets++
+		case traceUpgrades:
+			entry, err := DecodeWALEntry(payload)
+			if err != nil {
+				return nil, err
+			}
+			if err := mtree.ApplyUpgrades(entry.Upgrades); err != nil {
+				return nil, err
+			}
+			result.Upgrades++
+		case traceInitialVersion:
+			initialVersion, n := binary.Uvarint(payload)
+			if n <= 0 {
+				return nil, errors.New("invalid initial version record")
+			}
+			if err := mtree.SetInitialVersion(int64(initialVersion)); err != nil {
+				return nil, err
+			}
+		case traceCommit:
+			var commitInfo CommitInfo
+			if err := commitInfo.Unmarshal(payload); err != nil {
+				return nil, err
+			}
+			if _, err := mtree.SaveVersion(true); err != nil {
+				return nil, err
+			}
+			if err := compareCommitInfo(mtree.LastCommitInfo(), &commitInfo); err != nil {
+				return nil, fmt.Errorf("commit version %d: %w", commitInfo.Version, err)
+			}
+			result.Commits++
+		default:
+			return nil, fmt.Errorf("unknown trace record type: %d", typ)
+		}
+	}
+
+	if mtree == nil {
+		return nil, errors.New("empty trace")
+	}
+	commitInfo := *mtree.LastCommitInfo()
+	result.LastCommitInfo = &commitInfo
+	return &result, nil
+}
+
+// loadReplayTree loads the version from the nearest snapshot and the WAL, without writing the db directory.
+func loadReplayTree(dir string, version int64) (*MultiTree, error) {
+	snapshotVersion, err := seekSnapshot(dir, uint32(version))
+	if err != nil {
+		return nil, err
+	}
+	mtree, err := LoadMultiTree(filepath.Join(dir, snapshotName(snapshotVersion)), false, 0)
+	if err != nil {
+		return nil, err
+	}
+	if mtree.Version() < version {
+		log, err := OpenWAL(walPath(dir), &wal.Options{NoCopy: true, NoSync: true})
+		if err != nil {
+			return nil, errors.Join(err, mtree.Close())
+		}
+		err = mtree.CatchupWAL(log, version)
+		if err = errors.Join(err, log.Close()); err != nil {
+			return nil, errors.Join(err, mtree.Close())
+		}
+	}
+	return mtree, nil
+}
@@ -0,0 +1,58 @@
+package memiavl
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayTrace(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Load(dir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSet("test", ChangeSets[0]))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	tracePath := filepath.Join(t.TempDir(), "trace")
+	db, err = Load(dir, Options{TraceFile: tracePath})
+	require.NoError(t, err)
+	for _, changes := range ChangeSets[1:3] {
+		require.NoError(t, db.ApplyChangeSet("test", changes))
+		_, err := db.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// the restart is appended to the same trace
+	db, err = Load(dir, Options{TraceFile: tracePath})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyUpgrades([]*TreeNameUpgrade{{Name: "new"}}))
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("new", "hello", "world")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	expected := *db.LastCommitInfo()
+	require.NoError(t, db.Close())
+
+	result, err := ReplayTrace(dir, tracePath, NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Loads)
+	require.Equal(t, 3, result.ChangeSets)
+	require.Equal(t, 1, result.Upgrades)
+	require.Equal(t, 3, result.Commits)
+	require.Equal(t, expected.Hash(), result.LastCommitInfo.Hash())
+
+	// the db with the diverged states is reported
+	otherDir := t.TempDir()
+	db, err = Load(otherDir, Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets(mockNameChangeSet("test", "hello", "other")))
+	_, err = db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = ReplayTrace(otherDir, tracePath, NewNopLogger())
+	require.ErrorContains(t, err, "loaded version 1")
+}
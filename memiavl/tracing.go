@@ -0,0 +1,20 @@
+package memiavl
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the long operations, it's a no-op unless the host application installs a tracer
+// provider with `otel.SetTracerProvider`.
+var tracer = otel.Tracer("github.com/crypto-org-chain/cronos/memiavl")
+
+// endSpan records the error on the span if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -5,19 +5,12 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"math"
-
-	"github.com/cosmos/iavl/cache"
+	"sync/atomic"
+	"time"
 )
 
 var emptyHash = sha256.New().Sum(nil)
 
-func NewCache(cacheSize int) cache.Cache {
-	if cacheSize == 0 {
-		return nil
-	}
-	return cache.New(cacheSize)
-}
-
 // Tree verify change sets by replay them to rebuild iavl tree and verify the root hashes
 type Tree struct {
 	version, cowVersion uint32
@@ -25,19 +18,33 @@ type Tree struct {
 	root     Node
 	snapshot *Snapshot
 
-	// simple lru cache provided by iavl library
-	cache cache.Cache
+	// lru cache of the values, nil if disabled
+	cache *Cache
 
 	// when true, the get and iterator methods could return a slice pointing to mmaped blob files.
 	zeroCopy bool
-}
 
-type cacheNode struct {
-	key, value []byte
-}
-
-func (n *cacheNode) GetKey() []byte {
-	return n.key
+	// allocates the new nodes and tracks their memory usage, it's owned by the tree and dropped along with it, nil in
+	// the copies.
+	arena *nodeArena
+
+	// the max number of goroutines to compute the root hash, 0 or 1 means sequential.
+	hashConcurrency int
+
+	// the unique keys read in the current window of the cache advisor, nil if disabled, owned by the db, so it
+	// survives the snapshot switches.
+	workingSet *workingSet
+	// counts the reads and their latencies, nil if disabled, owned by the db, so it survives the snapshot switches.
+	queryStats *queryStats
+	// the recently accessed keys to pre-warm the cache of the tree loaded from the next snapshot, nil if disabled.
+	recentKeys *keyRing
+
+	// the cache statistics since last commit, updated atomically
+	cacheHits, cacheMisses uint64
+	// the cache statistics accumulated on commit since the tree is loaded, updated atomically
+	cacheHitsTotal, cacheMissesTotal uint64
+	// set atomically when the tree is closed
+	closed int32
 }
 
 // NewEmptyTree creates an empty tree at an arbitrary version.
@@ -51,6 +58,7 @@ func NewEmptyTree(version uint64, cacheSize int) *Tree {
 		// no need to copy if the tree is not backed by snapshot
 		zeroCopy: true,
 		cache:    NewCache(cacheSize),
+		arena:    newNodeArena(false),
 	}
 }
 
@@ -75,6 +83,7 @@ func NewFromSnapshot(snapshot *Snapshot, zeroCopy bool, cacheSize int) *Tree {
 		snapshot: snapshot,
 		zeroCopy: zeroCopy,
 		cache:    NewCache(cacheSize),
+		arena:    newNodeArena(false),
 	}
 
 	if !snapshot.IsEmpty() {
@@ -88,6 +97,79 @@ func (t *Tree) SetZeroCopy(zeroCopy bool) {
 	t.zeroCopy = zeroCopy
 }
 
+// SetHashConcurrency sets the max number of goroutines to compute the root hash, 0 or 1 means sequential.
+func (t *Tree) SetHashConcurrency(concurrency int) {
+	t.hashConcurrency = concurrency
+}
+
+// SetCacheShards sets the number of shards of the cache, the cache is recreated empty if the number changes, so it's
+// called before the tree is used.
+func (t *Tree) SetCacheShards(shards int) {
+	if t.cache == nil || t.cache.Shards() == 1<<cacheShardBits(shards) {
+		return
+	}
+	t.cache = NewShardedCache(t.cache.size, shards)
+}
+
+// SetCachePrewarmSize sets the number of the recently accessed keys to record, which are used to pre-warm the cache
+// of the tree loaded from the next snapshot, 0 means disabled.
+func (t *Tree) SetCachePrewarmSize(size int) {
+	if size <= 0 || t.cache == nil {
+		t.recentKeys = nil
+		return
+	}
+	if t.recentKeys == nil || len(t.recentKeys.keys) != size {
+		t.recentKeys = newKeyRing(size)
+	}
+}
+
+// prewarmCache loads the values of the keys into the cache, the cache statistics are not affected.
+func (t *Tree) prewarmCache(keys [][]byte) {
+	if t.cache == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := t.cache.Get(key); ok {
+			continue
+		}
+		if _, value := t.GetWithIndex(key); value != nil {
+			t.cache.Add(key, value)
+		}
+	}
+}
+
+// SetNodeArena enables or disables the chunked allocation of the new nodes.
+func (t *Tree) SetNodeArena(enable bool) {
+	if t.arena == nil {
+		t.arena = newNodeArena(enable)
+		return
+	}
+	t.arena.chunked = enable
+	if !enable {
+		t.arena.chunk = nil
+	}
+}
+
+// SetCompactNodes enables or disables the compact mode of the new leaves, which don't retain their hashes.
+func (t *Tree) SetCompactNodes(enable bool) {
+	if t.arena == nil {
+		t.arena = newNodeArena(false)
+	}
+	t.arena.compact = enable
+}
+
+// memoryUsage returns the approximate heap bytes of the nodes created since the tree is loaded, plus the cache.
+func (t *Tree) memoryUsage() uint64 {
+	var usage uint64
+	if t.arena != nil {
+		usage += t.arena.bytes
+	}
+	if t.cache != nil {
+		usage += t.cache.Bytes()
+	}
+	return usage
+}
+
 func (t *Tree) IsEmpty() bool {
 	return t.root == nil
 }
@@ -121,9 +203,19 @@ func (t *Tree) Copy(cacheSize int) *Tree {
 		// protect the existing `MemNode`s from get modified in-place
 		t.cowVersion = t.version
 	}
+	// the nodes are shared, the snapshot-backed ones are immutable, and the in-memory ones are protected by
+	// `cowVersion`, so only the tree struct itself is copied.
 	newTree := *t
-	// cache is not copied along because it's not thread-safe to access
-	newTree.cache = NewCache(cacheSize)
+	// cache is not shared because the main tree keeps updating it, the entries of the new one are allocated on first
+	// use
+	newTree.cache = NewShardedCache(cacheSize, t.cache.Shards())
+	newTree.cacheHits, newTree.cacheMisses = 0, 0
+	newTree.cacheHitsTotal, newTree.cacheMissesTotal = 0, 0
+	// the arena is not thread-safe either, the copy allocates from the heap
+	newTree.arena = nil
+	newTree.recentKeys = nil
+	newTree.workingSet = nil
+	newTree.queryStats = nil
 	return &newTree
 }
 
@@ -143,14 +235,14 @@ func (t *Tree) set(key, value []byte) {
 		// the value could be nil when replaying changes from write-ahead-log because of protobuf decoding
 		value = []byte{}
 	}
-	t.root, _ = setRecursive(t.root, key, value, t.version+1, t.cowVersion)
+	t.root, _ = setRecursive(t.arena, t.root, key, value, t.version+1, t.cowVersion)
 	if t.cache != nil {
-		t.cache.Add(&cacheNode{key, value})
+		t.cache.Add(key, value)
 	}
 }
 
 func (t *Tree) remove(key []byte) {
-	_, t.root, _ = removeRecursive(t.root, key, t.version+1, t.cowVersion)
+	_, t.root, _ = removeRecursive(t.arena, t.root, key, t.version+1, t.cowVersion)
 	if t.cache != nil {
 		t.cache.Remove(key)
 	}
@@ -182,6 +274,9 @@ func (t *Tree) RootHash() []byte {
 	if t.root == nil {
 		return emptyHash
 	}
+	if root, ok := t.root.(*MemNode); ok && t.hashConcurrency > 1 {
+		root.hashParallel(t.hashConcurrency)
+	}
 	return t.root.SafeHash()
 }
 
@@ -214,19 +309,33 @@ func (t *Tree) GetByIndex(index int64) ([]byte, []byte) {
 }
 
 func (t *Tree) Get(key []byte) []byte {
+	if t.queryStats != nil {
+		defer t.queryStats.observeGet(time.Now())
+	}
+	if t.recentKeys != nil {
+		t.recentKeys.add(key)
+	}
 	if t.cache != nil {
-		if node := t.cache.Get(key); node != nil {
-			return node.(*cacheNode).value
+		if value, ok := t.cache.Get(key); ok {
+			atomic.AddUint64(&t.cacheHits, 1)
+			if t.workingSet != nil {
+				t.workingSet.add(key, value)
+			}
+			return value
 		}
+		atomic.AddUint64(&t.cacheMisses, 1)
 	}
 
 	_, value := t.GetWithIndex(key)
 	if value == nil {
 		return nil
 	}
+	if t.workingSet != nil {
+		t.workingSet.add(key, value)
+	}
 
 	if t.cache != nil {
-		t.cache.Add(&cacheNode{key, value})
+		t.cache.Add(key, value)
 	}
 	return value
 }
@@ -236,7 +345,13 @@ func (t *Tree) Has(key []byte) bool {
 }
 
 func (t *Tree) Iterator(start, end []byte, ascending bool) *Iterator {
-	return NewIterator(start, end, ascending, t.root, t.zeroCopy)
+	if t.queryStats == nil {
+		return NewIterator(start, end, ascending, t.root, t.zeroCopy)
+	}
+	opened := time.Now()
+	iter := NewIterator(start, end, ascending, t.root, t.zeroCopy)
+	iter.stats, iter.opened = t.queryStats, opened
+	return iter
 }
 
 // ScanPostOrder scans the tree in post-order, and call the callback function on each node.
@@ -289,6 +404,8 @@ func (t *Tree) Export() *Exporter {
 }
 
 func (t *Tree) Close() error {
+	// fail the `GetPinned` calls before the snapshot is released
+	atomic.StoreInt32(&t.closed, 1)
 	var err error
 	if t.snapshot != nil {
 		err = t.snapshot.Close()
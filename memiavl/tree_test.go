@@ -3,7 +3,10 @@ package memiavl
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	db "github.com/cosmos/cosmos-db"
@@ -202,6 +205,235 @@ func TestRootHashesInitialVersion(t *testing.T) {
 	}
 }
 
+func TestRootHashesNodeArena(t *testing.T) {
+	tree := New(0)
+	tree.SetNodeArena(true)
+
+	for i, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+		hash, _, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+		require.Equal(t, RefHashes[i], hash)
+
+		// the copy-on-write nodes are allocated from the arena too
+		snapshot := tree.Copy(0)
+		require.Nil(t, snapshot.arena)
+		require.Equal(t, hash, snapshot.RootHash())
+	}
+}
+
+func TestRootHashesCompactNodes(t *testing.T) {
+	tree := New(0)
+	tree.SetCompactNodes(true)
+
+	for i, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+		hash, _, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+		require.Equal(t, RefHashes[i], hash)
+
+		snapshot := tree.Copy(0)
+		require.Equal(t, hash, snapshot.RootHash())
+	}
+
+	// the leaves don't retain the hashes
+	var leaves int
+	var walk func(node Node)
+	walk = func(node Node) {
+		n, ok := node.(*MemNode)
+		if !ok {
+			return
+		}
+		if n.IsLeaf() {
+			leaves++
+			require.NotZero(t, n.flags&flagCompactLeaf)
+			require.Equal(t, HashNode(n), n.Hash())
+			return
+		}
+		require.NotEmpty(t, n.hash)
+		walk(n.left)
+		walk(n.right)
+	}
+	walk(tree.root)
+	require.NotZero(t, leaves)
+}
+
+func TestCompactNodesSplitKeys(t *testing.T) {
+	ref := iavl.NewMutableTree(wrapper.NewDBWrapper(db.NewMemDB()), 0, true, log.NewNopLogger())
+	expected := New(0)
+	tree := New(0)
+	tree.SetCompactNodes(true)
+
+	// the keys in the same contract share the long prefixes
+	r := rand.New(rand.NewSource(0))
+	for v := 0; v < 20; v++ {
+		var changes ChangeSet
+		for i := 0; i < 200; i++ {
+			key := []byte(fmt.Sprintf("contract-%02d/slot-%04d", r.Intn(10), r.Intn(1000)))
+			if r.Intn(4) == 0 {
+				changes.Pairs = append(changes.Pairs, &KVPair{Key: key, Delete: true})
+			} else {
+				changes.Pairs = append(changes.Pairs, &KVPair{Key: key, Value: []byte(strconv.Itoa(r.Int()))})
+			}
+		}
+		require.NoError(t, applyChangeSetRef(ref, changes))
+		refHash, _, err := ref.SaveVersion()
+		require.NoError(t, err)
+		expected.ApplyChangeSet(changes)
+		_, _, err = expected.SaveVersion(true)
+		require.NoError(t, err)
+		tree.ApplyChangeSet(changes)
+		hash, _, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+		require.Equal(t, refHash, hash)
+	}
+
+	// the keys of the leaves and the branches are split
+	var splitLeaves, splitBranches int
+	var walk func(node Node)
+	walk = func(node Node) {
+		n, ok := node.(*MemNode)
+		if !ok {
+			return
+		}
+		if len(n.keyPrefix()) > 0 {
+			if n.IsLeaf() {
+				splitLeaves++
+			} else {
+				splitBranches++
+			}
+		}
+		if !n.IsLeaf() {
+			require.Nil(t, n.Value())
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(tree.root)
+	require.NotZero(t, splitLeaves)
+	require.NotZero(t, splitBranches)
+
+	require.Equal(t, collectIter(expected.Iterator(nil, nil, true)), collectIter(tree.Iterator(nil, nil, true)))
+	start, end := []byte("contract-03/slot-05"), []byte("contract-07/slot")
+	require.Equal(t, collectIter(expected.Iterator(start, end, true)), collectIter(tree.Iterator(start, end, true)))
+	require.Equal(t, collectIter(expected.Iterator(start, end, false)), collectIter(tree.Iterator(start, end, false)))
+	for i := int64(0); i < expected.root.Size(); i++ {
+		key, value := expected.GetByIndex(i)
+		require.Equal(t, value, tree.Get(key))
+		require.Equal(t, value, tree.Get(bytes.Clone(key)))
+		index, _ := tree.GetWithIndex(key)
+		require.Equal(t, i, index)
+		key2, value2 := tree.GetByIndex(i)
+		require.Equal(t, key, key2)
+		require.Equal(t, value, value2)
+	}
+	require.Nil(t, tree.Get([]byte("contract-0")))
+	require.Nil(t, tree.Get([]byte("contract-03/slot-05000")))
+
+	// the split keys are not joined in the lookups
+	key, _ := expected.GetByIndex(expected.root.Size() / 2)
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		_ = tree.Get(key)
+	}))
+
+	// the split keys are joined in the snapshot
+	snapshotDir := t.TempDir()
+	require.NoError(t, tree.WriteSnapshot(snapshotDir))
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(t, err)
+	defer snapshot.Close()
+	loaded := NewFromSnapshot(snapshot, true, 0)
+	require.Equal(t, tree.RootHash(), loaded.RootHash())
+	require.Equal(t, collectIter(expected.Iterator(nil, nil, true)), collectIter(loaded.Iterator(nil, nil, true)))
+}
+
+func TestRootHashesParallel(t *testing.T) {
+	tree := New(0)
+	tree.SetHashConcurrency(4)
+
+	for i, changes := range ChangeSets {
+		tree.ApplyChangeSet(changes)
+		hash, _, err := tree.SaveVersion(true)
+		require.NoError(t, err)
+		require.Equal(t, RefHashes[i], hash)
+	}
+
+	// large random blocks on top of a snapshot, compared with the sequential hashing
+	snapshotDir := t.TempDir()
+	require.NoError(t, tree.WriteSnapshot(snapshotDir))
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	parallel := NewFromSnapshot(snapshot, true, 0)
+	parallel.SetHashConcurrency(8)
+	sequential := NewFromSnapshot(snapshot, true, 0)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 1000; j++ {
+			key := []byte(fmt.Sprintf("key-%d", r.Intn(5000)))
+			if r.Intn(5) == 0 {
+				parallel.remove(key)
+				sequential.remove(key)
+			} else {
+				value := []byte(fmt.Sprint(r.Int()))
+				parallel.set(key, value)
+				sequential.set(key, value)
+			}
+		}
+		hash, _, err := parallel.SaveVersion(true)
+		require.NoError(t, err)
+		expected, _, err := sequential.SaveVersion(true)
+		require.NoError(t, err)
+		require.Equal(t, expected, hash)
+	}
+}
+
+// TestGetZeroAllocs guards the read path against the allocations in zero-copy mode.
+func TestGetZeroAllocs(t *testing.T) {
+	tree := New(0)
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		keys = append(keys, key)
+		tree.set(key, []byte(fmt.Sprint(i)))
+	}
+	_, _, err := tree.SaveVersion(true)
+	require.NoError(t, err)
+
+	snapshotDir := t.TempDir()
+	require.NoError(t, tree.WriteSnapshot(snapshotDir))
+	snapshot, err := OpenSnapshot(snapshotDir)
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	testCases := []struct {
+		name string
+		tree *Tree
+	}{
+		{"memory", tree},
+		{"disk", NewFromSnapshot(snapshot, true, 0)},
+		// all hits after warmed up
+		{"disk-cache-hit", NewFromSnapshot(snapshot, true, len(keys))},
+		// the keys are evicted before being visited again
+		{"disk-cache-miss", NewFromSnapshot(snapshot, true, len(keys)/10)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range keys {
+				require.NotNil(t, tc.tree.Get(key))
+			}
+
+			i := 0
+			allocs := testing.AllocsPerRun(len(keys)*2, func() {
+				_ = tc.tree.Get(keys[i%len(keys)])
+				i++
+			})
+			require.Zero(t, allocs)
+		})
+	}
+}
+
 func TestNewKey(t *testing.T) {
 	tree := New(0)
 
@@ -310,3 +542,83 @@ func TestGetByIndex(t *testing.T) {
 		require.Equal(t, pair.Value, v)
 	}
 }
+
+func TestCopyAllocatesCacheLazily(t *testing.T) {
+	tree := New(10)
+	tree.ApplyChangeSet(ChangeSet{Pairs: []*KVPair{{Key: []byte("hello"), Value: []byte("world")}}})
+	_, _, err := tree.SaveVersion(true)
+	require.NoError(t, err)
+
+	copied := tree.Copy(10)
+	require.Nil(t, copied.cache.shards[0].entries)
+	require.Equal(t, []byte("world"), copied.Get([]byte("hello")))
+	require.Len(t, copied.cache.shards[0].entries, 10)
+	require.Equal(t, 1, copied.cache.Len())
+}
+
+func TestShardedCache(t *testing.T) {
+	cache := NewShardedCache(100, 3)
+	require.Equal(t, 4, cache.Shards())
+
+	var (
+		wg         sync.WaitGroup
+		mismatches atomic.Int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := []byte(fmt.Sprintf("key%d", j%200))
+				if value, ok := cache.Get(key); ok {
+					if !bytes.Equal(key, value) {
+						mismatches.Add(1)
+					}
+				} else {
+					cache.Add(key, key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Zero(t, mismatches.Load())
+
+	// each shard holds a quarter of the capacity
+	require.LessOrEqual(t, cache.Len(), 100)
+	for i := range cache.shards {
+		require.LessOrEqual(t, cache.shards[i].len, 25)
+	}
+
+	cache.Purge()
+	require.Zero(t, cache.Len())
+	require.Zero(t, cache.Bytes())
+
+	// the tree recreates the cache with the new number of shards
+	tree := New(10)
+	tree.SetCacheShards(2)
+	require.Equal(t, 2, tree.cache.Shards())
+	require.Equal(t, 2, tree.Copy(10).cache.Shards())
+}
+
+func TestCacheStats(t *testing.T) {
+	require.Equal(t, CacheStats{}, New(0).CacheStats())
+
+	tree := New(2)
+	tree.ApplyChangeSet(ChangeSet{Pairs: []*KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte("3")},
+	}})
+	_, _, err := tree.SaveVersion(true)
+	require.NoError(t, err)
+
+	require.Equal(t, []byte("1"), tree.Get([]byte("a")))
+	require.Equal(t, []byte("3"), tree.Get([]byte("c")))
+	stats := tree.CacheStats()
+	require.Equal(t, CacheStats{Hits: 1, Misses: 1, Evictions: 2, Entries: 2, Bytes: 4}, stats)
+	require.Equal(t, 0.5, stats.HitRatio())
+
+	// the lookups accumulated on commit are not counted twice
+	tree.popCacheStats()
+	require.Equal(t, stats, tree.CacheStats())
+}
@@ -0,0 +1,86 @@
+package memiavl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UpgradeHistoryFileName is the append-only file recording the committed store upgrades.
+const UpgradeHistoryFileName = "upgrades.jsonl"
+
+// UpgradeRecord is a committed store upgrade, one JSON object per line in the upgrade history file.
+type UpgradeRecord struct {
+	// the version in which the upgrade is committed
+	Version    int64     `json:"version"`
+	Time       time.Time `json:"time"`
+	Name       string    `json:"name"`
+	RenameFrom string    `json:"rename_from,omitempty"`
+	Delete     bool      `json:"delete,omitempty"`
+}
+
+// appendUpgradeHistory appends the upgrades committed in the version to the history file.
+func appendUpgradeHistory(dir string, version int64, upgrades []*TreeNameUpgrade) error {
+	var buf bytes.Buffer
+	now := time.Now().UTC()
+	for _, upgrade := range upgrades {
+		bz, err := json.Marshal(UpgradeRecord{
+			Version:    version,
+			Time:       now,
+			Name:       upgrade.Name,
+			RenameFrom: upgrade.RenameFrom,
+			Delete:     upgrade.Delete,
+		})
+		if err != nil {
+			return err
+		}
+		buf.Write(bz)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, UpgradeHistoryFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = fdatasync(f)
+	}
+	return errors.Join(err, f.Close())
+}
+
+// UpgradeHistory returns the store upgrades committed since the history is recorded, in the commit order. The records
+// are not removed when the db is rolled back, so it could contain the versions after the latest one.
+func (db *DB) UpgradeHistory() ([]UpgradeRecord, error) {
+	f, err := os.Open(filepath.Join(db.dir, UpgradeHistoryFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []UpgradeRecord
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// the incomplete line is ignored, it's being appended, or left by a crash
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record UpgradeRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
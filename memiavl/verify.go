@@ -0,0 +1,115 @@
+package memiavl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/tidwall/wal"
+)
+
+// Verify checks the consistency of the whole db, it's expensive, should only be done offline:
+//   - the hashes of all the nodes in all the snapshots, and the root hashes match the commit infos recorded in the
+//     snapshot metadata.
+//   - replaying the WAL from the earliest snapshot reproduces the commit infos recorded in the later snapshots.
+//
+// It returns the commit info of the latest version after replaying the whole WAL.
+func Verify(dir string, logger Logger) (*CommitInfo, error) {
+	var versions []int64
+	if err := traverseSnapshots(dir, true, func(version int64) (bool, error) {
+		versions = append(versions, version)
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, errors.New("empty memiavl db")
+	}
+
+	for _, version := range versions {
+		if err := VerifySnapshot(filepath.Join(dir, snapshotName(version))); err != nil {
+			return nil, fmt.Errorf("snapshot %d: %w", version, err)
+		}
+		logger.Info("verified snapshot", "version", version)
+	}
+
+	mtree, err := LoadMultiTree(filepath.Join(dir, snapshotName(versions[0])), false, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer mtree.Close()
+
+	wal, err := OpenWAL(walPath(dir), &wal.Options{NoCopy: true, NoSync: true})
+	if err != nil {
+		return nil, err
+	}
+	defer wal.Close()
+
+	for _, version := range versions[1:] {
+		if err := mtree.CatchupWAL(wal, version); err != nil {
+			return nil, err
+		}
+		metadata, err := readMetadata(filepath.Join(dir, snapshotName(version)))
+		if err != nil {
+			return nil, err
+		}
+		if err := compareCommitInfo(mtree.LastCommitInfo(), metadata.CommitInfo); err != nil {
+			return nil, fmt.Errorf("replay wal to snapshot %d: %w", version, err)
+		}
+		logger.Info("verified wal replay", "version", version)
+	}
+
+	if err := mtree.CatchupWAL(wal, 0); err != nil {
+		return nil, err
+	}
+
+	commitInfo := *mtree.LastCommitInfo()
+	return &commitInfo, nil
+}
+
+// VerifySnapshot recomputes the hashes of all the nodes in the snapshot, and compare the root hashes with
+// the commit info recorded in the metadata.
+func VerifySnapshot(snapshotDir string) error {
+	mtree, err := LoadMultiTree(snapshotDir, false, 0)
+	if err != nil {
+		return err
+	}
+	defer mtree.Close()
+
+	for _, tree := range mtree.trees {
+		if tree.snapshot == nil {
+			continue
+		}
+		if err := tree.snapshot.ScanNodes(func(node PersistedNode) error {
+			if !VerifyHash(node) {
+				return fmt.Errorf("tree %s: node hash mismatch, version: %d, key: %X", tree.Name, node.Version(), node.Key())
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return compareCommitInfo(mtree.buildCommitInfo(mtree.Version()), mtree.LastCommitInfo())
+}
+
+// compareCommitInfo compares the versions and the root hashes of the stores.
+func compareCommitInfo(actual, expected *CommitInfo) error {
+	if actual.Version != expected.Version {
+		return fmt.Errorf("version mismatch, expect %d, got %d", expected.Version, actual.Version)
+	}
+	if len(actual.StoreInfos) != len(expected.StoreInfos) {
+		return fmt.Errorf("number of stores mismatch, expect %d, got %d", len(expected.StoreInfos), len(actual.StoreInfos))
+	}
+	for i, info := range actual.StoreInfos {
+		expectedInfo := expected.StoreInfos[i]
+		if info.Name != expectedInfo.Name {
+			return fmt.Errorf("store name mismatch, expect %s, got %s", expectedInfo.Name, info.Name)
+		}
+		if !bytes.Equal(info.CommitId.Hash, expectedInfo.CommitId.Hash) {
+			return fmt.Errorf("store %s: root hash mismatch, expect %X, got %X", info.Name, expectedInfo.CommitId.Hash, info.CommitId.Hash)
+		}
+	}
+	return nil
+}
@@ -7,12 +7,57 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"unsafe"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/wal"
 )
 
+// maxPooledBufferSize is the max capacity of the marshal buffers put back to the pool, so the huge ones of the rare
+// large blocks are not retained.
+const maxPooledBufferSize = 16 * 1024 * 1024
+
+// marshalBufferPool recycles the buffers to encode the WAL entries and the change sets.
+var marshalBufferPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// sizedMarshaler is implemented by the gogoproto generated messages.
+type sizedMarshaler interface {
+	Size() int
+	MarshalToSizedBuffer([]byte) (int, error)
+}
+
+// marshalPooled encodes the message into a buffer from the pool, the size is computed upfront, so the buffer is grown
+// at most once, the buffer must be released with `releaseMarshalBuffer` after the bytes are consumed.
+func marshalPooled(msg sizedMarshaler) (*[]byte, error) {
+	buf := marshalBufferPool.Get().(*[]byte)
+	size := msg.Size()
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	n, err := msg.MarshalToSizedBuffer(*buf)
+	if err != nil {
+		releaseMarshalBuffer(buf)
+		return nil, err
+	}
+	// the message is written backward from the end of the buffer
+	*buf = (*buf)[size-n:]
+	return buf, nil
+}
+
+func releaseMarshalBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBufferSize {
+		return
+	}
+	*buf = (*buf)[:0]
+	marshalBufferPool.Put(buf)
+}
+
 // OpenWAL opens the write ahead log, try to truncate the corrupted tail if there's any
 // TODO fix in upstream: https://github.com/tidwall/wal/pull/22
 func OpenWAL(dir string, opts *wal.Options) (*wal.Log, error) {
@@ -99,3 +144,98 @@ func loadNextBinaryEntry(data []byte) (n int, err error) {
 	}
 	return n + int(size), nil
 }
+
+// ErrWALTailCorrupt is returned by the WAL readers if the tail of the WAL is corrupted, which could be an entry being
+// written by a live db, the readers don't repair the WAL, retry later.
+var ErrWALTailCorrupt = errors.New("wal tail is corrupted or being written")
+
+// openWALReader opens the WAL without repairing the corrupted tail, so it's safe to read the WAL of a live db.
+func openWALReader(dir string) (*wal.Log, error) {
+	log, err := wal.Open(walPath(dir), &wal.Options{NoCopy: true, NoSync: true})
+	if errors.Is(err, wal.ErrCorrupt) {
+		return nil, ErrWALTailCorrupt
+	}
+	return log, err
+}
+
+// LatestWALVersion returns the version of the last WAL entry, it's like `GetLatestVersion` but don't repair the WAL,
+// so it can be called on a live db.
+func LatestWALVersion(dir string) (int64, error) {
+	metadata, err := readCurrentMetadata(dir)
+	if err != nil {
+		return 0, err
+	}
+	log, err := openWALReader(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+
+	lastIndex, err := log.LastIndex()
+	if err != nil {
+		return 0, err
+	}
+	if lastIndex == 0 {
+		// empty wal
+		return metadata.CommitInfo.Version, nil
+	}
+	return walVersion(lastIndex, uint32(metadata.InitialVersion)), nil
+}
+
+// IterateWAL iterates the WAL entries of the db in the version range [startVersion, endVersion], endVersion 0 means
+// the latest one, the callback returns false to stop the iteration. The WAL is not repaired, so it can read the WAL
+// of a live db, but it returns ErrWALTailCorrupt if the last entry is being written.
+func IterateWAL(dir string, startVersion, endVersion int64, fn func(version int64, entry *WALEntry) (bool, error)) error {
+	metadata, err := readCurrentMetadata(dir)
+	if err != nil {
+		return err
+	}
+	initialVersion := uint32(metadata.InitialVersion)
+
+	log, err := openWALReader(dir)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	firstIndex, err := log.FirstIndex()
+	if err != nil {
+		return err
+	}
+	lastIndex, err := log.LastIndex()
+	if err != nil {
+		return err
+	}
+	if lastIndex == 0 {
+		// empty wal
+		return nil
+	}
+
+	startIndex := walIndex(startVersion, initialVersion)
+	if startVersion <= 0 || startIndex < firstIndex {
+		return fmt.Errorf("version %d is pruned from wal, earliest version: %d", startVersion, walVersion(firstIndex, initialVersion))
+	}
+	endIndex := lastIndex
+	if endVersion > 0 && walIndex(endVersion, initialVersion) < endIndex {
+		endIndex = walIndex(endVersion, initialVersion)
+	}
+
+	for i := startIndex; i <= endIndex; i++ {
+		bz, err := log.Read(i)
+		if err != nil {
+			return fmt.Errorf("read wal log failed, %w", err)
+		}
+		entry, err := decodeLocalWALEntry(bz)
+		if err != nil {
+			return fmt.Errorf("decode wal log %d failed, %w", i, err)
+		}
+		cont, err := fn(walVersion(i, initialVersion), entry)
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
@@ -44,3 +44,23 @@ func TestCorruptedTail(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshalPooled(t *testing.T) {
+	// the buffers are reused by the smaller messages after the larger ones
+	for i := len(ChangeSets) - 1; i >= 0; i-- {
+		entry := WALEntry{Changesets: []*NamedChangeSet{{Name: "test", Changeset: ChangeSets[i]}}}
+		expected, err := entry.Marshal()
+		require.NoError(t, err)
+		buf, err := marshalPooled(&entry)
+		require.NoError(t, err)
+		require.Equal(t, expected, *buf)
+		releaseMarshalBuffer(buf)
+
+		expected, err = ChangeSets[i].Marshal()
+		require.NoError(t, err)
+		buf, err = marshalPooled(&ChangeSets[i])
+		require.NoError(t, err)
+		require.Equal(t, expected, *buf)
+		releaseMarshalBuffer(buf)
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	cmtcfg "github.com/cometbft/cometbft/config"
+	cmtstate "github.com/cometbft/cometbft/state"
+	cmtstore "github.com/cometbft/cometbft/store"
+)
+
+// cometAppHash returns the function to read the app hash of a version recorded by CometBFT, it's in the header of the
+// next block, or in the state if it's the latest block, nil if not recorded, for example, the block is not committed
+// by CometBFT before the node is stopped. It opens the CometBFT dbs, so it must run before the node is started.
+func cometAppHash(homePath, dbBackend string) func(version int64) ([]byte, error) {
+	return func(version int64) ([]byte, error) {
+		cfg := cmtcfg.DefaultConfig()
+		cfg.SetRoot(homePath)
+		if len(dbBackend) > 0 {
+			cfg.DBBackend = dbBackend
+		}
+
+		blockDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "blockstore", Config: cfg})
+		if err != nil {
+			return nil, err
+		}
+		blockStore := cmtstore.NewBlockStore(blockDB)
+		meta := blockStore.LoadBlockMeta(version + 1)
+		if err := blockStore.Close(); err != nil {
+			return nil, err
+		}
+		if meta != nil {
+			return meta.Header.AppHash, nil
+		}
+
+		stateDB, err := cmtcfg.DefaultDBProvider(&cmtcfg.DBContext{ID: "state", Config: cfg})
+		if err != nil {
+			return nil, err
+		}
+		stateStore := cmtstate.NewStore(stateDB, cmtstate.StoreOptions{})
+		defer stateStore.Close()
+		state, err := stateStore.Load()
+		if err != nil {
+			return nil, err
+		}
+		if state.LastBlockHeight != version {
+			return nil, nil
+		}
+		return state.AppHash, nil
+	}
+}
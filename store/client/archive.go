@@ -0,0 +1,137 @@
+package client
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// manifestFileName is the file in the root of the snapshot archive, which lists the files of the snapshot.
+const manifestFileName = "manifest.json"
+
+// archiveManifest describes the content of a snapshot archive, the archive contains a single memiavl snapshot
+// directory named `snapshot-<version>` and the manifest file.
+type archiveManifest struct {
+	Version int64         `json:"version"`
+	Files   []archiveFile `json:"files"`
+}
+
+type archiveFile struct {
+	// slash separated path relative to the root of the archive
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// extractArchive extracts the zstd compressed tarball into the directory, returns the files extracted with their
+// checksums, the manifest file is not included.
+func extractArchive(fileName, dir string) (map[string]archiveFile, error) {
+	fp, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	zr, err := zstd.NewReader(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]archiveFile)
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Clean(header.Name)
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("invalid file name in archive: %s", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			file, err := extractFile(tr, path)
+			if err != nil {
+				return nil, err
+			}
+			if name == manifestFileName {
+				continue
+			}
+			file.Name = filepath.ToSlash(name)
+			files[file.Name] = file
+		default:
+			return nil, fmt.Errorf("unsupported file type in archive: %s", header.Name)
+		}
+	}
+}
+
+// extractFile writes the file and computes the checksum at the same time.
+func extractFile(reader io.Reader, path string) (archiveFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return archiveFile{}, err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return archiveFile{}, err
+	}
+	defer fp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, hasher), reader)
+	if err != nil {
+		return archiveFile{}, err
+	}
+	if err := fp.Sync(); err != nil {
+		return archiveFile{}, err
+	}
+	return archiveFile{Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+func readManifest(fileName string) (*archiveManifest, error) {
+	bz, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var manifest archiveManifest
+	if err := json.Unmarshal(bz, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// verifyManifest checks the extracted files match the ones listed in the manifest exactly.
+func verifyManifest(manifest *archiveManifest, files map[string]archiveFile) error {
+	if len(manifest.Files) != len(files) {
+		return fmt.Errorf("number of files mismatch, manifest: %d, archive: %d", len(manifest.Files), len(files))
+	}
+	for _, expected := range manifest.Files {
+		file, ok := files[expected.Name]
+		if !ok {
+			return fmt.Errorf("file %s not found in archive", expected.Name)
+		}
+		if file.Size != expected.Size || file.SHA256 != expected.SHA256 {
+			return fmt.Errorf("file %s mismatch, expect size %d sha256 %s, got size %d sha256 %s",
+				expected.Name, expected.Size, expected.SHA256, file.Size, file.SHA256)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/store/config"
+	"github.com/spf13/cobra"
+)
+
+// writeRatios is the percentage of writes in the operations of each workload, the rest are reads.
+var writeRatios = map[string]int{
+	"write": 90,
+	"read":  10,
+	"mixed": 50,
+}
+
+func BenchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Args:  cobra.NoArgs,
+		Short: "Run synthetic workloads against a fresh memiavl db, report commit latency percentiles and snapshot rewrite duration",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			workload, err := cmd.Flags().GetString(flagWorkload)
+			if err != nil {
+				return err
+			}
+			writeRatio, ok := writeRatios[workload]
+			if !ok {
+				return fmt.Errorf("unknown workload: %s", workload)
+			}
+			blocks, err := cmd.Flags().GetInt(flagBlocks)
+			if err != nil {
+				return err
+			}
+			ops, err := cmd.Flags().GetInt(flagOps)
+			if err != nil {
+				return err
+			}
+			keySize, err := cmd.Flags().GetInt(flagKeySize)
+			if err != nil {
+				return err
+			}
+			valueSize, err := cmd.Flags().GetInt(flagValueSize)
+			if err != nil {
+				return err
+			}
+			stores, err := cmd.Flags().GetString(flagStores)
+			if err != nil {
+				return err
+			}
+			cacheSize, err := cmd.Flags().GetInt(flagCacheSize)
+			if err != nil {
+				return err
+			}
+			zeroCopy, err := cmd.Flags().GetBool(flagZeroCopy)
+			if err != nil {
+				return err
+			}
+			dir, err := cmd.Flags().GetString(flagDir)
+			if err != nil {
+				return err
+			}
+			seed, err := cmd.Flags().GetInt64(flagSeed)
+			if err != nil {
+				return err
+			}
+
+			if len(dir) == 0 {
+				dir, err = os.MkdirTemp("", "memiavl-bench-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(dir)
+			}
+
+			storeNames := strings.Split(stores, ",")
+			db, err := memiavl.Load(dir, memiavl.Options{
+				CreateIfMissing: true,
+				InitialStores:   storeNames,
+				CacheSize:       cacheSize,
+				ZeroCopy:        zeroCopy,
+				// rewrite snapshot explicitly at the end
+				SnapshotInterval: uint32(blocks) + 1,
+			})
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			b := benchmark{
+				db:         db,
+				rng:        rand.New(rand.NewSource(seed)),
+				stores:     storeNames,
+				keys:       make(map[string][][]byte, len(storeNames)),
+				keySize:    keySize,
+				valueSize:  valueSize,
+				writeRatio: writeRatio,
+			}
+			result, err := b.run(blocks, ops)
+			if err != nil {
+				return err
+			}
+			result.print()
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagWorkload, "mixed", "the workload to run, one of: write, read, mixed")
+	cmd.Flags().Int(flagBlocks, 1000, "number of blocks to commit")
+	cmd.Flags().Int(flagOps, 1000, "number of operations per store per block")
+	cmd.Flags().Int(flagKeySize, 32, "size of the keys in bytes")
+	cmd.Flags().Int(flagValueSize, 128, "size of the values in bytes")
+	cmd.Flags().String(flagStores, "bank,evm", "comma separated list of store names")
+	cmd.Flags().Int(flagCacheSize, config.DefaultCacheSize, "the tree cache size")
+	cmd.Flags().Bool(flagZeroCopy, false, "enable zero-copy mode")
+	cmd.Flags().String(flagDir, "", "the db directory, default to a temporary directory which is removed after the run")
+	cmd.Flags().Int64(flagSeed, 0, "the seed of the random generator")
+	return cmd
+}
+
+type benchmark struct {
+	db     *memiavl.DB
+	rng    *rand.Rand
+	stores []string
+	// the keys written, for reads to hit the existing keys
+	keys map[string][][]byte
+
+	keySize, valueSize int
+	writeRatio         int
+}
+
+type benchResult struct {
+	commits []time.Duration
+	reads   int
+	// total time spent in reads
+	readTime time.Duration
+	rewrite  time.Duration
+}
+
+func (b *benchmark) run(blocks, ops int) (*benchResult, error) {
+	result := &benchResult{
+		commits: make([]time.Duration, 0, blocks),
+	}
+
+	for i := 0; i < blocks; i++ {
+		changeSets := make([]*memiavl.NamedChangeSet, 0, len(b.stores))
+		for _, store := range b.stores {
+			tree := b.db.TreeByName(store)
+			var pairs []*memiavl.KVPair
+			for j := 0; j < ops; j++ {
+				keys := b.keys[store]
+				if len(keys) == 0 || b.rng.Intn(100) < b.writeRatio {
+					key := b.randBytes(b.keySize)
+					pairs = append(pairs, &memiavl.KVPair{Key: key, Value: b.randBytes(b.valueSize)})
+					b.keys[store] = append(keys, key)
+					continue
+				}
+
+				key := keys[b.rng.Intn(len(keys))]
+				start := time.Now()
+				tree.Get(key)
+				result.readTime += time.Since(start)
+				result.reads++
+			}
+			changeSets = append(changeSets, &memiavl.NamedChangeSet{
+				Name:      store,
+				Changeset: memiavl.ChangeSet{Pairs: pairs},
+			})
+		}
+
+		start := time.Now()
+		if err := b.db.ApplyChangeSets(changeSets); err != nil {
+			return nil, err
+		}
+		if _, err := b.db.Commit(); err != nil {
+			return nil, err
+		}
+		result.commits = append(result.commits, time.Since(start))
+	}
+
+	start := time.Now()
+	if err := b.db.RewriteSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := b.db.Reload(); err != nil {
+		return nil, err
+	}
+	result.rewrite = time.Since(start)
+	return result, nil
+}
+
+func (b *benchmark) randBytes(n int) []byte {
+	bz := make([]byte, n)
+	b.rng.Read(bz)
+	return bz
+}
+
+func (r *benchResult) print() {
+	slices.Sort(r.commits)
+	fmt.Printf("commits: %d\n", len(r.commits))
+	for _, p := range []int{50, 90, 99, 100} {
+		fmt.Printf("commit latency p%d: %s\n", p, percentile(r.commits, p))
+	}
+	if r.reads > 0 {
+		fmt.Printf("reads: %d, average latency: %s\n", r.reads, r.readTime/time.Duration(r.reads))
+	}
+	fmt.Printf("snapshot rewrite: %s\n", r.rewrite)
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted) - 1) * p / 100
+	return sorted[i]
+}
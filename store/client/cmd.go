@@ -0,0 +1,27 @@
+package client
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// MemIAVLGroupCmd returns the command group to inspect and manage the memiavl db offline.
+func MemIAVLGroupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "memiavl",
+		Short: "inspect and manage memiavl db offline",
+	}
+	cmd.AddCommand(
+		withProfiling(VerifyCmd()),
+		withProfiling(BenchCmd()),
+		PruneSnapshotsCmd(),
+		withProfiling(SalvageCmd()),
+		withProfiling(GetCmd()),
+		withProfiling(RestoreCmd()),
+		withProfiling(ReportCmd()),
+		withProfiling(ReplayTraceCmd()),
+		FollowCmd(),
+		SnapshotGroupCmd(),
+		WALGroupCmd(),
+	)
+	return cmd
+}
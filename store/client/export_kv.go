@@ -0,0 +1,233 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+// valueDecoders convert the raw values into the readable strings.
+var valueDecoders = map[string]func([]byte) (string, error){
+	"hex":    func(bz []byte) (string, error) { return hex.EncodeToString(bz), nil },
+	"base64": func(bz []byte) (string, error) { return base64.StdEncoding.EncodeToString(bz), nil },
+	"string": func(bz []byte) (string, error) { return string(bz), nil },
+	"uint64": func(bz []byte) (string, error) {
+		if len(bz) != 8 {
+			return "", fmt.Errorf("invalid uint64 value length: %d", len(bz))
+		}
+		return strconv.FormatUint(binary.BigEndian.Uint64(bz), 10), nil
+	},
+	"bigint": func(bz []byte) (string, error) { return new(big.Int).SetBytes(bz).String(), nil },
+}
+
+// kvWriter writes the key-value pairs in one of the output formats.
+type kvWriter interface {
+	WriteKV(key, value string) error
+	Close() error
+}
+
+func ExportKVCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export-kv <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Export the key-value pairs of a store in a snapshot to csv, jsonl or parquet format",
+		Long: `Stream the key-value pairs of a store in the snapshot of --version, default to the latest snapshot, in the
+order of keys, optionally filtered by the hex encoded --key-prefix, which can be repeated. The keys are hex encoded,
+the values are converted by --value-decoder:
+
+- hex: hex encoded, the default.
+- base64: base64 encoded.
+- string: the raw bytes as string, for example the amounts in bank store.
+- uint64: big-endian encoded uint64 in decimal.
+- bigint: big-endian encoded unsigned integer in decimal, for example the evm storage values.
+
+The output has two string columns "key" and "value", the parquet files are uncompressed.`,
+		RunE: func(cmd *cobra.Command, args []string) (returnErr error) {
+			dir := args[0]
+			store, err := cmd.Flags().GetString(flagStore)
+			if err != nil {
+				return err
+			}
+			format, err := cmd.Flags().GetString(flagFormat)
+			if err != nil {
+				return err
+			}
+			version, err := cmd.Flags().GetInt64(flagVersion)
+			if err != nil {
+				return err
+			}
+			prefixes, err := cmd.Flags().GetStringSlice(flagKeyPrefix)
+			if err != nil {
+				return err
+			}
+			decoderName, err := cmd.Flags().GetString(flagDecoder)
+			if err != nil {
+				return err
+			}
+			output, err := cmd.Flags().GetString(flagFile)
+			if err != nil {
+				return err
+			}
+			if len(store) == 0 {
+				return errors.New("store name is required")
+			}
+			decode, ok := valueDecoders[decoderName]
+			if !ok {
+				return fmt.Errorf("unknown value decoder: %s", decoderName)
+			}
+
+			keyPrefixes := make([][]byte, 0, len(prefixes))
+			for _, prefix := range prefixes {
+				bz, err := hex.DecodeString(prefix)
+				if err != nil {
+					return fmt.Errorf("invalid key prefix %s: %w", prefix, err)
+				}
+				keyPrefixes = append(keyPrefixes, bz)
+			}
+			keyPrefixes = mergeKeyPrefixes(keyPrefixes)
+			if len(keyPrefixes) == 0 {
+				keyPrefixes = append(keyPrefixes, nil)
+			}
+
+			if version < 0 {
+				versions, err := memiavl.SnapshotVersions(dir)
+				if err != nil {
+					return err
+				}
+				if len(versions) == 0 {
+					return fmt.Errorf("no snapshot found in %s", dir)
+				}
+				version = versions[len(versions)-1]
+			}
+			snapshot, err := memiavl.OpenSnapshot(filepath.Join(dir, fmt.Sprintf("snapshot-%020d", version), store))
+			if err != nil {
+				return err
+			}
+			defer snapshot.Close()
+
+			out := os.Stdout
+			if len(output) > 0 {
+				out, err = os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := out.Close(); returnErr == nil {
+						returnErr = err
+					}
+				}()
+			}
+			bufOut := bufio.NewWriter(out)
+			writer, err := newKVWriter(format, bufOut)
+			if err != nil {
+				return err
+			}
+
+			var count int
+			for _, prefix := range keyPrefixes {
+				if err := snapshot.ScanLeaves(prefix, func(key, value []byte) error {
+					decoded, err := decode(value)
+					if err != nil {
+						return fmt.Errorf("decode value of key %X: %w", key, err)
+					}
+					count++
+					return writer.WriteKV(hex.EncodeToString(key), decoded)
+				}); err != nil {
+					return err
+				}
+			}
+			if err := writer.Close(); err != nil {
+				return err
+			}
+			if err := bufOut.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "exported %d key-value pairs of store %s at version %d\n", count, store, version)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagStore, "", "the store name")
+	cmd.Flags().String(flagFormat, "csv", "the output format, csv, jsonl or parquet")
+	cmd.Flags().Int64(flagVersion, -1, "the version of the snapshot, default to the latest snapshot")
+	cmd.Flags().StringSlice(flagKeyPrefix, nil, "only export the keys with the hex encoded prefix, can be repeated")
+	cmd.Flags().String(flagDecoder, "hex", "the value decoder, hex, base64, string, uint64 or bigint")
+	cmd.Flags().String(flagFile, "", "the output file, default to stdout")
+	return cmd
+}
+
+// mergeKeyPrefixes sorts the prefixes and drops the ones covered by a shorter prefix,
+// so the keys are exported once in ascending order.
+func mergeKeyPrefixes(prefixes [][]byte) [][]byte {
+	sort.Slice(prefixes, func(i, j int) bool {
+		return bytes.Compare(prefixes[i], prefixes[j]) < 0
+	})
+	merged := prefixes[:0]
+	for _, prefix := range prefixes {
+		if len(merged) > 0 && bytes.HasPrefix(prefix, merged[len(merged)-1]) {
+			continue
+		}
+		merged = append(merged, prefix)
+	}
+	return merged
+}
+
+func newKVWriter(format string, w io.Writer) (kvWriter, error) {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"key", "value"}); err != nil {
+			return nil, err
+		}
+		return &csvKVWriter{writer}, nil
+	case "jsonl":
+		return &jsonlKVWriter{json.NewEncoder(w)}, nil
+	case "parquet":
+		return newParquetKVWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+type csvKVWriter struct {
+	*csv.Writer
+}
+
+func (w *csvKVWriter) WriteKV(key, value string) error {
+	return w.Write([]string{key, value})
+}
+
+func (w *csvKVWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+type jsonlKVWriter struct {
+	*json.Encoder
+}
+
+func (w *jsonlKVWriter) WriteKV(key, value string) error {
+	return w.Encode(struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}{key, value})
+}
+
+func (w *jsonlKVWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package client
+
+const (
+	flagAppHash     = "app-hash"
+	flagWorkload    = "workload"
+	flagBlocks      = "blocks"
+	flagOps         = "ops"
+	flagKeySize     = "key-size"
+	flagValueSize   = "value-size"
+	flagStores      = "stores"
+	flagCacheSize   = "cache-size"
+	flagZeroCopy    = "zero-copy"
+	flagDir         = "dir"
+	flagSeed        = "seed"
+	flagKeep        = "keep"
+	flagDryRun      = "dry-run"
+	flagStore       = "store"
+	flagKeysFile    = "keys-file"
+	flagHeight      = "height"
+	flagWorkers     = "workers"
+	flagFile        = "file"
+	flagVersion     = "version"
+	flagBaseVersion = "base-version"
+	flagOutput      = "output"
+	flagCPUProfile  = "cpu-profile"
+	flagMemProfile  = "mem-profile"
+	flagTrace       = "trace"
+	flagPrimary     = "primary"
+	flagRetry       = "retry-interval"
+	flagFormat      = "format"
+	flagKeyPrefix   = "key-prefix"
+	flagDecoder     = "value-decoder"
+	flagFrom        = "from"
+	flagDecode      = "decode"
+	flagInterval    = "poll-interval"
+	flagQuarantine  = "quarantine"
+	flagSkipHashes  = "skip-hashes"
+	flagOut         = "out"
+	flagPartSize    = "part-size"
+	flagToken       = "token"
+	flagTLS         = "tls"
+)
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/store/replication"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func FollowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "follow <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Keep a local memiavl db current by following the WAL stream of a primary node",
+		Long: `Connect to the WAL stream service of a primary node, apply the WAL entries after the local version and
+commit them, so the local db stays current with the primary. The local db must exist, for example restored from a
+snapshot of the primary, and must not be written by others. It reconnects on failures until interrupted.
+
+The token must match memiavl.wal-stream-token of the primary, use --tls if the primary is reached through TLS, the
+token is sent in plaintext otherwise.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			primary, err := cmd.Flags().GetString(flagPrimary)
+			if err != nil {
+				return err
+			}
+			if len(primary) == 0 {
+				return fmt.Errorf("--%s is required", flagPrimary)
+			}
+			retryInterval, err := cmd.Flags().GetDuration(flagRetry)
+			if err != nil {
+				return err
+			}
+			token, err := cmd.Flags().GetString(flagToken)
+			if err != nil {
+				return err
+			}
+			if len(token) == 0 {
+				return fmt.Errorf("--%s is required", flagToken)
+			}
+			useTLS, err := cmd.Flags().GetBool(flagTLS)
+			if err != nil {
+				return err
+			}
+			transport := insecure.NewCredentials()
+			if useTLS {
+				transport = credentials.NewTLS(nil)
+			}
+
+			db, err := memiavl.Load(args[0], memiavl.Options{})
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			conn, err := grpc.NewClient(
+				primary,
+				grpc.WithTransportCredentials(transport),
+				grpc.WithPerRPCCredentials(replication.TokenCredentials(token, useTLS)),
+			)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			for {
+				err := replication.Subscribe(ctx, conn, db.Version()+1, func(version int64, entry *memiavl.WALEntry) error {
+					return applyWALEntry(db, version, entry)
+				})
+				if ctx.Err() != nil {
+					fmt.Printf("stopped at version %d\n", db.Version())
+					return nil
+				}
+				fmt.Fprintf(os.Stderr, "wal stream broken at version %d: %v, retry in %s\n", db.Version(), err, retryInterval)
+
+				select {
+				case <-ctx.Done():
+					fmt.Printf("stopped at version %d\n", db.Version())
+					return nil
+				case <-time.After(retryInterval):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().String(flagPrimary, "", "the grpc address of the primary node serving the WAL stream")
+	cmd.Flags().Duration(flagRetry, 5*time.Second, "the interval to reconnect after the stream is broken")
+	cmd.Flags().String(flagToken, "", "the token of the wal stream service of the primary")
+	cmd.Flags().Bool(flagTLS, false, "connect to the primary with TLS, verified with the system root certificates")
+	return cmd
+}
+
+// applyWALEntry applies and commits a WAL entry received from the primary, the versions must be continuous.
+func applyWALEntry(db *memiavl.DB, version int64, entry *memiavl.WALEntry) error {
+	if version != db.Version()+1 {
+		return fmt.Errorf("received version %d, expect %d", version, db.Version()+1)
+	}
+	if len(entry.Upgrades) > 0 {
+		if err := db.ApplyUpgrades(entry.Upgrades); err != nil {
+			return err
+		}
+	}
+	if err := db.ApplyChangeSets(entry.Changesets); err != nil {
+		return err
+	}
+	if _, err := db.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyWALEntry(t *testing.T) {
+	primaryDir := t.TempDir()
+	primary, err := memiavl.Load(primaryDir, memiavl.Options{CreateIfMissing: true, InitialStores: []string{"test"}})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, primary.ApplyChangeSets([]*memiavl.NamedChangeSet{{
+			Name: "test",
+			Changeset: memiavl.ChangeSet{Pairs: []*memiavl.KVPair{
+				{Key: []byte("hello"), Value: []byte{byte(i)}},
+				{Key: []byte{byte(i)}, Value: []byte("world")},
+			}},
+		}}))
+		_, err := primary.Commit()
+		require.NoError(t, err)
+	}
+	hash := primary.LastCommitInfo().Hash()
+	require.NoError(t, primary.Close())
+
+	follower, err := memiavl.Load(t.TempDir(), memiavl.Options{CreateIfMissing: true})
+	require.NoError(t, err)
+	defer follower.Close()
+
+	var entries []*memiavl.WALEntry
+	require.NoError(t, memiavl.IterateWAL(primaryDir, 1, 0, func(version int64, entry *memiavl.WALEntry) (bool, error) {
+		entries = append(entries, entry)
+		return true, nil
+	}))
+	require.Len(t, entries, 3)
+
+	// the versions must be continuous
+	require.ErrorContains(t, applyWALEntry(follower, 2, entries[1]), "received version 2, expect 1")
+	require.Equal(t, int64(0), follower.Version())
+
+	require.NoError(t, applyWALEntry(follower, 1, entries[0]))
+	require.ErrorContains(t, applyWALEntry(follower, 3, entries[2]), "received version 3, expect 2")
+	require.ErrorContains(t, applyWALEntry(follower, 1, entries[0]), "received version 1, expect 2")
+	require.NoError(t, applyWALEntry(follower, 2, entries[1]))
+	require.NoError(t, applyWALEntry(follower, 3, entries[2]))
+	require.Equal(t, int64(3), follower.Version())
+	require.Equal(t, hash, follower.LastCommitInfo().Hash())
+}
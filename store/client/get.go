@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+func GetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Query a batch of keys of a store from memiavl db in read-only mode, output the results in JSONL format",
+		Long: `Query the keys listed in --keys-file, one hex encoded key per line, at the height specified by --height,
+default to the latest version. The db is opened in read-only mode, so it can run on a live node.
+
+The keys are resolved in sorted order by multiple workers, each one walks a contiguous range of keys, so the tree
+nodes and the mmap-ed pages are shared by the adjacent lookups, the results are printed in the input order:
+
+{"key":"<hex>","value":"<hex>"}
+
+the value is null if the key is not found.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := cmd.Flags().GetString(flagStore)
+			if err != nil {
+				return err
+			}
+			keysFile, err := cmd.Flags().GetString(flagKeysFile)
+			if err != nil {
+				return err
+			}
+			height, err := cmd.Flags().GetUint32(flagHeight)
+			if err != nil {
+				return err
+			}
+			workers, err := cmd.Flags().GetInt(flagWorkers)
+			if err != nil {
+				return err
+			}
+			if len(store) == 0 {
+				return errors.New("store name is required")
+			}
+
+			keys, err := readKeysFile(keysFile)
+			if err != nil {
+				return err
+			}
+
+			// the cache is disabled, which is not concurrency-safe.
+			db, err := memiavl.Load(args[0], memiavl.Options{ReadOnly: true, TargetVersion: height})
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			if height > 0 && db.Version() != int64(height) {
+				return fmt.Errorf("height %d not found, loaded version: %d", height, db.Version())
+			}
+			tree := db.TreeByName(store)
+			if tree == nil {
+				return fmt.Errorf("store %s not found", store)
+			}
+
+			values := getKeys(tree, keys, workers)
+			return writeJSONL(cmd.OutOrStdout(), keys, values)
+		},
+	}
+
+	cmd.Flags().String(flagStore, "", "the store name")
+	cmd.Flags().String(flagKeysFile, "", "the file contains the hex encoded keys, one per line, default to stdin")
+	cmd.Flags().Uint32(flagHeight, 0, "the height to query, default to the latest version")
+	cmd.Flags().Int(flagWorkers, runtime.NumCPU(), "number of concurrent workers")
+	return cmd
+}
+
+// readKeysFile parses the hex encoded keys, one per line, skip the empty lines.
+func readKeysFile(fileName string) ([][]byte, error) {
+	var reader io.Reader = os.Stdin
+	if len(fileName) > 0 {
+		fp, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer fp.Close()
+		reader = fp
+	}
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(line, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// getKeys resolves the keys in sorted order concurrently, returns the values in the input order.
+func getKeys(tree *memiavl.Tree, keys [][]byte, workers int) [][]byte {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+
+	if workers < 1 {
+		workers = 1
+	}
+	chunkSize := (len(order) + workers - 1) / workers
+
+	values := make([][]byte, len(keys))
+	var wg sync.WaitGroup
+	for start := 0; start < len(order); start += chunkSize {
+		chunk := order[start:min(start+chunkSize, len(order))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range chunk {
+				values[i] = tree.Get(keys[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return values
+}
+
+type getResult struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+func writeJSONL(w io.Writer, keys, values [][]byte) error {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	for i, key := range keys {
+		result := getResult{Key: hex.EncodeToString(key)}
+		if values[i] != nil {
+			value := hex.EncodeToString(values[i])
+			result.Value = &value
+		}
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+)
+
+// archiveMetadata is published alongside the snapshot archive, for the snapshot provider websites.
+type archiveMetadata struct {
+	Version int64  `json:"version"`
+	AppHash string `json:"app_hash"`
+	// the file name of the whole archive, the parts should be concatenated into it if split
+	Archive   string        `json:"archive"`
+	Size      int64         `json:"size"`
+	SHA256    string        `json:"sha256"`
+	Parts     []archiveFile `json:"parts,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func PackageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "package <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Package a snapshot of memiavl db into a distributable archive",
+		Long: `Package the current snapshot, or the one specified by --version, into a zstd compressed tarball with the
+manifest file listing the checksums of the files, which can be restored with the "restore" command.
+
+With --part-size, the archive is split into the files of fixed size with suffixes ".000", ".001" and so on, which
+can be concatenated into the archive. A metadata file "<out>.json" is written next to the archive, which contains the
+version, the app hash, and the sizes and checksums of the archive and the parts, for the snapshot provider websites.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			out, err := cmd.Flags().GetString(flagOut)
+			if err != nil {
+				return err
+			}
+			version, err := cmd.Flags().GetInt64(flagVersion)
+			if err != nil {
+				return err
+			}
+			partSize, err := cmd.Flags().GetInt64(flagPartSize)
+			if err != nil {
+				return err
+			}
+			if len(out) == 0 {
+				return fmt.Errorf("--%s is required", flagOut)
+			}
+
+			var name string
+			if version < 0 {
+				if name, err = memiavl.CurrentSnapshot(dir); err != nil {
+					return err
+				}
+			} else {
+				name = fmt.Sprintf("snapshot-%020d", version)
+			}
+			snapshotDir := filepath.Join(dir, name)
+
+			mtree, err := memiavl.LoadMultiTree(snapshotDir, false, 0)
+			if err != nil {
+				return err
+			}
+			metadata := archiveMetadata{
+				Version:   mtree.Version(),
+				AppHash:   hex.EncodeToString(CommitInfoHash(mtree.LastCommitInfo())),
+				Archive:   filepath.Base(out),
+				CreatedAt: time.Now().UTC(),
+			}
+			if err := mtree.Close(); err != nil {
+				return err
+			}
+
+			writer := newPartWriter(out, partSize<<20)
+			hasher := sha256.New()
+			size, err := writeArchive(io.MultiWriter(writer, hasher), snapshotDir, name, metadata.Version)
+			if err := errors.Join(err, writer.Close()); err != nil {
+				return errors.Join(err, writer.Remove())
+			}
+			metadata.Size = size
+			metadata.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+			if partSize > 0 {
+				metadata.Parts = writer.parts
+			}
+
+			bz, err := json.MarshalIndent(metadata, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(out+".json", bz, 0o600); err != nil {
+				return err
+			}
+			fmt.Printf("packaged snapshot at version %d, %d bytes, sha256 %s\n", metadata.Version, size, metadata.SHA256)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagOut, "", "the output archive file, for example snapshot.tar.zst")
+	cmd.Flags().Int64(flagVersion, -1, "the version of the snapshot, default to the current snapshot")
+	cmd.Flags().Int64(flagPartSize, 0, "split the archive into the parts of the size in MiB, 0 means no split")
+	return cmd
+}
+
+// writeArchive writes the snapshot directory as a zstd compressed tarball with the manifest file,
+// returns the size of the archive.
+func writeArchive(w io.Writer, snapshotDir, name string, version int64) (int64, error) {
+	counter := &countingWriter{w: w}
+	zw, err := zstd.NewWriter(counter)
+	if err != nil {
+		return 0, err
+	}
+	tw := tar.NewWriter(zw)
+
+	// the checksums are computed in a separate pass, so the manifest can be placed at the beginning
+	manifest := archiveManifest{Version: version}
+	if err := walkSnapshotFiles(snapshotDir, func(path, rel string, info fs.FileInfo) error {
+		if info.IsDir() {
+			// only the regular files are listed
+			return nil
+		}
+		file, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		file.Name = name + "/" + rel
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	bz, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestFileName,
+		Size:     int64(len(bz)),
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return 0, err
+	}
+	if _, err := tw.Write(bz); err != nil {
+		return 0, err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: time.Now()}); err != nil {
+		return 0, err
+	}
+	if err := walkSnapshotFiles(snapshotDir, func(path, rel string, info fs.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name + "/" + rel
+		if info.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		fp, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		_, err = io.Copy(tw, fp)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// walkSnapshotFiles walks the directories and regular files in the snapshot directory, except the root,
+// rel is the slash separated path relative to the root.
+func walkSnapshotFiles(root string, fn func(path, rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return fmt.Errorf("unsupported file type in snapshot: %s", path)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(rel), info)
+	})
+}
+
+func hashFile(path string) (archiveFile, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return archiveFile{}, err
+	}
+	defer fp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, fp)
+	if err != nil {
+		return archiveFile{}, err
+	}
+	return archiveFile{Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// partWriter writes the stream into the files of fixed size, named with the suffixes ".000", ".001" and so on,
+// or a single file named as the prefix if the part size is not positive, the checksums of the files are computed.
+type partWriter struct {
+	prefix   string
+	partSize int64
+
+	file    *os.File
+	written int64
+	hasher  hash.Hash
+	parts   []archiveFile
+}
+
+func newPartWriter(prefix string, partSize int64) *partWriter {
+	return &partWriter{prefix: prefix, partSize: partSize}
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		if w.file == nil || (w.partSize > 0 && w.written >= w.partSize) {
+			if err := w.nextPart(); err != nil {
+				return n, err
+			}
+		}
+		chunk := p
+		if w.partSize > 0 && int64(len(chunk)) > w.partSize-w.written {
+			chunk = chunk[:w.partSize-w.written]
+		}
+		m, err := w.file.Write(chunk)
+		w.hasher.Write(chunk[:m])
+		w.written += int64(m)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (w *partWriter) nextPart() error {
+	if err := w.closePart(); err != nil {
+		return err
+	}
+	path := w.prefix
+	if w.partSize > 0 {
+		path = fmt.Sprintf("%s.%03d", w.prefix, len(w.parts))
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	w.file = fp
+	w.written = 0
+	w.hasher = sha256.New()
+	w.parts = append(w.parts, archiveFile{Name: filepath.Base(path)})
+	return nil
+}
+
+func (w *partWriter) closePart() error {
+	if w.file == nil {
+		return nil
+	}
+	err := errors.Join(w.file.Sync(), w.file.Close())
+	w.file = nil
+	part := &w.parts[len(w.parts)-1]
+	part.Size = w.written
+	part.SHA256 = hex.EncodeToString(w.hasher.Sum(nil))
+	return err
+}
+
+// Close closes the last part.
+func (w *partWriter) Close() error {
+	return w.closePart()
+}
+
+// Remove removes the files written, used to clean up on failure.
+func (w *partWriter) Remove() error {
+	var errs []error
+	for _, part := range w.parts {
+		errs = append(errs, os.Remove(filepath.Join(filepath.Dir(w.prefix), part.Name)))
+	}
+	return errors.Join(errs...)
+}
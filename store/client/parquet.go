@@ -0,0 +1,53 @@
+package client
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// the row group is flushed when the buffered keys and values exceed the size, parquet-go keeps the whole row group
+// in memory until it's flushed.
+const parquetRowGroupSize = 64 * 1024 * 1024
+
+// parquetKVRow is the schema of the exported parquet files, two required string columns.
+type parquetKVRow struct {
+	Key   string `parquet:"key"`
+	Value string `parquet:"value"`
+}
+
+// parquetKVWriter writes the key-value pairs as uncompressed parquet rows.
+type parquetKVWriter struct {
+	writer *parquet.GenericWriter[parquetKVRow]
+	// reused to pass a single row to the writer without allocation
+	row [1]parquetKVRow
+	// the size of the keys and values buffered in the current row group
+	buffered int
+}
+
+func newParquetKVWriter(w io.Writer) *parquetKVWriter {
+	return &parquetKVWriter{writer: parquet.NewGenericWriter[parquetKVRow](w)}
+}
+
+func (w *parquetKVWriter) WriteKV(key, value string) error {
+	w.row[0] = parquetKVRow{Key: key, Value: value}
+	if _, err := w.writer.Write(w.row[:]); err != nil {
+		return err
+	}
+	w.buffered += len(key) + len(value)
+	if w.buffered >= parquetRowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (w *parquetKVWriter) flush() error {
+	w.buffered = 0
+	return w.writer.Flush()
+}
+
+// Close flushes the pending rows and writes the file footer, it don't close the underlying writer.
+func (w *parquetKVWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParquetWriter(t *testing.T) {
+	var expected []parquetKVRow
+	for i := 0; i < 1000; i++ {
+		expected = append(expected, parquetKVRow{Key: fmt.Sprintf("key%04d", i), Value: fmt.Sprintf("value%d", i)})
+	}
+	// the empty and non-ascii values
+	expected = append(expected, parquetKVRow{Key: "empty"}, parquetKVRow{Key: "unicode", Value: "値"})
+
+	var buf bytes.Buffer
+	pw := newParquetKVWriter(&buf)
+	for i, row := range expected {
+		require.NoError(t, pw.WriteKV(row.Key, row.Value))
+		if i == 500 {
+			// split into multiple row groups
+			require.NoError(t, pw.flush())
+		}
+	}
+	require.NoError(t, pw.Close())
+
+	// read back
+	bz := buf.Bytes()
+	file, err := parquet.OpenFile(bytes.NewReader(bz), int64(len(bz)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(expected)), file.NumRows())
+	require.Len(t, file.RowGroups(), 2)
+
+	fields := file.Schema().Fields()
+	require.Len(t, fields, 2)
+	for i, name := range []string{"key", "value"} {
+		require.Equal(t, name, fields[i].Name())
+		require.True(t, fields[i].Required())
+		require.Equal(t, parquet.ByteArray, fields[i].Type().Kind())
+		require.NotNil(t, fields[i].Type().LogicalType().UTF8)
+	}
+
+	reader := parquet.NewGenericReader[parquetKVRow](bytes.NewReader(bz))
+	defer reader.Close()
+	rows := make([]parquetKVRow, len(expected)+1)
+	n, err := reader.Read(rows)
+	if !errors.Is(err, io.EOF) {
+		require.NoError(t, err)
+	}
+	require.Equal(t, expected, rows[:n])
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/spf13/cobra"
+)
+
+// withProfiling adds the profiling flags to the command, the profiles only cover the execution of the command,
+// profiling the full node is impractical for the batch jobs.
+func withProfiling(cmd *cobra.Command) *cobra.Command {
+	runE := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) (returnErr error) {
+		stop, err := startProfiling(cmd)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stop(); returnErr == nil {
+				returnErr = err
+			}
+		}()
+		return runE(cmd, args)
+	}
+
+	cmd.Flags().String(flagCPUProfile, "", "write cpu profile to the file")
+	cmd.Flags().String(flagMemProfile, "", "write heap profile to the file when the command finishes")
+	cmd.Flags().String(flagTrace, "", "write execution trace to the file")
+	return cmd
+}
+
+// startProfiling starts the profilers enabled by the flags, returns the function to stop them and write the profiles.
+func startProfiling(cmd *cobra.Command) (func() error, error) {
+	cpuProfile, err := cmd.Flags().GetString(flagCPUProfile)
+	if err != nil {
+		return nil, err
+	}
+	memProfile, err := cmd.Flags().GetString(flagMemProfile)
+	if err != nil {
+		return nil, err
+	}
+	traceFile, err := cmd.Flags().GetString(flagTrace)
+	if err != nil {
+		return nil, err
+	}
+
+	var stops []func() error
+	stop := func() error {
+		var errs []error
+		for i := len(stops) - 1; i >= 0; i-- {
+			errs = append(errs, stops[i]())
+		}
+		return errors.Join(errs...)
+	}
+
+	if len(cpuProfile) > 0 {
+		fp, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, errors.Join(err, stop())
+		}
+		if err := pprof.StartCPUProfile(fp); err != nil {
+			return nil, errors.Join(err, fp.Close(), stop())
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return fp.Close()
+		})
+	}
+
+	if len(traceFile) > 0 {
+		fp, err := os.Create(traceFile)
+		if err != nil {
+			return nil, errors.Join(err, stop())
+		}
+		if err := trace.Start(fp); err != nil {
+			return nil, errors.Join(err, fp.Close(), stop())
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return fp.Close()
+		})
+	}
+
+	if len(memProfile) > 0 {
+		// create the file early to detect the errors before running the command.
+		fp, err := os.Create(memProfile)
+		if err != nil {
+			return nil, errors.Join(err, stop())
+		}
+		stops = append(stops, func() error {
+			// get up-to-date statistics
+			runtime.GC()
+			return errors.Join(pprof.WriteHeapProfile(fp), fp.Close())
+		})
+	}
+
+	return stop, nil
+}
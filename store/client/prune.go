@@ -0,0 +1,50 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+func PruneSnapshotsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune-snapshots <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Prune the old snapshots and WAL entries of memiavl db, the node must be stopped",
+		Long: `Prune the snapshots older than the current one, except the most recent ones specified by --keep,
+and truncate the WAL until the earliest remaining snapshot, it's useful when the automatic retention settings
+were misconfigured, use --dry-run to see what would be removed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keep, err := cmd.Flags().GetUint32(flagKeep)
+			if err != nil {
+				return err
+			}
+			dryRun, err := cmd.Flags().GetBool(flagDryRun)
+			if err != nil {
+				return err
+			}
+
+			result, err := memiavl.PruneSnapshots(args[0], keep, dryRun)
+			if err != nil {
+				return err
+			}
+
+			for _, version := range result.Snapshots {
+				fmt.Printf("snapshot: %d\n", version)
+			}
+			if result.WALEnd > 0 {
+				fmt.Printf("wal: %d-%d\n", result.WALStart, result.WALEnd)
+			}
+			fmt.Printf("reclaimed: snapshots %d bytes, wal %d bytes\n", result.SnapshotBytes, result.WALBytes)
+			if dryRun {
+				fmt.Println("dry run, nothing is removed")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Uint32(flagKeep, 1, "number of old snapshots to keep besides the current one")
+	cmd.Flags().Bool(flagDryRun, false, "list the snapshots and WAL range to remove without removing them")
+	return cmd
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+type storeReport struct {
+	Name       string `json:"name"`
+	Keys       int64  `json:"keys"`
+	Bytes      int64  `json:"bytes"`
+	DeltaKeys  int64  `json:"delta_keys"`
+	DeltaBytes int64  `json:"delta_bytes"`
+}
+
+type sizeReport struct {
+	Version     int64         `json:"version"`
+	BaseVersion *int64        `json:"base_version"`
+	Stores      []storeReport `json:"stores"`
+}
+
+func ReportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Report the key count and size of each store in a snapshot, and the growth since a previous snapshot",
+		Long: `Report the key count and the size of the snapshot files of each store in the snapshot of --version,
+default to the latest snapshot, and the delta since the snapshot of --base-version, default to the previous snapshot,
+so operators can see which module is driving the disk growth.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			version, err := cmd.Flags().GetInt64(flagVersion)
+			if err != nil {
+				return err
+			}
+			baseVersion, err := cmd.Flags().GetInt64(flagBaseVersion)
+			if err != nil {
+				return err
+			}
+			output, err := cmd.Flags().GetString(flagOutput)
+			if err != nil {
+				return err
+			}
+
+			versions, err := memiavl.SnapshotVersions(dir)
+			if err != nil {
+				return err
+			}
+			if len(versions) == 0 {
+				return fmt.Errorf("no snapshot found in %s", dir)
+			}
+			if version < 0 {
+				version = versions[len(versions)-1]
+			}
+			if baseVersion < 0 {
+				// the previous snapshot
+				for _, v := range versions {
+					if v < version {
+						baseVersion = v
+					}
+				}
+			}
+
+			report := sizeReport{Version: version}
+			stats, err := memiavl.SnapshotStats(dir, version)
+			if err != nil {
+				return err
+			}
+			base := make(map[string]memiavl.StoreStats)
+			if baseVersion >= 0 {
+				report.BaseVersion = &baseVersion
+				baseStats, err := memiavl.SnapshotStats(dir, baseVersion)
+				if err != nil {
+					return err
+				}
+				for _, s := range baseStats {
+					base[s.Name] = s
+				}
+			}
+			for _, s := range stats {
+				report.Stores = append(report.Stores, storeReport{
+					Name:       s.Name,
+					Keys:       s.Keys,
+					Bytes:      s.Bytes,
+					DeltaKeys:  s.Keys - base[s.Name].Keys,
+					DeltaBytes: s.Bytes - base[s.Name].Bytes,
+				})
+			}
+
+			switch output {
+			case "json":
+				return json.NewEncoder(os.Stdout).Encode(report)
+			case "text":
+				return report.print()
+			default:
+				return fmt.Errorf("unknown output format: %s", output)
+			}
+		},
+	}
+
+	cmd.Flags().Int64(flagVersion, -1, "the snapshot version to report, default to the latest snapshot")
+	cmd.Flags().Int64(flagBaseVersion, -1, "the snapshot version to compare with, default to the previous snapshot")
+	cmd.Flags().String(flagOutput, "text", "the output format, text or json")
+	return cmd
+}
+
+func (r *sizeReport) print() error {
+	if r.BaseVersion != nil {
+		fmt.Printf("version: %d, base version: %d\n", r.Version, *r.BaseVersion)
+	} else {
+		fmt.Printf("version: %d\n", r.Version)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "STORE\tKEYS\tBYTES\tΔKEYS\tΔBYTES\t")
+	var total storeReport
+	for _, s := range r.Stores {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%+d\t%+d\t\n", s.Name, s.Keys, s.Bytes, s.DeltaKeys, s.DeltaBytes)
+		total.Keys += s.Keys
+		total.Bytes += s.Bytes
+		total.DeltaKeys += s.DeltaKeys
+		total.DeltaBytes += s.DeltaBytes
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%+d\t%+d\t\n", "total", total.Keys, total.Bytes, total.DeltaKeys, total.DeltaBytes)
+	return w.Flush()
+}
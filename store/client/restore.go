@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+func RestoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Restore memiavl db from a local snapshot archive, the node must be stopped",
+		Long: `Restore memiavl db from a zstd compressed snapshot tarball, which contains a single snapshot directory and
+the manifest file listing the checksums of the files. The archive is extracted into a temporary directory inside the db
+directory, verified against the manifest, then the snapshot is moved into place with the "current" link and an empty
+WAL, so the node is ready to start. The temporary directory is removed on failure, the db must not exist yet.`,
+		RunE: func(cmd *cobra.Command, args []string) (returnErr error) {
+			dir := args[0]
+			fileName, err := cmd.Flags().GetString(flagFile)
+			if err != nil {
+				return err
+			}
+			if len(fileName) == 0 {
+				return fmt.Errorf("--%s is required", flagFile)
+			}
+
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+			// the temporary directory is also cleaned up by memiavl on startup if the process is killed.
+			tmpDir, err := os.MkdirTemp(dir, "restore-*"+memiavl.TmpSuffix)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := os.RemoveAll(tmpDir); returnErr == nil {
+					returnErr = err
+				}
+			}()
+
+			files, err := extractArchive(fileName, tmpDir)
+			if err != nil {
+				return fmt.Errorf("fail to extract archive: %w", err)
+			}
+			manifest, err := readManifest(filepath.Join(tmpDir, manifestFileName))
+			if err != nil {
+				return err
+			}
+			if err := verifyManifest(manifest, files); err != nil {
+				return err
+			}
+
+			version, err := memiavl.InstallSnapshot(dir, filepath.Join(tmpDir, fmt.Sprintf("snapshot-%020d", manifest.Version)))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("restored snapshot at version %d\n", version)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagFile, "", "the snapshot archive, a zstd compressed tarball")
+	return cmd
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+func SalvageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "salvage <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Remove the damaged snapshots of memiavl db and repair the db to the newest valid snapshot, the node must be stopped",
+		Long: `Detect the half-written temporary directories, and the snapshots which can't be loaded or fail the hash
+verification, remove them or move them into the --quarantine directory, repoint the "current" link to the newest valid
+snapshot, and truncate the corrupted tail of the WAL. If the WAL don't connect to the newest valid snapshot anymore,
+it's reset to start from the next version, the node then replays the later blocks on startup.
+Use --dry-run to see what would be done.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			quarantine, err := cmd.Flags().GetString(flagQuarantine)
+			if err != nil {
+				return err
+			}
+			skipHashes, err := cmd.Flags().GetBool(flagSkipHashes)
+			if err != nil {
+				return err
+			}
+			dryRun, err := cmd.Flags().GetBool(flagDryRun)
+			if err != nil {
+				return err
+			}
+
+			result, err := memiavl.Salvage(args[0], quarantine, !skipHashes, dryRun)
+			if err != nil {
+				return err
+			}
+
+			for _, damaged := range result.Damaged {
+				fmt.Printf("damaged: %s, %s\n", damaged.Name, damaged.Reason)
+			}
+			fmt.Printf("current snapshot: %d\n", result.Current)
+			if result.Repointed {
+				fmt.Println("current link repointed")
+			}
+			if result.WALTailTruncated {
+				fmt.Println("wal: corrupted tail truncated")
+			}
+			if result.WALReset {
+				fmt.Printf("wal: reset to start from version %d, the later blocks will be replayed\n", result.Current+1)
+			}
+			if dryRun {
+				fmt.Println("dry run, nothing is changed")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagQuarantine, "", "move the damaged snapshots into the directory instead of removing them")
+	cmd.Flags().Bool(flagSkipHashes, false, "only check the structure of the snapshot files without verifying the node hashes")
+	cmd.Flags().Bool(flagDryRun, false, "report the damages without changing anything")
+	return cmd
+}
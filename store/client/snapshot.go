@@ -0,0 +1,18 @@
+package client
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// SnapshotGroupCmd returns the command group to work with the snapshots of memiavl db.
+func SnapshotGroupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "work with the snapshots of memiavl db",
+	}
+	cmd.AddCommand(
+		withProfiling(ExportKVCmd()),
+		withProfiling(PackageCmd()),
+	)
+	return cmd
+}
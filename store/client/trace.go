@@ -0,0 +1,34 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+
+	"cosmossdk.io/log"
+)
+
+func ReplayTraceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay-trace <dir> <trace-file>",
+		Args:  cobra.ExactArgs(2),
+		Short: "Replay the inputs recorded in the trace file on a copy of memiavl db, and check the commit infos",
+		Long: `Replay the change sets, the upgrades and the commits recorded in the trace file by "memiavl.trace-file", on
+the states loaded from the db directory, which is never written, and compare the root hashes of each commit with the
+recorded ones. Exits with non-zero code on the first mismatch, which reproduces the non-deterministic app hash bugs.
+The snapshots of the versions the node is started at must be kept in the db, or reachable by replaying the WAL.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.NewLogger(cmd.ErrOrStderr())
+			result, err := memiavl.ReplayTrace(args[0], args[1], logger)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("replayed %d loads, %d change sets, %d upgrades, %d commits\n",
+				result.Loads, result.ChangeSets, result.Upgrades, result.Commits)
+			fmt.Printf("version: %d, app hash: %X\n", result.LastCommitInfo.Version, CommitInfoHash(result.LastCommitInfo))
+			return nil
+		},
+	}
+	return cmd
+}
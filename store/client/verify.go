@@ -0,0 +1,74 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+
+	"cosmossdk.io/log"
+	"cosmossdk.io/store/types"
+)
+
+func VerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Verify the snapshot hashes and replay the WAL to check the consistency of memiavl db, the node must be stopped",
+		Long: `Verify the hashes of all the nodes in all the snapshots, replay the WAL from the earliest snapshot and check
+the commit infos match the ones recorded in later snapshots, finally compare the app hash of the latest version with
+the expected one if --app-hash is given. Exits with non-zero code on any mismatch.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			expected, err := cmd.Flags().GetString(flagAppHash)
+			if err != nil {
+				return err
+			}
+
+			logger := log.NewLogger(cmd.ErrOrStderr())
+			commitInfo, err := memiavl.Verify(dir, logger)
+			if err != nil {
+				return err
+			}
+
+			appHash := CommitInfoHash(commitInfo)
+			fmt.Printf("version: %d, app hash: %X\n", commitInfo.Version, appHash)
+
+			if len(expected) == 0 {
+				return nil
+			}
+			expectedHash, err := hex.DecodeString(expected)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(appHash, expectedHash) {
+				return fmt.Errorf("app hash mismatch, expect %X, got %X", expectedHash, appHash)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagAppHash, "", "the expected hex encoded app hash of the latest version")
+	return cmd
+}
+
+// CommitInfoHash computes the app hash of the commit info, same as the rootmulti store.
+func CommitInfoHash(commitInfo *memiavl.CommitInfo) []byte {
+	storeInfos := make([]types.StoreInfo, len(commitInfo.StoreInfos))
+	for i, storeInfo := range commitInfo.StoreInfos {
+		storeInfos[i] = types.StoreInfo{
+			Name: storeInfo.Name,
+			CommitId: types.CommitID{
+				Version: storeInfo.CommitId.Version,
+				Hash:    storeInfo.CommitId.Hash,
+			},
+		}
+	}
+	info := types.CommitInfo{
+		Version:    commitInfo.Version,
+		StoreInfos: storeInfos,
+	}
+	return info.Hash()
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+// WALGroupCmd returns the command group to inspect the WAL of memiavl db.
+func WALGroupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wal",
+		Short: "inspect the WAL of memiavl db",
+	}
+	cmd.AddCommand(
+		TailCmd(),
+	)
+	return cmd
+}
+
+func TailCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tail <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Follow the WAL of memiavl db and print the state changes as they are committed",
+		Long: `Follow the WAL of memiavl db and print the state changes as they are committed, like "tail -f" for the
+state, until interrupted. It starts after the latest version by default, or from the version specified by --from.
+The WAL is never written, so it can run against the db of a running node.
+
+The changes are printed one per line:
+
+<version> <store> set <key> <value>
+<version> <store> delete <key>
+
+The keys and values are hex encoded, with --decode, the printable ones are printed as quoted strings instead.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			store, err := cmd.Flags().GetString(flagStore)
+			if err != nil {
+				return err
+			}
+			decode, err := cmd.Flags().GetBool(flagDecode)
+			if err != nil {
+				return err
+			}
+			from, err := cmd.Flags().GetInt64(flagFrom)
+			if err != nil {
+				return err
+			}
+			interval, err := cmd.Flags().GetDuration(flagInterval)
+			if err != nil {
+				return err
+			}
+
+			next := from
+			if next <= 0 {
+				latest, err := memiavl.LatestWALVersion(dir)
+				if err != nil {
+					return err
+				}
+				next = latest + 1
+			}
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			out := cmd.OutOrStdout()
+			for {
+				if err := memiavl.IterateWAL(dir, next, 0, func(version int64, entry *memiavl.WALEntry) (bool, error) {
+					printWALEntry(out, version, entry, store, decode)
+					next = version + 1
+					return ctx.Err() == nil, nil
+				}); err != nil && !errors.Is(err, memiavl.ErrWALTailCorrupt) {
+					// the corrupted tail could be an entry being written, retry in next poll
+					return err
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().String(flagStore, "", "only print the changes of the store")
+	cmd.Flags().Bool(flagDecode, false, "print the printable keys and values as quoted strings")
+	cmd.Flags().Int64(flagFrom, 0, "the version to start from, default to the next version")
+	cmd.Flags().Duration(flagInterval, 500*time.Millisecond, "the interval to poll the WAL for new entries")
+	return cmd
+}
+
+func printWALEntry(w io.Writer, version int64, entry *memiavl.WALEntry, store string, decode bool) {
+	for _, upgrade := range entry.Upgrades {
+		switch {
+		case upgrade.Delete:
+			fmt.Fprintf(w, "%d %s upgrade delete\n", version, upgrade.Name)
+		case len(upgrade.RenameFrom) > 0:
+			fmt.Fprintf(w, "%d %s upgrade rename-from %s\n", version, upgrade.Name, upgrade.RenameFrom)
+		default:
+			fmt.Fprintf(w, "%d %s upgrade add\n", version, upgrade.Name)
+		}
+	}
+	for _, cs := range entry.Changesets {
+		if len(store) > 0 && cs.Name != store {
+			continue
+		}
+		for _, pair := range cs.Changeset.Pairs {
+			if pair.Delete {
+				fmt.Fprintf(w, "%d %s delete %s\n", version, cs.Name, formatBytes(pair.Key, decode))
+			} else {
+				fmt.Fprintf(w, "%d %s set %s %s\n", version, cs.Name, formatBytes(pair.Key, decode), formatBytes(pair.Value, decode))
+			}
+		}
+	}
+}
+
+// formatBytes returns the hex encoding of the bytes, or the quoted string if decode is true and it's printable.
+func formatBytes(bz []byte, decode bool) string {
+	if decode && isPrintable(bz) {
+		return strconv.Quote(string(bz))
+	}
+	return hex.EncodeToString(bz)
+}
+
+func isPrintable(bz []byte) bool {
+	if !utf8.Valid(bz) {
+		return false
+	}
+	for _, r := range string(bz) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/crypto-org-chain/cronos/memiavl"
+import (
+	"time"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+)
 
 const DefaultCacheSize = 1000
 
@@ -14,6 +18,10 @@ type MemIAVLConfig struct {
 	// AsyncCommitBuffer defines the size of asynchronous commit queue, this greatly improve block catching-up
 	// performance, -1 means synchronous commit.
 	AsyncCommitBuffer int `mapstructure:"async-commit-buffer"`
+	// WaitDurableBeforeCommit defines if the commit waits for the WAL of the previous block to be persisted in async
+	// commit mode, so the WAL writing overlaps with the execution of the next block, and at most one block is lost
+	// and replayed on crash.
+	WaitDurableBeforeCommit bool `mapstructure:"wait-durable-before-commit"`
 	// SnapshotKeepRecent defines what many old snapshots (excluding the latest one) to keep after new snapshots are
 	// taken, defaults to 1 to make sure ibc relayers work.
 	SnapshotKeepRecent uint32 `mapstructure:"snapshot-keep-recent"`
@@ -21,6 +29,104 @@ type MemIAVLConfig struct {
 	SnapshotInterval uint32 `mapstructure:"snapshot-interval"`
 	// CacheSize defines the size of the cache for each memiavl store.
 	CacheSize int `mapstructure:"cache-size"`
+	// CacheShards defines the number of shards of the cache for each memiavl store, each shard has its own lock,
+	// 0 or 1 means a single shard.
+	CacheShards int `mapstructure:"cache-shards"`
+	// ShutdownTimeout defines the max duration to wait for the in-flight snapshot rewrite to complete on shutdown,
+	// it's cancelled after the timeout, default to 0.
+	ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout"`
+	// StreamingFileDir defines the directory to write the state changes committed in each block, disabled if empty.
+	StreamingFileDir string `mapstructure:"streaming-file-dir"`
+	// StreamingGRPCAddress defines the address of the remote `ABCIListenerService` to stream the state changes
+	// committed in each block to, disabled if empty.
+	StreamingGRPCAddress string `mapstructure:"streaming-grpc-address"`
+	// CommitmentOnly defines if the snapshots only store the value hashes, the raw values are served by versiondb,
+	// which must be enabled with synchronous writes.
+	CommitmentOnly bool `mapstructure:"commitment-only"`
+	// BulkLoadGenesis defines if the genesis state is written into the snapshot directly, bypassing the in-memory
+	// trees and the WAL, which speeds up the import of the large genesis states.
+	BulkLoadGenesis bool `mapstructure:"bulk-load-genesis"`
+	// NodesMmapAdvice and KVsMmapAdvice define the expected access patterns of the snapshot files, the nodes advice
+	// applies to the branch and leaf nodes files, the kvs advice applies to the key-value pairs file, one of: "normal",
+	// "random", "sequential", "willneed", default to "random" if empty.
+	NodesMmapAdvice string `mapstructure:"nodes-mmap-advice"`
+	KVsMmapAdvice   string `mapstructure:"kvs-mmap-advice"`
+	// PrefaultNodes defines if the nodes files are loaded into the page cache on startup.
+	PrefaultNodes bool `mapstructure:"prefault-nodes"`
+	// HugePageNodes defines if the nodes files are advised to be backed by the transparent huge pages, only supported
+	// on linux.
+	HugePageNodes bool `mapstructure:"huge-page-nodes"`
+	// MetricsAddress defines the address to serve the prometheus metrics of the memiavl internals, empty means disabled.
+	MetricsAddress string `mapstructure:"metrics-address"`
+	// TraceFile defines the file to record the inputs of the db for "cronosd memiavl replay-trace", empty means
+	// disabled, it's expensive, only enable it to debug the app hash mismatches.
+	TraceFile string `mapstructure:"trace-file"`
+	// WALStreamToken defines the token of the WAL stream service served on the grpc server for the standbys, empty
+	// means disabled, the WAL exposes the whole state, so the token is required, and the grpc server should be kept
+	// in a private network or behind a TLS proxy.
+	WALStreamToken string `mapstructure:"wal-stream-token"`
+	// QueryMetrics defines if the gets and iterators of each store and their latencies are reported in the metrics.
+	QueryMetrics bool `mapstructure:"query-metrics"`
+	// StatusInterval defines the interval to write the status.json file into the memiavl directory, 0 means disabled.
+	StatusInterval time.Duration `mapstructure:"status-interval"`
+	// SnapshotEventLog defines the JSONL file to append the snapshot lifecycle events to, empty means disabled.
+	SnapshotEventLog string `mapstructure:"snapshot-event-log"`
+	// SlowCommitThreshold defines the duration of the commits to be logged as slow, 0 means disabled.
+	SlowCommitThreshold time.Duration `mapstructure:"slow-commit-threshold"`
+	// SlowCommitPhaseThreshold defines the duration of any phase of the commits to be logged as slow, 0 means disabled.
+	SlowCommitPhaseThreshold time.Duration `mapstructure:"slow-commit-phase-threshold"`
+	// LogLevelWAL, LogLevelSnapshot, LogLevelPrune and LogLevelCommit define the minimum log levels of the
+	// subsystems, one of "debug", "info", "error" and "none", empty means all the logs are passed to the node logger.
+	LogLevelWAL      string `mapstructure:"log-level-wal"`
+	LogLevelSnapshot string `mapstructure:"log-level-snapshot"`
+	LogLevelPrune    string `mapstructure:"log-level-prune"`
+	LogLevelCommit   string `mapstructure:"log-level-commit"`
+	// NodeArena defines if the new tree nodes are allocated in chunks to reduce the GC pauses, the replaced nodes are
+	// only freed after the next snapshot switch, so the memory usage is higher.
+	NodeArena bool `mapstructure:"node-arena"`
+	// CompactNodes defines if the new leaf nodes don't retain their hashes and share the key prefixes with the
+	// neighbor keys, which lowers the memory usage between the snapshot rewrites at the cost of rehashing the leaves.
+	CompactNodes bool `mapstructure:"compact-nodes"`
+	// HashConcurrency defines the max number of goroutines to compute the root hash of each store in commit, 0 or 1
+	// means sequential.
+	HashConcurrency int `mapstructure:"hash-concurrency"`
+	// WALReplayConcurrency defines the max number of stores to apply the change sets concurrently when replaying the
+	// WAL on startup, 0 or 1 means sequential.
+	WALReplayConcurrency int `mapstructure:"wal-replay-concurrency"`
+	// MemoryBudgetBytes defines the approximate memory usage of the in-memory nodes and the caches to trigger a
+	// snapshot rewrite ahead of the snapshot interval, 0 means disabled.
+	MemoryBudgetBytes uint64 `mapstructure:"memory-budget-bytes"`
+	// MinFreeDiskBytes defines the free disk space below which the snapshot rewrites are refused, 0 means disabled.
+	MinFreeDiskBytes uint64 `mapstructure:"min-free-disk-bytes"`
+	// WALLagAlertThreshold defines the number of committed versions not persisted yet in async commit mode, above which
+	// a warning is logged and the db is reported unhealthy, 0 means disabled.
+	WALLagAlertThreshold uint64 `mapstructure:"wal-lag-alert-threshold"`
+	// SnapshotWriteBufferSize defines the write buffer size of each snapshot file, default to 4KiB if zero.
+	SnapshotWriteBufferSize int `mapstructure:"snapshot-write-buffer-size"`
+	// PreallocateSnapshot defines if the snapshot files are preallocated to their estimated sizes before writing,
+	// only supported on linux.
+	PreallocateSnapshot bool `mapstructure:"preallocate-snapshot"`
+	// BlobThreshold defines the size above which the values are stored in a separate blobs file of the snapshots,
+	// deduplicated by the hashes, 0 means disabled.
+	BlobThreshold int `mapstructure:"blob-threshold"`
+	// PrefixCompressKeys defines if the keys in the kvs file of the snapshots are prefix compressed.
+	PrefixCompressKeys bool `mapstructure:"prefix-compress-keys"`
+	// CachePrewarmSize defines the number of the most recently accessed keys of each store to pre-warm the caches
+	// after the snapshot switch, 0 means disabled.
+	CachePrewarmSize int `mapstructure:"cache-prewarm-size"`
+	// CacheAdvisorWindow defines the number of blocks to record the unique keys read from each store, the working set
+	// and the recommended cache-size are logged at the end of each window, 0 means disabled.
+	CacheAdvisorWindow uint32 `mapstructure:"cache-advisor-window"`
+	// SnapshotMaxReplayTime defines the max estimated time to replay the WAL on restart, the snapshot is rewritten
+	// when it's exceeded instead of every snapshot-interval blocks, 0 means disabled.
+	SnapshotMaxReplayTime time.Duration `mapstructure:"snapshot-max-replay-time"`
+	// RewriteStallTimeout defines the duration without progress to cancel the background snapshot rewrite, 0 means
+	// disabled, RetryStalledRewrite defines if the cancelled rewrite is retried on the next block.
+	RewriteStallTimeout time.Duration `mapstructure:"rewrite-stall-timeout"`
+	RetryStalledRewrite bool          `mapstructure:"retry-stalled-rewrite"`
+	// CheckAppHash defines if the app hash of the loaded version is checked against the one recorded by CometBFT on
+	// startup, the root hashes of the stores are always checked against the persisted commit info.
+	CheckAppHash bool `mapstructure:"check-app-hash"`
 }
 
 func DefaultMemIAVLConfig() MemIAVLConfig {
@@ -20,6 +20,11 @@ zero-copy = {{ .MemIAVL.ZeroCopy }}
 # performance, -1 means synchronous commit.
 async-commit-buffer = {{ .MemIAVL.AsyncCommitBuffer }}
 
+# WaitDurableBeforeCommit defines if the commit waits for the WAL of the previous block to be persisted in async
+# commit mode, so the WAL writing overlaps with the execution of the next block, and at most one block is lost
+# and replayed on crash.
+wait-durable-before-commit = {{ .MemIAVL.WaitDurableBeforeCommit }}
+
 # SnapshotKeepRecent defines what many old snapshots (excluding the latest one) to keep after new snapshots are
 # taken, defaults to 1 to make sure ibc relayers work.
 snapshot-keep-recent = {{ .MemIAVL.SnapshotKeepRecent }}
@@ -27,6 +32,141 @@ snapshot-keep-recent = {{ .MemIAVL.SnapshotKeepRecent }}
 # SnapshotInterval defines the block interval the memiavl snapshot is taken, default to 1000.
 snapshot-interval = {{ .MemIAVL.SnapshotInterval }}
 
+# NOTE: if pruning = "custom", the snapshot-interval and snapshot-keep-recent are derived from pruning-interval and
+# pruning-keep-recent instead.
+
 # CacheSize defines the size of the cache for each memiavl store, default to 1000.
 cache-size = {{ .MemIAVL.CacheSize }}
+
+# CacheShards defines the number of shards of the cache for each memiavl store, each shard has its own lock,
+# 0 or 1 means a single shard.
+cache-shards = {{ .MemIAVL.CacheShards }}
+
+# ShutdownTimeout defines the max duration to wait for the in-flight snapshot rewrite to complete on shutdown,
+# it's cancelled after the timeout, default to 0.
+shutdown-timeout = "{{ .MemIAVL.ShutdownTimeout }}"
+
+# StreamingFileDir defines the directory to write the state changes committed in each block, disabled if empty.
+streaming-file-dir = "{{ .MemIAVL.StreamingFileDir }}"
+
+# StreamingGRPCAddress defines the address of the remote ABCIListenerService to stream the state changes
+# committed in each block to, disabled if empty.
+streaming-grpc-address = "{{ .MemIAVL.StreamingGRPCAddress }}"
+
+# CommitmentOnly defines if the snapshots only store the value hashes, the raw values are served by versiondb,
+# which must be enabled with synchronous writes.
+commitment-only = {{ .MemIAVL.CommitmentOnly }}
+
+# BulkLoadGenesis defines if the genesis state is written into the snapshot directly, bypassing the in-memory
+# trees and the WAL, which speeds up the import of the large genesis states.
+bulk-load-genesis = {{ .MemIAVL.BulkLoadGenesis }}
+
+# NodesMmapAdvice and KVsMmapAdvice define the expected access patterns of the snapshot files, the nodes advice
+# applies to the branch and leaf nodes files, the kvs advice applies to the key-value pairs file, one of: "normal",
+# "random", "sequential", "willneed", default to "random" if empty.
+nodes-mmap-advice = "{{ .MemIAVL.NodesMmapAdvice }}"
+kvs-mmap-advice = "{{ .MemIAVL.KVsMmapAdvice }}"
+
+# PrefaultNodes defines if the nodes files are loaded into the page cache on startup.
+prefault-nodes = {{ .MemIAVL.PrefaultNodes }}
+
+# HugePageNodes defines if the nodes files are advised to be backed by the transparent huge pages, only supported
+# on linux.
+huge-page-nodes = {{ .MemIAVL.HugePageNodes }}
+
+# MetricsAddress defines the address to serve the prometheus metrics of the memiavl internals, empty means disabled.
+metrics-address = "{{ .MemIAVL.MetricsAddress }}"
+
+# TraceFile defines the file to record the inputs of the db for "cronosd memiavl replay-trace", empty means
+# disabled, it's expensive, only enable it to debug the app hash mismatches.
+trace-file = "{{ .MemIAVL.TraceFile }}"
+
+# WALStreamToken defines the token of the WAL stream service served on the grpc server for the standbys, empty
+# means disabled, the WAL exposes the whole state, so the token is required, and the grpc server should be kept
+# in a private network or behind a TLS proxy.
+wal-stream-token = "{{ .MemIAVL.WALStreamToken }}"
+
+# QueryMetrics defines if the gets and iterators of each store and their latencies are reported in the metrics.
+query-metrics = {{ .MemIAVL.QueryMetrics }}
+
+# StatusInterval defines the interval to write the status.json file into the memiavl directory, 0 means disabled.
+status-interval = "{{ .MemIAVL.StatusInterval }}"
+
+# SnapshotEventLog defines the JSONL file to append the snapshot lifecycle events to, empty means disabled.
+snapshot-event-log = "{{ .MemIAVL.SnapshotEventLog }}"
+
+# SlowCommitThreshold defines the duration of the commits to be logged as slow, 0 means disabled.
+slow-commit-threshold = "{{ .MemIAVL.SlowCommitThreshold }}"
+
+# SlowCommitPhaseThreshold defines the duration of any phase of the commits to be logged as slow, 0 means disabled.
+slow-commit-phase-threshold = "{{ .MemIAVL.SlowCommitPhaseThreshold }}"
+
+# LogLevelWAL, LogLevelSnapshot, LogLevelPrune and LogLevelCommit define the minimum log levels of the
+# subsystems, one of "debug", "info", "error" and "none", empty means all the logs are passed to the node logger.
+log-level-wal = "{{ .MemIAVL.LogLevelWAL }}"
+log-level-snapshot = "{{ .MemIAVL.LogLevelSnapshot }}"
+log-level-prune = "{{ .MemIAVL.LogLevelPrune }}"
+log-level-commit = "{{ .MemIAVL.LogLevelCommit }}"
+
+# NodeArena defines if the new tree nodes are allocated in chunks to reduce the GC pauses, the replaced nodes are
+# only freed after the next snapshot switch, so the memory usage is higher.
+node-arena = {{ .MemIAVL.NodeArena }}
+
+# CompactNodes defines if the new leaf nodes don't retain their hashes and share the key prefixes with the
+# neighbor keys, which lowers the memory usage between the snapshot rewrites at the cost of rehashing the leaves.
+compact-nodes = {{ .MemIAVL.CompactNodes }}
+
+# HashConcurrency defines the max number of goroutines to compute the root hash of each store in commit, 0 or 1
+# means sequential.
+hash-concurrency = {{ .MemIAVL.HashConcurrency }}
+
+# WALReplayConcurrency defines the max number of stores to apply the change sets concurrently when replaying the
+# WAL on startup, 0 or 1 means sequential.
+wal-replay-concurrency = {{ .MemIAVL.WALReplayConcurrency }}
+
+# MemoryBudgetBytes defines the approximate memory usage of the in-memory nodes and the caches to trigger a
+# snapshot rewrite ahead of the snapshot interval, 0 means disabled.
+memory-budget-bytes = {{ .MemIAVL.MemoryBudgetBytes }}
+
+# MinFreeDiskBytes defines the free disk space below which the snapshot rewrites are refused, 0 means disabled.
+min-free-disk-bytes = {{ .MemIAVL.MinFreeDiskBytes }}
+
+# WALLagAlertThreshold defines the number of committed versions not persisted yet in async commit mode, above which
+# a warning is logged and the db is reported unhealthy, 0 means disabled.
+wal-lag-alert-threshold = {{ .MemIAVL.WALLagAlertThreshold }}
+
+# SnapshotWriteBufferSize defines the write buffer size of each snapshot file, default to 4KiB if zero.
+snapshot-write-buffer-size = {{ .MemIAVL.SnapshotWriteBufferSize }}
+
+# PreallocateSnapshot defines if the snapshot files are preallocated to their estimated sizes before writing,
+# only supported on linux.
+preallocate-snapshot = {{ .MemIAVL.PreallocateSnapshot }}
+
+# BlobThreshold defines the size above which the values are stored in a separate blobs file of the snapshots,
+# deduplicated by the hashes, 0 means disabled.
+blob-threshold = {{ .MemIAVL.BlobThreshold }}
+
+# PrefixCompressKeys defines if the keys in the kvs file of the snapshots are prefix compressed.
+prefix-compress-keys = {{ .MemIAVL.PrefixCompressKeys }}
+
+# CachePrewarmSize defines the number of the most recently accessed keys of each store to pre-warm the caches
+# after the snapshot switch, 0 means disabled.
+cache-prewarm-size = {{ .MemIAVL.CachePrewarmSize }}
+
+# CacheAdvisorWindow defines the number of blocks to record the unique keys read from each store, the working set
+# and the recommended cache-size are logged at the end of each window, 0 means disabled.
+cache-advisor-window = {{ .MemIAVL.CacheAdvisorWindow }}
+
+# SnapshotMaxReplayTime defines the max estimated time to replay the WAL on restart, the snapshot is rewritten
+# when it's exceeded instead of every snapshot-interval blocks, 0 means disabled.
+snapshot-max-replay-time = "{{ .MemIAVL.SnapshotMaxReplayTime }}"
+
+# RewriteStallTimeout defines the duration without progress to cancel the background snapshot rewrite, 0 means
+# disabled, RetryStalledRewrite defines if the cancelled rewrite is retried on the next block.
+rewrite-stall-timeout = "{{ .MemIAVL.RewriteStallTimeout }}"
+retry-stalled-rewrite = {{ .MemIAVL.RetryStalledRewrite }}
+
+# CheckAppHash defines if the app hash of the loaded version is checked against the one recorded by CometBFT on
+# startup, the root hashes of the stores are always checked against the persisted commit info.
+check-app-hash = {{ .MemIAVL.CheckAppHash }}
 `
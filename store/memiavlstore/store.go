@@ -29,6 +29,8 @@ var (
 type Store struct {
 	tree   *memiavl.Tree
 	logger log.Logger
+	// storage serves the raw values in commitment-only mode, the tree only stores the value hashes.
+	storage types.KVStore
 
 	changeSet memiavl.ChangeSet
 }
@@ -41,6 +43,12 @@ func (st *Store) SetTree(tree *memiavl.Tree) {
 	st.tree = tree
 }
 
+// SetStorage sets the storage which serves the raw values of the store in commitment-only mode, the reads are
+// delegated to it, the tree is only used to compute the root hash and the merkle proofs.
+func (st *Store) SetStorage(storage types.KVStore) {
+	st.storage = storage
+}
+
 func (st *Store) Commit() types.CommitID {
 	panic("memiavl store is not supposed to be committed alone")
 }
@@ -89,11 +97,17 @@ func (st *Store) Set(key, value []byte) {
 
 // Get Implements types.KVStore.
 func (st *Store) Get(key []byte) []byte {
+	if st.storage != nil {
+		return st.storage.Get(key)
+	}
 	return st.tree.Get(key)
 }
 
 // Has Implements types.KVStore.
 func (st *Store) Has(key []byte) bool {
+	if st.storage != nil {
+		return st.storage.Has(key)
+	}
 	return st.tree.Has(key)
 }
 
@@ -106,11 +120,32 @@ func (st *Store) Delete(key []byte) {
 }
 
 func (st *Store) Iterator(start, end []byte) types.Iterator {
-	return st.tree.Iterator(start, end, true)
+	if st.storage != nil {
+		return st.storage.Iterator(start, end)
+	}
+	return &pooledIterator{st.tree.Iterator(start, end, true)}
 }
 
 func (st *Store) ReverseIterator(start, end []byte) types.Iterator {
-	return st.tree.Iterator(start, end, false)
+	if st.storage != nil {
+		return st.storage.ReverseIterator(start, end)
+	}
+	return &pooledIterator{st.tree.Iterator(start, end, false)}
+}
+
+// pooledIterator releases the memiavl iterator into the pool on close, the reference is dropped after that, so closing
+// it again don't release the iterator reused by others.
+type pooledIterator struct {
+	*memiavl.Iterator
+}
+
+func (it *pooledIterator) Close() error {
+	if it.Iterator == nil {
+		return nil
+	}
+	memiavl.ReleaseIterator(it.Iterator)
+	it.Iterator = nil
+	return nil
 }
 
 // SetInitialVersion sets the initial version of the IAVL tree. It is used when
@@ -143,14 +178,14 @@ func (st *Store) Query(req *types.RequestQuery) (res *types.ResponseQuery, err e
 	switch req.Path {
 	case "/key": // get by key
 		res.Key = req.Data // data holds the key bytes
-		res.Value = st.tree.Get(res.Key)
+		res.Value = st.Get(res.Key)
 
 		if !req.Prove {
 			break
 		}
 
 		// get proof from tree and convert to merkle.Proof before adding to result
-		res.ProofOps = getProofFromTree(st.tree, req.Data, res.Value != nil)
+		res.ProofOps = getProofFromTree(st.tree, st.storage, req.Data, res.Value != nil)
 	case "/subspace":
 		pairs := memiavl.Pairs{
 			Pairs: make([]memiavl.Pair, 0),
@@ -188,7 +223,9 @@ func (st *Store) WorkingHash() []byte {
 // Takes a MutableTree, a key, and a flag for creating existence or absence proof and returns the
 // appropriate merkle.Proof. Since this must be called after querying for the value, this function should never error
 // Thus, it will panic on error rather than returning it
-func getProofFromTree(tree *memiavl.Tree, key []byte, exists bool) *cmtprotocrypto.ProofOps {
+//
+// If the storage is not nil, the tree is commitment-only, the values in the proof are replaced by the raw ones.
+func getProofFromTree(tree *memiavl.Tree, storage types.KVStore, key []byte, exists bool) *cmtprotocrypto.ProofOps {
 	var (
 		commitmentProof *ics23.CommitmentProof
 		err             error
@@ -210,6 +247,27 @@ func getProofFromTree(tree *memiavl.Tree, key []byte, exists bool) *cmtprotocryp
 		}
 	}
 
+	if storage != nil {
+		fillProofValues(commitmentProof, storage)
+	}
+
 	op := types.NewIavlCommitmentOp(key, commitmentProof)
 	return &cmtprotocrypto.ProofOps{Ops: []cmtprotocrypto.ProofOp{op.ProofOp()}}
 }
+
+// fillProofValues replaces the leaf values in the proof with the raw values in the storage, the leaves of a
+// commitment-only tree only keep the value hashes, but the ics23 verifier hashes the value itself.
+func fillProofValues(proof *ics23.CommitmentProof, storage types.KVStore) {
+	fill := func(ep *ics23.ExistenceProof) {
+		if ep != nil {
+			ep.Value = storage.Get(ep.Key)
+		}
+	}
+	switch p := proof.Proof.(type) {
+	case *ics23.CommitmentProof_Exist:
+		fill(p.Exist)
+	case *ics23.CommitmentProof_Nonexist:
+		fill(p.Nonexist.Left)
+		fill(p.Nonexist.Right)
+	}
+}
@@ -0,0 +1,36 @@
+package memiavlstore
+
+import (
+	"testing"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/log"
+)
+
+func TestIteratorCloseTwice(t *testing.T) {
+	tree := memiavl.New(0)
+	tree.ApplyChangeSet(memiavl.ChangeSet{Pairs: []*memiavl.KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+	}})
+	_, _, err := tree.SaveVersion(true)
+	require.NoError(t, err)
+	store := New(tree, log.NewNopLogger())
+
+	iter := store.Iterator(nil, nil)
+	require.NoError(t, iter.Close())
+	require.NoError(t, iter.Close())
+
+	// the iterators after the double close are not shared
+	iter1 := store.Iterator(nil, nil)
+	iter2 := store.ReverseIterator(nil, nil)
+	require.Equal(t, []byte("a"), iter1.Key())
+	require.Equal(t, []byte("b"), iter2.Key())
+	iter1.Next()
+	require.Equal(t, []byte("b"), iter1.Key())
+	require.Equal(t, []byte("b"), iter2.Key())
+	require.NoError(t, iter1.Close())
+	require.NoError(t, iter2.Close())
+}
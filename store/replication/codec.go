@@ -0,0 +1,39 @@
+package replication
+
+import (
+	"encoding"
+	"fmt"
+
+	grpcencoding "google.golang.org/grpc/encoding"
+)
+
+// codecName is the content-subtype of the replication service, the messages are encoded in simple binary formats,
+// so the service don't need the generated protobuf code.
+const codecName = "memiavl-raw"
+
+func init() {
+	grpcencoding.RegisterCodec(rawCodec{})
+}
+
+// rawCodec encodes the messages implementing the `encoding.BinaryMarshaler` and `encoding.BinaryUnmarshaler`.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type: %T", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("unsupported message type: %T", v)
+	}
+	return m.UnmarshalBinary(data)
+}
+
+func (rawCodec) Name() string {
+	return codecName
+}
@@ -0,0 +1,207 @@
+package replication
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName     = "memiavl.replication.WALStream"
+	subscribeMethod = "/" + serviceName + "/Subscribe"
+
+	// DefaultPollInterval is the interval to check the new entries in the WAL of the primary.
+	DefaultPollInterval = 100 * time.Millisecond
+
+	// authorizationHeader carries the bearer token of the subscribers.
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+var errEmptyToken = errors.New("the wal stream requires a token")
+
+// SubscribeRequest subscribes the WAL entries starting from the version.
+type SubscribeRequest struct {
+	StartVersion int64
+}
+
+func (r *SubscribeRequest) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(r.StartVersion)), nil
+}
+
+func (r *SubscribeRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("invalid subscribe request length: %d", len(data))
+	}
+	r.StartVersion = int64(binary.BigEndian.Uint64(data))
+	return nil
+}
+
+// WALMessage is a WAL entry of a version, the entry is the protobuf encoded `memiavl.WALEntry`.
+type WALMessage struct {
+	Version int64
+	Entry   []byte
+}
+
+func (m *WALMessage) MarshalBinary() ([]byte, error) {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(m.Version)), m.Entry...), nil
+}
+
+func (m *WALMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("invalid wal message length: %d", len(data))
+	}
+	m.Version = int64(binary.BigEndian.Uint64(data))
+	m.Entry = data[8:]
+	return nil
+}
+
+// walStreamHandler is the handler type of the service.
+type walStreamHandler interface {
+	subscribe(req *SubscribeRequest, stream grpc.ServerStream) error
+}
+
+var walStreamDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*walStreamHandler)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Subscribe",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				var req SubscribeRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return srv.(walStreamHandler).subscribe(&req, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+}
+
+// RegisterWALStreamServer registers the service streaming the WAL entries of the memiavl db in the directory,
+// the db is written by the node in the same process or another one, the WAL is polled for the new entries.
+// The WAL exposes the whole state, the subscribers must present the token, see `TokenCredentials`.
+func RegisterWALStreamServer(s grpc.ServiceRegistrar, dir string, pollInterval time.Duration, token string) error {
+	if len(token) == 0 {
+		return errEmptyToken
+	}
+	s.RegisterService(&walStreamDesc, &walStreamServer{dir: dir, pollInterval: pollInterval, token: token})
+	return nil
+}
+
+type walStreamServer struct {
+	dir          string
+	pollInterval time.Duration
+	token        string
+}
+
+// authenticate checks the bearer token in the metadata of the stream.
+func (s *walStreamServer) authenticate(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	expected := []byte(bearerPrefix + s.token)
+	for _, value := range md.Get(authorizationHeader) {
+		if subtle.ConstantTimeCompare([]byte(value), expected) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid wal stream token")
+}
+
+func (s *walStreamServer) subscribe(req *SubscribeRequest, stream grpc.ServerStream) error {
+	if err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+
+	next := req.StartVersion
+	for {
+		if err := memiavl.IterateWAL(s.dir, next, 0, func(version int64, entry *memiavl.WALEntry) (bool, error) {
+			bz, err := entry.Marshal()
+			if err != nil {
+				return false, err
+			}
+			if err := stream.SendMsg(&WALMessage{Version: version, Entry: bz}); err != nil {
+				return false, err
+			}
+			next = version + 1
+			return true, nil
+		}); err != nil && !errors.Is(err, memiavl.ErrWALTailCorrupt) {
+			// the corrupted tail could be an entry being written, retry in next poll
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// tokenCredentials attaches the bearer token to the requests.
+type tokenCredentials struct {
+	token      string
+	requireTLS bool
+}
+
+// TokenCredentials returns the per-RPC credentials presenting the token to the WAL stream service, if `requireTLS` is
+// false, the token is allowed to be sent over the plaintext connections, for example in a private network.
+func TokenCredentials(token string, requireTLS bool) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token, requireTLS: requireTLS}
+}
+
+func (c tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{authorizationHeader: bearerPrefix + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
+
+// Subscribe receives the WAL entries from the primary starting from the version, until the context is canceled
+// or the stream is broken.
+func Subscribe(
+	ctx context.Context,
+	conn grpc.ClientConnInterface,
+	startVersion int64,
+	fn func(version int64, entry *memiavl.WALEntry) error,
+) error {
+	stream, err := conn.NewStream(ctx, &walStreamDesc.Streams[0], subscribeMethod, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&SubscribeRequest{StartVersion: startVersion}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var msg WALMessage
+		if err := stream.RecvMsg(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		entry, err := memiavl.DecodeWALEntry(msg.Entry)
+		if err != nil {
+			return fmt.Errorf("decode wal entry %d failed, %w", msg.Version, err)
+		}
+		if err := fn(msg.Version, entry); err != nil {
+			return err
+		}
+	}
+}
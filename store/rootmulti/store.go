@@ -1,11 +1,15 @@
 package rootmulti
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	dbm "github.com/cosmos/cosmos-db"
 	"github.com/crypto-org-chain/cronos/memiavl"
@@ -32,6 +36,10 @@ var (
 	_ types.Queryable        = (*Store)(nil)
 )
 
+// StorageFunc returns the storage of the raw values of the store at the version, nil means the latest version,
+// it's used in the commitment-only mode, for example, the versiondb.
+type StorageFunc func(name string, version *int64) types.KVStore
+
 type Store struct {
 	dir    string
 	db     *memiavl.DB
@@ -45,7 +53,23 @@ type Store struct {
 	stores       map[types.StoreKey]types.CommitStore
 	listeners    map[types.StoreKey]*types.MemoryListener
 
+	// the change sets of the listened stores written to memiavl in current block,
+	// they are exactly what's committed, popped by PopStateCache after commit.
+	changeSets []*memiavl.NamedChangeSet
+
 	opts memiavl.Options
+	// the max duration to wait for the in-flight snapshot rewrite on close
+	shutdownTimeout time.Duration
+	// serves the raw values in commitment-only mode
+	storage StorageFunc
+	// serves the queries at the heights pruned in memiavl, see `SetHistoricalQuerier`
+	historicalQuerier types.Queryable
+	// write the genesis state into the snapshot directly, see `memiavl.DB.BulkLoad`
+	bulkLoadGenesis bool
+	// the working version is committed by the bulk load already
+	bulkLoaded bool
+	// returns the app hash of the version recorded externally, nil if unknown, see `SetExpectedAppHash`
+	expectedAppHash func(version int64) ([]byte, error)
 
 	// sdk46Compact defines if the root hash is compatible with cosmos-sdk 0.46 and before.
 	sdk46Compact bool
@@ -87,6 +111,21 @@ func (rs *Store) flush() error {
 		return changeSets[i].Name < changeSets[j].Name
 	})
 
+	for _, cs := range changeSets {
+		if rs.ListeningEnabled(rs.keysByName[cs.Name]) {
+			rs.changeSets = append(rs.changeSets, cs)
+		}
+	}
+
+	if rs.bulkLoadGenesis && rs.db.Version() == 0 {
+		// the genesis state is committed when the app hash is computed, before the `Commit` call
+		if _, err := rs.db.BulkLoad(changeSets); err != nil {
+			return err
+		}
+		rs.bulkLoaded = true
+		return nil
+	}
+
 	return rs.db.ApplyChangeSets(changeSets)
 }
 
@@ -116,16 +155,26 @@ func (rs *Store) Commit() types.CommitID {
 		}
 	}
 
-	_, err := rs.db.Commit()
-	if err != nil {
+	if rs.bulkLoaded {
+		rs.bulkLoaded = false
+	} else if _, err := rs.db.Commit(); err != nil {
 		panic(err)
 	}
 
-	// the underlying memiavl tree might be reloaded, update the tree.
+	rs.updateTrees()
+	return rs.lastCommitInfo.CommitID()
+}
+
+// updateTrees updates the trees of the stores and the commit info after a new version,
+// the underlying memiavl trees might be reloaded.
+func (rs *Store) updateTrees() {
 	for key := range rs.stores {
 		store := rs.stores[key]
-		if store.GetStoreType() == types.StoreTypeIAVL {
-			store.(*memiavlstore.Store).SetTree(rs.db.TreeByName(key.Name()))
+		if store.GetStoreType() != types.StoreTypeIAVL {
+			continue
+		}
+		if tree := rs.db.TreeByName(key.Name()); tree != nil {
+			store.(*memiavlstore.Store).SetTree(tree)
 		}
 	}
 
@@ -133,11 +182,47 @@ func (rs *Store) Commit() types.CommitID {
 	if rs.sdk46Compact {
 		rs.lastCommitInfo = amendCommitInfo(rs.lastCommitInfo, rs.storesParams)
 	}
-	return rs.lastCommitInfo.CommitID()
 }
 
+// CatchupWAL applies the versions committed by the primary to the read-only store of a hot standby,
+// see `memiavl.DB.CatchupWAL`.
+func (rs *Store) CatchupWAL() error {
+	if err := rs.db.CatchupWAL(); err != nil {
+		return err
+	}
+	rs.updateTrees()
+	return nil
+}
+
+// Close closes the db, the in-flight snapshot rewrite is waited for the shutdown timeout before cancelled.
 func (rs *Store) Close() error {
-	return rs.db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), rs.shutdownTimeout)
+	defer cancel()
+	return rs.db.Shutdown(ctx)
+}
+
+// SetShutdownTimeout sets the max duration to wait for the in-flight snapshot rewrite on close.
+func (rs *Store) SetShutdownTimeout(timeout time.Duration) {
+	rs.shutdownTimeout = timeout
+}
+
+// SetExpectedAppHash sets the source of the app hashes recorded externally, for example by CometBFT, the loaded version
+// is checked against it, so a node with the corrupted state fails to start rather than forking immediately. The
+// function returns nil if the app hash of the version is unknown.
+func (rs *Store) SetExpectedAppHash(fn func(version int64) ([]byte, error)) {
+	rs.expectedAppHash = fn
+}
+
+// SetSnapshotWriterLimit changes the max number of trees written in parallel by the snapshot rewrites at runtime,
+// for example on the config reload, see `memiavl.DB.SetSnapshotWriterLimit`.
+func (rs *Store) SetSnapshotWriterLimit(limit int) int {
+	return rs.db.SetSnapshotWriterLimit(limit)
+}
+
+// SetBulkLoadGenesis sets if the genesis state is written into the memiavl snapshot directly, it's much faster to
+// import the large genesis states, the app hash is the same.
+func (rs *Store) SetBulkLoadGenesis(bulkLoadGenesis bool) {
+	rs.bulkLoadGenesis = bulkLoadGenesis
 }
 
 // LastCommitID Implements interface Committer
@@ -189,7 +274,8 @@ func (rs *Store) CacheMultiStore() types.CacheMultiStore {
 		if kv, ok := store.(types.KVStore); ok {
 			// Wire the listenkv.Store to allow listeners to observe the writes from the cache store,
 			// set same listeners on cache store will observe duplicated writes.
-			if rs.ListeningEnabled(k) {
+			// The writes of memiavl stores are observed from the committed change sets instead.
+			if rs.ListeningEnabled(k) && v.GetStoreType() != types.StoreTypeIAVL {
 				store = listenkv.NewStore(kv, k, rs.listeners[k])
 			}
 		}
@@ -200,14 +286,17 @@ func (rs *Store) CacheMultiStore() types.CacheMultiStore {
 
 // CacheMultiStoreWithVersion Implements interface MultiStore
 // used to createQueryContext, abci_query or grpc query service.
+//
+// The historical version is loaded from the nearest snapshot and the WAL in read-only mode, the returned store
+// implements io.Closer to release the loaded db after the query.
 func (rs *Store) CacheMultiStoreWithVersion(version int64) (types.CacheMultiStore, error) {
 	if version == 0 || (rs.lastCommitInfo != nil && version == rs.lastCommitInfo.Version) {
+		if rs.opts.ReadOnly {
+			return rs.cacheMultiStoreWithView()
+		}
 		return rs.CacheMultiStore(), nil
 	}
-	opts := rs.opts
-	opts.TargetVersion = uint32(version)
-	opts.ReadOnly = true
-	db, err := memiavl.Load(rs.dir, opts)
+	db, err := rs.loadHistoricalVersion(version)
 	if err != nil {
 		return nil, err
 	}
@@ -221,12 +310,49 @@ func (rs *Store) CacheMultiStoreWithVersion(version int64) (types.CacheMultiStor
 		}
 	}
 
-	// add all the iavl stores at the target version.
+	// add all the iavl stores at the target version, the stores not exist at the version are empty,
+	// the same as iavl nodes, the stores deleted since then are skipped.
 	for _, tree := range db.Trees() {
-		stores[rs.keysByName[tree.Name]] = memiavlstore.New(tree.Tree, rs.logger)
+		if key, ok := rs.keysByName[tree.Name]; ok {
+			stores[key] = rs.newMemIAVLStore(tree.Name, tree.Tree, &version)
+		}
+	}
+	for key, params := range rs.storesParams {
+		if _, ok := stores[key]; !ok && params.typ == types.StoreTypeIAVL {
+			stores[key] = rs.newMemIAVLStore(key.Name(), memiavl.NewEmptyTree(uint64(version), 0), &version)
+		}
 	}
 
-	return cachemulti.NewStore(stores, nil, nil, nil), nil
+	return cachemulti.NewStore(stores, nil, nil, db), nil
+}
+
+// cacheMultiStoreWithView serves the latest version of a read-only store from the copies of the trees, which are not
+// modified or closed by the concurrent WAL catch-up of a hot standby, the view is released when the returned store is
+// closed.
+func (rs *Store) cacheMultiStoreWithView() (types.CacheMultiStore, error) {
+	view := rs.db.CopyView()
+	if view == nil {
+		return nil, errors.Wrap(sdkerrors.ErrInvalidRequest, "memiavl db is closed")
+	}
+	version := view.Version()
+
+	stores := make(map[types.StoreKey]types.CacheWrapper)
+	for k, store := range rs.stores {
+		if store.GetStoreType() != types.StoreTypeIAVL {
+			stores[k] = store
+		}
+	}
+	for key, params := range rs.storesParams {
+		if params.typ != types.StoreTypeIAVL {
+			continue
+		}
+		tree := view.TreeByName(key.Name())
+		if tree == nil {
+			tree = memiavl.NewEmptyTree(uint64(version), 0)
+		}
+		stores[key] = rs.newMemIAVLStore(key.Name(), tree, &version)
+	}
+	return cachemulti.NewStore(stores, nil, nil, view), nil
 }
 
 // GetStore Implements interface MultiStore
@@ -342,31 +468,25 @@ func (rs *Store) LoadVersionAndUpgrade(version int64, upgrades *types.StoreUpgra
 	}
 
 	opts := rs.opts
-	opts.CreateIfMissing = true
+	// read-only mode is used to load the historical versions in offline commands, it don't create the db.
+	opts.CreateIfMissing = !opts.ReadOnly
 	opts.InitialStores = initialStores
 	opts.TargetVersion = uint32(version)
 	db, err := memiavl.Load(rs.dir, opts)
 	if err != nil {
 		return errors.Wrapf(err, "fail to load memiavl at %s", rs.dir)
 	}
-
-	var treeUpgrades []*memiavl.TreeNameUpgrade
-	if upgrades != nil {
-		for _, name := range upgrades.Deleted {
-			treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: name, Delete: true})
-		}
-		for _, name := range upgrades.Added {
-			treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: name})
-		}
-		for _, rename := range upgrades.Renamed {
-			treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: rename.NewKey, RenameFrom: rename.OldKey})
-		}
+	if err := rs.checkAppHash(db); err != nil {
+		_ = db.Close()
+		return err
 	}
 
-	if len(treeUpgrades) > 0 {
+	if treeUpgrades := convertStoreUpgrades(db, upgrades); len(treeUpgrades) > 0 {
+		// the upgrades are recorded in the WAL in next commit, so they are replayed along with the change sets.
 		if err := db.ApplyUpgrades(treeUpgrades); err != nil {
-			return err
+			return errors.Wrap(err, "fail to apply store upgrades")
 		}
+		rs.logger.Info("applied store upgrades", "version", db.Version()+1, "upgrades", treeUpgrades)
 	}
 
 	newStores := make(map[types.StoreKey]types.CommitStore, len(storesKeys))
@@ -392,6 +512,64 @@ func (rs *Store) LoadVersionAndUpgrade(version int64, upgrades *types.StoreUpgra
 	return nil
 }
 
+// checkAppHash compares the app hash of the loaded version with the expected one, the error lists the root hashes of
+// the stores, to be compared with the ones of a healthy node.
+func (rs *Store) checkAppHash(db *memiavl.DB) error {
+	if rs.expectedAppHash == nil || db.Version() == 0 {
+		return nil
+	}
+	expected, err := rs.expectedAppHash(db.Version())
+	if err != nil {
+		return errors.Wrap(err, "fail to read the expected app hash")
+	}
+	if expected == nil {
+		return nil
+	}
+
+	commitInfo := convertCommitInfo(db.LastCommitInfo())
+	if rs.sdk46Compact {
+		commitInfo = amendCommitInfo(commitInfo, rs.storesParams)
+	}
+	appHash := commitInfo.Hash()
+	if bytes.Equal(appHash, expected) {
+		return nil
+	}
+	stores := make([]string, len(commitInfo.StoreInfos))
+	for i, info := range commitInfo.StoreInfos {
+		stores[i] = fmt.Sprintf("%s: %X", info.Name, info.CommitId.Hash)
+	}
+	return fmt.Errorf("app hash mismatch at version %d, expect %X, got %X, the root hashes of the stores:\n%s",
+		db.Version(), expected, appHash, strings.Join(stores, "\n"))
+}
+
+// convertStoreUpgrades converts the store upgrades declared in the upgrade plan to memiavl tree upgrades,
+// the renames are applied before the additions, so a new store can reuse the old name of a renamed one,
+// the stores which exist already are skipped, for example, the initial stores of a new db.
+func convertStoreUpgrades(db *memiavl.DB, upgrades *types.StoreUpgrades) []*memiavl.TreeNameUpgrade {
+	if upgrades == nil {
+		return nil
+	}
+
+	var treeUpgrades []*memiavl.TreeNameUpgrade
+	for _, rename := range upgrades.Renamed {
+		treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: rename.NewKey, RenameFrom: rename.OldKey})
+	}
+	for _, name := range upgrades.Deleted {
+		treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: name, Delete: true})
+	}
+	for _, name := range upgrades.Added {
+		// the name is freed if the existing store is deleted or renamed in the same upgrade
+		freed := upgrades.IsDeleted(name) || slices.ContainsFunc(upgrades.Renamed, func(rename types.StoreRename) bool {
+			return rename.OldKey == name
+		})
+		if db.TreeByName(name) != nil && !freed {
+			continue
+		}
+		treeUpgrades = append(treeUpgrades, &memiavl.TreeNameUpgrade{Name: name})
+	}
+	return treeUpgrades
+}
+
 func (rs *Store) loadCommitStoreFromParams(db *memiavl.DB, key types.StoreKey, params storeParams) (types.CommitStore, error) {
 	switch params.typ {
 	case types.StoreTypeMulti:
@@ -401,7 +579,7 @@ func (rs *Store) loadCommitStoreFromParams(db *memiavl.DB, key types.StoreKey, p
 		if tree == nil {
 			return nil, fmt.Errorf("new store is not added in upgrades: %s", key.Name())
 		}
-		return types.CommitStore(memiavlstore.New(tree, rs.logger)), nil
+		return types.CommitStore(rs.newMemIAVLStore(key.Name(), tree, nil)), nil
 	case types.StoreTypeDB:
 		panic("recursive MultiStores not yet supported")
 	case types.StoreTypeTransient:
@@ -510,8 +688,23 @@ func (rs *Store) AddListeners(keys []types.StoreKey) {
 // Calling PopStateCache destroys only the currently accumulated state in each listener
 // not the state in the store itself. This is a mutating and destructive operation.
 // This method has been synchronized.
+//
+// The state changes of the memiavl stores are converted from the change sets committed to memiavl,
+// which are the same as the ones written to the WAL, rather than the writes observed in the cache stores.
 func (rs *Store) PopStateCache() []*types.StoreKVPair {
 	var cache []*types.StoreKVPair
+	for _, cs := range rs.changeSets {
+		for _, pair := range cs.Changeset.Pairs {
+			cache = append(cache, &types.StoreKVPair{
+				StoreKey: cs.Name,
+				Delete:   pair.Delete,
+				Key:      pair.Key,
+				Value:    pair.Value,
+			})
+		}
+	}
+	rs.changeSets = nil
+
 	for key := range rs.listeners {
 		ls := rs.listeners[key]
 		if ls != nil {
@@ -537,6 +730,71 @@ func (rs *Store) GetStoreByName(name string) types.Store {
 	return rs.GetCommitStore(key)
 }
 
+// SetStorage enables the commitment-only mode, the raw values are served by the storage, memiavl only keeps the value
+// hashes in the snapshots, which is enabled by the `CommitmentOnly` option. The storage must be written synchronously
+// on commit, so the latest values are consistent with the trees.
+func (rs *Store) SetStorage(storage StorageFunc) {
+	rs.storage = storage
+	for key, store := range rs.stores {
+		if s, ok := store.(*memiavlstore.Store); ok {
+			s.SetStorage(storage(key.Name(), nil))
+		}
+	}
+}
+
+// SetHistoricalQuerier sets the querier of the heights pruned in memiavl, like versiondb, which serves the values
+// without the merkle proofs.
+func (rs *Store) SetHistoricalQuerier(querier types.Queryable) {
+	rs.historicalQuerier = querier
+}
+
+// newMemIAVLStore creates the store of the tree, the storage is set in commitment-only mode.
+func (rs *Store) newMemIAVLStore(name string, tree *memiavl.Tree, version *int64) *memiavlstore.Store {
+	store := memiavlstore.New(tree, rs.logger)
+	if rs.storage != nil {
+		store.SetStorage(rs.storage(name, version))
+	}
+	return store
+}
+
+// loadHistoricalVersion loads the historical version from the nearest retained snapshot and the WAL in read-only mode,
+// the caller should close the returned db.
+func (rs *Store) loadHistoricalVersion(version int64) (*memiavl.DB, error) {
+	if rs.lastCommitInfo != nil && version > rs.lastCommitInfo.Version {
+		return nil, errors.Wrapf(sdkerrors.ErrInvalidHeight, "version %d is greater than the latest version %d", version, rs.lastCommitInfo.Version)
+	}
+
+	// the snapshot and the WAL of the version are not pruned while loading, after that the loaded db only reads the
+	// mmap-ed files, which are still valid after removed.
+	if err := rs.db.PinVersion(version); err != nil {
+		return nil, errors.Wrapf(sdkerrors.ErrInvalidHeight, "failed to load version %d: %s", version, err)
+	}
+	defer func() { _ = rs.db.Unpin(version) }()
+
+	opts := rs.opts
+	opts.TargetVersion = uint32(version)
+	opts.ReadOnly = true
+	// the live db holds the exclusive lock, and the queries must not keep it from restarting
+	opts.NoLock = true
+	// the values could be accessed after the db is closed, for example, when encoding the query responses.
+	opts.ZeroCopy = false
+	// the metrics address is held by the live db
+	opts.MetricsAddress = ""
+	// the historical db only serves a query, faulting in all the nodes costs more than the query itself
+	opts.PrefaultNodes = false
+	db, err := memiavl.Load(rs.dir, opts)
+	if err != nil {
+		return nil, errors.Wrapf(sdkerrors.ErrInvalidHeight, "failed to load version %d: %s", version, err)
+	}
+	return db, nil
+}
+
+// treeSource is implemented by `memiavl.DB` and `memiavl.View`.
+type treeSource interface {
+	TreeByName(name string) *memiavl.Tree
+	LastCommitInfo() *memiavl.CommitInfo
+}
+
 // Query Implements interface Queryable
 func (rs *Store) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
 	version := req.Height
@@ -547,14 +805,26 @@ func (rs *Store) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
 	// If the request's height is the latest height we've committed, then utilize
 	// the store's lastCommitInfo as this commit info may not be flushed to disk.
 	// Otherwise, we query for the commit info from disk.
-	db := rs.db
-	if version != rs.lastCommitInfo.Version {
-		var err error
-		db, err = memiavl.Load(rs.dir, memiavl.Options{TargetVersion: uint32(version), ReadOnly: true})
+	var db treeSource = rs.db
+	switch {
+	case version != rs.lastCommitInfo.Version:
+		historical, err := rs.loadHistoricalVersion(version)
 		if err != nil {
+			if rs.historicalQuerier != nil && errors.IsOf(err, sdkerrors.ErrInvalidHeight) && rs.isPruned(version) {
+				return rs.historicalQuerier.Query(req)
+			}
 			return nil, err
 		}
-		defer db.Close()
+		defer historical.Close()
+		db = historical
+	case rs.opts.ReadOnly:
+		// the trees of a hot standby are modified by the concurrent WAL catch-up
+		view := rs.db.CopyView()
+		if view == nil {
+			return nil, errors.Wrap(sdkerrors.ErrInvalidRequest, "memiavl db is closed")
+		}
+		defer view.Release()
+		db = view
 	}
 
 	path := req.Path
@@ -563,7 +833,11 @@ func (rs *Store) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
 		return nil, err
 	}
 
-	store := types.Queryable(memiavlstore.New(db.TreeByName(storeName), rs.logger))
+	tree := db.TreeByName(storeName)
+	if tree == nil {
+		return nil, errors.Wrapf(sdkerrors.ErrUnknownRequest, "no such store: %s at version %d", storeName, version)
+	}
+	store := types.Queryable(rs.newMemIAVLStore(storeName, tree, &version))
 
 	// trim the path and make the query
 	req.Path = subpath
@@ -591,6 +865,26 @@ func (rs *Store) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
 	return res, nil
 }
 
+// ProvableVersion returns the earliest version not smaller than `version` that the merkle proofs can be generated for,
+// the old versions are not available after their snapshots are pruned.
+func (rs *Store) ProvableVersion(version int64) (int64, error) {
+	earliest, err := memiavl.GetEarliestVersion(rs.dir)
+	if err != nil {
+		return 0, err
+	}
+	if version < earliest {
+		return earliest, nil
+	}
+	return version, nil
+}
+
+// isPruned returns if the version is older than the earliest snapshot, only the pruned versions are served by the
+// historical querier, which can't generate the proofs for them.
+func (rs *Store) isPruned(version int64) bool {
+	provable, err := rs.ProvableVersion(version)
+	return err == nil && provable > version
+}
+
 // parsePath expects a format like /<storeName>[/<subpath>]
 // Must start with /, subpath may be empty
 // Returns error if it doesn't start with /
@@ -1,8 +1,11 @@
 package rootmulti
 
 import (
+	"io"
+	"net"
 	"testing"
 
+	"github.com/crypto-org-chain/cronos/memiavl"
 	"github.com/stretchr/testify/require"
 
 	"cosmossdk.io/log"
@@ -13,3 +16,215 @@ func TestLastCommitID(t *testing.T) {
 	store := NewStore(t.TempDir(), log.NewNopLogger(), false, false)
 	require.Equal(t, types.CommitID{}, store.LastCommitID())
 }
+
+func TestPopStateCache(t *testing.T) {
+	store := NewStore(t.TempDir(), log.NewNopLogger(), false, false)
+	bankKey := types.NewKVStoreKey("bank")
+	accKey := types.NewKVStoreKey("acc")
+	tkey := types.NewTransientStoreKey("transient")
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	store.MountStoreWithDB(accKey, types.StoreTypeIAVL, nil)
+	store.MountStoreWithDB(tkey, types.StoreTypeTransient, nil)
+	store.AddListeners([]types.StoreKey{bankKey, tkey})
+	require.NoError(t, store.LoadLatestVersion())
+
+	cms := store.CacheMultiStore()
+	cms.GetKVStore(bankKey).Set([]byte("hello"), []byte("world"))
+	cms.GetKVStore(bankKey).Set([]byte("hello1"), []byte("world1"))
+	cms.GetKVStore(bankKey).Delete([]byte("hello1"))
+	cms.GetKVStore(accKey).Set([]byte("hello"), []byte("world"))
+	cms.GetKVStore(tkey).Set([]byte("hello"), []byte("world"))
+	cms.Write()
+	store.Commit()
+
+	// the writes of the memiavl stores are the committed change set, the transient store's are observed by listenkv
+	require.Equal(t, []*types.StoreKVPair{
+		{StoreKey: "bank", Key: []byte("hello"), Value: []byte("world")},
+		{StoreKey: "bank", Delete: true, Key: []byte("hello1")},
+		{StoreKey: "transient", Key: []byte("hello"), Value: []byte("world")},
+	}, store.PopStateCache())
+	require.Empty(t, store.PopStateCache())
+}
+
+func TestCacheMultiStoreWithVersion(t *testing.T) {
+	dir := t.TempDir()
+	bankKey := types.NewKVStoreKey("bank")
+	store := NewStore(dir, log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	for i := 1; i <= 3; i++ {
+		store.GetKVStore(bankKey).Set([]byte("hello"), []byte{byte(i)})
+		store.Commit()
+	}
+	require.NoError(t, store.Close())
+
+	// the store added after the historical versions is empty
+	evmKey := types.NewKVStoreKey("evm")
+	store = NewStore(dir, log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	store.MountStoreWithDB(evmKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersionAndUpgrade(&types.StoreUpgrades{Added: []string{"evm"}}))
+	defer store.Close()
+
+	cms, err := store.CacheMultiStoreWithVersion(2)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, cms.GetKVStore(bankKey).Get([]byte("hello")))
+	require.Nil(t, cms.GetKVStore(evmKey).Get([]byte("hello")))
+	require.NoError(t, cms.(io.Closer).Close())
+
+	_, err = store.CacheMultiStoreWithVersion(4)
+	require.Error(t, err)
+}
+
+func TestQueryHistoricalVersion(t *testing.T) {
+	bankKey := types.NewKVStoreKey("bank")
+	store := NewStore(t.TempDir(), log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	defer store.Close()
+	for i := 1; i <= 3; i++ {
+		store.GetKVStore(bankKey).Set([]byte("hello"), []byte{byte(i)})
+		store.Commit()
+		if i == 2 {
+			require.NoError(t, store.db.RewriteSnapshot())
+		}
+	}
+
+	// served from the retained snapshots and the WAL
+	for version := int64(1); version <= 3; version++ {
+		res, err := store.Query(&types.RequestQuery{Path: "/bank/key", Data: []byte("hello"), Height: version})
+		require.NoError(t, err)
+		require.Equal(t, version, res.Height)
+		require.Equal(t, []byte{byte(version)}, res.Value)
+	}
+
+	_, err := store.Query(&types.RequestQuery{Path: "/bank/key", Data: []byte("hello"), Height: 4})
+	require.Error(t, err)
+	_, err = store.Query(&types.RequestQuery{Path: "/evm/key", Data: []byte("hello"), Height: 2})
+	require.Error(t, err)
+}
+
+func TestQueryHistoricalVersionWithMetrics(t *testing.T) {
+	// reserve a free port for the metrics server of the live db
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	bankKey := types.NewKVStoreKey("bank")
+	store := NewStore(t.TempDir(), log.NewNopLogger(), false, false)
+	store.SetMemIAVLOptions(memiavl.Options{MetricsAddress: addr})
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	defer store.Close()
+	for i := 1; i <= 2; i++ {
+		store.GetKVStore(bankKey).Set([]byte("hello"), []byte{byte(i)})
+		store.Commit()
+	}
+
+	// the historical db don't serve the metrics on the address held by the live db
+	res, err := store.Query(&types.RequestQuery{Path: "/bank/key", Data: []byte("hello"), Height: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, res.Value)
+
+	cms, err := store.CacheMultiStoreWithVersion(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, cms.GetKVStore(bankKey).Get([]byte("hello")))
+	require.NoError(t, cms.(io.Closer).Close())
+}
+
+func TestStoreUpgrades(t *testing.T) {
+	dir := t.TempDir()
+	bankKey := types.NewKVStoreKey("bank")
+	accKey := types.NewKVStoreKey("acc")
+	store := NewStore(dir, log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	store.MountStoreWithDB(accKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	store.GetKVStore(bankKey).Set([]byte("hello"), []byte("world"))
+	store.Commit()
+	require.NoError(t, store.Close())
+
+	upgrades := &types.StoreUpgrades{
+		Added:   []string{"evm", "bank"},
+		Renamed: []types.StoreRename{{OldKey: "bank", NewKey: "bank2"}},
+		Deleted: []string{"acc"},
+	}
+	bank2Key := types.NewKVStoreKey("bank2")
+	evmKey := types.NewKVStoreKey("evm")
+	load := func(upgrades *types.StoreUpgrades) *Store {
+		store := NewStore(dir, log.NewNopLogger(), false, false)
+		store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+		store.MountStoreWithDB(bank2Key, types.StoreTypeIAVL, nil)
+		store.MountStoreWithDB(evmKey, types.StoreTypeIAVL, nil)
+		require.NoError(t, store.LoadLatestVersionAndUpgrade(upgrades))
+		return store
+	}
+
+	// restart at the upgrade height before committing
+	require.NoError(t, load(upgrades).Close())
+
+	store = load(upgrades)
+	require.Equal(t, []byte("world"), store.GetKVStore(bank2Key).Get([]byte("hello")))
+	require.Nil(t, store.GetKVStore(bankKey).Get([]byte("hello")))
+	store.Commit()
+	require.NoError(t, store.Close())
+
+	// the upgrades are persisted in the WAL
+	store = load(nil)
+	defer store.Close()
+	require.Equal(t, []byte("world"), store.GetKVStore(bank2Key).Get([]byte("hello")))
+	require.Equal(t, []string{"bank", "bank2", "evm"}, storeNames(store.lastCommitInfo))
+
+	// the added stores exist in a new db already
+	store = NewStore(t.TempDir(), log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(evmKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersionAndUpgrade(&types.StoreUpgrades{Added: []string{"evm"}}))
+	require.NoError(t, store.Close())
+}
+
+func storeNames(commitInfo *types.CommitInfo) []string {
+	names := make([]string, len(commitInfo.StoreInfos))
+	for i, info := range commitInfo.StoreInfos {
+		names[i] = info.Name
+	}
+	return names
+}
+
+func TestCheckAppHash(t *testing.T) {
+	dir := t.TempDir()
+	bankKey := types.NewKVStoreKey("bank")
+	store := NewStore(dir, log.NewNopLogger(), false, false)
+	store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	store.GetKVStore(bankKey).Set([]byte("hello"), []byte("world"))
+	commitID := store.Commit()
+	require.NoError(t, store.Close())
+
+	for _, tc := range []struct {
+		name     string
+		expected []byte
+		errMsg   string
+	}{
+		{"match", commitID.Hash, ""},
+		{"unknown", nil, ""},
+		{"mismatch", []byte("invalid"), "app hash mismatch at version 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewStore(dir, log.NewNopLogger(), false, false)
+			store.MountStoreWithDB(bankKey, types.StoreTypeIAVL, nil)
+			store.SetExpectedAppHash(func(version int64) ([]byte, error) {
+				require.Equal(t, int64(1), version)
+				return tc.expected, nil
+			})
+			err := store.LoadLatestVersion()
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+				require.ErrorContains(t, err, "bank: ")
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, store.Close())
+		})
+	}
+}
@@ -1,27 +1,87 @@
 package store
 
 import (
+	"math"
 	"path/filepath"
+	"time"
 
 	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/store/replication"
 	"github.com/crypto-org-chain/cronos/store/rootmulti"
+	"github.com/crypto-org-chain/cronos/store/snapshotter"
+	"github.com/crypto-org-chain/cronos/store/streaming"
 	"github.com/spf13/cast"
+	"google.golang.org/grpc"
 
 	"cosmossdk.io/log"
+	pruningtypes "cosmossdk.io/store/pruning/types"
+	storetypes "cosmossdk.io/store/types"
 
 	"github.com/cosmos/cosmos-sdk/baseapp"
 	servertypes "github.com/cosmos/cosmos-sdk/server/types"
+	"github.com/cosmos/cosmos-sdk/telemetry"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
 const (
-	FlagMemIAVL             = "memiavl.enable"
-	FlagAsyncCommitBuffer   = "memiavl.async-commit-buffer"
-	FlagZeroCopy            = "memiavl.zero-copy"
-	FlagSnapshotKeepRecent  = "memiavl.snapshot-keep-recent"
-	FlagSnapshotInterval    = "memiavl.snapshot-interval"
-	FlagCacheSize           = "memiavl.cache-size"
-	FlagSnapshotWriterLimit = "memiavl.snapshot-writer-limit"
+	FlagMemIAVL              = "memiavl.enable"
+	FlagAsyncCommitBuffer    = "memiavl.async-commit-buffer"
+	FlagWaitDurable          = "memiavl.wait-durable-before-commit"
+	FlagZeroCopy             = "memiavl.zero-copy"
+	FlagSnapshotKeepRecent   = "memiavl.snapshot-keep-recent"
+	FlagSnapshotInterval     = "memiavl.snapshot-interval"
+	FlagCacheSize            = "memiavl.cache-size"
+	FlagCacheShards          = "memiavl.cache-shards"
+	FlagSnapshotWriterLimit  = "memiavl.snapshot-writer-limit"
+	FlagStreamingFileDir     = "memiavl.streaming-file-dir"
+	FlagShutdownTimeout      = "memiavl.shutdown-timeout"
+	FlagStreamingGRPCAddress = "memiavl.streaming-grpc-address"
+	FlagCommitmentOnly       = "memiavl.commitment-only"
+	FlagBulkLoadGenesis      = "memiavl.bulk-load-genesis"
+	FlagNodesMmapAdvice      = "memiavl.nodes-mmap-advice"
+	FlagKVsMmapAdvice        = "memiavl.kvs-mmap-advice"
+	FlagPrefaultNodes        = "memiavl.prefault-nodes"
+	FlagHugePageNodes        = "memiavl.huge-page-nodes"
+	FlagMetricsAddress       = "memiavl.metrics-address"
+	FlagTraceFile            = "memiavl.trace-file"
+	FlagQueryMetrics         = "memiavl.query-metrics"
+	FlagStatusInterval       = "memiavl.status-interval"
+	FlagSnapshotEventLog     = "memiavl.snapshot-event-log"
+	FlagSlowCommit           = "memiavl.slow-commit-threshold"
+	FlagSlowCommitPhase      = "memiavl.slow-commit-phase-threshold"
+	FlagLogLevelWAL          = "memiavl.log-level-wal"
+	FlagLogLevelSnapshot     = "memiavl.log-level-snapshot"
+	FlagLogLevelPrune        = "memiavl.log-level-prune"
+	FlagLogLevelCommit       = "memiavl.log-level-commit"
+	FlagNodeArena            = "memiavl.node-arena"
+	FlagCompactNodes         = "memiavl.compact-nodes"
+	FlagHashConcurrency      = "memiavl.hash-concurrency"
+	FlagWALReplayConcurrency = "memiavl.wal-replay-concurrency"
+	FlagMemoryBudget         = "memiavl.memory-budget-bytes"
+	FlagMinFreeDisk          = "memiavl.min-free-disk-bytes"
+	FlagWALLagAlert          = "memiavl.wal-lag-alert-threshold"
+	FlagSnapshotWriteBuffer  = "memiavl.snapshot-write-buffer-size"
+	FlagPreallocateSnapshot  = "memiavl.preallocate-snapshot"
+	FlagBlobThreshold        = "memiavl.blob-threshold"
+	FlagPrefixCompressKeys   = "memiavl.prefix-compress-keys"
+	FlagCachePrewarmSize     = "memiavl.cache-prewarm-size"
+	FlagCacheAdvisorWindow   = "memiavl.cache-advisor-window"
+	FlagSnapshotMaxReplay    = "memiavl.snapshot-max-replay-time"
+	FlagRewriteStallTimeout  = "memiavl.rewrite-stall-timeout"
+	FlagRetryStalledRewrite  = "memiavl.retry-stalled-rewrite"
+	FlagCheckAppHash         = "memiavl.check-app-hash"
+	FlagWALStreamToken       = "memiavl.wal-stream-token"
+	// FlagReadOnly is not in the config file, it's set by the offline commands which only read the historical states.
+	FlagReadOnly = "memiavl.read-only"
+	// FlagNoLock is not in the config file, it's set by the query server, so it don't keep the node from starting.
+	FlagNoLock = "memiavl.no-lock"
+
+	// the sdk's pruning options, same as the ones defined in the server package.
+	flagPruning           = "pruning"
+	flagPruningKeepRecent = "pruning-keep-recent"
+	flagPruningInterval   = "pruning-interval"
+	// the db backend of CometBFT, defined in `config.toml`.
+	flagDBBackend = "db_backend"
 )
 
 // SetupMemIAVL insert the memiavl setter in front of baseapp options, so that
@@ -37,12 +97,63 @@ func SetupMemIAVL(
 ) []func(*baseapp.BaseApp) {
 	if cast.ToBool(appOpts.Get(FlagMemIAVL)) {
 		opts := memiavl.Options{
-			AsyncCommitBuffer:   cast.ToInt(appOpts.Get(FlagAsyncCommitBuffer)),
-			ZeroCopy:            cast.ToBool(appOpts.Get(FlagZeroCopy)),
-			SnapshotKeepRecent:  cast.ToUint32(appOpts.Get(FlagSnapshotKeepRecent)),
-			SnapshotInterval:    cast.ToUint32(appOpts.Get(FlagSnapshotInterval)),
-			CacheSize:           cacheSize,
-			SnapshotWriterLimit: cast.ToInt(appOpts.Get(FlagSnapshotWriterLimit)),
+			AsyncCommitBuffer:       cast.ToInt(appOpts.Get(FlagAsyncCommitBuffer)),
+			WaitDurableBeforeCommit: cast.ToBool(appOpts.Get(FlagWaitDurable)),
+			ZeroCopy:                cast.ToBool(appOpts.Get(FlagZeroCopy)),
+			SnapshotKeepRecent:      cast.ToUint32(appOpts.Get(FlagSnapshotKeepRecent)),
+			SnapshotInterval:        cast.ToUint32(appOpts.Get(FlagSnapshotInterval)),
+			CacheSize:               cacheSize,
+			CacheShards:             cast.ToInt(appOpts.Get(FlagCacheShards)),
+			SnapshotWriterLimit:     cast.ToInt(appOpts.Get(FlagSnapshotWriterLimit)),
+			ReadOnly:                cast.ToBool(appOpts.Get(FlagReadOnly)),
+			NoLock:                  cast.ToBool(appOpts.Get(FlagNoLock)),
+			CommitmentOnly:          cast.ToBool(appOpts.Get(FlagCommitmentOnly)),
+			PrefaultNodes:           cast.ToBool(appOpts.Get(FlagPrefaultNodes)),
+			HugePageNodes:           cast.ToBool(appOpts.Get(FlagHugePageNodes)),
+			MetricsAddress:          cast.ToString(appOpts.Get(FlagMetricsAddress)),
+			TraceFile:               cast.ToString(appOpts.Get(FlagTraceFile)),
+			QueryMetrics:            cast.ToBool(appOpts.Get(FlagQueryMetrics)),
+			StatusInterval:          cast.ToDuration(appOpts.Get(FlagStatusInterval)),
+			SnapshotEventLog:        cast.ToString(appOpts.Get(FlagSnapshotEventLog)),
+			SlowCommitThreshold:     cast.ToDuration(appOpts.Get(FlagSlowCommit)),
+			NodeArena:               cast.ToBool(appOpts.Get(FlagNodeArena)),
+			CompactNodes:            cast.ToBool(appOpts.Get(FlagCompactNodes)),
+			HashConcurrency:         cast.ToInt(appOpts.Get(FlagHashConcurrency)),
+			WALReplayConcurrency:    cast.ToInt(appOpts.Get(FlagWALReplayConcurrency)),
+			MemoryBudgetBytes:       cast.ToUint64(appOpts.Get(FlagMemoryBudget)),
+			MinFreeDiskBytes:        cast.ToUint64(appOpts.Get(FlagMinFreeDisk)),
+			WALLagAlertThreshold:    cast.ToUint64(appOpts.Get(FlagWALLagAlert)),
+			SnapshotWriteBufferSize: cast.ToInt(appOpts.Get(FlagSnapshotWriteBuffer)),
+			PreallocateSnapshot:     cast.ToBool(appOpts.Get(FlagPreallocateSnapshot)),
+			BlobThreshold:           cast.ToInt(appOpts.Get(FlagBlobThreshold)),
+			PrefixCompressKeys:      cast.ToBool(appOpts.Get(FlagPrefixCompressKeys)),
+			CachePrewarmSize:        cast.ToInt(appOpts.Get(FlagCachePrewarmSize)),
+			CacheAdvisorWindow:      cast.ToUint32(appOpts.Get(FlagCacheAdvisorWindow)),
+			SnapshotMaxReplayTime:   cast.ToDuration(appOpts.Get(FlagSnapshotMaxReplay)),
+			RewriteStallTimeout:     cast.ToDuration(appOpts.Get(FlagRewriteStallTimeout)),
+			RetryStalledRewrite:     cast.ToBool(appOpts.Get(FlagRetryStalledRewrite)),
+			Metrics:                 telemetryMetrics{},
+		}
+		opts.SlowCommitPhaseThreshold = cast.ToDuration(appOpts.Get(FlagSlowCommitPhase))
+
+		applyPruningOptions(logger, appOpts, &opts)
+
+		var err error
+		if opts.NodesMmapAdvice, err = memiavl.ParseMmapAdvice(cast.ToString(appOpts.Get(FlagNodesMmapAdvice))); err != nil {
+			panic(err)
+		}
+		if opts.KVsMmapAdvice, err = memiavl.ParseMmapAdvice(cast.ToString(appOpts.Get(FlagKVsMmapAdvice))); err != nil {
+			panic(err)
+		}
+		for flag, level := range map[string]*memiavl.LogLevel{
+			FlagLogLevelWAL:      &opts.LogLevels.WAL,
+			FlagLogLevelSnapshot: &opts.LogLevels.Snapshot,
+			FlagLogLevelPrune:    &opts.LogLevels.Prune,
+			FlagLogLevelCommit:   &opts.LogLevels.Commit,
+		} {
+			if *level, err = memiavl.ParseLogLevel(cast.ToString(appOpts.Get(flag))); err != nil {
+				panic(err)
+			}
 		}
 
 		if opts.ZeroCopy {
@@ -52,20 +163,129 @@ func SetupMemIAVL(
 
 		// cms must be overridden before the other options, because they may use the cms,
 		// make sure the cms aren't be overridden by the other options later on.
-		baseAppOptions = append([]func(*baseapp.BaseApp){setMemIAVL(homePath, logger, opts, sdk46Compact, supportExportNonSnapshotVersion)}, baseAppOptions...)
+		shutdownTimeout := cast.ToDuration(appOpts.Get(FlagShutdownTimeout))
+		bulkLoadGenesis := cast.ToBool(appOpts.Get(FlagBulkLoadGenesis))
+		var expectedAppHash func(int64) ([]byte, error)
+		if cast.ToBool(appOpts.Get(FlagCheckAppHash)) {
+			expectedAppHash = cometAppHash(homePath, cast.ToString(appOpts.Get(flagDBBackend)))
+		}
+		baseAppOptions = append([]func(*baseapp.BaseApp){setMemIAVL(homePath, logger, opts, shutdownTimeout, bulkLoadGenesis, sdk46Compact, supportExportNonSnapshotVersion, expectedAppHash)}, baseAppOptions...)
 	}
 
 	return baseAppOptions
 }
 
-func setMemIAVL(homePath string, logger log.Logger, opts memiavl.Options, sdk46Compact, supportExportNonSnapshotVersion bool) func(*baseapp.BaseApp) {
+// applyPruningOptions translates the sdk's custom pruning options to the memiavl retention settings, the pruning
+// happens when a new snapshot is taken, so `pruning-interval` becomes the snapshot interval, and the versions
+// within `pruning-keep-recent` are retained by the old snapshots and the WAL after the earliest one.
+// The other pruning strategies don't apply to memiavl, the memiavl settings are used as is.
+func applyPruningOptions(logger log.Logger, appOpts servertypes.AppOptions, opts *memiavl.Options) {
+	if cast.ToString(appOpts.Get(flagPruning)) != pruningtypes.PruningOptionCustom {
+		return
+	}
+
+	if interval := cast.ToUint32(appOpts.Get(flagPruningInterval)); interval > 0 {
+		opts.SnapshotInterval = interval
+	}
+	if opts.SnapshotInterval == 0 {
+		opts.SnapshotInterval = memiavl.DefaultSnapshotInterval
+	}
+
+	// the versions after the latest snapshot are always kept in the WAL,
+	// each old snapshot keeps another snapshot interval of versions.
+	keepRecent := cast.ToUint64(appOpts.Get(flagPruningKeepRecent))
+	interval := uint64(opts.SnapshotInterval)
+	opts.SnapshotKeepRecent = uint32(min((keepRecent+interval-1)/interval, math.MaxUint32))
+
+	logger.Info(
+		"memiavl retention settings derived from custom pruning options",
+		"snapshot-interval", opts.SnapshotInterval,
+		"snapshot-keep-recent", opts.SnapshotKeepRecent,
+	)
+}
+
+// WALStreamRegistrar returns the function registering the WAL stream service on the grpc server of the node, which
+// the `follow` command of the standbys subscribes to, it's nil if memiavl is disabled or `memiavl.wal-stream-token` is
+// empty, the subscribers must present the token.
+func WALStreamRegistrar(homePath string, appOpts servertypes.AppOptions) func(grpc.ServiceRegistrar) error {
+	token := cast.ToString(appOpts.Get(FlagWALStreamToken))
+	if !cast.ToBool(appOpts.Get(FlagMemIAVL)) || len(token) == 0 {
+		return nil
+	}
+	dir := filepath.Join(homePath, "data", "memiavl.db")
+	return func(server grpc.ServiceRegistrar) error {
+		return replication.RegisterWALStreamServer(server, dir, replication.DefaultPollInterval, token)
+	}
+}
+
+func setMemIAVL(homePath string, logger log.Logger, opts memiavl.Options, shutdownTimeout time.Duration, bulkLoadGenesis, sdk46Compact, supportExportNonSnapshotVersion bool, expectedAppHash func(int64) ([]byte, error)) func(*baseapp.BaseApp) {
 	return func(bapp *baseapp.BaseApp) {
 		// trigger state-sync snapshot creation by memiavl
-		opts.TriggerStateSyncExport = func(height int64) {
-			go bapp.SnapshotManager().SnapshotIfApplicable(height)
-		}
+		opts.TriggerStateSyncExport = snapshotter.TriggerStateSyncExport(bapp.SnapshotManager)
 		cms := rootmulti.NewStore(filepath.Join(homePath, "data", "memiavl.db"), logger, sdk46Compact, supportExportNonSnapshotVersion)
 		cms.SetMemIAVLOptions(opts)
+		cms.SetShutdownTimeout(shutdownTimeout)
+		cms.SetBulkLoadGenesis(bulkLoadGenesis)
+		cms.SetExpectedAppHash(expectedAppHash)
 		bapp.SetCMS(cms)
 	}
 }
+
+// SetupStreaming registers the streaming services of the state changes committed in each block, the file and grpc
+// sinks configured in the memiavl section, and the plugin configured in the `streaming.abci` section of cosmos-sdk.
+// When memiavl is enabled, the state changes of the commitment stores are the change sets committed to memiavl.
+func SetupStreaming(bapp *baseapp.BaseApp, appOpts servertypes.AppOptions, keys map[string]*storetypes.KVStoreKey) error {
+	if err := bapp.RegisterStreamingServices(appOpts, keys); err != nil {
+		return err
+	}
+
+	if !cast.ToBool(appOpts.Get(FlagMemIAVL)) {
+		return nil
+	}
+
+	var listeners []storetypes.ABCIListener
+	if dir := cast.ToString(appOpts.Get(FlagStreamingFileDir)); len(dir) > 0 {
+		listener, err := streaming.NewFileListener(dir)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	if address := cast.ToString(appOpts.Get(FlagStreamingGRPCAddress)); len(address) > 0 {
+		listener, err := streaming.NewGRPCListener(address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil
+	}
+
+	// listen for all keys to simplify configuration
+	exposedKeys := make([]storetypes.StoreKey, 0, len(keys))
+	for _, key := range keys {
+		exposedKeys = append(exposedKeys, key)
+	}
+	bapp.CommitMultiStore().AddListeners(exposedKeys)
+
+	sm := bapp.StreamingManager()
+	sm.ABCIListeners = append(sm.ABCIListeners, listeners...)
+	bapp.SetStreamingManager(sm)
+	return nil
+}
+
+// telemetryMetrics reports the memiavl metrics through the sdk telemetry, which is a no-op if telemetry is disabled.
+type telemetryMetrics struct{}
+
+func (telemetryMetrics) MeasureSince(start time.Time, keys ...string) {
+	telemetry.MeasureSince(start, keys...)
+}
+
+func (telemetryMetrics) SetGauge(val float32, keys ...string) {
+	telemetry.SetGauge(val, keys...)
+}
+
+func (telemetryMetrics) IncrCounter(val float32, keys ...string) {
+	telemetry.IncrCounter(val, keys...)
+}
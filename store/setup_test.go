@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/log"
+	pruningtypes "cosmossdk.io/store/pruning/types"
+)
+
+// appOptionsMap is a stub implementing AppOptions which gets data from a map
+type appOptionsMap map[string]interface{}
+
+func (m appOptionsMap) Get(key string) interface{} {
+	return m[key]
+}
+
+func TestApplyPruningOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		appOpts    appOptionsMap
+		opts       memiavl.Options
+		interval   uint32
+		keepRecent uint32
+	}{
+		{
+			"keep-recent is zero",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionCustom, flagPruningKeepRecent: 0, flagPruningInterval: 100},
+			memiavl.Options{SnapshotInterval: 1000, SnapshotKeepRecent: 5},
+			100, 0,
+		},
+		{
+			"interval divides keep-recent",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionCustom, flagPruningKeepRecent: 1000, flagPruningInterval: 100},
+			memiavl.Options{},
+			100, 10,
+		},
+		{
+			"interval doesn't divide keep-recent",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionCustom, flagPruningKeepRecent: 1001, flagPruningInterval: 100},
+			memiavl.Options{},
+			100, 11,
+		},
+		{
+			"pruning-interval is zero, the memiavl interval is used",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionCustom, flagPruningKeepRecent: 2500, flagPruningInterval: 0},
+			memiavl.Options{SnapshotInterval: 1000},
+			1000, 3,
+		},
+		{
+			"both intervals are zero, the default interval is used",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionCustom, flagPruningKeepRecent: 100, flagPruningInterval: 0},
+			memiavl.Options{},
+			memiavl.DefaultSnapshotInterval, 1,
+		},
+		{
+			"default strategy is ignored",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionDefault, flagPruningKeepRecent: 1000, flagPruningInterval: 100},
+			memiavl.Options{SnapshotInterval: 1000, SnapshotKeepRecent: 5},
+			1000, 5,
+		},
+		{
+			"nothing strategy is ignored",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionNothing},
+			memiavl.Options{SnapshotInterval: 1000, SnapshotKeepRecent: 5},
+			1000, 5,
+		},
+		{
+			"everything strategy is ignored",
+			appOptionsMap{flagPruning: pruningtypes.PruningOptionEverything},
+			memiavl.Options{SnapshotInterval: 1000, SnapshotKeepRecent: 5},
+			1000, 5,
+		},
+		{
+			"pruning is not set",
+			appOptionsMap{},
+			memiavl.Options{SnapshotInterval: 1000, SnapshotKeepRecent: 5},
+			1000, 5,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			applyPruningOptions(log.NewNopLogger(), tc.appOpts, &opts)
+			require.Equal(t, tc.interval, opts.SnapshotInterval)
+			require.Equal(t, tc.keepRecent, opts.SnapshotKeepRecent)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package snapshotter
+
+import (
+	"fmt"
+
+	"github.com/cosmos/gogoproto/proto"
+
+	"cosmossdk.io/errors"
+	"cosmossdk.io/store/snapshots/types"
+)
+
+// SnapshotFormat is the format of the extension payloads, each payload is an encoded `SnapshotItem`.
+const SnapshotFormat = 1
+
+var _ types.ExtensionSnapshotter = (*ExtensionSnapshotter)(nil)
+
+// ExtensionSnapshotter includes a memiavl db owned by a module, for example an indexer, into the state-sync snapshots
+// as an extension, the db is exported at the snapshot height, so it must be committed along with the blocks.
+type ExtensionSnapshotter struct {
+	name                            string
+	dir                             string
+	supportExportNonSnapshotVersion bool
+
+	// the db is closed before restoring and reopened after
+	closeDB, openDB func() error
+}
+
+func NewExtensionSnapshotter(
+	name, dir string, supportExportNonSnapshotVersion bool, closeDB, openDB func() error,
+) *ExtensionSnapshotter {
+	return &ExtensionSnapshotter{
+		name:                            name,
+		dir:                             dir,
+		supportExportNonSnapshotVersion: supportExportNonSnapshotVersion,
+		closeDB:                         closeDB,
+		openDB:                          openDB,
+	}
+}
+
+// SnapshotName implements types.ExtensionSnapshotter
+func (s *ExtensionSnapshotter) SnapshotName() string {
+	return s.name
+}
+
+// SnapshotFormat implements types.ExtensionSnapshotter
+func (s *ExtensionSnapshotter) SnapshotFormat() uint32 {
+	return SnapshotFormat
+}
+
+// SupportedFormats implements types.ExtensionSnapshotter
+func (s *ExtensionSnapshotter) SupportedFormats() []uint32 {
+	return []uint32{SnapshotFormat}
+}
+
+// SnapshotExtension implements types.ExtensionSnapshotter
+func (s *ExtensionSnapshotter) SnapshotExtension(height uint64, payloadWriter types.ExtensionPayloadWriter) error {
+	return Export(s.dir, height, s.supportExportNonSnapshotVersion, payloadProtoWriter(payloadWriter))
+}
+
+// RestoreExtension implements types.ExtensionSnapshotter
+func (s *ExtensionSnapshotter) RestoreExtension(height uint64, format uint32, payloadReader types.ExtensionPayloadReader) error {
+	if format != SnapshotFormat {
+		return errors.Wrapf(types.ErrUnknownFormat, "format %v", format)
+	}
+
+	if err := s.closeDB(); err != nil {
+		return fmt.Errorf("failed to close db: %w", err)
+	}
+
+	item, err := Import(s.dir, height, payloadProtoReader(payloadReader))
+	if err != nil {
+		return err
+	}
+	if item.Item != nil {
+		return fmt.Errorf("unexpected snapshot item in extension %s: %T", s.name, item.Item)
+	}
+
+	return s.openDB()
+}
+
+// payloadProtoWriter adapts the extension payload writer to protoio.Writer.
+type payloadProtoWriter types.ExtensionPayloadWriter
+
+func (w payloadProtoWriter) WriteMsg(msg proto.Message) error {
+	bz, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w(bz)
+}
+
+// payloadProtoReader adapts the extension payload reader to protoio.Reader, it returns io.EOF after the last payload.
+type payloadProtoReader types.ExtensionPayloadReader
+
+func (r payloadProtoReader) ReadMsg(msg proto.Message) error {
+	bz, err := r()
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(bz, msg)
+}
@@ -0,0 +1,54 @@
+package snapshotter
+
+import (
+	"io"
+	"testing"
+
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensionSnapshotter(t *testing.T) {
+	dir := t.TempDir()
+	db, err := memiavl.Load(dir, memiavl.Options{CreateIfMissing: true, InitialStores: []string{"index"}})
+	require.NoError(t, err)
+	require.NoError(t, db.ApplyChangeSets([]*memiavl.NamedChangeSet{{
+		Name: "index",
+		Changeset: memiavl.ChangeSet{Pairs: []*memiavl.KVPair{
+			{Key: []byte("hello"), Value: []byte("world")},
+		}},
+	}}))
+	height, err := db.Commit()
+	require.NoError(t, err)
+	require.NoError(t, db.RewriteSnapshot())
+	require.NoError(t, db.Close())
+
+	var payloads [][]byte
+	source := NewExtensionSnapshotter("index", dir, false, nil, nil)
+	require.NoError(t, source.SnapshotExtension(uint64(height), func(payload []byte) error {
+		payloads = append(payloads, payload)
+		return nil
+	}))
+
+	targetDir := t.TempDir()
+	var opened bool
+	target := NewExtensionSnapshotter("index", targetDir, false, func() error { return nil }, func() error {
+		opened = true
+		return nil
+	})
+	require.NoError(t, target.RestoreExtension(uint64(height), SnapshotFormat, func() ([]byte, error) {
+		if len(payloads) == 0 {
+			return nil, io.EOF
+		}
+		payload := payloads[0]
+		payloads = payloads[1:]
+		return payload, nil
+	}))
+	require.True(t, opened)
+
+	db, err = memiavl.Load(targetDir, memiavl.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, height, db.Version())
+	require.Equal(t, []byte("world"), db.TreeByName("index").Get([]byte("hello")))
+}
@@ -0,0 +1,143 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	protoio "github.com/cosmos/gogoproto/io"
+	"github.com/crypto-org-chain/cronos/memiavl"
+
+	cosmoserrors "cosmossdk.io/errors"
+	"cosmossdk.io/store/snapshots"
+	"cosmossdk.io/store/snapshots/types"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+// Export writes the memiavl db at the height into the state-sync snapshot stream, the height must be a snapshot
+// version of the db, unless supportExportNonSnapshotVersion is true, which replays the WAL to the height.
+func Export(dir string, height uint64, supportExportNonSnapshotVersion bool, protoWriter protoio.Writer) (returnErr error) {
+	if height > math.MaxUint32 {
+		return fmt.Errorf("height overflows uint32: %d", height)
+	}
+	version := uint32(height)
+
+	exporter, err := memiavl.NewMultiTreeExporter(dir, version, supportExportNonSnapshotVersion)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		returnErr = errors.Join(returnErr, exporter.Close())
+	}()
+
+	for {
+		item, err := exporter.Next()
+		if err != nil {
+			if errors.Is(err, memiavl.ErrorExportDone) {
+				break
+			}
+
+			return err
+		}
+
+		switch item := item.(type) {
+		case *memiavl.ExportNode:
+			if err := protoWriter.WriteMsg(&types.SnapshotItem{
+				Item: &types.SnapshotItem_IAVL{
+					IAVL: &types.SnapshotIAVLItem{
+						Key:     item.Key,
+						Value:   item.Value,
+						Height:  int32(item.Height),
+						Version: item.Version,
+					},
+				},
+			}); err != nil {
+				return err
+			}
+		case string:
+			if err := protoWriter.WriteMsg(&types.SnapshotItem{
+				Item: &types.SnapshotItem_Store{
+					Store: &types.SnapshotStoreItem{
+						Name: item,
+					},
+				},
+			}); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown item type %T", item)
+		}
+	}
+
+	return nil
+}
+
+// Import restores the memiavl db at the height from the state-sync snapshot stream, the db must be closed,
+// it returns the first item which don't belong to memiavl, for example an extension item.
+func Import(dir string, height uint64, protoReader protoio.Reader) (types.SnapshotItem, error) {
+	importer, err := memiavl.NewMultiTreeImporter(dir, height)
+	if err != nil {
+		return types.SnapshotItem{}, err
+	}
+	defer importer.Close()
+
+	var snapshotItem types.SnapshotItem
+loop:
+	for {
+		snapshotItem = types.SnapshotItem{}
+		err := protoReader.ReadMsg(&snapshotItem)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return types.SnapshotItem{}, cosmoserrors.Wrap(err, "invalid protobuf message")
+		}
+
+		switch item := snapshotItem.Item.(type) {
+		case *types.SnapshotItem_Store:
+			if err := importer.AddTree(item.Store.Name); err != nil {
+				return types.SnapshotItem{}, err
+			}
+		case *types.SnapshotItem_IAVL:
+			if item.IAVL.Height > math.MaxInt8 {
+				return types.SnapshotItem{}, cosmoserrors.Wrapf(sdkerrors.ErrLogic, "node height %v cannot exceed %v",
+					item.IAVL.Height, math.MaxInt8)
+			}
+			node := &memiavl.ExportNode{
+				Key:     item.IAVL.Key,
+				Value:   item.IAVL.Value,
+				Height:  int8(item.IAVL.Height),
+				Version: item.IAVL.Version,
+			}
+			// Protobuf does not differentiate between []byte{} as nil, but fortunately IAVL does
+			// not allow nil keys nor nil values for leaf nodes, so we can always set them to empty.
+			if node.Key == nil {
+				node.Key = []byte{}
+			}
+			if node.Height == 0 && node.Value == nil {
+				node.Value = []byte{}
+			}
+			importer.AddNode(node)
+		default:
+			// unknown element, could be an extension
+			break loop
+		}
+	}
+
+	if err := importer.Finalize(); err != nil {
+		return types.SnapshotItem{}, err
+	}
+
+	return snapshotItem, nil
+}
+
+// TriggerStateSyncExport returns the callback for memiavl's `Options.TriggerStateSyncExport`, which takes the
+// state-sync snapshot after a new memiavl snapshot is written, the manager is resolved lazily because it's set up
+// after the db is created.
+func TriggerStateSyncExport(manager func() *snapshots.Manager) func(height int64) {
+	return func(height int64) {
+		go manager().SnapshotIfApplicable(height)
+	}
+}
@@ -0,0 +1,85 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	protoio "github.com/cosmos/gogoproto/io"
+	"github.com/cosmos/gogoproto/proto"
+
+	storetypes "cosmossdk.io/store/types"
+)
+
+var _ storetypes.ABCIListener = (*FileListener)(nil)
+
+// FileListener writes the state changes of each block into two files in the directory, the same layout as the file
+// streaming service of cosmos-sdk 0.47:
+//
+//   - `block-{height}-meta`: the FinalizeBlock request and response, and the Commit response.
+//   - `block-{height}-data`: the `StoreKVPair`s committed in the block.
+//
+// The messages are protobuf encoded and prefixed with the uvarint length, the files are written on commit and renamed
+// into place atomically, so the readers never see partial files.
+type FileListener struct {
+	dir string
+
+	// the FinalizeBlock messages of the block being committed
+	height int64
+	meta   []proto.Message
+}
+
+func NewFileListener(dir string) (*FileListener, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileListener{dir: dir}, nil
+}
+
+// ListenFinalizeBlock implements `ABCIListener` interface.
+func (l *FileListener) ListenFinalizeBlock(_ context.Context, req abci.RequestFinalizeBlock, res abci.ResponseFinalizeBlock) error {
+	l.height = req.Height
+	l.meta = []proto.Message{&req, &res}
+	return nil
+}
+
+// ListenCommit implements `ABCIListener` interface.
+func (l *FileListener) ListenCommit(_ context.Context, res abci.ResponseCommit, changeSet []*storetypes.StoreKVPair) error {
+	data := make([]proto.Message, len(changeSet))
+	for i, pair := range changeSet {
+		data[i] = pair
+	}
+
+	// the data file is written first, the presence of the meta file indicates the block is complete
+	if err := writeDelimitedFile(filepath.Join(l.dir, fmt.Sprintf("block-%d-data", l.height)), data); err != nil {
+		return err
+	}
+	meta := append(l.meta, &res)
+	l.meta = nil
+	return writeDelimitedFile(filepath.Join(l.dir, fmt.Sprintf("block-%d-meta", l.height)), meta)
+}
+
+func writeDelimitedFile(path string, msgs []proto.Message) error {
+	tmpPath := path + ".tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := protoio.NewDelimitedWriter(fp)
+	for _, msg := range msgs {
+		if err := writer.WriteMsg(msg); err != nil {
+			_ = fp.Close()
+			return err
+		}
+	}
+	if err := fp.Sync(); err != nil {
+		_ = fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
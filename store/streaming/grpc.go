@@ -0,0 +1,56 @@
+package streaming
+
+import (
+	"context"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	streamingabci "cosmossdk.io/store/streaming/abci"
+	storetypes "cosmossdk.io/store/types"
+)
+
+var _ storetypes.ABCIListener = (*GRPCListener)(nil)
+
+// GRPCListener forwards the state changes to a remote `ABCIListenerService`, it's the same service the streaming
+// plugins of cosmos-sdk implement, so the existing plugins can be deployed as standalone services.
+type GRPCListener struct {
+	conn   *grpc.ClientConn
+	client streamingabci.ABCIListenerServiceClient
+
+	// the height of the block being committed
+	height int64
+}
+
+func NewGRPCListener(address string) (*GRPCListener, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCListener{
+		conn:   conn,
+		client: streamingabci.NewABCIListenerServiceClient(conn),
+	}, nil
+}
+
+// ListenFinalizeBlock implements `ABCIListener` interface.
+func (l *GRPCListener) ListenFinalizeBlock(ctx context.Context, req abci.RequestFinalizeBlock, res abci.ResponseFinalizeBlock) error {
+	l.height = req.Height
+	_, err := l.client.ListenFinalizeBlock(ctx, &streamingabci.ListenFinalizeBlockRequest{Req: &req, Res: &res})
+	return err
+}
+
+// ListenCommit implements `ABCIListener` interface.
+func (l *GRPCListener) ListenCommit(ctx context.Context, res abci.ResponseCommit, changeSet []*storetypes.StoreKVPair) error {
+	_, err := l.client.ListenCommit(ctx, &streamingabci.ListenCommitRequest{
+		BlockHeight: l.height,
+		Res:         &res,
+		ChangeSet:   changeSet,
+	})
+	return err
+}
+
+func (l *GRPCListener) Close() error {
+	return l.conn.Close()
+}
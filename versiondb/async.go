@@ -0,0 +1,228 @@
+package versiondb
+
+import (
+	"errors"
+	"sync"
+
+	"cosmossdk.io/store/types"
+)
+
+var (
+	_ VersionStore = (*AsyncVersionStore)(nil)
+
+	ErrAsyncStoreClosed = errors.New("async version store is closed")
+)
+
+// AsyncVersionStore wraps a `VersionStore` to persist the change sets in a background goroutine,
+// so the writing don't add latency to block commit, the pending blocks are merged into a single write batch
+// if the underlying store implements `BatchVersionStore`.
+//
+// The pending queue is bounded, `PutAtVersion` blocks when it's full, so the writer can't lag behind too much.
+// The reads wait for the pending blocks they depend on, so they never observe stale state.
+//
+// If the node crashes, the pending blocks are lost, it's safe because the iavl tree is capped to the
+// latest version of versiondb on startup, the lost blocks will be replayed.
+type AsyncVersionStore struct {
+	VersionStore
+
+	maxPending int
+
+	mtx  sync.Mutex
+	cond *sync.Cond
+	// the change sets not persisted yet
+	pending []VersionedChangeSet
+	// the latest version accepted by `PutAtVersion`
+	queuedVersion int64
+	// the latest version persisted by the background writer
+	writtenVersion int64
+	// the first error happened in background writer, the store stops working after that
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+// NewAsyncVersionStore starts the background writer, `maxPending` is the maximum number of blocks buffered.
+func NewAsyncVersionStore(store VersionStore, maxPending int) (*AsyncVersionStore, error) {
+	version, err := store.GetLatestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if maxPending <= 0 {
+		maxPending = 1
+	}
+
+	s := &AsyncVersionStore{
+		VersionStore:   store,
+		maxPending:     maxPending,
+		queuedVersion:  version,
+		writtenVersion: version,
+		done:           make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mtx)
+	go s.writeLoop()
+	return s, nil
+}
+
+// PutAtVersion implements VersionStore interface, it enqueues the change set and returns immediately,
+// unless the pending queue is full, the errors of previous writes are returned.
+func (s *AsyncVersionStore) PutAtVersion(version int64, changeSet []*types.StoreKVPair) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for len(s.pending) >= s.maxPending && s.err == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return ErrAsyncStoreClosed
+	}
+
+	s.pending = append(s.pending, VersionedChangeSet{Version: version, ChangeSet: changeSet})
+	s.queuedVersion = version
+	s.cond.Broadcast()
+	return nil
+}
+
+// GetAtVersion implements VersionStore interface
+func (s *AsyncVersionStore) GetAtVersion(storeKey string, key []byte, version *int64) ([]byte, error) {
+	if err := s.waitFor(version); err != nil {
+		return nil, err
+	}
+	return s.VersionStore.GetAtVersion(storeKey, key, version)
+}
+
+// HasAtVersion implements VersionStore interface
+func (s *AsyncVersionStore) HasAtVersion(storeKey string, key []byte, version *int64) (bool, error) {
+	if err := s.waitFor(version); err != nil {
+		return false, err
+	}
+	return s.VersionStore.HasAtVersion(storeKey, key, version)
+}
+
+// IteratorAtVersion implements VersionStore interface
+func (s *AsyncVersionStore) IteratorAtVersion(storeKey string, start, end []byte, version *int64) (Iterator, error) {
+	if err := s.waitFor(version); err != nil {
+		return nil, err
+	}
+	return s.VersionStore.IteratorAtVersion(storeKey, start, end, version)
+}
+
+// ReverseIteratorAtVersion implements VersionStore interface
+func (s *AsyncVersionStore) ReverseIteratorAtVersion(storeKey string, start, end []byte, version *int64) (Iterator, error) {
+	if err := s.waitFor(version); err != nil {
+		return nil, err
+	}
+	return s.VersionStore.ReverseIteratorAtVersion(storeKey, start, end, version)
+}
+
+// GetLatestVersion implements VersionStore interface, it waits for the pending writes.
+func (s *AsyncVersionStore) GetLatestVersion() (int64, error) {
+	if err := s.waitFor(nil); err != nil {
+		return 0, err
+	}
+	return s.VersionStore.GetLatestVersion()
+}
+
+// Import implements VersionStore interface, it waits for the pending writes.
+func (s *AsyncVersionStore) Import(version int64, ch <-chan ImportEntry) error {
+	if err := s.waitFor(nil); err != nil {
+		return err
+	}
+	if err := s.VersionStore.Import(version, ch); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.queuedVersion = version
+	s.writtenVersion = version
+	return nil
+}
+
+// Flush implements VersionStore interface, it waits for the pending writes.
+func (s *AsyncVersionStore) Flush() error {
+	if err := s.waitFor(nil); err != nil {
+		return err
+	}
+	return s.VersionStore.Flush()
+}
+
+// Close flushes the pending writes and stops the background writer.
+func (s *AsyncVersionStore) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mtx.Unlock()
+
+	<-s.done
+
+	if s.err != nil {
+		return s.err
+	}
+	return s.VersionStore.Flush()
+}
+
+// waitFor blocks until the version is persisted, `nil` means all the pending versions.
+func (s *AsyncVersionStore) waitFor(version *int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	target := s.queuedVersion
+	if version != nil && *version < target {
+		target = *version
+	}
+	for s.writtenVersion < target && s.err == nil {
+		s.cond.Wait()
+	}
+	return s.err
+}
+
+func (s *AsyncVersionStore) writeLoop() {
+	defer close(s.done)
+
+	for {
+		s.mtx.Lock()
+		for len(s.pending) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.pending) == 0 {
+			// closed and drained
+			s.mtx.Unlock()
+			return
+		}
+		changeSets := s.pending
+		s.pending = nil
+		s.mtx.Unlock()
+
+		err := s.write(changeSets)
+
+		s.mtx.Lock()
+		if err != nil {
+			s.err = err
+		} else {
+			s.writtenVersion = changeSets[len(changeSets)-1].Version
+		}
+		s.cond.Broadcast()
+		s.mtx.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *AsyncVersionStore) write(changeSets []VersionedChangeSet) error {
+	if store, ok := s.VersionStore.(BatchVersionStore); ok {
+		return store.PutAtVersions(changeSets)
+	}
+
+	for _, cs := range changeSets {
+		if err := s.VersionStore.PutAtVersion(cs.Version, cs.ChangeSet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
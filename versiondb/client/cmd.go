@@ -22,13 +22,19 @@ func ChangeSetGroupCmd(opts Options) *cobra.Command {
 		ListDefaultStoresCmd(opts.DefaultStores),
 		DumpChangeSetCmd(opts),
 		PrintChangeSetCmd(),
+		IndexChangeSetCmd(),
+		GetChangeSetCmd(),
+		DiffChangeSetCmd(),
+		FindKeyCmd(),
 		VerifyChangeSetCmd(opts.DefaultStores),
 		BuildVersionDBSSTCmd(opts.DefaultStores),
 		IngestVersionDBSSTCmd(),
 		ChangeSetToVersionDBCmd(),
 		RestoreAppDBCmd(opts),
+		ImportAppDBCmd(opts),
 		RestoreVersionDBCmd(),
 		FixDataCmd(opts.DefaultStores),
+		ScanGarbageCmd(opts.DefaultStores),
 	)
 	return cmd
 }
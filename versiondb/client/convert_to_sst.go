@@ -49,6 +49,10 @@ func BuildVersionDBSSTCmd(defaultStores []string) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			indexDir, err := cmd.Flags().GetString(flagIndexDir)
+			if err != nil {
+				return err
+			}
 
 			changeSetDir := args[0]
 			sstDir := args[1]
@@ -64,7 +68,7 @@ func BuildVersionDBSSTCmd(defaultStores []string) *cobra.Command {
 			group, _ := pool.GroupContext(context.Background())
 			for _, store := range stores {
 				group.Submit(func() error {
-					return convertSingleStore(store, changeSetDir, sstDir, sstFileSize, sorterChunkSize)
+					return convertSingleStore(store, changeSetDir, sstDir, indexDir, sstFileSize, sorterChunkSize)
 				})
 			}
 
@@ -76,13 +80,14 @@ func BuildVersionDBSSTCmd(defaultStores []string) *cobra.Command {
 	cmd.Flags().String(flagStores, "", "list of store names, default to the current store list in application")
 	cmd.Flags().Int64(flagSorterChunkSize, DefaultSorterChunkSize, "uncompressed chunk size for external sorter, it decides the peak ram usage, on disk it'll be snappy compressed")
 	cmd.Flags().Int(flagConcurrency, runtime.NumCPU(), "Number concurrent goroutines to parallelize the work")
+	cmd.Flags().String(flagIndexDir, "", "write the block index files of the change set files into the directory, in the same layout as the change set directory, not written if empty")
 
 	return cmd
 }
 
 // convertSingleStore handles a single store, can run in parallel with other stores,
 // it starts extra goroutines for parallel pipeline.
-func convertSingleStore(store, changeSetDir, sstDir string, sstFileSize uint64, sorterChunkSize int64) error {
+func convertSingleStore(store, changeSetDir, sstDir, indexDir string, sstFileSize uint64, sorterChunkSize int64) error {
 	csFiles, err := scanChangeSetFiles(changeSetDir, store)
 	if err != nil {
 		return err
@@ -102,12 +107,14 @@ func convertSingleStore(store, changeSetDir, sstDir string, sstFileSize uint64,
 	}, PipelineBufferSize)
 
 	for _, file := range csFiles {
+		index := newIndexBuilder(indexDir, filepath.Join(store, filepath.Base(file.FileName)))
 		if err = withChangeSetFile(file.FileName, func(reader Reader) error {
 			_, err := IterateChangeSets(reader, func(version int64, changeSet *iavl.ChangeSet) (bool, error) {
 				for _, pair := range changeSet.Pairs {
 					inputChan <- encodeSorterItem(uint64(version), pair)
 					isEmpty = false
 				}
+				index.add(version, changeSet)
 				return true, nil
 			})
 
@@ -115,6 +122,9 @@ func convertSingleStore(store, changeSetDir, sstDir string, sstFileSize uint64,
 		}); err != nil {
 			break
 		}
+		if err = index.finish(); err != nil {
+			break
+		}
 	}
 	close(inputChan)
 	if err != nil {
@@ -185,9 +195,12 @@ func scanChangeSetFiles(changeSetDir, store string) ([]FileWithVersion, error) {
 		}
 		return nil, err
 	}
-	fileNames := make([]string, len(entries))
-	for i, entry := range entries {
-		fileNames[i] = filepath.Join(storeDir, entry.Name())
+	fileNames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if isIndexFile(entry.Name()) {
+			continue
+		}
+		fileNames = append(fileNames, filepath.Join(storeDir, entry.Name()))
 	}
 	return SortFilesByFirstVerson(fileNames)
 }
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/iavl"
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/spf13/cobra"
+)
+
+func DiffChangeSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff dir",
+		Short: "Print the net key changes of a store between two heights, by folding the change set files or the WAL of memiavl db",
+		Long: `Print the net key changes of a store between two heights, the changes in the blocks (from, to] are folded together,
+only the last write of each key is kept, the result is printed in json format, one key per line, sorted by key.
+
+The dir can be either a change set directory, or a memiavl db directory, in which case the WAL is used.
+A key written and deleted in the range is printed as deleted, even if it don't exist at the from height.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := cmd.Flags().GetInt64(flagFrom)
+			if err != nil {
+				return err
+			}
+			to, err := cmd.Flags().GetInt64(flagTo)
+			if err != nil {
+				return err
+			}
+			store, err := cmd.Flags().GetString(flagStore)
+			if err != nil {
+				return err
+			}
+			keyPrefix, err := cmd.Flags().GetString(flagKeyPrefix)
+			if err != nil {
+				return err
+			}
+			prefix, err := hex.DecodeString(strings.TrimPrefix(keyPrefix, "0x"))
+			if err != nil {
+				return fmt.Errorf("invalid key prefix: %w", err)
+			}
+			if len(store) == 0 {
+				return errors.New("store name is required")
+			}
+			if to <= from {
+				return fmt.Errorf("invalid height range: (%d, %d]", from, to)
+			}
+
+			changeSet, err := diffStoreChangeSets(args[0], store, prefix, from, to)
+			if err != nil {
+				return err
+			}
+			return printChangeSet(changeSet)
+		},
+	}
+	cmd.Flags().Int64(flagFrom, 0, "the start height, exclusive")
+	cmd.Flags().Int64(flagTo, 0, "the end height, inclusive")
+	cmd.Flags().String(flagStore, "", "the store name")
+	cmd.Flags().String(flagKeyPrefix, "", "only print the keys with the hex encoded prefix")
+	return cmd
+}
+
+// changeSetFolder folds the change sets into the net changes, keeps the last write of each key.
+type changeSetFolder struct {
+	prefix []byte
+	pairs  map[string]*iavl.KVPair
+}
+
+func newChangeSetFolder(prefix []byte) *changeSetFolder {
+	return &changeSetFolder{prefix: prefix, pairs: make(map[string]*iavl.KVPair)}
+}
+
+func (f *changeSetFolder) add(pair *iavl.KVPair) {
+	if !bytes.HasPrefix(pair.Key, f.prefix) {
+		return
+	}
+	f.pairs[string(pair.Key)] = pair
+}
+
+// result returns the net changes sorted by key.
+func (f *changeSetFolder) result() *iavl.ChangeSet {
+	changeSet := &iavl.ChangeSet{Pairs: make([]*iavl.KVPair, 0, len(f.pairs))}
+	for _, pair := range f.pairs {
+		changeSet.Pairs = append(changeSet.Pairs, pair)
+	}
+	sort.Slice(changeSet.Pairs, func(i, j int) bool {
+		return bytes.Compare(changeSet.Pairs[i].Key, changeSet.Pairs[j].Key) < 0
+	})
+	return changeSet
+}
+
+// diffStoreChangeSets folds the change sets of the store in the version range (from, to].
+func diffStoreChangeSets(dir, store string, prefix []byte, from, to int64) (*iavl.ChangeSet, error) {
+	folder := newChangeSetFolder(prefix)
+	if err := iterateStoreChangeSets(dir, store, from, to, func(_ int64, changeSet *iavl.ChangeSet) error {
+		for _, pair := range changeSet.Pairs {
+			folder.add(pair)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return folder.result(), nil
+}
+
+// iterateChangeSetFiles iterates the change sets of the store in the version range (from, to] from the change set files.
+func iterateChangeSetFiles(changeSetDir, store string, from, to int64, fn func(int64, *iavl.ChangeSet) error) error {
+	files, err := scanChangeSetFiles(changeSetDir, store)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no change set files found for store %s", store)
+	}
+
+	for i, file := range files {
+		if file.Version > uint64(to) {
+			break
+		}
+		if i+1 < len(files) && files[i+1].Version <= uint64(from+1) {
+			// the whole file is before the range
+			continue
+		}
+
+		if err := withChangeSetFile(file.FileName, func(reader Reader) error {
+			_, err := IterateChangeSets(reader, func(version int64, changeSet *iavl.ChangeSet) (bool, error) {
+				if version <= from {
+					return true, nil
+				}
+				if version > to {
+					return false, nil
+				}
+				return true, fn(version, changeSet)
+			})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateWAL iterates the change sets of the store in the version range (from, to] from the WAL of memiavl db,
+// the versions where the store is not changed are skipped.
+func iterateWAL(dir, store string, from, to int64, fn func(int64, *iavl.ChangeSet) error) error {
+	return memiavl.IterateWAL(dir, from+1, to, func(version int64, entry *memiavl.WALEntry) (bool, error) {
+		for _, cs := range entry.Changesets {
+			if cs.Name != store {
+				continue
+			}
+			changeSet := &iavl.ChangeSet{Pairs: make([]*iavl.KVPair, len(cs.Changeset.Pairs))}
+			for i, pair := range cs.Changeset.Pairs {
+				changeSet.Pairs[i] = &iavl.KVPair{Delete: pair.Delete, Key: pair.Key, Value: pair.Value}
+			}
+			if err := fn(version, changeSet); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// iterateStoreChangeSets iterates the change sets of the store in the version range (from, to], from either
+// a change set directory or the WAL of a memiavl db.
+func iterateStoreChangeSets(dir, store string, from, to int64, fn func(int64, *iavl.ChangeSet) error) error {
+	if isMemIAVLDB(dir) {
+		return iterateWAL(dir, store, from, to, fn)
+	}
+	return iterateChangeSetFiles(dir, store, from, to, fn)
+}
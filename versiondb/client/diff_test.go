@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffChangeSets(t *testing.T) {
+	changeSetDir := t.TempDir()
+	storeDir := filepath.Join(changeSetDir, "bank")
+	require.NoError(t, os.MkdirAll(storeDir, os.ModePerm))
+
+	// split the change sets into two files
+	for _, first := range []int{1, 3} {
+		var buf bytes.Buffer
+		end := len(ChangeSets)
+		if first == 1 {
+			end = 2
+		}
+		for i := first - 1; i < end; i++ {
+			require.NoError(t, WriteChangeSet(&buf, int64(i+1), ChangeSets[i]))
+		}
+		fileName := filepath.Join(storeDir, "block-"+strconv.Itoa(first))
+		require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0o600))
+	}
+
+	changeSet, err := diffStoreChangeSets(changeSetDir, "bank", nil, 1, 3)
+	require.NoError(t, err)
+	var keys []string
+	for _, pair := range changeSet.Pairs {
+		keys = append(keys, string(pair.Key))
+	}
+	require.Equal(t, []string{"hello", "hello1", "hello2", "hello3"}, keys)
+	require.Equal(t, []byte("world1"), changeSet.Pairs[0].Value)
+
+	changeSet, err = diffStoreChangeSets(changeSetDir, "bank", []byte("hello1"), 0, 5)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(changeSet.Pairs))
+	require.Equal(t, []byte("hello1"), changeSet.Pairs[0].Key)
+	require.False(t, changeSet.Pairs[0].Delete)
+	require.Equal(t, []byte("hello19"), changeSet.Pairs[1].Key)
+	require.True(t, changeSet.Pairs[1].Delete)
+}
+
+func TestFindKey(t *testing.T) {
+	var buf bytes.Buffer
+	for i, changeSet := range ChangeSets {
+		require.NoError(t, WriteChangeSet(&buf, int64(i+1), changeSet))
+	}
+	changeSetDir := t.TempDir()
+	storeDir := filepath.Join(changeSetDir, "bank")
+	require.NoError(t, os.MkdirAll(storeDir, os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(storeDir, "block-1"), buf.Bytes(), 0o600))
+
+	writes, err := findKey(changeSetDir, "bank", []byte("hello"), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []keyWrite{
+		{Version: 1, Value: []byte("world")},
+		{Version: 2, Value: []byte("world1")},
+		{Version: 5, Delete: true},
+	}, writes)
+
+	writes, err = findKey(changeSetDir, "bank", []byte("hello"), 1, 4)
+	require.NoError(t, err)
+	require.Equal(t, []keyWrite{{Version: 2, Value: []byte("world1")}}, writes)
+}
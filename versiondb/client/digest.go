@@ -0,0 +1,276 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// DigestFileSuffix is appended to the store name to get the name of the digest file in change set directory.
+	DigestFileSuffix = ".digests"
+	// HeadDigestFileName is the file in change set directory which stores the hex encoded head digest.
+	HeadDigestFileName = "head-digest"
+
+	// SizeDigestEntry is the size of an encoded digest entry: version(8) + digest(32)
+	SizeDigestEntry = 8 + sha256.Size
+)
+
+// Digest is the chained digest of change sets.
+type Digest [sha256.Size]byte
+
+// DigestEntry is the chained digest of a block.
+type DigestEntry struct {
+	Version int64
+	Digest  Digest
+}
+
+// chainDigest computes the digest of a block, chained with the digest of the previous block:
+// `sha256(prev || block)`, the block is the encoded change set including the version header.
+func chainDigest(prev Digest, block []byte) Digest {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(block)
+
+	var digest Digest
+	h.Sum(digest[:0])
+	return digest
+}
+
+// readRawChangeSet reads the encoded change set of a block without decoding the payload.
+func readRawChangeSet(reader Reader) (int64, []byte, error) {
+	var versionHeader [16]byte
+	if _, err := io.ReadFull(reader, versionHeader[:]); err != nil {
+		return 0, nil, err
+	}
+	version := int64(binary.LittleEndian.Uint64(versionHeader[:8]))
+	size := binary.LittleEndian.Uint64(versionHeader[8:16])
+
+	var buf bytes.Buffer
+	buf.Write(versionHeader[:])
+	if _, err := io.CopyN(&buf, reader, int64(size)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return version, buf.Bytes(), nil
+}
+
+// DigestChangeSets iterates the change set stream and computes the chained digests, starting from `prev`,
+// returns the digest of the last block.
+func DigestChangeSets(reader Reader, prev Digest, fn func(DigestEntry) error) (Digest, error) {
+	for {
+		version, block, err := readRawChangeSet(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return prev, nil
+			}
+			return prev, err
+		}
+
+		prev = chainDigest(prev, block)
+		if err := fn(DigestEntry{Version: version, Digest: prev}); err != nil {
+			return prev, err
+		}
+	}
+}
+
+// HeadDigest combines the digests of the last blocks of the stores into a single one,
+// `sha256(name1 || digest1 || name2 || digest2 ...)`, the stores are sorted by name.
+func HeadDigest(heads map[string]Digest) Digest {
+	names := make([]string, 0, len(heads))
+	for name := range heads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		digest := heads[name]
+		h.Write([]byte(name))
+		h.Write(digest[:])
+	}
+
+	var digest Digest
+	h.Sum(digest[:0])
+	return digest
+}
+
+// digestFileName returns the name of digest file of the store in change set directory.
+func digestFileName(changeSetDir, store string) string {
+	return filepath.Join(changeSetDir, store+DigestFileSuffix)
+}
+
+// ReadDigestFile decodes the digest entries from the file.
+func ReadDigestFile(fileName string) ([]DigestEntry, error) {
+	bz, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(bz)%SizeDigestEntry != 0 {
+		return nil, fmt.Errorf("invalid digest file size: %d, file: %s", len(bz), fileName)
+	}
+
+	entries := make([]DigestEntry, len(bz)/SizeDigestEntry)
+	for i := range entries {
+		entry := bz[i*SizeDigestEntry:]
+		entries[i].Version = int64(binary.LittleEndian.Uint64(entry))
+		copy(entries[i].Digest[:], entry[8:SizeDigestEntry])
+	}
+	return entries, nil
+}
+
+// WriteDigestFile encodes the digest entries to the file.
+func WriteDigestFile(fileName string, entries []DigestEntry) (returnErr error) {
+	fp, err := createFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := fp.Close(); returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	writer := bufio.NewWriter(fp)
+	var buf [8]byte
+	for _, entry := range entries {
+		binary.LittleEndian.PutUint64(buf[:], uint64(entry.Version))
+		if _, err := writer.Write(buf[:]); err != nil {
+			return err
+		}
+		if _, err := writer.Write(entry.Digest[:]); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// loadDigestsBefore loads the existing digest entries of the store older than the version,
+// so incremental dumps can continue the chain.
+func loadDigestsBefore(changeSetDir, store string, version int64) ([]DigestEntry, error) {
+	entries, err := ReadDigestFile(digestFileName(changeSetDir, store))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Version >= version
+	})
+	return entries[:i], nil
+}
+
+// lastDigest returns the digest of the last entry, or zero digest if empty.
+func lastDigest(entries []DigestEntry) Digest {
+	if len(entries) == 0 {
+		return Digest{}
+	}
+	return entries[len(entries)-1].Digest
+}
+
+// digestChangeSetFiles recomputes the chained digests from the change set files of the store.
+func digestChangeSetFiles(changeSetDir, store string, fn func(DigestEntry) error) (Digest, error) {
+	files, err := scanChangeSetFiles(changeSetDir, store)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	var digest Digest
+	for _, file := range files {
+		if err := withChangeSetFile(file.FileName, func(reader Reader) error {
+			digest, err = DigestChangeSets(reader, digest, fn)
+			return err
+		}); err != nil {
+			return Digest{}, err
+		}
+	}
+	return digest, nil
+}
+
+// writeHeadDigest computes the head digest from the digest files of the stores, and writes it to change set directory.
+func writeHeadDigest(changeSetDir string, stores []string) (Digest, error) {
+	heads := make(map[string]Digest, len(stores))
+	for _, store := range stores {
+		entries, err := ReadDigestFile(digestFileName(changeSetDir, store))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// store not exists
+				continue
+			}
+			return Digest{}, err
+		}
+		if len(entries) > 0 {
+			heads[store] = lastDigest(entries)
+		}
+	}
+
+	head := HeadDigest(heads)
+	return head, os.WriteFile(filepath.Join(changeSetDir, HeadDigestFileName), []byte(hex.EncodeToString(head[:])), 0o600)
+}
+
+// verifyDigests recomputes the chained digests of the change set files, and compare the head digest with
+// the published one, if not match, it tries to locate the first mismatched block using the digest files.
+func verifyDigests(changeSetDir string, stores []string, expected Digest) error {
+	heads := make(map[string]Digest, len(stores))
+	for _, store := range stores {
+		recorded, err := ReadDigestFile(digestFileName(changeSetDir, store))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		var (
+			i        int
+			mismatch *DigestEntry
+		)
+		empty := true
+		head, err := digestChangeSetFiles(changeSetDir, store, func(entry DigestEntry) error {
+			empty = false
+			if mismatch == nil && i < len(recorded) && recorded[i] != entry {
+				mismatch = &entry
+			}
+			i++
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("store %s: %w", store, err)
+		}
+		if mismatch != nil {
+			return fmt.Errorf("store %s: digest mismatch at version %d", store, mismatch.Version)
+		}
+		if !empty {
+			heads[store] = head
+		}
+	}
+
+	head := HeadDigest(heads)
+	if head != expected {
+		return fmt.Errorf("head digest mismatch, expect %X, got %X", expected[:], head[:])
+	}
+	return nil
+}
+
+// parseDigest decodes the hex encoded digest.
+func parseDigest(s string) (Digest, error) {
+	var digest Digest
+	bz, err := hex.DecodeString(s)
+	if err != nil {
+		return digest, err
+	}
+	if len(bz) != len(digest) {
+		return digest, fmt.Errorf("invalid digest length: %d", len(bz))
+	}
+	copy(digest[:], bz)
+	return digest, nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestChain(t *testing.T) {
+	var buf bytes.Buffer
+	for i, changeSet := range ChangeSets {
+		require.NoError(t, WriteChangeSet(&buf, int64(i+1), changeSet))
+	}
+
+	dir := t.TempDir()
+	storeDir := filepath.Join(dir, "bank")
+	require.NoError(t, os.MkdirAll(storeDir, os.ModePerm))
+	fileName := filepath.Join(storeDir, "block-1")
+	require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0o600))
+
+	var entries []DigestEntry
+	_, err := DigestChangeSets(bytes.NewReader(buf.Bytes()), Digest{}, func(entry DigestEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(ChangeSets), len(entries))
+	require.NoError(t, WriteDigestFile(digestFileName(dir, "bank"), entries))
+
+	head, err := writeHeadDigest(dir, []string{"bank", "acc"})
+	require.NoError(t, err)
+	require.NoError(t, verifyDigests(dir, []string{"bank", "acc"}, head))
+
+	// tamper the last block
+	bz := buf.Bytes()
+	bz[len(bz)-1] ^= 1
+	require.NoError(t, os.WriteFile(fileName, bz, 0o600))
+	require.Error(t, verifyDigests(dir, []string{"bank", "acc"}, head))
+}
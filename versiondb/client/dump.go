@@ -152,14 +152,39 @@ func DumpChangeSetCmd(opts Options) *cobra.Command {
 					})
 				}
 
+				// continue the digest chain of previous dumps
+				digests, err := loadDigestsBefore(outDir, store, storeStartVersion)
+				if err != nil {
+					return err
+				}
+
 				// for each chunk, wait for related tasks to finish, and concatenate the result files in order
 				for _, chunk := range chunks {
 					if err := chunk.collect(outDir, zlibLevel); err != nil {
 						return err
 					}
+
+					if err := withChangeSetFile(chunk.outputFile(outDir, zlibLevel), func(reader Reader) error {
+						_, err := DigestChangeSets(reader, lastDigest(digests), func(entry DigestEntry) error {
+							digests = append(digests, entry)
+							return nil
+						})
+						return err
+					}); err != nil {
+						return err
+					}
+				}
+
+				if err := WriteDigestFile(digestFileName(outDir, store), digests); err != nil {
+					return err
 				}
 			}
 
+			head, err := writeHeadDigest(outDir, stores)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("head digest: %X\n", head[:])
 			return nil
 		},
 	}
@@ -222,6 +247,15 @@ type chunk struct {
 	taskGroup    *pond.TaskGroupWithContext
 }
 
+// outputFile returns the name of the chunk file.
+func (c *chunk) outputFile(outDir string, zlibLevel int) string {
+	output := filepath.Join(outDir, c.store, fmt.Sprintf("block-%d", c.beginVersion))
+	if zlibLevel > 0 {
+		output += ZlibFileSuffix
+	}
+	return output
+}
+
 // collect wait for the tasks to complete and concatenate the files into a single output file.
 func (c *chunk) collect(outDir string, zlibLevel int) (returnErr error) {
 	storeDir := filepath.Join(outDir, c.store)
@@ -229,10 +263,7 @@ func (c *chunk) collect(outDir string, zlibLevel int) (returnErr error) {
 		return err
 	}
 
-	output := filepath.Join(storeDir, fmt.Sprintf("block-%d", c.beginVersion))
-	if zlibLevel > 0 {
-		output += ZlibFileSuffix
-	}
+	output := c.outputFile(outDir, zlibLevel)
 
 	if err := c.taskGroup.Wait(); err != nil {
 		return err
@@ -277,7 +308,12 @@ func (c *chunk) collect(outDir string, zlibLevel int) (returnErr error) {
 		}
 	}
 
-	return bufWriter.Flush()
+	if err := bufWriter.Flush(); err != nil {
+		return err
+	}
+
+	// build the block index so the change set of a single block can be located without scanning.
+	return WriteIndexFile(output)
 }
 
 // copyTmpFile append the snappy compressed temporary file to writer
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cosmos/iavl"
+	"github.com/spf13/cobra"
+)
+
+// keyWrite is a write of the key in a block.
+type keyWrite struct {
+	Version int64  `json:"version"`
+	Delete  bool   `json:"delete"`
+	Value   []byte `json:"value"`
+}
+
+func FindKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "find-key dir",
+		Short: "Find the blocks that wrote or deleted a key, by scanning the change set files or the WAL of memiavl db",
+		Long: `Scan the change sets in the blocks (from, to] for every write or deletion of the key, print the heights
+and the values in json format, one per line. The dir can be either a change set directory, or a memiavl db directory,
+in which case the WAL is used.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := cmd.Flags().GetInt64(flagFrom)
+			if err != nil {
+				return err
+			}
+			to, err := cmd.Flags().GetInt64(flagTo)
+			if err != nil {
+				return err
+			}
+			store, err := cmd.Flags().GetString(flagStore)
+			if err != nil {
+				return err
+			}
+			keyHex, err := cmd.Flags().GetString(flagKey)
+			if err != nil {
+				return err
+			}
+			key, err := hex.DecodeString(strings.TrimPrefix(keyHex, "0x"))
+			if err != nil {
+				return fmt.Errorf("invalid key: %w", err)
+			}
+			if len(store) == 0 || len(key) == 0 {
+				return errors.New("store and key are required")
+			}
+			if to == 0 {
+				to = math.MaxInt64
+			}
+
+			writes, err := findKey(args[0], store, key, from, to)
+			if err != nil {
+				return err
+			}
+			for _, write := range writes {
+				js, err := json.Marshal(write)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(js))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Int64(flagFrom, 0, "the start height, exclusive")
+	cmd.Flags().Int64(flagTo, 0, "the end height, inclusive, 0 means no end")
+	cmd.Flags().String(flagStore, "", "the store name")
+	cmd.Flags().String(flagKey, "", "the hex encoded key")
+	return cmd
+}
+
+// findKey returns the writes of the key in the version range (from, to], in ascending order.
+func findKey(dir, store string, key []byte, from, to int64) ([]keyWrite, error) {
+	var writes []keyWrite
+	err := iterateStoreChangeSets(dir, store, from, to, func(version int64, changeSet *iavl.ChangeSet) error {
+		for _, pair := range changeSet.Pairs {
+			if bytes.Equal(pair.Key, key) {
+				writes = append(writes, keyWrite{Version: version, Delete: pair.Delete, Value: pair.Value})
+			}
+		}
+		return nil
+	})
+	return writes, err
+}
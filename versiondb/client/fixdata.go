@@ -49,7 +49,11 @@ func FixDataCmd(defaultStores []string) *cobra.Command {
 				return err
 			}
 
-			return nil
+			if dryRun {
+				return nil
+			}
+			// reclaim the space of the wrong data in the quiet hours
+			return versionDB.RequestCompaction()
 		},
 	}
 
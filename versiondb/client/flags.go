@@ -27,4 +27,13 @@ const (
 	flagInitialVersion   = "initial-version"
 	flagSDK64Compact     = "sdk64-compact"
 	flagIAVLVersion      = "iavl-version"
+	flagHeadDigest       = "head-digest"
+	flagRetainVersion    = "retain-version"
+	flagClean            = "clean"
+	flagRemovalHeights   = "removal-heights"
+	flagFrom             = "from"
+	flagTo               = "to"
+	flagKeyPrefix        = "key-prefix"
+	flagKey              = "key"
+	flagIndexDir         = "index-dir"
 )
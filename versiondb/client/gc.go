@@ -0,0 +1,111 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
+	"github.com/linxGnu/grocksdb"
+	"github.com/spf13/cobra"
+)
+
+func ScanGarbageCmd(defaultStores []string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc <dir>",
+		Args:  cobra.ExactArgs(1),
+		Short: "Scan versiondb for expired tombstones, orphan stores and timestamp anomalies, optionally clean them",
+		Long: `Scan versiondb for the wasted space:
+- keys whose latest version is a tombstone before the retain version.
+- stores not in the list of the app, normally removed by store upgrades.
+- versions newer than the latest version, or at zero.
+
+With --clean, the history before the retain version is pruned, which removes the expired keys and the
+orphan stores, the versions before the retain version can't be queried anymore, the timestamp anomalies are
+only reported, use the fixdata command for the versions at zero. The orphan stores are deleted at their
+removal heights, which must be provided with --removal-heights, so the queries inside the retention window
+still see them before the removal.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := args[0]
+			retainVersion, err := cmd.Flags().GetInt64(flagRetainVersion)
+			if err != nil {
+				return err
+			}
+			clean, err := cmd.Flags().GetBool(flagClean)
+			if err != nil {
+				return err
+			}
+			stores, err := GetStoresOrDefault(cmd, defaultStores)
+			if err != nil {
+				return err
+			}
+			removalHeights, err := cmd.Flags().GetStringToInt64(flagRemovalHeights)
+			if err != nil {
+				return err
+			}
+
+			var (
+				db       *grocksdb.DB
+				cfHandle *grocksdb.ColumnFamilyHandle
+			)
+			if clean {
+				db, cfHandle, err = tsrocksdb.OpenVersionDB(dir)
+			} else {
+				db, cfHandle, err = tsrocksdb.OpenVersionDBForReadOnly(dir, false)
+			}
+			if err != nil {
+				return err
+			}
+			defer func() {
+				cfHandle.Destroy()
+				db.Close()
+			}()
+
+			versionDB := tsrocksdb.NewStoreWithDB(db, cfHandle)
+			report, err := versionDB.ScanGarbage(stores, retainVersion)
+			if err != nil {
+				return err
+			}
+			printGarbageReport(report)
+
+			if !clean {
+				return nil
+			}
+			orphans := make(map[string]int64, len(report.OrphanVersions))
+			for _, store := range report.OrphanStores() {
+				height, ok := removalHeights[store]
+				if !ok {
+					return fmt.Errorf("the removal height of the orphan store %s is unknown, set it with --%s", store, flagRemovalHeights)
+				}
+				orphans[store] = height
+			}
+			return versionDB.CleanGarbage(orphans, retainVersion)
+		},
+	}
+
+	cmd.Flags().Int64(flagRetainVersion, 0, "the first version of the retention window, the keys deleted before it are garbage")
+	cmd.Flags().Bool(flagClean, false, "prune the history before the retain version, open the database in read-write mode")
+	cmd.Flags().String(flagStores, "", "list of store names, default to the current store list in application")
+	cmd.Flags().StringToInt64(flagRemovalHeights, nil, "the heights of the store upgrades which removed the orphan stores, like: store1=100,store2=200")
+	return cmd
+}
+
+func printGarbageReport(report *tsrocksdb.GarbageReport) {
+	fmt.Printf("total versions: %d\n", report.TotalVersions)
+	for _, store := range sortedKeys(report.ExpiredVersions) {
+		fmt.Printf("expired versions: %s %d\n", store, report.ExpiredVersions[store])
+	}
+	for _, store := range sortedKeys(report.OrphanVersions) {
+		fmt.Printf("orphan store versions: %s %d\n", store, report.OrphanVersions[store])
+	}
+	fmt.Printf("future versions: %d\n", report.FutureVersions)
+	fmt.Printf("zero versions: %d\n", report.ZeroVersions)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
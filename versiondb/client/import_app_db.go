@@ -0,0 +1,163 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	dbm "github.com/cosmos/cosmos-db"
+	gogotypes "github.com/cosmos/gogoproto/types"
+	"github.com/cosmos/iavl"
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
+	"github.com/spf13/cobra"
+
+	log "cosmossdk.io/log"
+	"cosmossdk.io/store/wrapper"
+
+	"github.com/cosmos/cosmos-sdk/client/flags"
+	"github.com/cosmos/cosmos-sdk/server"
+)
+
+func ImportAppDBCmd(opts Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import-app-db memiavl-dir",
+		Short: "Build a memiavl db from the iavl trees in `application.db` directly",
+		Long: `Export the iavl trees of the stores in "application.db" at the target version (default to the latest one),
+and import the nodes into a memiavl snapshot in one streaming pass, without an intermediate state-sync snapshot or
+change set files, so nodes on either iavl on-disk format (--iavl-version) can switch to memiavl without re-syncing.
+The stores which don't exist at the target version are skipped.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := server.GetServerContextFromCmd(cmd)
+			if err := ctx.Viper.BindPFlags(cmd.Flags()); err != nil {
+				return err
+			}
+
+			db, err := opts.OpenReadOnlyDB(ctx.Viper.GetString(flags.FlagHome), server.GetAppDBBackend(ctx.Viper))
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			targetVersion, err := cmd.Flags().GetInt64(flagTargetVersion)
+			if err != nil {
+				return err
+			}
+			iavlVersion, err := cmd.Flags().GetInt(flagIAVLVersion)
+			if err != nil {
+				return err
+			}
+			stores, err := GetStoresOrDefault(cmd, opts.DefaultStores)
+			if err != nil {
+				return err
+			}
+
+			if targetVersion == 0 {
+				if targetVersion, err = getLatestAppDBVersion(db); err != nil {
+					return err
+				}
+			}
+
+			return ImportAppDB(db, args[0], stores, targetVersion, iavlVersion)
+		},
+	}
+
+	cmd.Flags().String(flagStores, "", "list of store names, default to the current store list in application")
+	cmd.Flags().Int64(flagTargetVersion, 0, "the version to import, default to the latest version")
+	cmd.Flags().Int(flagIAVLVersion, IAVLV1, "IAVL version, 0: v0, 1: v1")
+	return cmd
+}
+
+// ImportAppDB exports the iavl trees of the stores at the version and imports them into a new memiavl db in `dir`.
+func ImportAppDB(db dbm.DB, dir string, stores []string, version int64, iavlVersion int) (returnErr error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	importer, err := memiavl.NewMultiTreeImporter(dir, uint64(version))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, importer.Close())
+	}()
+
+	// the trees of the snapshot are ordered by name
+	stores = append([]string(nil), stores...)
+	sort.Strings(stores)
+
+	for _, store := range stores {
+		prefixDB := dbm.NewPrefixDB(db, []byte(fmt.Sprintf(tsrocksdb.StorePrefixTpl, store)))
+		firstVersion, err := getFirstVersion(prefixDB, iavlVersion)
+		if err != nil {
+			return err
+		}
+		if firstVersion == 0 || firstVersion > version {
+			fmt.Println("skip store not exist at the version", store)
+			continue
+		}
+
+		if err := importer.AddTree(store); err != nil {
+			return err
+		}
+		count, err := exportIAVLTree(prefixDB, version, importer.AddNode)
+		if err != nil {
+			return fmt.Errorf("export store %s failed: %w", store, err)
+		}
+		fmt.Println("imported store", store, "nodes", count)
+	}
+
+	return importer.Finalize()
+}
+
+// exportIAVLTree exports the nodes of the iavl tree at the version in post-order, both iavl on-disk formats are
+// understood by the iavl library.
+func exportIAVLTree(db dbm.DB, version int64, fn func(*memiavl.ExportNode)) (int, error) {
+	tree := iavl.NewMutableTree(wrapper.NewDBWrapper(db), 0, true, log.NewNopLogger())
+	itree, err := tree.GetImmutable(version)
+	if err != nil {
+		return 0, err
+	}
+	exporter, err := itree.Export()
+	if err != nil {
+		return 0, err
+	}
+	defer exporter.Close()
+
+	var count int
+	for {
+		node, err := exporter.Next()
+		if errors.Is(err, iavl.ErrorExportDone) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		fn(&memiavl.ExportNode{
+			Key:     node.Key,
+			Value:   node.Value,
+			Version: node.Version,
+			Height:  node.Height,
+		})
+		count++
+	}
+}
+
+// getLatestAppDBVersion reads the latest version committed to `application.db` by the root multistore.
+func getLatestAppDBVersion(db dbm.DB) (int64, error) {
+	bz, err := db.Get([]byte(latestVersionKey))
+	if err != nil {
+		return 0, err
+	}
+	if bz == nil {
+		return 0, errors.New("empty application.db")
+	}
+
+	var version int64
+	if err := gogotypes.StdInt64Unmarshal(&version, bz); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
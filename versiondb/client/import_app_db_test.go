@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/cosmos/iavl"
+	"github.com/crypto-org-chain/cronos/memiavl"
+	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
+	"github.com/stretchr/testify/require"
+
+	log "cosmossdk.io/log"
+	"cosmossdk.io/store/wrapper"
+)
+
+func TestImportAppDB(t *testing.T) {
+	db := dbm.NewMemDB()
+	newTree := func(store string, opts ...iavl.Option) *iavl.MutableTree {
+		prefixDB := dbm.NewPrefixDB(db, []byte(fmt.Sprintf(tsrocksdb.StorePrefixTpl, store)))
+		return iavl.NewMutableTree(wrapper.NewDBWrapper(prefixDB), 0, true, log.NewNopLogger(), opts...)
+	}
+
+	// "acc" is modified in each version, "bank" is created at version 2
+	acc, bank := newTree("acc"), newTree("bank", iavl.InitialVersionOption(2))
+	hashes := make(map[string]map[int64][]byte)
+	for _, store := range []string{"acc", "bank"} {
+		hashes[store] = make(map[int64][]byte)
+	}
+	for version := int64(1); version <= 3; version++ {
+		for i := 0; i < 100; i++ {
+			_, err := acc.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d-%d", version, i)))
+			require.NoError(t, err)
+		}
+		_, _, err := acc.Remove([]byte(fmt.Sprintf("key%03d", version)))
+		require.NoError(t, err)
+		hash, v, err := acc.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, version, v)
+		hashes["acc"][version] = hash
+
+		if version >= 2 {
+			_, err := bank.Set([]byte("supply"), []byte(fmt.Sprintf("%d", version)))
+			require.NoError(t, err)
+			hash, v, err := bank.SaveVersion()
+			require.NoError(t, err)
+			require.Equal(t, version, v)
+			hashes["bank"][version] = hash
+		}
+	}
+
+	for version := int64(1); version <= 3; version++ {
+		dir := t.TempDir()
+		require.NoError(t, ImportAppDB(db, dir, []string{"bank", "acc"}, version, IAVLV1))
+
+		mdb, err := memiavl.Load(dir, memiavl.Options{ReadOnly: true})
+		require.NoError(t, err)
+		require.Equal(t, version, mdb.Version())
+
+		tree := mdb.TreeByName("acc")
+		require.NotNil(t, tree)
+		require.Equal(t, hashes["acc"][version], tree.RootHash())
+		require.Equal(t, []byte(fmt.Sprintf("value%d-50", version)), tree.Get([]byte("key050")))
+		require.Nil(t, tree.Get([]byte(fmt.Sprintf("key%03d", version))))
+
+		// the store not existing at the version is skipped
+		tree = mdb.TreeByName("bank")
+		if version < 2 {
+			require.Nil(t, tree)
+		} else {
+			require.NotNil(t, tree)
+			require.Equal(t, hashes["bank"][version], tree.RootHash())
+			require.Equal(t, []byte(fmt.Sprintf("%d", version)), tree.Get([]byte("supply")))
+		}
+		require.NoError(t, mdb.Close())
+	}
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cosmos/iavl"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// IndexFileSuffix is appended to the change set file name to get the name of the index file.
+	IndexFileSuffix = ".idx"
+
+	// SizeIndexEntry is the size of an encoded index entry: version(8) + offset(8) + size(8)
+	SizeIndexEntry = 24
+)
+
+// IndexEntry locates the change set of a single block inside a change set file.
+//
+// Offset and Size are measured in the decompressed change set stream, and include the 16 bytes version header,
+// so for plain files it's the exact byte range in the file, for compressed files the reader still need to
+// decompress the leading bytes, but the payload parsing of the preceding blocks is skipped.
+type IndexEntry struct {
+	Version int64
+	Offset  int64
+	Size    int64
+}
+
+// ChangeSetIndex is a list of index entries sorted by version.
+type ChangeSetIndex []IndexEntry
+
+// Find returns the index entry of the version, returns false if not found.
+func (idx ChangeSetIndex) Find(version int64) (IndexEntry, bool) {
+	i := sort.Search(len(idx), func(i int) bool {
+		return idx[i].Version >= version
+	})
+	if i < len(idx) && idx[i].Version == version {
+		return idx[i], true
+	}
+	return IndexEntry{}, false
+}
+
+// IndexFileName returns the name of the index file of the change set file.
+func IndexFileName(fileName string) string {
+	return fileName + IndexFileSuffix
+}
+
+// isIndexFile checks if the file is a change set index file rather than a change set file.
+func isIndexFile(fileName string) bool {
+	return strings.HasSuffix(fileName, IndexFileSuffix)
+}
+
+// BuildChangeSetIndex scans the change set stream and records the position of each version,
+// the change set payloads are skipped without parsing.
+//
+// Index file format:
+// ```
+// version: int64
+// offset: int64
+// size: int64
+//
+// repeat with next version
+// ```
+func BuildChangeSetIndex(reader Reader) (ChangeSetIndex, error) {
+	var (
+		idx    ChangeSetIndex
+		offset int64
+	)
+	for {
+		version, size, _, err := ReadChangeSet(reader, false)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return idx, nil
+			}
+			return nil, err
+		}
+		idx = append(idx, IndexEntry{Version: version, Offset: offset, Size: size})
+		offset += size
+	}
+}
+
+// WriteChangeSetIndex encodes the index entries to writer.
+func WriteChangeSetIndex(writer io.Writer, idx ChangeSetIndex) error {
+	var buf [SizeIndexEntry]byte
+	for _, entry := range idx {
+		binary.LittleEndian.PutUint64(buf[:], uint64(entry.Version))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(entry.Offset))
+		binary.LittleEndian.PutUint64(buf[16:], uint64(entry.Size))
+		if _, err := writer.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadChangeSetIndex decodes the index entries from the index file.
+func ReadChangeSetIndex(indexFile string) (ChangeSetIndex, error) {
+	bz, err := os.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(bz)%SizeIndexEntry != 0 {
+		return nil, fmt.Errorf("invalid index file size: %d, file: %s", len(bz), indexFile)
+	}
+
+	idx := make(ChangeSetIndex, len(bz)/SizeIndexEntry)
+	for i := range idx {
+		entry := bz[i*SizeIndexEntry:]
+		idx[i] = IndexEntry{
+			Version: int64(binary.LittleEndian.Uint64(entry)),
+			Offset:  int64(binary.LittleEndian.Uint64(entry[8:])),
+			Size:    int64(binary.LittleEndian.Uint64(entry[16:])),
+		}
+	}
+	return idx, nil
+}
+
+// WriteIndexFile builds the index of the change set file and writes it next to it.
+func WriteIndexFile(fileName string) error {
+	var idx ChangeSetIndex
+	if err := withChangeSetFile(fileName, func(reader Reader) error {
+		var err error
+		idx, err = BuildChangeSetIndex(reader)
+		return err
+	}); err != nil {
+		return err
+	}
+	return writeIndex(IndexFileName(fileName), idx)
+}
+
+// writeIndex writes the index entries to the index file.
+func writeIndex(indexFile string, idx ChangeSetIndex) (returnErr error) {
+	fp, err := createFile(indexFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := fp.Close(); returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	writer := bufio.NewWriter(fp)
+	if err := WriteChangeSetIndex(writer, idx); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// indexBuilder records the index entries of a change set file while it's parsed by the ingest commands, so the index
+// files are written for the change set files not produced by dump, for example downloaded ones, without scanning
+// them again. The input directories are not modified, the index file is written into `--index-dir`, it's a no-op if
+// the flag is not set.
+type indexBuilder struct {
+	indexFile string
+	idx       ChangeSetIndex
+	offset    int64
+}
+
+// newIndexBuilder returns the builder writing the index file of the change set file into `indexDir`, under the
+// relative path `name`, the builder is disabled if `indexDir` is empty.
+func newIndexBuilder(indexDir, name string) *indexBuilder {
+	if len(indexDir) == 0 {
+		return &indexBuilder{}
+	}
+	return &indexBuilder{indexFile: IndexFileName(filepath.Join(indexDir, name))}
+}
+
+// add records the change set of the version, the size is derived from the parsed pairs, which must equal the payload
+// size in the header, see `ReadChangeSet`.
+func (b *indexBuilder) add(version int64, changeSet *iavl.ChangeSet) {
+	if len(b.indexFile) == 0 {
+		return
+	}
+	size := int64(16)
+	for _, pair := range changeSet.Pairs {
+		size += int64(encodedSizeOfKVPair(pair))
+	}
+	b.idx = append(b.idx, IndexEntry{Version: version, Offset: b.offset, Size: size})
+	b.offset += size
+}
+
+// finish writes the index file, it's called after the whole change set file is ingested.
+func (b *indexBuilder) finish() error {
+	if len(b.indexFile) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(b.indexFile), os.ModePerm); err != nil {
+		return err
+	}
+	return writeIndex(b.indexFile, b.idx)
+}
+
+// ReadChangeSetAt reads the change set of a single version from the change set file, using the index entry,
+// plain files are accessed with seek directly, compressed files are decompressed until the offset.
+func ReadChangeSetAt(fileName string, entry IndexEntry) (*iavl.ChangeSet, error) {
+	var reader ReadCloser
+	if strings.HasSuffix(fileName, ZlibFileSuffix) || strings.HasSuffix(fileName, SnappyFileSuffix) {
+		var err error
+		reader, err = openChangeSetFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, reader, entry.Offset); err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+	} else {
+		fp, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fp.Seek(entry.Offset, io.SeekStart); err != nil {
+			_ = fp.Close()
+			return nil, err
+		}
+		reader = WrapReader(bufio.NewReader(fp), fp)
+	}
+	defer reader.Close()
+
+	version, _, changeSet, err := ReadChangeSet(reader, true)
+	if err != nil {
+		return nil, err
+	}
+	if version != entry.Version {
+		return nil, fmt.Errorf("index is stale, expect version %d, got %d", entry.Version, version)
+	}
+	return changeSet, nil
+}
+
+// FindChangeSet locates the change set of the version in the change set directory of a store,
+// it uses the index files to avoid scanning the change set files.
+func FindChangeSet(changeSetDir, store string, version int64) (*iavl.ChangeSet, error) {
+	files, err := scanChangeSetFiles(changeSetDir, store)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last file whose first version is not larger than the target version.
+	i := sort.Search(len(files), func(i int) bool {
+		return files[i].Version > uint64(version)
+	})
+	if i == 0 {
+		return nil, fmt.Errorf("version %d not found in store %s", version, store)
+	}
+	fileName := files[i-1].FileName
+
+	idx, err := ReadChangeSetIndex(IndexFileName(fileName))
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := idx.Find(version)
+	if !ok {
+		return nil, fmt.Errorf("version %d not found in store %s", version, store)
+	}
+	return ReadChangeSetAt(fileName, entry)
+}
+
+func IndexChangeSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index plain-1 [plain-2] ...",
+		Short: "Build the block index files for change set files, so the change set of a single block can be located without scanning",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, fileName := range args {
+				if err := WriteIndexFile(fileName); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func GetChangeSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get changeSetDir store version",
+		Short: "Print the change set of a single block using the index files",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			changeSet, err := FindChangeSet(args[0], args[1], version)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("version: %d\n", version)
+			return printChangeSet(changeSet)
+		},
+	}
+	return cmd
+}
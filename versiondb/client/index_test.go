@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/iavl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeSetIndex(t *testing.T) {
+	var buf bytes.Buffer
+	for i, changeSet := range ChangeSets {
+		require.NoError(t, WriteChangeSet(&buf, int64(i+1), changeSet))
+	}
+
+	storeDir := filepath.Join(t.TempDir(), "bank")
+	require.NoError(t, os.MkdirAll(storeDir, os.ModePerm))
+	fileName := filepath.Join(storeDir, "block-1")
+	require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0o600))
+	require.NoError(t, WriteIndexFile(fileName))
+
+	idx, err := ReadChangeSetIndex(IndexFileName(fileName))
+	require.NoError(t, err)
+	require.Equal(t, len(ChangeSets), len(idx))
+
+	var total int64
+	for _, entry := range idx {
+		total += entry.Size
+	}
+	require.Equal(t, int64(buf.Len()), total)
+
+	for i, changeSet := range ChangeSets {
+		version := int64(i + 1)
+		cs, err := FindChangeSet(filepath.Dir(storeDir), "bank", version)
+		require.NoError(t, err)
+		require.Equal(t, changeSet, cs)
+	}
+
+	_, ok := idx.Find(int64(len(ChangeSets) + 1))
+	require.False(t, ok)
+}
+
+func TestIngestIndex(t *testing.T) {
+	var buf bytes.Buffer
+	for i, changeSet := range ChangeSets {
+		require.NoError(t, WriteChangeSet(&buf, int64(i+1), changeSet))
+	}
+	inputDir := t.TempDir()
+	fileName := filepath.Join(inputDir, "block-1")
+	require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0o600))
+
+	ingest := func(index *indexBuilder) {
+		require.NoError(t, withChangeSetFile(fileName, func(reader Reader) error {
+			_, err := IterateChangeSets(reader, func(version int64, changeSet *iavl.ChangeSet) (bool, error) {
+				index.add(version, changeSet)
+				return true, nil
+			})
+			return err
+		}))
+		require.NoError(t, index.finish())
+	}
+
+	// not written without the index directory
+	ingest(newIndexBuilder("", "acc/block-1"))
+
+	// the index file is written into the index directory, the input directory is not modified
+	indexDir := t.TempDir()
+	ingest(newIndexBuilder(indexDir, "acc/block-1"))
+	entries, err := os.ReadDir(inputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	idx, err := ReadChangeSetIndex(filepath.Join(indexDir, "acc", "block-1"+IndexFileSuffix))
+	require.NoError(t, err)
+
+	// same as the index built by scanning the file
+	require.NoError(t, WriteIndexFile(fileName))
+	expected, err := ReadChangeSetIndex(IndexFileName(fileName))
+	require.NoError(t, err)
+	require.Equal(t, expected, idx)
+}
@@ -32,13 +32,20 @@ func IngestVersionDBSSTCmd() *cobra.Command {
 				}
 			}
 
+			store := tsrocksdb.NewStoreWithDB(db, cfHandle)
+			if len(args) > 1 {
+				// reclaim the space of overlapping versions in the quiet hours
+				if err := store.RequestCompaction(); err != nil {
+					return err
+				}
+			}
+
 			maxVersion, err := cmd.Flags().GetInt64(flagMaximumVersion)
 			if err != nil {
 				return err
 			}
 			if maxVersion > 0 {
 				// update latest version
-				store := tsrocksdb.NewStoreWithDB(db, cfHandle)
 				latestVersion, err := store.GetLatestVersion()
 				if err != nil {
 					return err
@@ -53,12 +53,8 @@ func PrintChangeSetCmd() *cobra.Command {
 						return false, nil
 					}
 					fmt.Printf("version: %d\n", version)
-					for _, pair := range changeSet.Pairs {
-						js, err := json.Marshal(pair)
-						if err != nil {
-							return false, err
-						}
-						fmt.Println(string(js))
+					if err := printChangeSet(changeSet); err != nil {
+						return false, err
 					}
 					return true, nil
 				})
@@ -71,3 +67,15 @@ func PrintChangeSetCmd() *cobra.Command {
 	cmd.Flags().Int64(flagEndVersion, 0, "End(exclusive) of the version range to print, 0 means no end")
 	return cmd
 }
+
+// printChangeSet prints the key-value pairs of the change set in json format, one per line.
+func printChangeSet(changeSet *iavl.ChangeSet) error {
+	for _, pair := range changeSet.Pairs {
+		js, err := json.Marshal(pair)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(js))
+	}
+	return nil
+}
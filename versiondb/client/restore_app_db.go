@@ -50,7 +50,10 @@ func RestoreAppDBCmd(opts Options) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "restore-app-db snapshot-dir application.db",
 		Short: "Restore `application.db` from memiavl snapshots",
-		Args:  cobra.ExactArgs(2),
+		Long: `Restore "application.db" from memiavl snapshots, the snapshot-dir can be a single snapshot, or the whole
+memiavl db, in the latter case, the db is loaded at the target version (default to the latest one) first,
+which makes it possible to disable memiavl without re-syncing the node.`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			sstFileSizeTarget, err := cmd.Flags().GetUint64(flagSSTFileSize)
 			if err != nil {
@@ -68,6 +71,10 @@ func RestoreAppDBCmd(opts Options) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			targetVersion, err := cmd.Flags().GetInt64(flagTargetVersion)
+			if err != nil {
+				return err
+			}
 			stores, err := GetStoresOrDefault(cmd, opts.DefaultStores)
 			if err != nil {
 				return err
@@ -79,6 +86,19 @@ func RestoreAppDBCmd(opts Options) *cobra.Command {
 				return err
 			}
 
+			if isMemIAVLDB(snapshotDir) {
+				tmpDir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(iavlDir)), "memiavl-snapshot-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tmpDir)
+
+				if err := writeMemIAVLSnapshot(snapshotDir, targetVersion, tmpDir); err != nil {
+					return errors.Wrap(err, "write memiavl snapshot fail")
+				}
+				snapshotDir = tmpDir
+			}
+
 			// load the snapshots and compute commit info first
 			var lastestVersion int64
 			var storeInfos []storetypes.StoreInfo
@@ -173,10 +193,36 @@ func RestoreAppDBCmd(opts Options) *cobra.Command {
 	cmd.Flags().Uint64(flagSorterChunkSize, DefaultSorterChunkSizeIAVL, "uncompressed chunk size for external sorter, it decides the peak ram usage, on disk it'll be snappy compressed")
 	cmd.Flags().Int(flagConcurrency, runtime.NumCPU(), "Number concurrent goroutines to parallelize the work")
 	cmd.Flags().Bool(flagSDK64Compact, false, "Should the app hash calculation be compatible with cosmos-sdk v0.46 and earlier")
+	cmd.Flags().Int64(flagTargetVersion, 0, "the version to restore if snapshot-dir is a memiavl db, default to the latest version")
 
 	return cmd
 }
 
+// isMemIAVLDB checks if the directory is a memiavl db rather than a single snapshot.
+func isMemIAVLDB(dir string) bool {
+	_, err := memiavl.CurrentSnapshot(dir)
+	return err == nil
+}
+
+// writeMemIAVLSnapshot loads the memiavl db at the target version, replaying the WAL if necessary,
+// and writes the snapshot to the output directory.
+func writeMemIAVLSnapshot(dir string, targetVersion int64, output string) error {
+	db, err := memiavl.Load(dir, memiavl.Options{
+		TargetVersion: uint32(targetVersion),
+		ReadOnly:      true,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if targetVersion > 0 && db.Version() != targetVersion {
+		return fmt.Errorf("version %d not found in memiavl db, latest version: %d", targetVersion, db.Version())
+	}
+
+	return db.WriteSnapshot(output)
+}
+
 // oneStore process a single store, can run in parallel with other stores,
 func oneStore(sstWriter *grocksdb.SSTFileWriter, store string, snapshot *memiavl.Snapshot, sstDir string, sstFileSizeTarget, sorterChunkSize uint64) error {
 	prefix := []byte(fmt.Sprintf(storeKeyPrefix, store))
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"path/filepath"
+
 	"github.com/cosmos/iavl"
 	"github.com/crypto-org-chain/cronos/versiondb/tsrocksdb"
 	"github.com/spf13/cobra"
@@ -16,6 +18,10 @@ func ChangeSetToVersionDBCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			indexDir, err := cmd.Flags().GetString(flagIndexDir)
+			if err != nil {
+				return err
+			}
 
 			versionDB, err := tsrocksdb.NewStore(args[0])
 			if err != nil {
@@ -23,11 +29,13 @@ func ChangeSetToVersionDBCmd() *cobra.Command {
 			}
 
 			for _, plainFile := range args[1:] {
+				index := newIndexBuilder(indexDir, filepath.Base(plainFile))
 				if err := withChangeSetFile(plainFile, func(reader Reader) error {
 					_, err := IterateChangeSets(reader, func(version int64, changeSet *iavl.ChangeSet) (bool, error) {
 						if err := versionDB.FeedChangeSet(version, store, changeSet); err != nil {
 							return false, err
 						}
+						index.add(version, changeSet)
 						return true, nil
 					})
 
@@ -35,6 +43,9 @@ func ChangeSetToVersionDBCmd() *cobra.Command {
 				}); err != nil {
 					return err
 				}
+				if err := index.finish(); err != nil {
+					return err
+				}
 			}
 
 			return nil
@@ -42,5 +53,6 @@ func ChangeSetToVersionDBCmd() *cobra.Command {
 	}
 
 	cmd.Flags().String(flagStore, "", "store name, the keys are prefixed with \"s/k:{store}/\"")
+	cmd.Flags().String(flagIndexDir, "", "write the block index files of the change set files into the directory, not written if empty")
 	return cmd
 }
@@ -55,6 +55,23 @@ func VerifyChangeSetCmd(defaultStores []string) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			headDigest, err := cmd.Flags().GetString(flagHeadDigest)
+			if err != nil {
+				return err
+			}
+
+			if len(headDigest) > 0 {
+				// validate the integrity of the change set files only, without replaying.
+				expected, err := parseDigest(headDigest)
+				if err != nil {
+					return err
+				}
+				if err := verifyDigests(args[0], stores, expected); err != nil {
+					return err
+				}
+				fmt.Println("head digest verified successfully")
+				return nil
+			}
 
 			if len(saveSnapshot) > 0 {
 				// detect the write permission early on.
@@ -177,6 +194,7 @@ func VerifyChangeSetCmd(defaultStores []string) *cobra.Command {
 	cmd.Flags().Int(flagConcurrency, runtime.NumCPU(), "Number concurrent goroutines to parallelize the work")
 	cmd.Flags().Bool(flagCheck, false, "Check the replayed hash with the one stored in change set directory")
 	cmd.Flags().Bool(flagSave, false, "Save the verify result to change set directory, otherwise output to stdout")
+	cmd.Flags().String(flagHeadDigest, "", "Validate the change set files against the published hex encoded head digest only, without replaying")
 
 	return cmd
 }
@@ -0,0 +1,68 @@
+package versiondb
+
+import (
+	"errors"
+	"strings"
+
+	"cosmossdk.io/store/types"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+var _ types.RootMultiStore = (*FallbackMultiStore)(nil)
+
+// FallbackMultiStore serves the queries from the commitment store, and falls back to versiondb when the height
+// is not available in it, normally pruned, so the historical queries don't fail with "height not available".
+// The states served from versiondb don't come with merkle proofs.
+type FallbackMultiStore struct {
+	// the commitment store
+	types.RootMultiStore
+
+	versionDB *MultiStore
+
+	// serve the historical heights from versiondb whenever available, only the latest height is served from the
+	// commitment store, it skips the loading of the historical trees, which is much slower than the point lookups
+	// in versiondb, for example, the EVM JSON-RPC calls at historical blocks.
+	fastHistorical bool
+}
+
+// NewFallbackMultiStore returns a new `FallbackMultiStore`, `parent` is the commitment store.
+func NewFallbackMultiStore(parent types.RootMultiStore, versionDB *MultiStore, fastHistorical bool) *FallbackMultiStore {
+	return &FallbackMultiStore{
+		RootMultiStore: parent,
+		versionDB:      versionDB,
+		fastHistorical: fastHistorical,
+	}
+}
+
+// CacheMultiStoreWithVersion implements `RootMultiStore` interface, it loads the version from the commitment store first,
+// and tries versiondb if the height is not available in it, the other failures are returned as is. The historical
+// heights are served from versiondb directly if `fastHistorical` is set.
+func (s *FallbackMultiStore) CacheMultiStoreWithVersion(version int64) (types.CacheMultiStore, error) {
+	if s.fastHistorical && version > 0 && version < s.RootMultiStore.LatestVersion() {
+		if latest, err := s.versionDB.versionDB.GetLatestVersion(); err == nil && version <= latest {
+			return s.versionDB.CacheMultiStoreWithVersion(version)
+		}
+	}
+
+	cms, err := s.RootMultiStore.CacheMultiStoreWithVersion(version)
+	if err == nil {
+		return cms, nil
+	}
+	if !isHeightNotAvailable(err) {
+		return nil, err
+	}
+
+	latest, verr := s.versionDB.versionDB.GetLatestVersion()
+	if verr != nil || version > latest {
+		// versiondb can't serve it either
+		return nil, err
+	}
+	return s.versionDB.CacheMultiStoreWithVersion(version)
+}
+
+// isHeightNotAvailable returns if the commitment store fails because the height is not available, the memiavl store
+// wraps `ErrInvalidHeight`, the sdk iavl store returns a plain error, which is matched by the message.
+func isHeightNotAvailable(err error) bool {
+	return errors.Is(err, sdkerrors.ErrInvalidHeight) || strings.Contains(err.Error(), "version does not exist")
+}
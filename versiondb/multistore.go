@@ -1,15 +1,23 @@
 package versiondb
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
+	errorsmod "cosmossdk.io/errors"
 	"cosmossdk.io/store/cachemulti"
 	"cosmossdk.io/store/types"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 )
 
-var _ types.MultiStore = (*MultiStore)(nil)
+var (
+	_ types.MultiStore = (*MultiStore)(nil)
+	_ types.Queryable  = (*MultiStore)(nil)
+)
 
 // MultiStore wraps `VersionStore` to implement `MultiStore` interface.
 type MultiStore struct {
@@ -148,8 +156,41 @@ func (s *MultiStore) LatestVersion() int64 {
 	return version
 }
 
+// QueryWithProof answers the key query at height with the value from versiondb, and the merkle proof from the parent
+// commitment store, see `QueryWithProof`.
+func (s *MultiStore) QueryWithProof(storeKey string, key []byte, height int64) (*types.ResponseQuery, error) {
+	prover, ok := s.parent.(Prover)
+	if !ok {
+		return nil, errors.New("the commitment store don't support historical proofs")
+	}
+	return QueryWithProof(s.versionDB, prover, storeKey, key, height)
+}
+
+// Query implements `Queryable` interface, it only supports the key queries, like "/<store>/key", the merkle proof is
+// generated by the parent commitment store if `req.Prove` is set, see `QueryWithProof`, it's used by the commitment
+// store to serve the abci queries at the pruned heights, where the proofs are not available.
+func (s *MultiStore) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
+	paths := strings.SplitN(strings.TrimPrefix(req.Path, "/"), "/", 2)
+	if len(paths) != 2 || paths[1] != "key" {
+		return nil, errorsmod.Wrapf(sdkerrors.ErrUnknownRequest, "unsupported query path in versiondb: %s", req.Path)
+	}
+	storeKey := paths[0]
+	if req.Prove {
+		return s.QueryWithProof(storeKey, req.Data, req.Height)
+	}
+
+	value, err := s.versionDB.GetAtVersion(storeKey, req.Data, &req.Height)
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseQuery{Key: req.Data, Value: value, Height: req.Height}, nil
+}
+
 // Close will flush the versiondb
 func (s *MultiStore) Close() error {
+	if closer, ok := s.versionDB.(io.Closer); ok {
+		return closer.Close()
+	}
 	return s.versionDB.Flush()
 }
 
@@ -0,0 +1,56 @@
+package versiondb
+
+import (
+	"bytes"
+	"fmt"
+
+	"cosmossdk.io/errors"
+	"cosmossdk.io/store/types"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+// Prover is implemented by the commitment store which can generate merkle proofs for the historical states,
+// for example the memiavl based root multistore.
+type Prover interface {
+	// ProvableVersion returns the earliest version not smaller than `version` that the proofs can be generated for.
+	ProvableVersion(version int64) (int64, error)
+	// Query handles the abci queries, the proof is generated if `req.Prove` is true.
+	Query(req *types.RequestQuery) (*types.ResponseQuery, error)
+}
+
+// QueryWithProof answers the key query at `height`, the value is read from versiondb, and the merkle proof is
+// generated by the prover at the same height, the value is checked to be consistent with the prover. A proof built at
+// another height doesn't prove the value at `height`, so the query fails if `height` is pruned in the prover.
+func QueryWithProof(store VersionStore, prover Prover, storeKey string, key []byte, height int64) (*types.ResponseQuery, error) {
+	proofHeight, err := prover.ProvableVersion(height)
+	if err != nil {
+		return nil, err
+	}
+	if proofHeight != height {
+		return nil, errors.Wrapf(
+			sdkerrors.ErrInvalidHeight,
+			"proof not available for pruned height %d, the earliest provable height is %d", height, proofHeight,
+		)
+	}
+
+	value, err := store.GetAtVersion(storeKey, key, &height)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := prover.Query(&types.RequestQuery{
+		Path:   fmt.Sprintf("/%s/key", storeKey),
+		Data:   key,
+		Height: height,
+		Prove:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(res.Value, value) {
+		return nil, fmt.Errorf("versiondb and commitment store are inconsistent, store: %s, key: %X, height: %d", storeKey, key, height)
+	}
+	return res, nil
+}
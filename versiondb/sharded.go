@@ -0,0 +1,186 @@
+package versiondb
+
+import (
+	"errors"
+	"sync"
+
+	"cosmossdk.io/store/types"
+)
+
+var _ BatchVersionStore = (*ShardedVersionStore)(nil)
+
+// ShardedVersionStore routes the stores to different `VersionStore` instances, so the big stores can live in separate
+// db directories, potentially on different disks, the stores not configured go to the default instance.
+//
+// Every block is written to all the instances, even if the change set is empty for some of them,
+// so their latest versions move together, after a crash the latest version is the minimal one among them,
+// the blocks beyond that are simply rewritten.
+type ShardedVersionStore struct {
+	// the default instance is the first one
+	instances []VersionStore
+	// store key -> index of instance
+	shards map[string]int
+}
+
+// StoreGroup is a group of stores living in the same `VersionStore` instance.
+type StoreGroup struct {
+	Stores []string
+	Store  VersionStore
+}
+
+// NewShardedVersionStore creates a new `ShardedVersionStore`, the stores not included in any group go to `defaultStore`.
+func NewShardedVersionStore(defaultStore VersionStore, groups []StoreGroup) *ShardedVersionStore {
+	instances := make([]VersionStore, 0, len(groups)+1)
+	instances = append(instances, defaultStore)
+	shards := make(map[string]int)
+	for _, group := range groups {
+		instances = append(instances, group.Store)
+		for _, name := range group.Stores {
+			shards[name] = len(instances) - 1
+		}
+	}
+
+	return &ShardedVersionStore{
+		instances: instances,
+		shards:    shards,
+	}
+}
+
+// route returns the index of the instance of the store key
+func (s *ShardedVersionStore) route(storeKey string) int {
+	return s.shards[storeKey]
+}
+
+// split splits the change set by instances, preserving the order of pairs.
+func (s *ShardedVersionStore) split(changeSet []*types.StoreKVPair) [][]*types.StoreKVPair {
+	result := make([][]*types.StoreKVPair, len(s.instances))
+	for _, pair := range changeSet {
+		i := s.route(pair.StoreKey)
+		result[i] = append(result[i], pair)
+	}
+	return result
+}
+
+// GetAtVersion implements VersionStore interface
+func (s *ShardedVersionStore) GetAtVersion(storeKey string, key []byte, version *int64) ([]byte, error) {
+	return s.instances[s.route(storeKey)].GetAtVersion(storeKey, key, version)
+}
+
+// HasAtVersion implements VersionStore interface
+func (s *ShardedVersionStore) HasAtVersion(storeKey string, key []byte, version *int64) (bool, error) {
+	return s.instances[s.route(storeKey)].HasAtVersion(storeKey, key, version)
+}
+
+// IteratorAtVersion implements VersionStore interface
+func (s *ShardedVersionStore) IteratorAtVersion(storeKey string, start, end []byte, version *int64) (Iterator, error) {
+	return s.instances[s.route(storeKey)].IteratorAtVersion(storeKey, start, end, version)
+}
+
+// ReverseIteratorAtVersion implements VersionStore interface
+func (s *ShardedVersionStore) ReverseIteratorAtVersion(storeKey string, start, end []byte, version *int64) (Iterator, error) {
+	return s.instances[s.route(storeKey)].ReverseIteratorAtVersion(storeKey, start, end, version)
+}
+
+// GetLatestVersion implements VersionStore interface, returns the minimal latest version of the instances.
+func (s *ShardedVersionStore) GetLatestVersion() (int64, error) {
+	var latest int64
+	for i, store := range s.instances {
+		version, err := store.GetLatestVersion()
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || version < latest {
+			latest = version
+		}
+	}
+	return latest, nil
+}
+
+// PutAtVersion implements VersionStore interface
+func (s *ShardedVersionStore) PutAtVersion(version int64, changeSet []*types.StoreKVPair) error {
+	changeSets := s.split(changeSet)
+	return s.forEachInstance(func(i int, store VersionStore) error {
+		return store.PutAtVersion(version, changeSets[i])
+	})
+}
+
+// PutAtVersions implements BatchVersionStore interface
+func (s *ShardedVersionStore) PutAtVersions(changeSets []VersionedChangeSet) error {
+	splitted := make([][]VersionedChangeSet, len(s.instances))
+	for _, cs := range changeSets {
+		for i, pairs := range s.split(cs.ChangeSet) {
+			splitted[i] = append(splitted[i], VersionedChangeSet{Version: cs.Version, ChangeSet: pairs})
+		}
+	}
+
+	return s.forEachInstance(func(i int, store VersionStore) error {
+		if batchStore, ok := store.(BatchVersionStore); ok {
+			return batchStore.PutAtVersions(splitted[i])
+		}
+		for _, cs := range splitted[i] {
+			if err := store.PutAtVersion(cs.Version, cs.ChangeSet); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Import implements VersionStore interface, the entries are dispatched to the instances concurrently.
+func (s *ShardedVersionStore) Import(version int64, ch <-chan ImportEntry) error {
+	chs := make([]chan ImportEntry, len(s.instances))
+	for i := range chs {
+		chs[i] = make(chan ImportEntry, cap(ch))
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(s.instances))
+	)
+	for i, store := range s.instances {
+		wg.Add(1)
+		go func(i int, store VersionStore) {
+			defer wg.Done()
+			errs[i] = store.Import(version, chs[i])
+			// drain the channel in case of failure, so the dispatcher is not blocked.
+			for range chs[i] {
+			}
+		}(i, store)
+	}
+
+	for entry := range ch {
+		chs[s.route(entry.StoreKey)] <- entry
+	}
+	for _, c := range chs {
+		close(c)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Flush implements VersionStore interface
+func (s *ShardedVersionStore) Flush() error {
+	return s.forEachInstance(func(_ int, store VersionStore) error {
+		return store.Flush()
+	})
+}
+
+// forEachInstance runs the function on all the instances concurrently.
+func (s *ShardedVersionStore) forEachInstance(fn func(int, VersionStore) error) error {
+	if len(s.instances) == 1 {
+		return fn(0, s.instances[0])
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.instances))
+	for i, store := range s.instances {
+		wg.Add(1)
+		go func(i int, store VersionStore) {
+			defer wg.Done()
+			errs[i] = fn(i, store)
+		}(i, store)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
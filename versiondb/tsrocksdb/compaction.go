@@ -0,0 +1,192 @@
+package tsrocksdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/linxGnu/grocksdb"
+
+	"cosmossdk.io/log"
+)
+
+const (
+	compactionPendingKey = "s/compaction-pending"
+
+	DefaultCompactionCheckInterval = 10 * time.Minute
+)
+
+// RequestCompaction marks the db as needing a bottommost compaction, it should be called after large
+// prunes or migrations, the compaction is done later by the `CompactionScheduler` in the quiet hours.
+func (s Store) RequestCompaction() error {
+	return s.db.Put(defaultSyncWriteOpts, []byte(compactionPendingKey), []byte{1})
+}
+
+// CompactionPending returns if there's a pending compaction request.
+func (s Store) CompactionPending() (bool, error) {
+	bz, err := s.db.GetBytes(defaultReadOpts, []byte(compactionPendingKey))
+	if err != nil {
+		return false, err
+	}
+	return len(bz) > 0, nil
+}
+
+// Compact runs a full compaction on the versiondb column family, including the bottommost level,
+// so the disk space of deleted or trimmed versions is reclaimed, then it clears the pending request.
+func (s Store) Compact() error {
+	s.compactRange()
+	return s.clearCompactionRequest()
+}
+
+func (s Store) compactRange() {
+	opts := grocksdb.NewCompactRangeOptions()
+	defer opts.Destroy()
+	opts.SetBottommostLevelCompaction(grocksdb.KForceOptimized)
+	opts.SetExclusiveManualCompaction(false)
+
+	s.db.CompactRangeCFOpt(s.cfHandle, grocksdb.Range{}, opts)
+}
+
+func (s Store) clearCompactionRequest() error {
+	return s.db.Delete(defaultSyncWriteOpts, []byte(compactionPendingKey))
+}
+
+// CompactionWindow is the daily quiet hours `[Start, End)`, measured as the offsets of the local time of day,
+// the window wraps around midnight if `End` is smaller than `Start`.
+type CompactionWindow struct {
+	Start, End time.Duration
+}
+
+// ParseCompactionWindow parses the window in format "HH:MM-HH:MM", for example "02:00-05:00".
+func ParseCompactionWindow(s string) (CompactionWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return CompactionWindow{}, fmt.Errorf("invalid compaction window: %s", s)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return CompactionWindow{}, err
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return CompactionWindow{}, err
+	}
+	return CompactionWindow{Start: start, End: end}, nil
+}
+
+// Contains checks if the time is inside the window.
+func (w CompactionWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// CompactionScheduler checks the pending compaction requests periodically, and runs the compaction in the quiet hours,
+// because rocksdb won't reclaim the disk space of the bottommost level on its own in time.
+type CompactionScheduler struct {
+	stores   []Store
+	window   CompactionWindow
+	interval time.Duration
+	logger   log.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewCompactionScheduler(stores []Store, window CompactionWindow, interval time.Duration, logger log.Logger) *CompactionScheduler {
+	return &CompactionScheduler{
+		stores:   stores,
+		window:   window,
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler in background.
+func (c *CompactionScheduler) Start() {
+	go c.loop()
+}
+
+// Stop signals the scheduler to stop and waits for it to exit, the running compaction is aborted, so the shutdown is
+// not blocked by a full compaction which could take hours, the request is kept and retried after restart.
+func (c *CompactionScheduler) Stop() {
+	close(c.stop)
+	for _, store := range c.stores {
+		store.db.DisableManualCompaction()
+	}
+	<-c.done
+	for _, store := range c.stores {
+		store.db.EnableManualCompaction()
+	}
+}
+
+func (c *CompactionScheduler) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *CompactionScheduler) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			if !c.window.Contains(now) {
+				continue
+			}
+			c.runPending()
+		}
+	}
+}
+
+// runPending runs the compaction for the stores with pending requests.
+func (c *CompactionScheduler) runPending() {
+	for _, store := range c.stores {
+		if c.stopped() {
+			return
+		}
+		pending, err := store.CompactionPending()
+		if err != nil {
+			c.logger.Error("failed to check pending compaction of versiondb", "err", err)
+			continue
+		}
+		if !pending {
+			continue
+		}
+
+		c.logger.Info("start scheduled compaction of versiondb")
+		start := time.Now()
+		store.compactRange()
+		if c.stopped() {
+			// the compaction is aborted by the shutdown
+			c.logger.Info("scheduled compaction of versiondb is canceled", "elapsed", time.Since(start))
+			return
+		}
+		if err := store.clearCompactionRequest(); err != nil {
+			c.logger.Error("failed to compact versiondb", "err", err)
+			continue
+		}
+		c.logger.Info("finished scheduled compaction of versiondb", "elapsed", time.Since(start))
+	}
+}
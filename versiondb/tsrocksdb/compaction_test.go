@@ -0,0 +1,76 @@
+package tsrocksdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/log"
+)
+
+func TestCompactionWindow(t *testing.T) {
+	testCases := []struct {
+		window string
+		hour   int
+		result bool
+	}{
+		{"02:00-05:00", 1, false},
+		{"02:00-05:00", 2, true},
+		{"02:00-05:00", 5, false},
+		{"23:00-03:00", 23, true},
+		{"23:00-03:00", 0, true},
+		{"23:00-03:00", 3, false},
+		{"23:00-03:00", 12, false},
+	}
+
+	for _, tc := range testCases {
+		window, err := ParseCompactionWindow(tc.window)
+		require.NoError(t, err)
+		now := time.Date(2023, 1, 1, tc.hour, 0, 0, 0, time.Local)
+		require.Equal(t, tc.result, window.Contains(now), "window: %s, hour: %d", tc.window, tc.hour)
+	}
+
+	_, err := ParseCompactionWindow("02:00")
+	require.Error(t, err)
+}
+
+func TestCompactionRequest(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	pending, err := store.CompactionPending()
+	require.NoError(t, err)
+	require.False(t, pending)
+
+	require.NoError(t, store.RequestCompaction())
+	pending, err = store.CompactionPending()
+	require.NoError(t, err)
+	require.True(t, pending)
+
+	require.NoError(t, store.Compact())
+	pending, err = store.CompactionPending()
+	require.NoError(t, err)
+	require.False(t, pending)
+}
+
+func TestCompactionSchedulerStop(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.RequestCompaction())
+
+	// the compaction aborted by the shutdown keeps the request
+	scheduler := NewCompactionScheduler([]Store{store}, CompactionWindow{End: 24 * time.Hour}, time.Hour, log.NewNopLogger())
+	scheduler.Start()
+	scheduler.Stop()
+	scheduler.runPending()
+	pending, err := store.CompactionPending()
+	require.NoError(t, err)
+	require.True(t, pending)
+
+	// the manual compaction is enabled again after stopped
+	require.NoError(t, store.Compact())
+	pending, err = store.CompactionPending()
+	require.NoError(t, err)
+	require.False(t, pending)
+}
@@ -0,0 +1,240 @@
+package tsrocksdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/linxGnu/grocksdb"
+)
+
+// the value types of rocksdb internal keys, see: `db/dbformat.h`.
+const (
+	valueTypeDeletion              = 0x0
+	valueTypeSingleDeletion        = 0x7
+	valueTypeDeletionWithTimestamp = 0x14
+
+	// sequence number and value type packed in 8 bytes
+	internalKeyFooterSize = 8
+)
+
+var storeKeyPrefix = []byte("s/k:")
+
+// GarbageReport summarizes the wasted space found by `ScanGarbage`, all the numbers are counted in versions,
+// a key modified in N blocks has N versions.
+type GarbageReport struct {
+	// versions of the keys whose latest version is a tombstone before the retention version, by store.
+	ExpiredVersions map[string]int
+	// versions of the stores which don't exist in the app anymore, by store.
+	OrphanVersions map[string]int
+	// versions newer than the latest version, left by interrupted writes or bad restores.
+	FutureVersions int
+	// versions at zero, see: https://github.com/crypto-org-chain/cronos/issues/1683
+	ZeroVersions int
+	// total number of versions scanned.
+	TotalVersions int
+}
+
+// OrphanStores returns the sorted names of the orphan stores.
+func (r *GarbageReport) OrphanStores() []string {
+	names := make([]string, 0, len(r.OrphanVersions))
+	for name := range r.OrphanVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ScanGarbage iterates all the versions of all the keys, including the tombstones, to find the wasted space:
+// - the keys deleted before `retainVersion`, they are invisible to the queries inside the retention window.
+// - the stores not in `stores`, normally removed by store upgrades.
+// - the versions with anomalous timestamps, newer than the latest version or at zero.
+func (s Store) ScanGarbage(stores []string, retainVersion int64) (*GarbageReport, error) {
+	latest, err := s.GetLatestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]struct{}, len(stores))
+	for _, store := range stores {
+		known[store] = struct{}{}
+	}
+
+	readOpts := newTSReadOptions(nil)
+	defer readOpts.Destroy()
+	// returns all the versions in internal key format, including the tombstones
+	var startTS [TimestampSize]byte
+	readOpts.SetIterStartTimestamp(startTS[:])
+
+	itr := s.db.NewIteratorCF(readOpts, s.cfHandle)
+	defer itr.Close()
+
+	report := &GarbageReport{
+		ExpiredVersions: make(map[string]int),
+		OrphanVersions:  make(map[string]int),
+	}
+
+	var (
+		// the user key of the current key, without timestamp
+		current []byte
+		// number of versions of the current key
+		versions int
+		// if the current key is expired, decided by its latest version, which comes first.
+		expired bool
+		store   string
+	)
+	flush := func() {
+		if versions > 0 && expired {
+			report.ExpiredVersions[store] += versions
+		}
+	}
+
+	for itr.SeekToFirst(); itr.Valid(); itr.Next() {
+		key := itr.Key()
+		userKey, ts, valueType, err := parseInternalKey(key.Data())
+		key.Free()
+		if err != nil {
+			return nil, err
+		}
+
+		report.TotalVersions++
+		switch {
+		case ts > uint64(latest):
+			report.FutureVersions++
+		case ts == 0:
+			report.ZeroVersions++
+		}
+
+		if !bytes.Equal(userKey, current) {
+			flush()
+
+			current = userKey
+			versions = 0
+			store, err = parseStoreName(userKey)
+			if err != nil {
+				return nil, err
+			}
+			expired = isTombstone(valueType) && ts < uint64(retainVersion)
+		}
+		versions++
+
+		if _, ok := known[store]; !ok {
+			report.OrphanVersions[store]++
+		}
+	}
+	flush()
+
+	return report, itr.Err()
+}
+
+// CleanGarbage prunes the versions before `retainVersion`, which drops the expired keys together with the versions
+// they shadow. The orphan stores are deleted at their removal heights, by writing tombstones at the removal height for
+// all the keys alive before it, so the queries inside the retention window still see the stores before the removal,
+// and the history before `retainVersion` is pruned with the rest.
+//
+// NOTICE: the versions before `retainVersion` can't be queried after this.
+func (s Store) CleanGarbage(removalHeights map[string]int64, retainVersion int64) error {
+	if retainVersion <= 0 {
+		return errors.New("retain version must be positive")
+	}
+
+	var ts [TimestampSize]byte
+	for _, store := range sortedStores(removalHeights) {
+		height := removalHeights[store]
+		if height <= 0 {
+			return fmt.Errorf("removal height of store %s must be positive", store)
+		}
+		binary.LittleEndian.PutUint64(ts[:], uint64(height))
+		if err := s.deleteStoreAt(store, height-1, ts[:]); err != nil {
+			return err
+		}
+	}
+
+	binary.LittleEndian.PutUint64(ts[:], uint64(retainVersion))
+	opts := grocksdb.NewCompactRangeOptions()
+	defer opts.Destroy()
+	opts.SetFullHistoryTsLow(ts[:])
+	opts.SetBottommostLevelCompaction(grocksdb.KForceOptimized)
+	opts.SetExclusiveManualCompaction(false)
+
+	s.db.CompactRangeCFOpt(s.cfHandle, grocksdb.Range{}, opts)
+	return nil
+}
+
+// deleteStoreAt writes tombstones at `ts` for all the keys of the store alive at the version.
+func (s Store) deleteStoreAt(store string, version int64, ts []byte) error {
+	iter, err := s.IteratorAtVersion(store, nil, nil, &version)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	batch := grocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	prefix := storePrefix(store)
+	for ; iter.Valid(); iter.Next() {
+		batch.DeleteCFWithTS(s.cfHandle, cloneAppend(prefix, iter.Key()), ts)
+
+		if batch.Count() >= ImportCommitBatchSize {
+			if err := s.db.Write(defaultWriteOpts, batch); err != nil {
+				return err
+			}
+			batch.Clear()
+		}
+	}
+
+	if batch.Count() > 0 {
+		if err := s.db.Write(defaultSyncWriteOpts, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseInternalKey splits the internal key into user key, timestamp and value type,
+// the format is: `user key | timestamp(8) | sequence number and value type(8)`.
+func parseInternalKey(key []byte) ([]byte, uint64, byte, error) {
+	if len(key) < TimestampSize+internalKeyFooterSize {
+		return nil, 0, 0, fmt.Errorf("invalid internal key: %X", key)
+	}
+	footer := key[len(key)-internalKeyFooterSize:]
+	key = key[:len(key)-internalKeyFooterSize]
+	ts := binary.LittleEndian.Uint64(key[len(key)-TimestampSize:])
+	userKey := bytes.Clone(key[:len(key)-TimestampSize])
+	// the value type is the lowest byte of the little endian encoded footer
+	return userKey, ts, footer[0], nil
+}
+
+// parseStoreName extracts the store name from the key prefix `s/k:<store>/`.
+func parseStoreName(key []byte) (string, error) {
+	if !bytes.HasPrefix(key, storeKeyPrefix) {
+		return "", fmt.Errorf("invalid versiondb key: %X", key)
+	}
+	rest := key[len(storeKeyPrefix):]
+	i := bytes.IndexByte(rest, '/')
+	if i < 0 {
+		return "", fmt.Errorf("invalid versiondb key: %X", key)
+	}
+	return string(rest[:i]), nil
+}
+
+func sortedStores(m map[string]int64) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isTombstone(valueType byte) bool {
+	switch valueType {
+	case valueTypeDeletion, valueTypeSingleDeletion, valueTypeDeletionWithTimestamp:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package tsrocksdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/store/types"
+)
+
+func TestScanGarbage(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.PutAtVersion(1, []*types.StoreKVPair{
+		{StoreKey: "bank", Key: []byte("deleted"), Value: []byte("1")},
+		{StoreKey: "bank", Key: []byte("alive"), Value: []byte("1")},
+		{StoreKey: "removed", Key: []byte("hello"), Value: []byte("1")},
+	}))
+	require.NoError(t, store.PutAtVersion(2, []*types.StoreKVPair{
+		{StoreKey: "bank", Key: []byte("deleted"), Delete: true},
+	}))
+	require.NoError(t, store.PutAtVersion(3, []*types.StoreKVPair{
+		{StoreKey: "bank", Key: []byte("alive"), Value: []byte("3")},
+	}))
+	require.NoError(t, store.PutAtVersion(4, nil))
+	require.NoError(t, store.PutAtVersion(5, nil))
+
+	report, err := store.ScanGarbage([]string{"bank"}, 3)
+	require.NoError(t, err)
+	require.Equal(t, 5, report.TotalVersions)
+	require.Equal(t, map[string]int{"bank": 2}, report.ExpiredVersions)
+	require.Equal(t, map[string]int{"removed": 1}, report.OrphanVersions)
+	require.Equal(t, 0, report.FutureVersions)
+	require.Equal(t, 0, report.ZeroVersions)
+
+	// the deletion is inside the retention window
+	report, err = store.ScanGarbage([]string{"bank"}, 2)
+	require.NoError(t, err)
+	require.Empty(t, report.ExpiredVersions)
+
+	// the store is removed by the upgrade at height 5, after the retain version
+	require.Equal(t, []string{"removed"}, report.OrphanStores())
+	require.NoError(t, store.CleanGarbage(map[string]int64{"removed": 5}, 3))
+
+	bz, err := store.GetAtVersion("bank", []byte("alive"), nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), bz)
+
+	// still visible before the removal height
+	version := int64(4)
+	bz, err = store.GetAtVersion("removed", []byte("hello"), &version)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), bz)
+
+	bz, err = store.GetAtVersion("removed", []byte("hello"), nil)
+	require.NoError(t, err)
+	require.Nil(t, bz)
+}
@@ -54,6 +54,18 @@ func OpenVersionDB(dir string) (*grocksdb.DB, *grocksdb.ColumnFamilyHandle, erro
 	opts := grocksdb.NewDefaultOptions()
 	opts.SetCreateIfMissing(true)
 	opts.SetCreateIfMissingColumnFamilies(true)
+	return openVersionDBWithOptions(dir, opts)
+}
+
+// OpenVersionDBWithRateLimit opens versiondb similar to `OpenVersionDB`, but throttles the flush and compaction IO
+// with the rate limit in bytes per second.
+func OpenVersionDBWithRateLimit(dir string, rateLimit int64) (*grocksdb.DB, *grocksdb.ColumnFamilyHandle, error) {
+	opts := grocksdb.NewDefaultOptions()
+	opts.SetRateLimiter(grocksdb.NewRateLimiter(rateLimit, 100*1000, 10))
+	return openVersionDBWithOptions(dir, opts)
+}
+
+func openVersionDBWithOptions(dir string, opts *grocksdb.Options) (*grocksdb.DB, *grocksdb.ColumnFamilyHandle, error) {
 	db, cfHandles, err := grocksdb.OpenDbColumnFamilies(
 		opts, dir, []string{"default", VersionDBCFName},
 		[]*grocksdb.Options{opts, NewVersionDBOpts(false)},
@@ -26,7 +26,8 @@ const (
 var (
 	errKeyEmpty = errors.New("key cannot be empty")
 
-	_ versiondb.VersionStore = Store{}
+	_ versiondb.VersionStore      = Store{}
+	_ versiondb.BatchVersionStore = Store{}
 
 	defaultWriteOpts     = grocksdb.NewDefaultWriteOptions()
 	defaultSyncWriteOpts = grocksdb.NewDefaultWriteOptions()
@@ -56,6 +57,16 @@ func NewStore(dir string) (Store, error) {
 	}, nil
 }
 
+// NewReadOnlyStore opens the db in read-only mode, it can be opened while the db is written by another process,
+// but the writes after the opening are not visible.
+func NewReadOnlyStore(dir string) (Store, error) {
+	db, cfHandle, err := OpenVersionDBForReadOnly(dir, false)
+	if err != nil {
+		return Store{}, err
+	}
+	return NewStoreWithDB(db, cfHandle), nil
+}
+
 func NewStoreWithDB(db *grocksdb.DB, cfHandle *grocksdb.ColumnFamilyHandle) Store {
 	return Store{
 		db:       db,
@@ -75,11 +86,30 @@ func (s Store) SetLatestVersion(version int64) error {
 
 // PutAtVersion implements VersionStore interface
 func (s Store) PutAtVersion(version int64, changeSet []*types.StoreKVPair) error {
-	var ts [TimestampSize]byte
-	binary.LittleEndian.PutUint64(ts[:], uint64(version))
+	batch := grocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	s.putChangeSet(batch, version, changeSet)
+	return s.db.Write(defaultSyncWriteOpts, batch)
+}
 
+// PutAtVersions implements BatchVersionStore interface,
+// it writes the change sets of multiple blocks in a single write batch.
+func (s Store) PutAtVersions(changeSets []versiondb.VersionedChangeSet) error {
 	batch := grocksdb.NewWriteBatch()
 	defer batch.Destroy()
+
+	for _, cs := range changeSets {
+		s.putChangeSet(batch, cs.Version, cs.ChangeSet)
+	}
+	return s.db.Write(defaultSyncWriteOpts, batch)
+}
+
+// putChangeSet appends the change set of a block to write batch, and bump the latest version.
+func (s Store) putChangeSet(batch *grocksdb.WriteBatch, version int64, changeSet []*types.StoreKVPair) {
+	var ts [TimestampSize]byte
+	binary.LittleEndian.PutUint64(ts[:], uint64(version))
+
 	batch.Put([]byte(latestVersionKey), ts[:])
 
 	for _, pair := range changeSet {
@@ -90,8 +120,6 @@ func (s Store) PutAtVersion(version int64, changeSet []*types.StoreKVPair) error
 			batch.PutCFWithTS(s.cfHandle, key, ts[:], pair.Value)
 		}
 	}
-
-	return s.db.Write(defaultSyncWriteOpts, batch)
 }
 
 func (s Store) GetAtVersionSlice(storeKey string, key []byte, version *int64) (*grocksdb.Slice, error) {
@@ -149,6 +177,27 @@ func (s Store) GetLatestVersion() (int64, error) {
 	return int64(binary.LittleEndian.Uint64(bz)), nil
 }
 
+// HasStoreHistory returns if the store has any version in the db, including the tombstones.
+func (s Store) HasStoreHistory(storeKey string) (bool, error) {
+	readOpts := newTSReadOptions(nil)
+	defer readOpts.Destroy()
+	// returns all the versions, including the tombstones
+	var startTS [TimestampSize]byte
+	readOpts.SetIterStartTimestamp(startTS[:])
+
+	itr := s.db.NewIteratorCF(readOpts, s.cfHandle)
+	defer itr.Close()
+
+	prefix := storePrefix(storeKey)
+	itr.Seek(prefix)
+	if !itr.Valid() {
+		return false, itr.Err()
+	}
+	key := itr.Key()
+	defer key.Free()
+	return bytes.HasPrefix(key.Data(), prefix), nil
+}
+
 // IteratorAtVersion implements VersionStore interface
 func (s Store) IteratorAtVersion(storeKey string, start, end []byte, version *int64) (versiondb.Iterator, error) {
 	return s.iteratorAtVersion(storeKey, start, end, version, false)
@@ -237,6 +286,14 @@ func (s Store) Flush() error {
 	)
 }
 
+// Close closes the db without flushing, the unflushed writes are recovered from the rocksdb WAL, the store must not
+// be used after that.
+func (s Store) Close() error {
+	s.cfHandle.Destroy()
+	s.db.Close()
+	return nil
+}
+
 // FixData fixes wrong data written in versiondb due to rocksdb upgrade, the operation is idempotent.
 // see: https://github.com/crypto-org-chain/cronos/issues/1683
 // call this before `SetSkipVersionZero(true)`.
@@ -298,6 +355,32 @@ func (s Store) fixDataStore(storeName string, dryRun bool) error {
 	return nil
 }
 
+// RollbackToVersion removes the versions after the target version and updates the latest version,
+// the db must not be opened by others.
+func RollbackToVersion(dir string, version int64) error {
+	db, cfHandle, err := OpenVersionDBAndTrimHistory(dir, version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cfHandle.Destroy()
+		db.Close()
+	}()
+
+	store := NewStoreWithDB(db, cfHandle)
+	latest, err := store.GetLatestVersion()
+	if err != nil {
+		return err
+	}
+	if latest <= version {
+		return nil
+	}
+	if err := store.SetLatestVersion(version); err != nil {
+		return err
+	}
+	return store.Flush()
+}
+
 type KVPairWithTS struct {
 	Key       []byte
 	Value     []byte
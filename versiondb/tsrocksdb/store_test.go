@@ -2,6 +2,7 @@ package tsrocksdb
 
 import (
 	"encoding/binary"
+	"errors"
 	"testing"
 
 	dbm "github.com/cosmos/cosmos-db"
@@ -9,7 +10,11 @@ import (
 	"github.com/linxGnu/grocksdb"
 	"github.com/stretchr/testify/require"
 
+	errorsmod "cosmossdk.io/errors"
+	"cosmossdk.io/store/cachemulti"
 	"cosmossdk.io/store/types"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 )
 
 func TestTSVersionDB(t *testing.T) {
@@ -20,6 +25,131 @@ func TestTSVersionDB(t *testing.T) {
 	})
 }
 
+func TestAsyncVersionDB(t *testing.T) {
+	versiondb.Run(t, func() versiondb.VersionStore {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		asyncStore, err := versiondb.NewAsyncVersionStore(store, 2)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, asyncStore.Close())
+		})
+		return asyncStore
+	})
+}
+
+func TestShardedVersionDB(t *testing.T) {
+	versiondb.Run(t, func() versiondb.VersionStore {
+		defaultStore, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		evmStore, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		return versiondb.NewShardedVersionStore(defaultStore, []versiondb.StoreGroup{
+			{Stores: []string{"evm"}, Store: evmStore},
+		})
+	})
+}
+
+func TestCloseShard(t *testing.T) {
+	defaultStore, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	evmDir := t.TempDir()
+	evmStore, err := NewStore(evmDir)
+	require.NoError(t, err)
+	store := versiondb.NewShardedVersionStore(defaultStore, []versiondb.StoreGroup{
+		{Stores: []string{"evm"}, Store: evmStore},
+	})
+	versiondb.SetupTestDB(t, store)
+	require.NoError(t, store.Flush())
+	require.NoError(t, evmStore.Close())
+
+	// the shard could be opened again after closed
+	evmStore, err = NewStore(evmDir)
+	require.NoError(t, err)
+	defer evmStore.Close()
+	version := int64(3)
+	value, err := evmStore.GetAtVersion("evm", []byte("modify-in-block2"), &version)
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), value)
+}
+
+// mockProver generates fake proofs with the values from a versiondb, and pretends the versions before
+// `earliest` are pruned.
+type mockProver struct {
+	store    versiondb.VersionStore
+	earliest int64
+}
+
+func (p mockProver) ProvableVersion(version int64) (int64, error) {
+	if version < p.earliest {
+		return p.earliest, nil
+	}
+	return version, nil
+}
+
+func (p mockProver) Query(req *types.RequestQuery) (*types.ResponseQuery, error) {
+	value, err := p.store.GetAtVersion("evm", req.Data, &req.Height)
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseQuery{Key: req.Data, Value: value, Height: req.Height}, nil
+}
+
+func TestQueryWithProof(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	versiondb.SetupTestDB(t, store)
+	prover := mockProver{store: store, earliest: 3}
+
+	// no proof for the pruned height, even if the key is not modified since then
+	_, err = versiondb.QueryWithProof(store, prover, "evm", []byte("z-genesis-only"), 1)
+	require.True(t, errors.Is(err, sdkerrors.ErrInvalidHeight))
+
+	// absent key at pruned height
+	_, err = versiondb.QueryWithProof(store, prover, "evm", []byte("add-in-block2"), 1)
+	require.Error(t, err)
+
+	// provable height
+	res, err := versiondb.QueryWithProof(store, prover, "evm", []byte("modify-in-block2"), 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), res.Height)
+	require.Equal(t, []byte("2"), res.Value)
+}
+
+// proverMultiStore is the parent commitment store which generates the proofs with mockProver.
+type proverMultiStore struct {
+	types.MultiStore
+	mockProver
+}
+
+func TestMultiStoreQuery(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	versiondb.SetupTestDB(t, store)
+	evmKey := types.NewKVStoreKey("evm")
+	parent := proverMultiStore{mockProver: mockProver{store: store, earliest: 3}}
+	verDB := versiondb.NewMultiStore(parent, store, map[string]*types.KVStoreKey{"evm": evmKey}, nil)
+
+	// served from versiondb without proof
+	res, err := verDB.Query(&types.RequestQuery{Path: "/evm/key", Data: []byte("modify-in-block2"), Height: 1})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.Height)
+	require.Equal(t, []byte("1"), res.Value)
+
+	// the proof at the pruned height is not available
+	_, err = verDB.Query(&types.RequestQuery{Path: "/evm/key", Data: []byte("z-genesis-only"), Height: 1, Prove: true})
+	require.True(t, errors.Is(err, sdkerrors.ErrInvalidHeight))
+
+	// the proof at the provable height
+	res, err = verDB.Query(&types.RequestQuery{Path: "/evm/key", Data: []byte("z-genesis-only"), Height: 3, Prove: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), res.Height)
+	require.Equal(t, []byte("2"), res.Value)
+
+	_, err = verDB.Query(&types.RequestQuery{Path: "/evm/subspace", Data: []byte("z"), Height: 1})
+	require.True(t, errors.Is(err, sdkerrors.ErrUnknownRequest))
+}
+
 // TestUserTimestampBasic tests the behaviors of user-defined timestamp feature of rocksdb
 func TestUserTimestampBasic(t *testing.T) {
 	key := []byte("hello")
@@ -228,6 +358,35 @@ func TestSkipVersionZero(t *testing.T) {
 	require.Equal(t, []byte{2}, bz)
 }
 
+func TestHasStoreHistory(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.PutAtVersion(1, []*types.StoreKVPair{
+		{StoreKey: "evm", Key: []byte("hello"), Value: []byte("world")},
+		{StoreKey: "bank", Key: []byte("hello"), Value: []byte("world")},
+	}))
+	// the deleted keys are still in the history
+	require.NoError(t, store.PutAtVersion(2, []*types.StoreKVPair{
+		{StoreKey: "bank", Key: []byte("hello"), Delete: true},
+	}))
+
+	for _, tc := range []struct {
+		store string
+		exp   bool
+	}{
+		{"evm", true},
+		{"bank", true},
+		{"acc", false},
+		{"ev", false},
+		{"zzz", false},
+	} {
+		has, err := store.HasStoreHistory(tc.store)
+		require.NoError(t, err)
+		require.Equal(t, tc.exp, has, tc.store)
+	}
+}
+
 type kvPair struct {
 	Key   []byte
 	Value []byte
@@ -241,3 +400,93 @@ func consumeIterator(it dbm.Iterator) []kvPair {
 	it.Close()
 	return result
 }
+
+// mockRootMultiStore is a commitment store which has the versions from `earliest` to `latest`, the historical versions
+// fail with `err` if it's set.
+type mockRootMultiStore struct {
+	types.RootMultiStore
+	earliest, latest int64
+	err              error
+}
+
+// mockCacheMultiStore marks the branches served by the commitment store.
+type mockCacheMultiStore struct {
+	types.CacheMultiStore
+	version int64
+}
+
+func (s mockRootMultiStore) LatestVersion() int64 {
+	return s.latest
+}
+
+func (s mockRootMultiStore) CacheMultiStoreWithVersion(version int64) (types.CacheMultiStore, error) {
+	if version == 0 {
+		version = s.latest
+	}
+	if version < s.earliest || version > s.latest {
+		return nil, errorsmod.Wrapf(sdkerrors.ErrInvalidHeight, "version %d not available", version)
+	}
+	if s.err != nil && version < s.latest {
+		return nil, s.err
+	}
+	return mockCacheMultiStore{version: version}, nil
+}
+
+func TestFallbackMultiStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	versiondb.SetupTestDB(t, store)
+
+	evmKey := types.NewKVStoreKey("evm")
+	verDB := versiondb.NewMultiStore(nil, store, map[string]*types.KVStoreKey{"evm": evmKey}, nil)
+	// versiondb has the versions up to 4, the commitment store has the versions from 3 to 6
+	parent := mockRootMultiStore{earliest: 3, latest: 6}
+
+	testCases := []struct {
+		name           string
+		fastHistorical bool
+		version        int64
+		// 0 means served by versiondb, -1 means failure
+		expParent int64
+	}{
+		{"latest", false, 0, 6},
+		{"latest version", false, 6, 6},
+		{"ahead of versiondb", false, 5, 5},
+		{"available in both", false, 3, 3},
+		{"pruned", false, 1, 0},
+		{"future", false, 7, -1},
+		{"fast latest", true, 0, 6},
+		{"fast latest version", true, 6, 6},
+		{"fast ahead of versiondb", true, 5, 5},
+		{"fast available in both", true, 3, 0},
+		{"fast pruned", true, 1, 0},
+		{"fast future", true, 7, -1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := versiondb.NewFallbackMultiStore(parent, verDB, tc.fastHistorical)
+			cms, err := ms.CacheMultiStoreWithVersion(tc.version)
+			switch tc.expParent {
+			case -1:
+				require.Error(t, err)
+			case 0:
+				require.NoError(t, err)
+				require.IsType(t, cachemulti.Store{}, cms)
+				// modified at version 2
+				expValue := []byte("2")
+				if tc.version < 2 {
+					expValue = []byte("1")
+				}
+				require.Equal(t, expValue, cms.GetKVStore(evmKey).Get([]byte("modify-in-block2")))
+			default:
+				require.NoError(t, err)
+				require.Equal(t, mockCacheMultiStore{version: tc.expParent}, cms)
+			}
+		})
+	}
+
+	// the other failures of the commitment store don't fall back to versiondb
+	broken := mockRootMultiStore{earliest: 3, latest: 6, err: errors.New("disk failure")}
+	_, err = versiondb.NewFallbackMultiStore(broken, verDB, false).CacheMultiStoreWithVersion(3)
+	require.ErrorContains(t, err, "disk failure")
+}
@@ -38,3 +38,17 @@ type ImportEntry struct {
 	Key      []byte
 	Value    []byte
 }
+
+// BatchVersionStore is a VersionStore which can persist the change sets of multiple blocks in a single write.
+type BatchVersionStore interface {
+	VersionStore
+
+	// PutAtVersions persists the change sets of consecutive blocks atomically.
+	PutAtVersions(changeSets []VersionedChangeSet) error
+}
+
+// VersionedChangeSet is the change set of a single block.
+type VersionedChangeSet struct {
+	Version   int64
+	ChangeSet []*types.StoreKVPair
+}